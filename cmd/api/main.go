@@ -1,10 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -12,14 +23,23 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/joho/godotenv"
 
 	"github.com/ilramdhan/costing-mvp/config"
 	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/internal/infrastructure/cache"
 	"github.com/ilramdhan/costing-mvp/internal/infrastructure/persistence"
+	"github.com/ilramdhan/costing-mvp/internal/modules/catalog"
 	"github.com/ilramdhan/costing-mvp/internal/modules/costing"
+	"github.com/ilramdhan/costing-mvp/internal/modules/dataio"
+	"github.com/ilramdhan/costing-mvp/internal/modules/engineering"
 	"github.com/ilramdhan/costing-mvp/pkg/database"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+	"github.com/ilramdhan/costing-mvp/pkg/procs"
 )
 
 func main() {
@@ -28,24 +48,131 @@ func main() {
 	cfg := config.Load()
 	ctx := context.Background()
 
-	// Database connection
+	// Size parallelism to the container CPU quota rather than the host's CPUs
+	procsInfo := procs.Apply(cfg.Worker.MaxProcs)
+	cfg.Worker.Resolve(procsInfo.GOMAXPROCS)
+	log.Printf("Effective parallelism: %s", procsInfo)
+
+	// The embedded driver runs a local PostgreSQL for development without a server
+	if cfg.Database.Driver == database.DriverEmbedded {
+		embedded, err := database.StartEmbedded(ctx, &cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to start embedded database: %v", err)
+		}
+		defer embedded.Stop()
+	}
+
+	// Database connection; job events record the application name as their actor
+	cfg.Database.ApplicationName = "costing-api"
 	pool, err := database.NewPool(ctx, &cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer pool.Close()
 
+	// Pending migrations are applied on start when DB_AUTO_MIGRATE is set; the migration
+	// lock keeps replicas starting together from applying them twice
+	if cfg.Database.AutoMigrate {
+		if _, err := database.MigrateUp(ctx, pool, database.Migrations(&cfg.Database)); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+
+	// Listings, counts and stats read from the replica when one is configured
+	readPool, err := database.NewReadPool(ctx, &cfg.Database, pool)
+	if err != nil {
+		log.Printf("Failed to connect to the read replica, reading from the primary: %v", err)
+		readPool = pool
+	}
+	if readPool != pool {
+		defer readPool.Close()
+	}
+	pools := database.Pools(pool, readPool)
+	database.WatchPools(ctx, pools, cfg.Database.PoolStallThreshold)
+
+	upsertMode, err := persistence.ResolveSummaryUpsertMode(ctx, pool, cfg.Database.SummaryUpsert)
+	if err != nil {
+		log.Fatalf("Failed to resolve summary upsert mode: %v", err)
+	}
+	log.Printf("Summary upsert mode: %s", upsertMode)
+
 	// Initialize repositories
 	masterYarnRepo := persistence.NewMasterYarnRepository(pool)
 	variantRepo := persistence.NewYarnVariantRepository(pool)
 	processStepRepo := persistence.NewProcessStepRepository(pool)
-	costRepo := persistence.NewVariantProcessCostRepository(pool)
-	summaryRepo := persistence.NewVariantCostSummaryRepository(pool)
+	processMasterRepo := persistence.NewProcessMasterRepository(pool)
+	dbRetry := database.NewRetryPolicy(&cfg.Database)
+	costRepo := persistence.NewVariantProcessCostRepository(pool, dbRetry)
+	summaryStore, err := cache.OpenSummaryStore(ctx, &cfg.SummaryCache, persistence.NewSummaryEvents(pool))
+	if err != nil {
+		log.Fatalf("Failed to open summary cache: %v", err)
+	}
+	summaryRepo := cache.NewSummaryRepository(persistence.NewVariantCostSummaryRepository(pool, upsertMode, dbRetry), summaryStore)
+	readMasterRepo := persistence.NewMasterYarnRepository(readPool)
+	readVariantRepo := persistence.NewYarnVariantRepository(readPool)
+	readSummaryRepo := persistence.NewVariantCostSummaryRepository(readPool, upsertMode, dbRetry)
+	searchRepo := persistence.NewSearchRepository(readPool)
+	historyRepo := persistence.NewCostHistoryRepository(readPool)
 	jobRepo := persistence.NewBatchJobRepository(pool)
+	partitionRepo := persistence.NewJobPartitionRepository(pool)
+	chunkRepo := persistence.NewJobChunkRepository(pool)
+	deadLetterRepo := persistence.NewVariantDeadLetterRepository(pool)
+	routingRuleRepo := persistence.NewRoutingRuleRepository(pool)
+	routingTemplateRepo := persistence.NewRoutingTemplateRepository(pool)
+	parameterRepo := persistence.NewMasterParameterRepository(pool)
+	formulaRepo := persistence.NewFormulaRepository(pool)
+	auditLogRepo := persistence.NewAuditLogRepository(pool)
+	jobEventRepo := persistence.NewJobEventRepository(pool)
+	importRepo := persistence.NewImportRepository(pool)
+	lotRepo := persistence.NewProductionLotRepository(pool)
+	rateRepo := persistence.NewPriceRateRepository(pool)
+	scheduleRepo := persistence.NewJobScheduleRepository(pool)
+	webhookRepo := persistence.NewWebhookRepository(pool)
+	cacheEvents := persistence.NewCacheEvents(pool)
+	exportStorage, err := dataio.NewDirStorage(cfg.Export.Dir)
+	if err != nil {
+		log.Fatalf("Failed to open export storage: %v", err)
+	}
+	jobEvents := persistence.NewJobEvents(pool)
 
 	// Initialize calculation engine and worker pool
-	engine := costing.NewCalculationEngine(variantRepo, processStepRepo, costRepo, summaryRepo)
-	workerPool := costing.NewWorkerPool(engine, variantRepo, summaryRepo, jobRepo, cfg.Worker.Count, cfg.Worker.BatchSize)
+	parserOpts := []formula.Option{
+		formula.WithMaxLength(cfg.Formula.MaxLength),
+		formula.WithMaxNodes(cfg.Formula.MaxNodes),
+		formula.WithTimeout(cfg.Formula.EvalTimeout),
+	}
+	if cfg.Formula.DivByZeroFallback != nil {
+		parserOpts = append(parserOpts, formula.WithDivByZeroFallback(*cfg.Formula.DivByZeroFallback))
+	}
+	formulaParser := formula.NewParser(parserOpts...)
+	engine := costing.NewCalculationEngine(masterYarnRepo, variantRepo, processStepRepo, costRepo, summaryRepo, formulaParser)
+	variantService := catalog.NewVariantService(masterYarnRepo, variantRepo, routingRuleRepo)
+	masterService := catalog.NewMasterService(masterYarnRepo, catalog.DuplicatePolicy{
+		Mode:           cfg.Catalog.DuplicateMode,
+		NameSimilarity: cfg.Catalog.DuplicateNameSimilarity,
+		AttrTolerance:  cfg.Catalog.DuplicateAttrTolerance,
+	})
+	formulaService := engineering.NewFormulaService(processStepRepo, parameterRepo, formulaRepo, formulaParser)
+	routingCache := costing.NewRoutingCache(variantRepo, processStepRepo, rateRepo, formulaParser)
+	batchTuning := costing.BatchTuning{TargetLatency: cfg.Worker.BatchTargetLatency, MinSize: cfg.Worker.MinBatchSize}
+	workerTuning := costing.WorkerTuning{MaxWorkers: cfg.Worker.MaxWorkerCount}
+	if cfg.Worker.AutoWorkers {
+		workerTuning.Window = cfg.Worker.AutoTuneWindow
+	}
+	writeThrottle := costing.WriteThrottle{MaxRowsPerSecond: cfg.Worker.MaxWriteRowsPerSecond, MaxConcurrentUpserts: cfg.Worker.MaxConcurrentUpserts}
+	workerPool := costing.NewWorkerPool(engine, variantRepo, summaryRepo, jobRepo, partitionRepo, chunkRepo, deadLetterRepo, routingCache, cfg.Worker.Count, cfg.Worker.WriterCount, cfg.Worker.ScanCount, cfg.Worker.BatchSize, cfg.Worker.DeadLetterAfter, batchTuning, writeThrottle, workerTuning)
+	retryPolicy := costing.RetryPolicy{BaseDelay: cfg.Worker.RetryBaseDelay, MaxDelay: cfg.Worker.RetryMaxDelay}
+	lotService := costing.NewLotCostingService(engine, lotRepo)
+	timelineService := costing.NewTimelineService(engine, processMasterRepo)
+	simulationService := costing.NewSimulationService(engine)
+
+	// Prewarm the cache in the background and keep it in sync with other processes
+	go func() {
+		if err := routingCache.Warm(ctx); err != nil {
+			log.Printf("Failed to prewarm cache: %v", err)
+		}
+	}()
+	go routingCache.Watch(ctx, cacheEvents)
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -53,12 +180,15 @@ func main() {
 		ReadTimeout:           30 * time.Second,
 		WriteTimeout:          30 * time.Second,
 		IdleTimeout:           120 * time.Second,
+		BodyLimit:             max(cfg.Import.MaxBytes, fiber.DefaultBodyLimit),
 		DisableStartupMessage: false,
 	})
 
 	// Middleware
 	app.Use(recover.New())
-	app.Use(logger.New())
+	app.Use(requestid.New())
+	// Access log lines carry the request ID its slow queries are logged with
+	app.Use(logger.New(logger.Config{Format: "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${locals:requestid} | ${error}\n"}))
 	app.Use(cors.New())
 
 	// Health check
@@ -69,188 +199,1788 @@ func main() {
 		})
 	})
 
+	// Connection pool statistics and query latencies in the Prometheus text format
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+		database.WriteMetrics(c, pools)
+		return nil
+	})
+
 	// API v1 routes
 	api := app.Group("/api/v1")
 
+	// Changes made by a request are audited under its optional X-Actor header, and its
+	// queries are logged with its X-Request-ID
+	api.Use(func(c *fiber.Ctx) error {
+		ctx := repository.WithActor(c.UserContext(), c.Get("X-Actor"))
+		c.SetUserContext(database.WithRequestID(ctx, c.GetRespHeader(fiber.HeaderXRequestID)))
+		return c.Next()
+	})
+
+	// Full-text search over master yarns and variants, e.g. ?q=BATCH-42 polyester premium
+	api.Get("/search", func(c *fiber.Ctx) error {
+		q := strings.TrimSpace(c.Query("q"))
+		if q == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "q is required"})
+		}
+		limit := c.QueryInt("limit", 20)
+		hits, err := searchRepo.Search(c.UserContext(), q, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"data":  hits,
+			"query": q,
+			"limit": limit,
+		})
+	})
+
 	// Master Yarn endpoints
+	// Pages follow next_cursor; ?offset= still works but reads every row it skips
 	api.Get("/master-yarns", func(c *fiber.Ctx) error {
 		limit := c.QueryInt("limit", 20)
-		offset := c.QueryInt("offset", 0)
-		yarns, err := masterYarnRepo.List(ctx, limit, offset)
+		count, _ := readMasterRepo.Count(c.UserContext())
+		if c.Query("offset") != "" {
+			offset := c.QueryInt("offset", 0)
+			yarns, err := readMasterRepo.List(c.UserContext(), limit, offset)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.JSON(fiber.Map{
+				"data":   yarns,
+				"total":  count,
+				"limit":  limit,
+				"offset": offset,
+			})
+		}
+		cursor, err := entity.ParseCursor(c.Query("cursor"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		yarns, err := readMasterRepo.ListAfter(c.UserContext(), cursor, limit)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		count, _ := masterYarnRepo.Count(ctx)
 		return c.JSON(fiber.Map{
-			"data":   yarns,
-			"total":  count,
-			"limit":  limit,
-			"offset": offset,
+			"data":  yarns,
+			"total": count,
+			"limit": limit,
+			"next_cursor": nextCursor(yarns, limit, func(y *entity.MasterYarn) entity.Cursor {
+				return entity.Cursor{CreatedAt: y.CreatedAt, ID: y.ID}
+			}),
 		})
 	})
 
-	api.Get("/master-yarns/:id", func(c *fiber.Ctx) error {
+	// Searches take any number of ?filter=field:op:value, e.g. ?filter=attr.fiber_type:eq:COTTON
+	api.Get("/master-yarns/search", func(c *fiber.Ctx) error {
+		filters, cursor, err := searchQuery(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		limit := c.QueryInt("limit", 20)
+		yarns, err := readMasterRepo.Search(c.UserContext(), filters, cursor, limit)
+		if err != nil {
+			return searchError(c, err)
+		}
+		return c.JSON(fiber.Map{
+			"data":  yarns,
+			"limit": limit,
+			"next_cursor": nextCursor(yarns, limit, func(y *entity.MasterYarn) entity.Cursor {
+				return entity.Cursor{CreatedAt: y.CreatedAt, ID: y.ID}
+			}),
+		})
+	})
+
+	// Create a master; likely duplicates are returned as warnings, or 409 in block mode unless ?force=true
+	api.Post("/master-yarns", func(c *fiber.Ctx) error {
+		var req masterYarnRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if req.Code == "" || req.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "code and name are required"})
+		}
+
+		yarn := req.toEntity()
+		duplicates, err := masterService.Create(c.UserContext(), yarn, c.QueryBool("force"))
+		if err != nil {
+			var dupErr *catalog.DuplicateError
+			if errors.As(err, &dupErr) {
+				return c.Status(409).JSON(fiber.Map{"error": err.Error(), "duplicates": dupErr.Candidates})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(fiber.Map{"data": yarn, "duplicates": duplicates})
+	})
+
+	api.Post("/master-yarns/import", func(c *fiber.Ctx) error {
+		var req []masterYarnRequest
+		if err := c.BodyParser(&req); err != nil || len(req) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "a non-empty array of master yarns is required"})
+		}
+		yarns := make([]*entity.MasterYarn, len(req))
+		for i, r := range req {
+			if r.Code == "" || r.Name == "" {
+				return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("row %d: code and name are required", i+1)})
+			}
+			yarns[i] = r.toEntity()
+		}
+		result, err := masterService.Import(c.UserContext(), yarns, c.QueryBool("force"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(result)
+	})
+
+	api.Get("/master-yarns/:id/duplicates", func(c *fiber.Ctx) error {
 		id, err := uuid.Parse(c.Params("id"))
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
 		}
-		yarn, err := masterYarnRepo.GetByID(ctx, id)
+		yarn, err := masterYarnRepo.GetByID(c.UserContext(), id)
 		if err != nil {
 			return c.Status(404).JSON(fiber.Map{"error": "not found"})
 		}
-		return c.JSON(yarn)
+		duplicates, err := masterService.FindDuplicates(c.UserContext(), yarn)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": duplicates})
 	})
 
-	// Variant endpoints
-	api.Get("/variants/count", func(c *fiber.Ctx) error {
-		count, err := variantRepo.Count(ctx)
+	// Merge a duplicate into this (canonical) master: variants are re-parented, the duplicate deactivated
+	api.Post("/master-yarns/:id/merge", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			DuplicateID uuid.UUID `json:"duplicate_id"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.DuplicateID == uuid.Nil {
+			return c.Status(400).JSON(fiber.Map{"error": "duplicate_id is required"})
+		}
+		result, err := masterService.Merge(c.UserContext(), id, req.DuplicateID, c.Get("X-Actor"))
+		if err != nil {
+			return restructureError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	// Merge this master into target, in one transaction with an audit log entry
+	api.Post("/master-yarns/:id/merge-into/:target", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		target, err := uuid.Parse(c.Params("target"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid target id"})
+		}
+		result, err := masterService.Merge(c.UserContext(), target, id, c.Get("X-Actor"))
+		if err != nil {
+			return restructureError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	// Move selected variants of this master to a new master
+	api.Post("/master-yarns/:id/split", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			masterYarnRequest
+			VariantIDs []uuid.UUID `json:"variant_ids"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if req.Code == "" || req.Name == "" || len(req.VariantIDs) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "code, name and variant_ids are required"})
+		}
+		result, err := masterService.Split(c.UserContext(), id, req.toEntity(), req.VariantIDs, c.Get("X-Actor"))
+		if err != nil {
+			return restructureError(c, err)
+		}
+		return c.Status(201).JSON(result)
+	})
+
+	api.Get("/master-yarns/:id/audit-log", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		logs, err := auditLogRepo.ListByEntity(c.UserContext(), entity.AuditEntityMasterYarn, id, c.QueryInt("limit", 50))
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(fiber.Map{"count": count})
+		return c.JSON(fiber.Map{"data": logs})
 	})
 
-	// Cost Summary endpoints
-	api.Get("/cost-summaries", func(c *fiber.Ctx) error {
+	api.Get("/master-yarns/:id/variants", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		cursor, err := entity.ParseCursor(c.Query("cursor"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
 		limit := c.QueryInt("limit", 20)
-		offset := c.QueryInt("offset", 0)
-		summaries, err := summaryRepo.List(ctx, limit, offset)
+		variants, err := readVariantRepo.ListAfter(c.UserContext(), id, cursor, limit)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.JSON(fiber.Map{
-			"data":   summaries,
-			"limit":  limit,
-			"offset": offset,
+			"data":  variants,
+			"limit": limit,
+			"next_cursor": nextCursor(variants, limit, func(v *entity.YarnVariant) entity.Cursor {
+				return entity.Cursor{CreatedAt: v.CreatedAt, ID: v.ID}
+			}),
 		})
 	})
 
-	api.Get("/cost-summaries/:id", func(c *fiber.Ctx) error {
+	// Deactivates every variant of a master; inactive variants are left out of recalculation,
+	// so nothing is queued
+	api.Post("/master-yarns/:id/variants/deactivate", func(c *fiber.Ctx) error {
 		id, err := uuid.Parse(c.Params("id"))
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
 		}
-		summary, err := summaryRepo.GetByVariantID(ctx, id)
+		if _, err := masterYarnRepo.GetByID(c.UserContext(), id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "master yarn not found"})
+		}
+		deactivated, err := variantRepo.DeactivateByMasterID(c.UserContext(), id)
 		if err != nil {
-			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(summary)
+		return c.JSON(fiber.Map{"deactivated": deactivated})
 	})
 
-	// Recalculation endpoints
-	api.Post("/recalculate/all", func(c *fiber.Ctx) error {
-		// Create job
-		now := time.Now()
-		job := &entity.BatchJob{
-			ID:        uuid.New(),
-			JobType:   entity.JobTypeRecalculateAll,
-			Status:    entity.JobStatusPending,
-			CreatedAt: now,
-			StartedAt: &now,
-		}
-		if err := jobRepo.Create(ctx, job); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-		}
-
-		// Base parameters for calculation (would come from price_rates table in production)
-		baseParams := map[string]interface{}{
-			"material_price":      50.0,
-			"electricity_rate":    1.5,
-			"labor_rate":          25.0,
-			"spindle_rate":        15.0,
-			"loom_rate":           20.0,
-			"dye_price":           100.0,
-			"water_rate":          0.02,
-			"steam_rate":          10.0,
-			"finishing_rate":      12.0,
-			"chemical_price":      80.0,
-			"packaging_price":     5.0,
-			"overhead_percentage": 0.1,
-			"raw_material_kg":     100.0,
-			"electricity_kwh_1":   50.0,
-			"labor_hours_1":       8.0,
-			"input_cost_1":        5000.0,
-			"spindle_hours":       10.0,
-			"labor_hours_2":       6.0,
-			"input_cost_2":        6000.0,
-			"loom_hours":          8.0,
-			"labor_hours_3":       5.0,
-			"input_cost_3":        7000.0,
-			"dye_kg":              2.5,
-			"water_liters":        500.0,
-			"steam_hours":         5.0,
-			"input_cost_4":        8000.0,
-			"finishing_hours":     4.0,
-			"chemical_kg":         1.5,
-			"input_cost_5":        9000.0,
-			"packaging_units":     10.0,
-			"labor_hours_6":       3.0,
-			"material_cost":       1000.0,
-		}
-
-		// Start async recalculation
-		go func() {
-			if err := workerPool.RecalculateAll(context.Background(), job.ID, baseParams); err != nil {
-				log.Printf("Recalculation failed: %v", err)
-				jobRepo.Fail(context.Background(), job.ID, err.Error())
-			}
-		}()
+	// Moves every variant of a master to another routing template and queues a recalculation
+	// of that routing, which covers the moved variants
+	api.Post("/master-yarns/:id/variants/reassign", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			RoutingTemplateID uuid.UUID `json:"routing_template_id"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.RoutingTemplateID == uuid.Nil {
+			return c.Status(400).JSON(fiber.Map{"error": "routing_template_id is required"})
+		}
+		if _, err := masterYarnRepo.GetByID(c.UserContext(), id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "master yarn not found"})
+		}
+		if _, err := routingTemplateRepo.GetByID(c.UserContext(), req.RoutingTemplateID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "routing template not found"})
+		}
+		reassigned, err := variantRepo.ReassignRoutingByMasterID(c.UserContext(), id, req.RoutingTemplateID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if reassigned == 0 {
+			return c.JSON(fiber.Map{"reassigned": reassigned})
+		}
 
+		job := &entity.BatchJob{
+			ID:          uuid.New(),
+			JobType:     entity.JobTypeRecalculateRouting,
+			Status:      entity.JobStatusPending,
+			Metadata:    map[string]interface{}{"routing_template_id": req.RoutingTemplateID, "master_yarn_id": id},
+			MaxAttempts: cfg.Worker.MaxAttempts,
+			CreatedAt:   time.Now(),
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("reassigned %d variants but failed to queue recalculation: %v", reassigned, err)})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
 		return c.Status(202).JSON(fiber.Map{
-			"job_id":  job.ID,
-			"message": "Recalculation started",
-			"status":  job.Status,
+			"reassigned": reassigned,
+			"job_id":     job.ID,
+			"message":    "Recalculation queued",
+			"status":     job.Status,
+			"priority":   job.Priority,
 		})
 	})
 
-	// Job status endpoints
-	api.Get("/jobs", func(c *fiber.Ctx) error {
-		jobs, err := jobRepo.ListRecent(ctx, 20)
+	// Audit log of every change to master data, formulas and price rates, newest first
+	api.Get("/audit-logs", func(c *fiber.Ctx) error {
+		filter := entity.AuditLogFilter{EntityType: c.Query("entity_type"), Actor: c.Query("actor")}
+		if value := c.Query("entity_id"); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid entity_id"})
+			}
+			filter.EntityID = id
+		}
+		cursor, err := entity.ParseCursor(c.Query("cursor"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		limit := c.QueryInt("limit", 50)
+		logs, err := auditLogRepo.List(c.UserContext(), filter, cursor, limit)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(fiber.Map{"data": jobs})
+		return c.JSON(fiber.Map{
+			"data":  logs,
+			"limit": limit,
+			"next_cursor": nextCursor(logs, limit, func(l *entity.AuditLog) entity.Cursor {
+				return entity.Cursor{CreatedAt: l.CreatedAt, ID: l.ID}
+			}),
+		})
 	})
 
-	api.Get("/jobs/:id", func(c *fiber.Ctx) error {
+	api.Get("/master-yarns/:id", func(c *fiber.Ctx) error {
 		id, err := uuid.Parse(c.Params("id"))
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
 		}
-		job, err := jobRepo.GetByID(ctx, id)
+		yarn, err := masterYarnRepo.GetByID(c.UserContext(), id)
 		if err != nil {
 			return c.Status(404).JSON(fiber.Map{"error": "not found"})
 		}
-		return c.JSON(fiber.Map{
-			"job":      job,
-			"progress": job.Progress(),
-		})
+		return c.JSON(yarn)
 	})
 
-	// Stats endpoint
-	api.Get("/stats", func(c *fiber.Ctx) error {
-		masterCount, _ := masterYarnRepo.Count(ctx)
-		variantCount, _ := variantRepo.Count(ctx)
+	// Variant endpoints
+	api.Get("/variants/search", func(c *fiber.Ctx) error {
+		filters, cursor, err := searchQuery(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		limit := c.QueryInt("limit", 20)
+		variants, err := readVariantRepo.Search(c.UserContext(), filters, cursor, limit)
+		if err != nil {
+			return searchError(c, err)
+		}
 		return c.JSON(fiber.Map{
-			"master_yarns":  masterCount,
-			"yarn_variants": variantCount,
-			"timestamp":     time.Now().Format(time.RFC3339),
+			"data":  variants,
+			"limit": limit,
+			"next_cursor": nextCursor(variants, limit, func(v *entity.YarnVariant) entity.Cursor {
+				return entity.Cursor{CreatedAt: v.CreatedAt, ID: v.ID}
+			}),
 		})
 	})
 
-	// Graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	api.Get("/variants/count", func(c *fiber.Ctx) error {
+		count, err := readVariantRepo.Count(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"count": count})
+	})
 
-	go func() {
-		<-quit
-		log.Println("Shutting down server...")
-		app.Shutdown()
-	}()
+	api.Post("/variants", func(c *fiber.Ctx) error {
+		var req struct {
+			MasterYarnID      uuid.UUID `json:"master_yarn_id"`
+			SKU               string    `json:"sku"`
+			BatchNo           string    `json:"batch_no"`
+			RoutingTemplateID uuid.UUID `json:"routing_template_id"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if req.MasterYarnID == uuid.Nil || req.SKU == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "master_yarn_id and sku are required"})
+		}
 
-	// Start server
-	log.Printf("Starting API server on :%s", cfg.App.Port)
-	if err := app.Listen(":" + cfg.App.Port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		now := time.Now()
+		variant := &entity.YarnVariant{
+			ID:                uuid.New(),
+			MasterYarnID:      req.MasterYarnID,
+			SKU:               req.SKU,
+			BatchNo:           req.BatchNo,
+			RoutingTemplateID: req.RoutingTemplateID,
+			IsActive:          true,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+		if err := variantService.Create(c.UserContext(), variant); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(variant)
+	})
+
+	// Schedule and cost of the variant's routing in one call, for planners
+	api.Get("/variants/:id/process-timeline", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		quantity := c.QueryFloat("quantity_kg", 0)
+		if quantity < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "quantity_kg must not be negative"})
+		}
+		baseParams, err := routingCache.BaseParams(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		timeline, err := timelineService.Build(c.UserContext(), id, quantity, baseParams)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(timeline)
+	})
+
+	// What-if costing: current parameters vs. the given overrides, with a per-step diff
+	api.Post("/variants/:id/simulate", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := c.BodyParser(&req); err != nil || len(req.Params) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "params is required"})
+		}
+		baseParams, err := routingCache.BaseParams(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		result, err := simulationService.Simulate(c.UserContext(), id, baseParams, req.Params)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(result)
+	})
+
+	// Routing rule endpoints
+	api.Get("/routing-rules", func(c *fiber.Ctx) error {
+		rules, err := routingRuleRepo.ListActive(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": rules})
+	})
+
+	api.Post("/routing-rules", func(c *fiber.Ctx) error {
+		var rule entity.RoutingRule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if rule.RoutingTemplateID == uuid.Nil {
+			return c.Status(400).JSON(fiber.Map{"error": "routing_template_id is required"})
+		}
+		rule.ID = uuid.New()
+		rule.IsActive = true
+		rule.CreatedAt = time.Now()
+		if err := routingRuleRepo.Create(c.UserContext(), &rule); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(rule)
+	})
+
+	api.Delete("/routing-rules/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if err := routingRuleRepo.Delete(c.UserContext(), id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	// Preview which rule applies, either for an existing master or for ad-hoc attributes
+	api.Get("/routing-rules/preview", func(c *fiber.Ctx) error {
+		attrs := map[string]interface{}{
+			"fiber_type": c.Query("fiber_type"),
+			"grade":      c.Query("grade"),
+		}
+		if masterID := c.Query("master_yarn_id"); masterID != "" {
+			id, err := uuid.Parse(masterID)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid master_yarn_id"})
+			}
+			master, err := masterYarnRepo.GetByID(c.UserContext(), id)
+			if err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "master yarn not found"})
+			}
+			attrs = master.FixedAttrs
+		}
+
+		rule, err := variantService.PreviewRoutingRule(c.UserContext(), attrs)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"fiber_type": attrs["fiber_type"],
+			"grade":      attrs["grade"],
+			"matched":    rule != nil,
+			"rule":       rule,
+		})
+	})
+
+	// Parameter and formula endpoints
+	api.Get("/parameters", func(c *fiber.Ctx) error {
+		params, err := parameterRepo.List(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": params})
+	})
+
+	// Rate of a parameter in effect on ?as_of= (YYYY-MM-DD), today by default
+	api.Get("/price-rates/:key", func(c *fiber.Ctx) error {
+		date := time.Now()
+		if value := c.Query("as_of"); value != "" {
+			var err error
+			if date, err = time.Parse(time.DateOnly, value); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "as_of must be a date (YYYY-MM-DD)"})
+			}
+		}
+		rate, err := rateRepo.GetRateAsOf(c.UserContext(), c.Params("key"), date)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "no rate in effect"})
+		}
+		return c.JSON(rate)
+	})
+
+	// Every rate of a parameter, latest effective first
+	api.Get("/price-rates/:key/history", func(c *fiber.Ctx) error {
+		rates, err := rateRepo.History(c.UserContext(), c.Params("key"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": rates})
+	})
+
+	api.Post("/formulas/validate", func(c *fiber.Ctx) error {
+		var req struct {
+			Expression string `json:"expression"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Expression == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "expression is required"})
+		}
+		issues, err := formulaService.Validate(c.UserContext(), req.Expression)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"valid":  len(issues) == 0,
+			"issues": issues,
+		})
+	})
+
+	// Formula sandbox: missing params are filled from current price rates and parameter defaults
+	api.Post("/formulas/evaluate", func(c *fiber.Ctx) error {
+		var req struct {
+			Expression string                 `json:"expression"`
+			Params     map[string]interface{} `json:"params"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Expression == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "expression is required"})
+		}
+		baseParams, err := routingCache.BaseParams(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		result, err := formulaService.Sandbox(c.UserContext(), req.Expression, req.Params, baseParams)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(result)
+	})
+
+	api.Put("/process-steps/:id/formula", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		// Either an inline expression or a reference to a library formula (latest version when omitted)
+		var req struct {
+			Expression     string `json:"formula_expression"`
+			FormulaName    string `json:"formula_name"`
+			FormulaVersion int    `json:"formula_version"`
+		}
+		if err := c.BodyParser(&req); err != nil || (req.Expression == "") == (req.FormulaName == "") {
+			return c.Status(400).JSON(fiber.Map{"error": "exactly one of formula_expression or formula_name is required"})
+		}
+
+		if req.FormulaName != "" {
+			_, err = formulaService.AttachStepFormula(c.UserContext(), id, req.FormulaName, req.FormulaVersion)
+		} else {
+			err = formulaService.UpdateStepFormula(c.UserContext(), id, req.Expression)
+		}
+		if err != nil {
+			var validationErr *engineering.FormulaValidationError
+			switch {
+			case errors.As(err, &validationErr):
+				return c.Status(422).JSON(fiber.Map{"error": "unit check failed", "issues": validationErr.Issues})
+			case errors.Is(err, pgx.ErrNoRows):
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			default:
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if err := cacheEvents.Notify(c.UserContext(), "process step formula updated"); err != nil {
+			log.Printf("Failed to invalidate cache: %v", err)
+		}
+		step, err := processStepRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(step)
+	})
+
+	// Named formula library endpoints
+	api.Get("/formulas", func(c *fiber.Ctx) error {
+		formulas, err := formulaRepo.List(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": formulas})
+	})
+
+	// Saving under an existing name creates a new version; steps keep the version they reference
+	api.Post("/formulas", func(c *fiber.Ctx) error {
+		var req struct {
+			Name        string `json:"name"`
+			Expression  string `json:"expression"`
+			Description string `json:"description"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Name == "" || req.Expression == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name and expression are required"})
+		}
+		f, err := formulaService.SaveFormula(c.UserContext(), req.Name, req.Expression, req.Description)
+		if err != nil {
+			var validationErr *engineering.FormulaValidationError
+			if errors.As(err, &validationErr) {
+				return c.Status(422).JSON(fiber.Map{"error": "unit check failed", "issues": validationErr.Issues})
+			}
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(f)
+	})
+
+	api.Get("/formulas/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		f, err := formulaRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		versions, err := formulaRepo.ListVersions(c.UserContext(), f.Name)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"formula": f, "versions": versions})
+	})
+
+	// Which routings use this formula version, to check impact before editing it
+	api.Get("/formulas/:id/usage", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		usages, err := formulaRepo.Usage(c.UserContext(), id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": usages})
+	})
+
+	// Production lot endpoints
+	api.Post("/variants/:id/lots", func(c *fiber.Ctx) error {
+		variantID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			BatchNo        string  `json:"batch_no"`
+			ProductionDate string  `json:"production_date"`
+			Quantity       float64 `json:"quantity"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if req.BatchNo == "" || req.Quantity <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "batch_no and a positive quantity are required"})
+		}
+
+		now := time.Now()
+		productionDate := now
+		if req.ProductionDate != "" {
+			productionDate, err = time.Parse("2006-01-02", req.ProductionDate)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "production_date must be YYYY-MM-DD"})
+			}
+		}
+		if _, err := variantRepo.GetByID(c.UserContext(), variantID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "variant not found"})
+		}
+
+		lot := &entity.ProductionLot{
+			ID:             uuid.New(),
+			YarnVariantID:  variantID,
+			BatchNo:        req.BatchNo,
+			ProductionDate: productionDate,
+			Quantity:       req.Quantity,
+			Status:         entity.LotStatusPlanned,
+			ActualValues:   map[string]interface{}{},
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if err := lotRepo.Create(c.UserContext(), lot); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(lot)
+	})
+
+	api.Get("/variants/:id/lots", func(c *fiber.Ctx) error {
+		variantID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		lots, err := lotRepo.ListByVariantID(c.UserContext(), variantID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": lots})
+	})
+
+	api.Get("/lots/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		lot, err := lotRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		return c.JSON(lot)
+	})
+
+	api.Put("/lots/:id/status", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			Status entity.LotStatus `json:"status"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Status == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "status is required"})
+		}
+		lot, err := lotService.ChangeStatus(c.UserContext(), id, req.Status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(lot)
+	})
+
+	// Record measured parameter values (e.g. actual kWh, waste %) and cost the lot
+	api.Put("/lots/:id/actuals", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		var req struct {
+			ActualValues map[string]interface{} `json:"actual_values"`
+		}
+		if err := c.BodyParser(&req); err != nil || len(req.ActualValues) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "actual_values is required"})
+		}
+		baseParams, err := routingCache.BaseParams(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		lot, err := lotService.RecordActuals(c.UserContext(), id, req.ActualValues, baseParams)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(lot)
+	})
+
+	api.Get("/master-yarns/:id/lot-report", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		lines, err := lotRepo.CostReportByMaster(c.UserContext(), id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": lines})
+	})
+
+	// Cost Summary endpoints
+	api.Get("/cost-summaries", func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 20)
+		if c.Query("offset") != "" {
+			offset := c.QueryInt("offset", 0)
+			summaries, err := readSummaryRepo.List(c.UserContext(), limit, offset)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.JSON(fiber.Map{
+				"data":   summaries,
+				"limit":  limit,
+				"offset": offset,
+			})
+		}
+		cursor, err := entity.ParseCursor(c.Query("cursor"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		summaries, err := readSummaryRepo.ListAfter(c.UserContext(), cursor, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"data":  summaries,
+			"limit": limit,
+			"next_cursor": nextCursor(summaries, limit, func(s *entity.VariantCostSummary) entity.Cursor {
+				return entity.Cursor{CreatedAt: s.CreatedAt, ID: s.YarnVariantID}
+			}),
+		})
+	})
+
+	api.Get("/cost-summaries/search", func(c *fiber.Ctx) error {
+		filters, cursor, err := searchQuery(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		limit := c.QueryInt("limit", 20)
+		summaries, err := readSummaryRepo.Search(c.UserContext(), filters, cursor, limit)
+		if err != nil {
+			return searchError(c, err)
+		}
+		return c.JSON(fiber.Map{
+			"data":  summaries,
+			"limit": limit,
+			"next_cursor": nextCursor(summaries, limit, func(s *entity.VariantCostSummary) entity.Cursor {
+				return entity.Cursor{CreatedAt: s.CreatedAt, ID: s.YarnVariantID}
+			}),
+		})
+	})
+
+	api.Get("/cost-summaries/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		summary, err := summaryRepo.GetByVariantID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		return c.JSON(summary)
+	})
+
+	// Superseded versions of a variant's summary and per-step costs, latest first;
+	// ?include_archived=true adds versions ARCHIVE_HISTORY jobs moved to the archive
+	api.Get("/cost-summaries/:id/history", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		versions, err := historyRepo.SummaryHistory(c.UserContext(), id, c.QueryBool("include_archived"), c.QueryInt("limit", 50))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": versions})
+	})
+
+	api.Get("/cost-summaries/:id/step-history", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		versions, err := historyRepo.ProcessCostHistory(c.UserContext(), id, c.QueryBool("include_archived"), c.QueryInt("limit", 50))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": versions})
+	})
+
+	// Queue an archival of the history; ?keep_versions= overrides ARCHIVE_KEEP_VERSIONS
+	api.Post("/cost-summaries/archive", func(c *fiber.Ctx) error {
+		job := &entity.BatchJob{
+			ID:          uuid.New(),
+			JobType:     entity.JobTypeArchiveHistory,
+			Status:      entity.JobStatusPending,
+			Metadata:    map[string]interface{}{},
+			MaxAttempts: cfg.Worker.MaxAttempts,
+			CreatedAt:   time.Now(),
+		}
+		if keep := c.QueryInt(entity.JobKeepVersionsKey); keep > 0 {
+			job.Metadata[entity.JobKeepVersionsKey] = keep
+		} else if c.Query(entity.JobKeepVersionsKey) != "" {
+			return c.Status(400).JSON(fiber.Map{"error": "keep_versions must be a positive integer"})
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":  job.ID,
+			"message": "Archival queued",
+			"status":  job.Status,
+		})
+	})
+
+	// Recalculation endpoints
+	api.Post("/recalculate/all", func(c *fiber.Ctx) error {
+		now := time.Now()
+		job := &entity.BatchJob{
+			ID:          uuid.New(),
+			JobType:     entity.JobTypeRecalculateAll,
+			Status:      entity.JobStatusPending,
+			MaxAttempts: cfg.Worker.MaxAttempts,
+			CreatedAt:   now,
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+
+		// ?worker_count= and ?batch_size= override the pool settings for this job only,
+		// e.g. to throttle a daytime run
+		job.Metadata = map[string]interface{}{}
+		if err := queryOverrides(c, job.Metadata, cfg.Worker.MaxWorkerCount, cfg.Worker.MaxBatchSize); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// ?queue=true leaves the job PENDING for a worker instance to claim
+		if c.QueryBool("queue") {
+			if err := jobRepo.Create(c.UserContext(), job); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			// Wake idle workers now; they also poll, so a lost notification only delays the job
+			if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+				log.Printf("Failed to notify workers: %v", err)
+			}
+			return c.Status(202).JSON(fiber.Map{
+				"job_id":  job.ID,
+				"message": "Recalculation queued",
+				"status":  job.Status,
+			})
+		}
+
+		// Otherwise run here, claimed by this process so worker instances do not pick it up
+		job.Status = entity.JobStatusRunning
+		job.ClaimedBy = cfg.Worker.ID
+		job.ClaimedAt = &now
+		job.HeartbeatAt = &now
+		job.StartedAt = &now
+		job.Attempts = 1
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			// Only one full recalculation runs at a time; a second would rewrite the same rows
+			if errors.Is(err, repository.ErrExclusiveJobRunning) {
+				response := fiber.Map{"error": "a full recalculation is already running; use ?queue=true to run this one after it"}
+				if running, err := jobRepo.GetRunning(c.UserContext(), job.JobType); err == nil {
+					response["running_job_id"] = running.ID
+				}
+				return c.Status(409).JSON(response)
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		baseParams, err := routingCache.BaseParams(c.UserContext())
+		if err != nil {
+			retryPolicy.HandleFailure(c.UserContext(), jobRepo, job, err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Start async recalculation; a failed run is left for worker instances to retry
+		go func() {
+			jobCtx := database.WithJobID(context.Background(), job.ID.String())
+			stopHeartbeat := costing.StartHeartbeat(jobCtx, jobRepo, job.ID, cfg.Worker.HeartbeatInterval)
+			err := workerPool.RecalculateAll(jobCtx, job.ID, baseParams)
+			stopHeartbeat()
+			if errors.Is(err, costing.ErrJobPaused) {
+				log.Printf("Job %s paused; resume it to continue on a worker instance", job.ID)
+				return
+			}
+			if err != nil {
+				log.Printf("Recalculation failed: %v", err)
+				if delay, err := retryPolicy.HandleFailure(context.Background(), jobRepo, job, err); err != nil {
+					log.Printf("Failed to record failure of job %s: %v", job.ID, err)
+				} else if delay > 0 {
+					log.Printf("Job %s will be retried by a worker in %v", job.ID, delay)
+				}
+			}
+		}()
+
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":  job.ID,
+			"message": "Recalculation started",
+			"status":  job.Status,
+		})
+	})
+
+	// Single-variant recalculation is always queued, ahead of bulk jobs by default
+	api.Post("/recalculate/variants/:id", func(c *fiber.Ctx) error {
+		variantID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if _, err := variantRepo.GetByID(c.UserContext(), variantID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "variant not found"})
+		}
+
+		job := &entity.BatchJob{
+			ID:           uuid.New(),
+			JobType:      entity.JobTypeRecalculateVariant,
+			Status:       entity.JobStatusPending,
+			TotalRecords: 1,
+			Metadata:     map[string]interface{}{"variant_id": variantID},
+			MaxAttempts:  cfg.Worker.MaxAttempts,
+			CreatedAt:    time.Now(),
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":   job.ID,
+			"message":  "Recalculation queued",
+			"status":   job.Status,
+			"priority": job.Priority,
+		})
+	})
+
+	// Routing recalculation covers only the variants of one routing template, e.g. after one
+	// of its formulas changed; it is always queued
+	api.Post("/recalculate/routing/:id", func(c *fiber.Ctx) error {
+		routingID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if _, err := routingTemplateRepo.GetByID(c.UserContext(), routingID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "routing template not found"})
+		}
+
+		job := &entity.BatchJob{
+			ID:          uuid.New(),
+			JobType:     entity.JobTypeRecalculateRouting,
+			Status:      entity.JobStatusPending,
+			Metadata:    map[string]interface{}{"routing_template_id": routingID},
+			MaxAttempts: cfg.Worker.MaxAttempts,
+			CreatedAt:   time.Now(),
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+		if err := queryOverrides(c, job.Metadata, cfg.Worker.MaxWorkerCount, cfg.Worker.MaxBatchSize); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":   job.ID,
+			"message":  "Recalculation queued",
+			"status":   job.Status,
+			"priority": job.Priority,
+		})
+	})
+
+	// Dead letters: variants left out of full recalculations after failing repeatedly
+	api.Get("/dead-letters", func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 20)
+		offset := c.QueryInt("offset", 0)
+		letters, err := deadLetterRepo.List(c.UserContext(), limit, offset)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		count, _ := deadLetterRepo.Count(c.UserContext())
+		return c.JSON(fiber.Map{
+			"data":   letters,
+			"total":  count,
+			"limit":  limit,
+			"offset": offset,
+		})
+	})
+
+	// Requeue returns a variant to full recalculations and queues a recalculation of it
+	api.Post("/dead-letters/:id/requeue", func(c *fiber.Ctx) error {
+		variantID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if err := deadLetterRepo.Requeue(c.UserContext(), variantID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "variant is not dead-lettered"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		job := &entity.BatchJob{
+			ID:           uuid.New(),
+			JobType:      entity.JobTypeRecalculateVariant,
+			Status:       entity.JobStatusPending,
+			TotalRecords: 1,
+			Metadata:     map[string]interface{}{"variant_id": variantID},
+			MaxAttempts:  cfg.Worker.MaxAttempts,
+			CreatedAt:    time.Now(),
+		}
+		job.Priority = job.JobType.DefaultPriority()
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":  job.ID,
+			"message": "Variant requeued",
+			"status":  job.Status,
+		})
+	})
+
+	// Imports stage an uploaded CSV or NDJSON file of masters, variants or rates with an
+	// IMPORT_DATA job; a worker validates the rows and copies the valid ones in. The file
+	// is sent as the "file" form field or as the request body.
+	api.Post("/imports", func(c *fiber.Ctx) error {
+		kind := c.Query("kind")
+		if !dataio.ValidKind(kind) {
+			return c.Status(400).JSON(fiber.Map{"error": "kind must be masters, variants, rates or parameters"})
+		}
+
+		content, filename := c.Body(), ""
+		if header, err := c.FormFile("file"); err == nil {
+			f, err := header.Open()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			defer f.Close()
+			if content, err = io.ReadAll(f); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			filename = header.Filename
+		}
+		if len(content) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "file is empty"})
+		}
+		format := c.Query("format", dataio.DetectFormat(filename, c.Get("Content-Type")))
+		if !dataio.ValidImportFormat(format) {
+			return c.Status(400).JSON(fiber.Map{"error": "format must be csv, ndjson or xlsx"})
+		}
+
+		now := time.Now()
+		job := &entity.BatchJob{
+			ID:          uuid.New(),
+			JobType:     entity.JobTypeImportData,
+			Status:      entity.JobStatusPending,
+			Metadata:    map[string]interface{}{"kind": kind, "format": format, "filename": filename},
+			MaxAttempts: cfg.Worker.MaxAttempts,
+			CreatedAt:   now,
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+		file := &entity.ImportFile{
+			JobID:     job.ID,
+			Kind:      kind,
+			Format:    format,
+			Filename:  filename,
+			Content:   content,
+			SizeBytes: int64(len(content)),
+			CreatedAt: now,
+		}
+		if err := importRepo.Stage(c.UserContext(), job, file); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":     job.ID,
+			"message":    "Import queued",
+			"status":     job.Status,
+			"kind":       kind,
+			"format":     format,
+			"size_bytes": file.SizeBytes,
+		})
+	})
+
+	// Exports write every cost summary to a CSV or NDJSON file, optionally with the cost of
+	// each process (?steps=true); the file is downloadable once the job completes
+	api.Post("/exports", func(c *fiber.Ctx) error {
+		info := &entity.ExportInfo{Format: c.Query("format", entity.FileFormatCSV), IncludeSteps: c.QueryBool("steps")}
+		if !dataio.ValidFormat(info.Format) {
+			return c.Status(400).JSON(fiber.Map{"error": "format must be csv or ndjson"})
+		}
+
+		job := &entity.BatchJob{
+			ID:          uuid.New(),
+			JobType:     entity.JobTypeExportData,
+			Status:      entity.JobStatusPending,
+			Metadata:    map[string]interface{}{entity.JobExportKey: info},
+			MaxAttempts: cfg.Worker.MaxAttempts,
+			CreatedAt:   time.Now(),
+		}
+		job.Priority = c.QueryInt("priority", job.JobType.DefaultPriority())
+		if err := jobRepo.Create(c.UserContext(), job); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), job.ID.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		return c.Status(202).JSON(fiber.Map{
+			"job_id":       job.ID,
+			"message":      "Export queued",
+			"status":       job.Status,
+			"format":       info.Format,
+			"download_url": exportDownloadURL(job.ID),
+		})
+	})
+
+	api.Get("/exports/:id/download", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		job, err := jobRepo.GetByID(c.UserContext(), id)
+		if err != nil || job.JobType != entity.JobTypeExportData {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		info, ok := job.ExportInfo()
+		if !ok || info.File == "" || job.Status != entity.JobStatusCompleted {
+			return c.Status(409).JSON(fiber.Map{"error": "export is not complete", "status": job.Status})
+		}
+		file, _, err := exportStorage.Open(info.File)
+		if err != nil {
+			return c.Status(410).JSON(fiber.Map{"error": "export file is no longer available"})
+		}
+
+		contentType := "text/csv"
+		if info.Format == entity.FileFormatNDJSON {
+			contentType = "application/x-ndjson"
+		}
+		c.Set("Content-Type", contentType)
+		c.Set("Content-Disposition", `attachment; filename="`+info.File+`"`)
+
+		// As for the progress stream, extend the write timeout as the file is sent
+		conn := c.Context().Conn()
+		writeTimeout := app.Config().WriteTimeout
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer file.Close()
+			buf := make([]byte, 256<<10)
+			for {
+				n, err := file.Read(buf)
+				if n > 0 {
+					if writeTimeout > 0 {
+						conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+					}
+					if _, werr := w.Write(buf[:n]); werr != nil {
+						return
+					}
+					if werr := w.Flush(); werr != nil {
+						return // client went away
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	// Job status endpoints
+	api.Get("/jobs", func(c *fiber.Ctx) error {
+		jobs, err := jobRepo.ListRecent(c.UserContext(), 20)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": jobs})
+	})
+
+	// Metrics of recent runs of a job type, for trend tracking
+	api.Get("/jobs/metrics", func(c *fiber.Ctx) error {
+		jobType := entity.JobType(c.Query("job_type", string(entity.JobTypeRecalculateAll)))
+		since := time.Now().AddDate(0, 0, -c.QueryInt("days", 30))
+		jobs, err := jobRepo.ListWithMetrics(c.UserContext(), jobType, since, c.QueryInt("limit", 100))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": costing.SummarizeJobMetrics(jobType, jobs)})
+	})
+
+	api.Get("/jobs/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		job, err := jobRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		response := fiber.Map{
+			"job":      job,
+			"progress": job.Progress(),
+		}
+		// Partitioned jobs report each replica's share; the job's counts are their sums
+		partitions, err := partitionRepo.ListByJob(c.UserContext(), id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if len(partitions) > 0 {
+			response["partitions"] = partitions
+		}
+		chunks, err := chunkRepo.CountByStatus(c.UserContext(), id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if len(chunks) > 0 {
+			response["chunks"] = chunks
+		}
+		if info, ok := job.ExportInfo(); ok && info.File != "" && job.Status == entity.JobStatusCompleted {
+			response["download_url"] = exportDownloadURL(job.ID)
+		}
+		return c.JSON(response)
+	})
+
+	api.Get("/jobs/:id/metrics", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		job, err := jobRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		metrics, ok := job.Metrics()
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "no metrics recorded for this job"})
+		}
+		return c.JSON(fiber.Map{"data": metrics})
+	})
+
+	// Events lists the job's status transitions and progress milestones, oldest first
+	api.Get("/jobs/:id/events", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if _, err := jobRepo.GetByID(c.UserContext(), id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		events, err := jobEventRepo.ListByJob(c.UserContext(), id, c.QueryInt("limit", 500))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": events})
+	})
+
+	// Stream pushes the job's progress as Server-Sent Events every interval_ms (default
+	// 1000) until it finishes or the client disconnects
+	api.Get("/jobs/:id/stream", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if _, err := jobRepo.GetByID(c.UserContext(), id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		interval := time.Duration(max(c.QueryInt("interval_ms", 1000), 250)) * time.Millisecond
+
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+
+		// The server's write timeout is set once per response, so extend it for every event
+		// The writer runs after the handler returns, when c may be reused
+		ctx := c.UserContext()
+		conn := c.Context().Conn()
+		writeTimeout := app.Config().WriteTimeout
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			var meter costing.ProgressMeter
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				job, err := jobRepo.GetByID(ctx, id)
+				if err != nil {
+					fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+					w.Flush()
+					return
+				}
+				data, _ := json.Marshal(meter.Next(job, time.Now()))
+				event := "progress"
+				if job.Status.Finished() {
+					event = "done"
+				}
+
+				if writeTimeout > 0 {
+					conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+				if err := w.Flush(); err != nil || job.Status.Finished() {
+					return // client went away, or nothing more to report
+				}
+				<-ticker.C
+			}
+		})
+		return nil
+	})
+
+	// Pause stops a job after the variants already dispatched are written (a running job
+	// notices within a few seconds); resume queues it to continue from its checkpoint
+	api.Post("/jobs/:id/pause", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		job, err := jobRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		if err := jobRepo.Pause(c.UserContext(), id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(409).JSON(fiber.Map{"error": "only PENDING or RUNNING jobs can be paused, job is " + string(job.Status)})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(fiber.Map{"job_id": id, "status": entity.JobStatusPaused})
+	})
+
+	api.Post("/jobs/:id/resume", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		job, err := jobRepo.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		if err := jobRepo.Resume(c.UserContext(), id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(409).JSON(fiber.Map{"error": "only PAUSED or FAILED jobs can be resumed, job is " + string(job.Status)})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := jobEvents.Notify(c.UserContext(), id.String()); err != nil {
+			log.Printf("Failed to notify workers: %v", err)
+		}
+		response := fiber.Map{"job_id": id, "status": entity.JobStatusPending}
+		if checkpoint, ok := job.Checkpoint(); ok {
+			response["checkpoint"] = checkpoint
+		}
+		return c.Status(202).JSON(response)
+	})
+
+	// Job schedule endpoints: worker instances create the scheduled jobs
+	api.Get("/job-schedules", func(c *fiber.Ctx) error {
+		schedules, err := scheduleRepo.List(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": schedules})
+	})
+
+	api.Post("/job-schedules", func(c *fiber.Ctx) error {
+		var schedule entity.JobSchedule
+		if err := c.BodyParser(&schedule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if schedule.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		switch schedule.JobType {
+		case entity.JobTypeRecalculateAll:
+		case entity.JobTypeRecalculateVariant:
+			if _, err := uuid.Parse(fmt.Sprint(schedule.Params["variant_id"])); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "params.variant_id is required for " + string(schedule.JobType)})
+			}
+		case entity.JobTypeRecalculateRouting:
+			if _, err := uuid.Parse(fmt.Sprint(schedule.Params["routing_template_id"])); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "params.routing_template_id is required for " + string(schedule.JobType)})
+			}
+		case entity.JobTypeArchiveHistory:
+			if keep, ok := schedule.Params[entity.JobKeepVersionsKey]; ok {
+				if n, isNumber := keep.(float64); !isNumber || n < 1 || n != float64(int(n)) {
+					return c.Status(400).JSON(fiber.Map{"error": "params.keep_versions must be a positive integer"})
+				}
+			}
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "unsupported job_type " + string(schedule.JobType)})
+		}
+		if err := costing.ValidateOverrides(schedule.Params, cfg.Worker.MaxWorkerCount, cfg.Worker.MaxBatchSize); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "params: " + err.Error()})
+		}
+		if schedule.Timezone == "" {
+			schedule.Timezone = "UTC"
+		}
+
+		now := time.Now()
+		nextRunAt, err := costing.NextRun(schedule.CronExpression, schedule.Timezone, now)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		schedule.ID = uuid.New()
+		schedule.IsActive = true
+		schedule.NextRunAt = nextRunAt
+		schedule.LastRunAt = nil
+		schedule.LastJobID = nil
+		schedule.CreatedAt = now
+		if err := scheduleRepo.Create(c.UserContext(), &schedule); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(schedule)
+	})
+
+	api.Delete("/job-schedules/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if err := scheduleRepo.Delete(c.UserContext(), id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	// Webhook endpoints: subscribers get a signed callback when a job completes or fails
+	api.Get("/webhooks", func(c *fiber.Ctx) error {
+		subs, err := webhookRepo.ListSubscriptions(c.UserContext())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": subs})
+	})
+
+	// The secret is returned only here; one is generated when none is given
+	api.Post("/webhooks", func(c *fiber.Ctx) error {
+		var req webhookRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		sub, err := req.toEntity()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := webhookRepo.CreateSubscription(c.UserContext(), sub); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(fiber.Map{"data": sub, "secret": sub.Secret})
+	})
+
+	api.Delete("/webhooks/:id", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if err := webhookRepo.DeleteSubscription(c.UserContext(), id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Status(404).JSON(fiber.Map{"error": "not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	api.Get("/webhooks/:id/deliveries", func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+		}
+		if _, err := webhookRepo.GetSubscription(c.UserContext(), id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		deliveries, err := webhookRepo.ListDeliveries(c.UserContext(), id, c.QueryInt("limit", 50))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"data": deliveries})
+	})
+
+	// Cache endpoints: invalidation is broadcast to every API and worker process,
+	// e.g. after loading new price rates
+	api.Post("/cache/invalidate", func(c *fiber.Ctx) error {
+		reason := c.Query("reason", "manual")
+		if err := cacheEvents.Notify(c.UserContext(), reason); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(fiber.Map{"message": "Cache invalidation broadcast"})
+	})
+
+	api.Get("/cache/stats", func(c *fiber.Ctx) error {
+		return c.JSON(routingCache.Stats())
+	})
+
+	// Stats endpoint
+	api.Get("/stats", func(c *fiber.Ctx) error {
+		masterCount, _ := readMasterRepo.Count(c.UserContext())
+		variantCount, _ := readVariantRepo.Count(c.UserContext())
+		return c.JSON(fiber.Map{
+			"master_yarns":  masterCount,
+			"yarn_variants": variantCount,
+			"parallelism": fiber.Map{
+				"runtime": procsInfo,
+				"workers": cfg.Worker.Count,
+				"writers": cfg.Worker.WriterCount,
+			},
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	})
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-quit
+		log.Println("Shutting down server...")
+		app.Shutdown()
+	}()
+
+	// Start server
+	log.Printf("Starting API server on :%s", cfg.App.Port)
+	if err := app.Listen(":" + cfg.App.Port); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+}
+
+// masterYarnRequest is the request body for creating or importing a master yarn
+type masterYarnRequest struct {
+	Code        string                 `json:"code"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	FixedAttrs  map[string]interface{} `json:"fixed_attrs"`
+}
+
+func (r masterYarnRequest) toEntity() *entity.MasterYarn {
+	now := time.Now()
+	return &entity.MasterYarn{
+		ID:          uuid.New(),
+		Code:        r.Code,
+		Name:        r.Name,
+		Description: r.Description,
+		FixedAttrs:  r.FixedAttrs,
+		IsActive:    true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// webhookRequest is the request body for creating a webhook subscription
+type webhookRequest struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"` // empty subscribes to every event type
+}
+
+func (r webhookRequest) toEntity() (*entity.WebhookSubscription, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("url must be an absolute http or https URL")
+	}
+	eventTypes := r.EventTypes
+	if len(eventTypes) == 0 {
+		eventTypes = entity.WebhookEventTypes
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(entity.WebhookEventTypes, t) {
+			return nil, fmt.Errorf("unsupported event type %q (supported: %s)", t, strings.Join(entity.WebhookEventTypes, ", "))
+		}
+	}
+	secret := r.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(key)
+	}
+	return &entity.WebhookSubscription{
+		ID:         uuid.New(),
+		URL:        r.URL,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		IsActive:   true,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// restructureError maps master merge/split failures to HTTP responses
+func restructureError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+}
+
+// searchQuery parses the repeated ?filter= and the ?cursor= of a search
+func searchQuery(c *fiber.Ctx) ([]entity.Filter, entity.Cursor, error) {
+	var filters []entity.Filter
+	for _, value := range c.Context().QueryArgs().PeekMulti("filter") {
+		filter, err := entity.ParseFilter(string(value))
+		if err != nil {
+			return nil, entity.Cursor{}, err
+		}
+		filters = append(filters, filter)
+	}
+	cursor, err := entity.ParseCursor(c.Query("cursor"))
+	return filters, cursor, err
+}
+
+// searchError maps search failures to HTTP responses
+func searchError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, repository.ErrInvalidFilter) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// nextCursor returns the cursor of the page after page, or "" when page is not full and
+// so is the last
+func nextCursor[T any](page []T, limit int, position func(T) entity.Cursor) string {
+	if len(page) == 0 || len(page) < limit {
+		return ""
+	}
+	return position(page[len(page)-1]).String()
+}
+
+// exportDownloadURL returns where the file of an export job is downloaded from
+func exportDownloadURL(jobID uuid.UUID) string {
+	return "/api/v1/exports/" + jobID.String() + "/download"
+}
+
+// queryOverrides copies the ?worker_count= and ?batch_size= overrides of a recalculation
+// into its job metadata and checks them against the configured maximums
+func queryOverrides(c *fiber.Ctx, metadata map[string]interface{}, maxWorkerCount, maxBatchSize int) error {
+	for _, key := range []string{entity.JobWorkerCountKey, entity.JobBatchSizeKey} {
+		if value := c.Query(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New(key + " must be a whole number")
+			}
+			metadata[key] = n
+		}
 	}
+	return costing.ValidateOverrides(metadata, maxWorkerCount, maxBatchSize)
 }
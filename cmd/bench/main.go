@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+
+	"github.com/ilramdhan/costing-mvp/config"
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/internal/infrastructure/cache"
+	"github.com/ilramdhan/costing-mvp/internal/infrastructure/persistence"
+	"github.com/ilramdhan/costing-mvp/internal/modules/costing"
+	"github.com/ilramdhan/costing-mvp/pkg/database"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+	"github.com/ilramdhan/costing-mvp/pkg/procs"
+)
+
+// benchReport is the result of a benchmark, comparable across releases run on the same
+// seeded database and hardware
+type benchReport struct {
+	Label         string        `json:"label"`
+	StartedAt     time.Time     `json:"started_at"`
+	GoVersion     string        `json:"go_version"`
+	ServerVersion int           `json:"server_version"`
+	Procs         int           `json:"procs"`
+	Writers       int           `json:"writers"`
+	Scanners      int           `json:"scanners"`
+	UpsertMode    string        `json:"upsert_mode"`
+	Variants      int64         `json:"variants"`
+	Results       []benchResult `json:"results"`
+}
+
+// benchResult is one worker count and batch size configuration, run Rounds times
+type benchResult struct {
+	Workers          int                  `json:"workers"`
+	BatchSize        int                  `json:"batch_size"`
+	MedianThroughput float64              `json:"median_throughput"` // variants per second
+	MedianElapsed    float64              `json:"median_elapsed_seconds"`
+	Runs             []*entity.JobMetrics `json:"runs"`
+}
+
+func main() {
+	godotenv.Load()
+
+	label := flag.String("label", "", "Name for the report, e.g. the release or commit")
+	workers := flag.String("workers", "", "Comma-separated worker counts (default WORKER_COUNT)")
+	batchSizes := flag.String("batch-sizes", "", "Comma-separated batch sizes (default BATCH_SIZE)")
+	rounds := flag.Int("rounds", 3, "Runs of each configuration; the median is reported")
+	format := flag.String("format", "markdown", "Report format: markdown or json")
+	out := flag.String("o", "", "Output file (default stdout)")
+	flag.Parse()
+
+	if *rounds <= 0 || (*format != "markdown" && *format != "json") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	cfg := config.Load()
+	ctx := context.Background()
+
+	procsInfo := procs.Apply(cfg.Worker.MaxProcs)
+	cfg.Worker.Resolve(procsInfo.GOMAXPROCS)
+	log.Printf("Effective parallelism: %s", procsInfo)
+
+	workerCounts, err := parseCounts(*workers, cfg.Worker.Count)
+	if err != nil {
+		log.Fatalf("Invalid -workers: %v", err)
+	}
+	sizes, err := parseCounts(*batchSizes, cfg.Worker.BatchSize)
+	if err != nil {
+		log.Fatalf("Invalid -batch-sizes: %v", err)
+	}
+
+	cfg.Database.ApplicationName = "costing-bench"
+	pool, err := database.NewPool(ctx, &cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	upsertMode, err := persistence.ResolveSummaryUpsertMode(ctx, pool, cfg.Database.SummaryUpsert)
+	if err != nil {
+		log.Fatalf("Failed to resolve summary upsert mode: %v", err)
+	}
+	serverVersion, err := persistence.ServerVersion(ctx, pool)
+	if err != nil {
+		log.Fatalf("Failed to detect server version: %v", err)
+	}
+
+	masterYarnRepo := persistence.NewMasterYarnRepository(pool)
+	variantRepo := persistence.NewYarnVariantRepository(pool)
+	processStepRepo := persistence.NewProcessStepRepository(pool)
+	dbRetry := database.NewRetryPolicy(&cfg.Database)
+	costRepo := persistence.NewVariantProcessCostRepository(pool, dbRetry)
+	summaryStore, err := cache.OpenSummaryStore(ctx, &cfg.SummaryCache, persistence.NewSummaryEvents(pool))
+	if err != nil {
+		log.Fatalf("Failed to open summary cache: %v", err)
+	}
+	summaryRepo := cache.NewSummaryRepository(persistence.NewVariantCostSummaryRepository(pool, upsertMode, dbRetry), summaryStore)
+	jobRepo := persistence.NewBatchJobRepository(pool)
+	rateRepo := persistence.NewPriceRateRepository(pool)
+
+	parserOpts := []formula.Option{
+		formula.WithMaxLength(cfg.Formula.MaxLength),
+		formula.WithMaxNodes(cfg.Formula.MaxNodes),
+		formula.WithTimeout(cfg.Formula.EvalTimeout),
+	}
+	if cfg.Formula.DivByZeroFallback != nil {
+		parserOpts = append(parserOpts, formula.WithDivByZeroFallback(*cfg.Formula.DivByZeroFallback))
+	}
+	parser := formula.NewParser(parserOpts...)
+	engine := costing.NewCalculationEngine(masterYarnRepo, variantRepo, processStepRepo, costRepo, summaryRepo, parser)
+
+	// Warm the cache once so every run measures recalculation rather than the first load
+	routingCache := costing.NewRoutingCache(variantRepo, processStepRepo, rateRepo, parser)
+	if err := routingCache.Warm(ctx); err != nil {
+		log.Fatalf("Failed to warm cache: %v", err)
+	}
+	baseParams, err := routingCache.BaseParams(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load base params: %v", err)
+	}
+
+	workerTuning := costing.WorkerTuning{MaxWorkers: cfg.Worker.MaxWorkerCount}
+	if cfg.Worker.AutoWorkers {
+		workerTuning.Window = cfg.Worker.AutoTuneWindow
+	}
+	batchTuning := costing.BatchTuning{TargetLatency: cfg.Worker.BatchTargetLatency, MinSize: cfg.Worker.MinBatchSize}
+	workerPool := costing.NewWorkerPool(engine, variantRepo, summaryRepo, jobRepo,
+		persistence.NewJobPartitionRepository(pool), persistence.NewJobChunkRepository(pool), persistence.NewVariantDeadLetterRepository(pool),
+		routingCache, cfg.Worker.Count, cfg.Worker.WriterCount, cfg.Worker.ScanCount, cfg.Worker.BatchSize, cfg.Worker.DeadLetterAfter,
+		batchTuning, costing.WriteThrottle{}, workerTuning)
+
+	report := benchReport{
+		Label:         *label,
+		StartedAt:     time.Now(),
+		GoVersion:     runtime.Version(),
+		ServerVersion: serverVersion,
+		Procs:         procsInfo.GOMAXPROCS,
+		Writers:       cfg.Worker.WriterCount,
+		Scanners:      cfg.Worker.ScanCount,
+		UpsertMode:    upsertMode,
+	}
+	for _, workerCount := range workerCounts {
+		for _, batchSize := range sizes {
+			result := benchResult{Workers: workerCount, BatchSize: batchSize}
+			for round := 1; round <= *rounds; round++ {
+				log.Printf("Running %d workers, batch size %d, round %d/%d", workerCount, batchSize, round, *rounds)
+				metrics, total := runOnce(ctx, jobRepo, workerPool, workerCount, batchSize, baseParams)
+				report.Variants = total
+				result.Runs = append(result.Runs, metrics)
+			}
+			result.MedianThroughput = median(result.Runs, func(m *entity.JobMetrics) float64 { return m.Throughput })
+			result.MedianElapsed = median(result.Runs, func(m *entity.JobMetrics) float64 { return m.ElapsedSeconds })
+			report.Results = append(report.Results, result)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeMarkdown(w, &report)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+// runOnce runs a full recalculation as a job claimed by the benchmark, with the worker
+// count and batch size as the job's overrides, and returns its metrics and total
+func runOnce(ctx context.Context, jobRepo repository.BatchJobRepository, workerPool *costing.WorkerPool, workerCount, batchSize int, baseParams map[string]interface{}) (*entity.JobMetrics, int64) {
+	now := time.Now()
+	job := &entity.BatchJob{
+		ID:          uuid.New(),
+		JobType:     entity.JobTypeRecalculateAll,
+		Status:      entity.JobStatusRunning,
+		MaxAttempts: 1,
+		Attempts:    1,
+		ClaimedBy:   "costing-bench",
+		ClaimedAt:   &now,
+		HeartbeatAt: &now,
+		StartedAt:   &now,
+		CreatedAt:   now,
+		Metadata: map[string]interface{}{
+			entity.JobWorkerCountKey: workerCount,
+			entity.JobBatchSizeKey:   batchSize,
+			"benchmark":              true,
+		},
+	}
+	if err := jobRepo.Create(ctx, job); err != nil {
+		if errors.Is(err, repository.ErrExclusiveJobRunning) {
+			log.Fatalf("A full recalculation is already running; stop the workers before benchmarking")
+		}
+		log.Fatalf("Failed to create job: %v", err)
+	}
+
+	if err := workerPool.RecalculateAll(ctx, job.ID, baseParams); err != nil {
+		jobRepo.Fail(ctx, job.ID, err.Error())
+		log.Fatalf("Recalculation failed: %v", err)
+	}
+
+	done, err := jobRepo.GetByID(ctx, job.ID)
+	if err != nil {
+		log.Fatalf("Failed to read job %s: %v", job.ID, err)
+	}
+	metrics, ok := done.Metrics()
+	if !ok {
+		log.Fatalf("Job %s recorded no metrics", job.ID)
+	}
+	return metrics, done.TotalRecords
+}
+
+// parseCounts parses a comma-separated list of positive counts, def when s is empty
+func parseCounts(s string, def int) ([]int, error) {
+	if s == "" {
+		return []int{def}, nil
+	}
+	var counts []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%q is not a positive count", part)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// median returns the median of value over runs
+func median(runs []*entity.JobMetrics, value func(*entity.JobMetrics) float64) float64 {
+	values := make([]float64, len(runs))
+	for i, m := range runs {
+		values[i] = value(m)
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+func writeMarkdown(w io.Writer, report *benchReport) error {
+	title := "Recalculation benchmark"
+	if report.Label != "" {
+		title += ": " + report.Label
+	}
+	fmt.Fprintf(w, "# %s\n\n", title)
+	fmt.Fprintf(w, "%s, %s, PostgreSQL %d, %d procs, %d writers, %d scanners, %s upserts, %d variants\n\n",
+		report.StartedAt.Format(time.RFC3339), report.GoVersion, report.ServerVersion, report.Procs,
+		report.Writers, report.Scanners, report.UpsertMode, report.Variants)
+	fmt.Fprintln(w, "| Workers | Batch size | Runs | Variants/sec | Elapsed (s) | Peak heap (MB) | Batch sizes | Stalled (s) |")
+	fmt.Fprintln(w, "|--------:|-----------:|-----:|-------------:|------------:|---------------:|------------:|------------:|")
+	for _, r := range report.Results {
+		var peak uint64
+		low, high := r.BatchSize, 0
+		var stalled float64
+		for _, m := range r.Runs {
+			peak = max(peak, m.HeapPeakBytes)
+			low, high = min(low, m.MinBatchSize), max(high, m.MaxBatchSize)
+			stalled += m.StalledSeconds
+		}
+		_, err := fmt.Fprintf(w, "| %d | %d | %d | %.0f | %.1f | %.1f | %d-%d | %.1f |\n",
+			r.Workers, r.BatchSize, len(r.Runs), r.MedianThroughput, r.MedianElapsed,
+			float64(peak)/(1<<20), low, high, stalled/float64(len(r.Runs)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
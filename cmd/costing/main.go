@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ilramdhan/costing-mvp/config"
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/internal/infrastructure/cache"
+	"github.com/ilramdhan/costing-mvp/internal/infrastructure/persistence"
+	"github.com/ilramdhan/costing-mvp/internal/modules/engineering"
+	"github.com/ilramdhan/costing-mvp/pkg/database"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+func main() {
+	godotenv.Load()
+
+	backfillCmd := flag.NewFlagSet("backfill", flag.ExitOnError)
+	field := backfillCmd.String("field", "", "Summary field to backfill ("+strings.Join(persistence.SummaryBackfillFields(), ", ")+")")
+	batchSize := backfillCmd.Int("batch-size", 5000, "Summaries updated per statement")
+
+	benchCmd := flag.NewFlagSet("bench-upsert", flag.ExitOnError)
+	benchRows := benchCmd.Int("rows", 100000, "Existing summaries to write back")
+	benchBatchSize := benchCmd.Int("batch-size", 5000, "Summaries per upsert")
+	benchRounds := benchCmd.Int("rounds", 3, "Times each mode writes all rows")
+
+	exportCmd := flag.NewFlagSet("config export", flag.ExitOnError)
+	exportOut := exportCmd.String("o", "", "Output file (default stdout)")
+
+	applyCmd := flag.NewFlagSet("config apply", flag.ExitOnError)
+	applyFile := applyCmd.String("f", "", "Configuration file to apply")
+	dryRun := applyCmd.Bool("dry-run", false, "Print the diff without applying it")
+	applyActor := applyCmd.String("actor", os.Getenv("USER"), "Who is applying, recorded in the audit log")
+
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: costing <command>")
+		fmt.Println("Commands: backfill, bench-upsert, config export, config apply")
+		os.Exit(1)
+	}
+
+	cfg := config.Load()
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "backfill":
+		backfillCmd.Parse(os.Args[2:])
+		if *field == "" || *batchSize <= 0 {
+			backfillCmd.Usage()
+			os.Exit(1)
+		}
+
+		pool := connect(ctx, cfg)
+		defer pool.Close()
+
+		// Backfilled summaries are dropped from the caches of running services
+		summaryStore, err := cache.OpenSummaryStore(ctx, &cfg.SummaryCache, persistence.NewSummaryEvents(pool))
+		if err != nil {
+			log.Fatalf("Failed to open summary cache: %v", err)
+		}
+		runBackfill(ctx, cache.NewSummaryRepository(persistence.NewVariantCostSummaryRepository(pool, persistence.SummaryUpsertInsert, database.NewRetryPolicy(&cfg.Database)), summaryStore), *field, *batchSize)
+	case "bench-upsert":
+		benchCmd.Parse(os.Args[2:])
+		if *benchRows <= 0 || *benchBatchSize <= 0 || *benchRounds <= 0 {
+			benchCmd.Usage()
+			os.Exit(1)
+		}
+
+		pool := connect(ctx, cfg)
+		defer pool.Close()
+
+		runBenchUpsert(ctx, pool, *benchRows, *benchBatchSize, *benchRounds)
+	case "config":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: costing config <export|apply>")
+			os.Exit(1)
+		}
+
+		switch os.Args[2] {
+		case "export":
+			exportCmd.Parse(os.Args[3:])
+		case "apply":
+			applyCmd.Parse(os.Args[3:])
+			if *applyFile == "" {
+				applyCmd.Usage()
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("Unknown config command: %s\n", os.Args[2])
+			os.Exit(1)
+		}
+
+		pool := connect(ctx, cfg)
+		defer pool.Close()
+		configService := engineering.NewConfigService(persistence.NewConfigRepository(pool), newParser(cfg))
+
+		if os.Args[2] == "export" {
+			runConfigExport(ctx, configService, *exportOut)
+		} else {
+			runConfigApply(repository.WithActor(ctx, *applyActor), configService, persistence.NewCacheEvents(pool), *applyFile, *dryRun)
+		}
+	default:
+		fmt.Printf("Unknown command: %s\n", os.Args[1])
+		os.Exit(1)
+	}
+}
+
+func connect(ctx context.Context, cfg *config.Config) *pgxpool.Pool {
+	pool, err := database.NewPool(ctx, &cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	return pool
+}
+
+// newParser creates a parser with the configured complexity limits, which apply
+// validates expressions against
+func newParser(cfg *config.Config) *formula.Parser {
+	return formula.NewParser(
+		formula.WithMaxLength(cfg.Formula.MaxLength),
+		formula.WithMaxNodes(cfg.Formula.MaxNodes),
+		formula.WithTimeout(cfg.Formula.EvalTimeout),
+	)
+}
+
+// runConfigExport writes the stored costing configuration as YAML
+func runConfigExport(ctx context.Context, configService *engineering.ConfigService, out string) {
+	costingConfig, err := configService.Export(ctx)
+	if err != nil {
+		log.Fatalf("Failed to export configuration: %v", err)
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(costingConfig); err != nil {
+		log.Fatalf("Failed to encode configuration: %v", err)
+	}
+
+	if out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(out, buf.Bytes(), 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", out, err)
+	}
+	log.Printf("Exported configuration to %s", out)
+}
+
+// runConfigApply diffs a YAML costing configuration against the database and, unless
+// dryRun, applies it and tells running services to drop their caches
+func runConfigApply(ctx context.Context, configService *engineering.ConfigService, cacheEvents *persistence.NotifyChannel, file string, dryRun bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", file, err)
+	}
+	var desired entity.CostingConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&desired); err != nil {
+		log.Fatalf("Failed to parse %s: %v", file, err)
+	}
+	if desired.Version != persistence.CostingConfigVersion {
+		log.Fatalf("Unsupported configuration version %d, expected %d", desired.Version, persistence.CostingConfigVersion)
+	}
+
+	var changes []*entity.ConfigChange
+	if dryRun {
+		changes, err = configService.Plan(ctx, &desired)
+	} else {
+		changes, err = configService.Apply(ctx, &desired)
+	}
+	if err != nil {
+		log.Fatalf("Failed to apply %s: %v", file, err)
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	switch {
+	case len(changes) == 0:
+		log.Println("No changes")
+	case dryRun:
+		log.Printf("%d change(s) planned; run without --dry-run to apply", len(changes))
+	default:
+		log.Printf("Applied %d change(s)", len(changes))
+		if err := cacheEvents.Notify(ctx, "costing config applied"); err != nil {
+			log.Printf("Failed to notify cache invalidation: %v", err)
+		}
+	}
+}
+
+// runBackfill fills a newly added summary field from the stored per-step costs in
+// batches, without recalculating any formulas
+func runBackfill(ctx context.Context, summaryRepo repository.VariantCostSummaryRepository, field string, batchSize int) {
+	missing, err := summaryRepo.CountMissing(ctx, field)
+	if err != nil {
+		log.Fatalf("Failed to count summaries missing %s: %v", field, err)
+	}
+	log.Printf("Backfilling %s for %d summaries...", field, missing)
+
+	start := time.Now()
+	var total int64
+	for {
+		updated, err := summaryRepo.Backfill(ctx, field, batchSize)
+		if err != nil {
+			log.Fatalf("Backfill failed after %d summaries: %v", total, err)
+		}
+		if updated == 0 {
+			break
+		}
+		total += updated
+		log.Printf("   %d/%d summaries updated", total, missing)
+	}
+
+	remaining, err := summaryRepo.CountMissing(ctx, field)
+	if err != nil {
+		log.Fatalf("Failed to count summaries missing %s: %v", field, err)
+	}
+	log.Printf("Backfilled %s for %d summaries in %v", field, total, time.Since(start).Round(time.Millisecond))
+	if remaining > 0 {
+		log.Printf("%d summaries have no stored per-step costs; run a full recalculation to fill them", remaining)
+	}
+}
+
+// runBenchUpsert writes up to rows existing summaries back unchanged with each summary
+// upsert mode the server supports and reports their throughput. Summaries keep their
+// values; only updated_at changes.
+func runBenchUpsert(ctx context.Context, pool *pgxpool.Pool, rows, batchSize, rounds int) {
+	version, err := persistence.ServerVersion(ctx, pool)
+	if err != nil {
+		log.Fatalf("Failed to detect server version: %v", err)
+	}
+
+	var batches [][]*entity.VariantCostSummary
+	loader := persistence.NewVariantCostSummaryRepository(pool, persistence.SummaryUpsertInsert, database.RetryPolicy{})
+	var cursor entity.Cursor
+	for loaded := 0; loaded < rows; {
+		batch, err := loader.ListAfter(ctx, cursor, min(batchSize, rows-loaded))
+		if err != nil {
+			log.Fatalf("Failed to load summaries: %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		batches = append(batches, batch)
+		loaded += len(batch)
+		last := batch[len(batch)-1]
+		cursor = entity.Cursor{CreatedAt: last.CreatedAt, ID: last.YarnVariantID}
+	}
+	if len(batches) == 0 {
+		log.Fatalf("No summaries to write; run a recalculation first")
+	}
+	total := (len(batches)-1)*batchSize + len(batches[len(batches)-1])
+	log.Printf("Writing %d summaries in batches of %d, %d rounds per mode, server version %d", total, batchSize, rounds, version)
+
+	for _, mode := range []string{persistence.SummaryUpsertInsert, persistence.SummaryUpsertMerge} {
+		resolved, err := persistence.ResolveSummaryUpsertMode(ctx, pool, mode)
+		if err != nil {
+			log.Printf("%-6s skipped: %v", mode, err)
+			continue
+		}
+		// No retries: a retried batch would count twice in the timings
+		summaryRepo := persistence.NewVariantCostSummaryRepository(pool, resolved, database.RetryPolicy{})
+
+		start := time.Now()
+		for round := 0; round < rounds; round++ {
+			for _, batch := range batches {
+				if _, err := summaryRepo.UpsertBatch(ctx, batch); err != nil {
+					log.Fatalf("%s upsert failed: %v", mode, err)
+				}
+			}
+		}
+		elapsed := time.Since(start)
+		written := total * rounds
+		log.Printf("%-6s %d rows in %v: %.0f rows/sec, %v per batch", mode, written, elapsed.Round(time.Millisecond),
+			float64(written)/elapsed.Seconds(), (elapsed / time.Duration(len(batches)*rounds)).Round(time.Microsecond))
+	}
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 
@@ -17,159 +20,287 @@ import (
 	"github.com/ilramdhan/costing-mvp/pkg/database"
 )
 
+// commands lists the commands and their arguments for the usage message
+const commands = "up [--to version], down [--steps n | --to version], status, plan [--to version], baseline <version>, force <version> [--yes], repair [--yes], create <name>"
+
 func main() {
-	godotenv.Load()
+	dir := flag.String("dir", "", "Migrations directory (default DB_MIGRATIONS_DIR, else the migrations built in)")
+	dsn := flag.String("dsn", "", "Connection string of the database to migrate (default DATABASE_URL, else DB_HOST and the other DB_ settings)")
+	envFile := flag.String("env-file", ".env", "Environment file to load, e.g. .env.staging")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: migrate [--dir path] [--dsn url] [--env-file path] <command>")
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands: "+commands)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// A missing default .env is fine; a missing file asked for by name is not
+	if err := godotenv.Load(*envFile); err != nil && *envFile != ".env" {
+		log.Fatalf("Failed to load %s: %v", *envFile, err)
+	}
 
 	upCmd := flag.NewFlagSet("up", flag.ExitOnError)
+	upTo := upCmd.String("to", "", "Apply pending migrations up to and including this version")
 	downCmd := flag.NewFlagSet("down", flag.ExitOnError)
+	downTo := downCmd.String("to", "", "Roll back every migration applied after this version (0 for all)")
+	downSteps := downCmd.Int("steps", 0, "Roll back this many of the latest applied migrations (default 1)")
 	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	planCmd := flag.NewFlagSet("plan", flag.ExitOnError)
+	planTo := planCmd.String("to", "", "Plan pending migrations up to and including this version")
+	planSQL := planCmd.Bool("sql", true, "Print the SQL of each migration")
+	baselineCmd := flag.NewFlagSet("baseline", flag.ExitOnError)
+	forceCmd := flag.NewFlagSet("force", flag.ExitOnError)
+	forceYes := forceCmd.Bool("yes", false, "Apply without asking for confirmation")
+	repairCmd := flag.NewFlagSet("repair", flag.ExitOnError)
+	repairYes := repairCmd.Bool("yes", false, "Apply without asking for confirmation")
+	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
 
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: migrate <command>")
-		fmt.Println("Commands: up, down, status")
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
+	command, args := flag.Arg(0), flag.Args()[1:]
 
 	cfg := config.Load()
+	if *dir != "" {
+		cfg.Database.MigrationsDir = *dir
+	}
+	if *dsn != "" {
+		cfg.Database.URL = *dsn
+	}
 	ctx := context.Background()
 
+	// Creating migration files needs no database. They go to the source tree, as the
+	// built-in migrations are the ones there when the binary was built.
+	if command == "create" {
+		createCmd.Parse(args)
+		createDir := cfg.Database.MigrationsDir
+		if createDir == "" {
+			createDir = "migrations"
+		}
+		createMigration(createDir, strings.Join(createCmd.Args(), " "))
+		return
+	}
+
+	// Name the target, so a run against the wrong environment is noticed
+	if target, err := pgconn.ParseConfig(cfg.Database.DSN()); err == nil {
+		log.Printf("Target database: %s on %s:%d as %s", target.Database, target.Host, target.Port, target.User)
+	}
+	if cfg.Database.MigrationsDir != "" {
+		log.Printf("Migrations from %s", cfg.Database.MigrationsDir)
+	}
+
 	pool, err := database.NewPool(ctx, &cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer pool.Close()
 
-	// Ensure migrations table exists
-	ensureMigrationsTable(ctx, pool)
+	// Ensure migrations table exists; plan changes nothing, so it leaves a missing one
+	if command != "plan" {
+		if err := database.EnsureMigrationsTable(ctx, pool); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	switch os.Args[1] {
+	migrations := database.Migrations(&cfg.Database)
+	switch command {
 	case "up":
-		upCmd.Parse(os.Args[2:])
-		runMigrationsUp(ctx, pool)
+		upCmd.Parse(args)
+		runMigrationsUp(ctx, pool, migrations, *upTo)
 	case "down":
-		downCmd.Parse(os.Args[2:])
-		runMigrationsDown(ctx, pool)
+		downCmd.Parse(args)
+		if *downSteps == 0 && *downTo == "" {
+			*downSteps = 1
+		}
+		runMigrationsDown(ctx, pool, migrations, *downSteps, *downTo)
 	case "status":
-		statusCmd.Parse(os.Args[2:])
-		showMigrationStatus(ctx, pool)
+		statusCmd.Parse(args)
+		showMigrationStatus(ctx, pool, migrations)
+	case "plan":
+		planCmd.Parse(args)
+		showMigrationPlan(ctx, pool, migrations, *planTo, *planSQL)
+	case "baseline":
+		baselineCmd.Parse(args)
+		runBaseline(ctx, pool, migrations, baselineCmd.Arg(0))
+	case "force":
+		forceCmd.Parse(args)
+		if forceCmd.Arg(0) == "" {
+			fmt.Println("Usage: migrate force <version> [--yes]")
+			os.Exit(1)
+		}
+		fix, err := database.ForceMigrations(ctx, pool, migrations, forceCmd.Arg(0), confirmFix(*forceYes))
+		reportFix(fix, err)
+	case "repair":
+		repairCmd.Parse(args)
+		fix, err := database.RepairMigrations(ctx, pool, migrations, confirmFix(*repairYes))
+		reportFix(fix, err)
 	default:
-		fmt.Printf("Unknown command: %s\n", os.Args[1])
+		fmt.Printf("Unknown command: %s\n", command)
 		os.Exit(1)
 	}
 }
 
-func ensureMigrationsTable(ctx context.Context, pool *pgxpool.Pool) {
-	_, err := pool.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS schema_migrations (
-			version VARCHAR(255) PRIMARY KEY,
-			applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
+func runMigrationsUp(ctx context.Context, pool *pgxpool.Pool, migrations fs.FS, to string) {
+	if _, err := database.MigrateUpTo(ctx, pool, migrations, to); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runMigrationsDown(ctx context.Context, pool *pgxpool.Pool, migrations fs.FS, steps int, to string) {
+	rolledBack, err := database.MigrateDown(ctx, pool, migrations, steps, to)
 	if err != nil {
-		log.Fatalf("Failed to create migrations table: %v", err)
+		log.Fatal(err)
+	}
+	if rolledBack == 0 {
+		log.Println("No migrations to rollback")
 	}
 }
 
-func runMigrationsUp(ctx context.Context, pool *pgxpool.Pool) {
-	files, err := filepath.Glob("migrations/*.up.sql")
+func showMigrationStatus(ctx context.Context, pool *pgxpool.Pool, migrations fs.FS) {
+	files, err := fs.Glob(migrations, "*.up.sql")
 	if err != nil {
 		log.Fatalf("Failed to find migration files: %v", err)
 	}
 	sort.Strings(files)
+	records, err := database.MigrationRecords(ctx, pool)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dirty := make(map[string]bool, len(records))
+	for _, r := range records {
+		dirty[r.Version] = r.Dirty
+	}
 
+	fmt.Println("Migration Status:")
+	fmt.Println("=================")
 	for _, file := range files {
-		version := extractVersion(file)
-		if isApplied(ctx, pool, version) {
-			log.Printf("Skipping %s (already applied)", version)
-			continue
-		}
-
-		content, err := os.ReadFile(file)
-		if err != nil {
-			log.Fatalf("Failed to read %s: %v", file, err)
-		}
-
-		log.Printf("Applying %s...", version)
-		if _, err := pool.Exec(ctx, string(content)); err != nil {
-			log.Fatalf("Failed to apply %s: %v", file, err)
+		version := database.MigrationVersion(file)
+		status := "PENDING"
+		if isDirty, recorded := dirty[version]; isDirty {
+			status = "DIRTY"
+		} else if recorded {
+			status = "APPLIED"
 		}
-
-		if _, err := pool.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
-			log.Fatalf("Failed to record migration %s: %v", version, err)
+		fmt.Printf("[%s] %s\n", status, version)
+	}
+	// Records of migrations no longer on disk; repair removes them
+	for _, r := range records {
+		if !hasFile(files, r.Version) {
+			fmt.Printf("[MISSING] %s\n", r.Version)
 		}
-		log.Printf("Applied %s successfully", version)
 	}
 }
 
-func runMigrationsDown(ctx context.Context, pool *pgxpool.Pool) {
-	files, err := filepath.Glob("migrations/*.down.sql")
-	if err != nil {
-		log.Fatalf("Failed to find migration files: %v", err)
-	}
-	sort.Sort(sort.Reverse(sort.StringSlice(files)))
-
-	// Only rollback the latest migration
-	if len(files) == 0 {
-		log.Println("No migrations to rollback")
-		return
+// hasFile reports whether a file of files has version
+func hasFile(files []string, version string) bool {
+	for _, file := range files {
+		if database.MigrationVersion(file) == version {
+			return true
+		}
 	}
+	return false
+}
 
-	file := files[0]
-	version := extractVersion(file)
-	if !isApplied(ctx, pool, version) {
-		log.Printf("Migration %s is not applied", version)
-		return
+func runBaseline(ctx context.Context, pool *pgxpool.Pool, migrations fs.FS, version string) {
+	if version == "" {
+		fmt.Println("Usage: migrate baseline <version>")
+		os.Exit(1)
 	}
-
-	content, err := os.ReadFile(file)
+	recorded, err := database.MigrateBaseline(ctx, pool, migrations, version)
 	if err != nil {
-		log.Fatalf("Failed to read %s: %v", file, err)
+		log.Fatal(err)
 	}
+	log.Printf("Baselined at %s: %d migration(s) marked as applied", database.NormalizeVersion(version), recorded)
+}
 
-	log.Printf("Rolling back %s...", version)
-	if _, err := pool.Exec(ctx, string(content)); err != nil {
-		log.Fatalf("Failed to rollback %s: %v", file, err)
+// confirmFix returns the confirmation force and repair ask for: it prints the fix, then
+// accepts it when yes is set or the user types yes
+func confirmFix(yes bool) func(database.MigrationFix) bool {
+	return func(fix database.MigrationFix) bool {
+		fmt.Println("Changes to schema_migrations (no migration SQL runs):")
+		for _, version := range fix.Record {
+			fmt.Printf("  record %s as applied\n", version)
+		}
+		for _, version := range fix.Remove {
+			fmt.Printf("  remove %s\n", version)
+		}
+		for _, version := range fix.Clean {
+			fmt.Printf("  mark %s as applied and clean\n", version)
+		}
+		if yes {
+			return true
+		}
+		fmt.Print("Apply these changes? Type yes to continue: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			fmt.Println("Cancelled; nothing changed")
+			return false
+		}
+		return true
 	}
+}
 
-	if _, err := pool.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", version); err != nil {
-		log.Fatalf("Failed to remove migration record %s: %v", version, err)
+func reportFix(fix database.MigrationFix, err error) {
+	switch {
+	case err != nil:
+		log.Fatal(err)
+	case fix.Empty():
+		log.Println("schema_migrations already matches; nothing to change")
 	}
-	log.Printf("Rolled back %s successfully", version)
 }
 
-func showMigrationStatus(ctx context.Context, pool *pgxpool.Pool) {
-	files, err := filepath.Glob("migrations/*.up.sql")
+// showMigrationPlan prints what up would apply without applying it: each pending
+// migration, what its statements touch, and its SQL
+func showMigrationPlan(ctx context.Context, pool *pgxpool.Pool, migrations fs.FS, to string, withSQL bool) {
+	plan, err := database.MigratePlan(ctx, pool, migrations, to)
 	if err != nil {
-		log.Fatalf("Failed to find migration files: %v", err)
+		log.Fatal(err)
+	}
+	if len(plan) == 0 {
+		fmt.Println("No pending migrations")
+		return
 	}
-	sort.Strings(files)
 
-	fmt.Println("Migration Status:")
-	fmt.Println("=================")
-	for _, file := range files {
-		version := extractVersion(file)
-		status := "PENDING"
-		if isApplied(ctx, pool, version) {
-			status = "APPLIED"
+	fmt.Printf("%d pending migration(s):\n", len(plan))
+	for _, migration := range plan {
+		mode := "in a transaction"
+		if !migration.Transactional {
+			mode = "outside a transaction"
+		}
+		fmt.Printf("\n=== %s (%s)\n", migration.File, mode)
+		for _, statement := range migration.Statements {
+			line := statement.Action
+			if statement.Object != "" {
+				line += " " + statement.Object
+			}
+			if statement.Table != "" && statement.Table != statement.Object {
+				line += " ON " + statement.Table
+			}
+			switch {
+			case statement.EstimatedRows >= 0:
+				line += fmt.Sprintf("  (~%d rows)", statement.EstimatedRows)
+			case statement.Table != "":
+				line += "  (new or unanalyzed table)"
+			}
+			fmt.Println("  " + line)
+		}
+		if withSQL {
+			fmt.Println("--- SQL ---")
+			fmt.Println(strings.TrimSpace(migration.SQL))
 		}
-		fmt.Printf("[%s] %s\n", status, version)
 	}
 }
 
-func extractVersion(filename string) string {
-	base := filepath.Base(filename)
-	parts := strings.Split(base, "_")
-	if len(parts) > 0 {
-		return parts[0]
+func createMigration(dir, name string) {
+	if name == "" {
+		fmt.Println("Usage: migrate create <name>")
+		os.Exit(1)
 	}
-	return base
-}
-
-func isApplied(ctx context.Context, pool *pgxpool.Pool, version string) bool {
-	var count int
-	err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", version).Scan(&count)
+	up, down, err := database.CreateMigration(dir, name, time.Now())
 	if err != nil {
-		return false
+		log.Fatal(err)
 	}
-	return count > 0
+	log.Printf("Created %s", up)
+	log.Printf("Created %s", down)
 }
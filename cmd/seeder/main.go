@@ -2,97 +2,189 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 
 	"github.com/ilramdhan/costing-mvp/config"
 	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
 	"github.com/ilramdhan/costing-mvp/internal/infrastructure/persistence"
+	"github.com/ilramdhan/costing-mvp/internal/modules/catalog"
+	"github.com/ilramdhan/costing-mvp/internal/modules/costing"
+	"github.com/ilramdhan/costing-mvp/internal/modules/dataio"
 	"github.com/ilramdhan/costing-mvp/pkg/database"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+	"github.com/ilramdhan/costing-mvp/pkg/procs"
 )
 
 var (
 	masterCount   = flag.Int("masters", 1000, "Number of master yarns to generate")
-	childrenCount = flag.Int("children", 100, "Number of children per master")
+	childrenCount = flag.Int("children", 100, "Number of children per master, the average with --distribution=pareto")
+	distribution  = flag.String("distribution", "uniform", "Children per master: uniform (--children each) or pareto (most masters a few, some thousands)")
+	skew          = flag.Float64("skew", 1.2, "Pareto shape for --distribution=pareto, above 1; lower puts more variants under the largest masters")
+	maxChildren   = flag.Int("max-children", 5000, "Most children a master gets with --distribution=pareto")
+	routingCount  = flag.Int("routings", 1, "Number of routings variants are spread over: the standard route, then generated routes of 3 to 8 steps")
 	batchSize     = flag.Int("batch", 5000, "Batch size for COPY operations")
-	workerCount   = flag.Int("workers", 10, "Number of parallel workers")
+	workerCount   = flag.Int("workers", 0, "Number of parallel writers (0 = WRITER_COUNT, else GOMAXPROCS)")
+	seed          = flag.Int64("seed", 0, "Seed for generated attributes and IDs; a seed always generates the same yarns (0 = random, logged)")
+	processCosts  = flag.Bool("process-costs", true, "Seed each variant's input values and per-step costs, so cost breakdowns show before the first recalculation")
+	fromDir       = flag.String("from-csv", "", "Load parameters, rates, masters and variants from the CSV, NDJSON or XLSX files in this directory instead of generating them")
+	resume        = flag.Bool("resume", false, "Continue the latest unfinished run from its last written master, with its seed and options")
 )
 
+// loadKinds are the kinds of records --from-csv loads, in an order where each file's
+// rows can refer to those of the files before it
+var loadKinds = []string{entity.ImportKindParameters, entity.ImportKindRates, entity.ImportKindMasters, entity.ImportKindVariants}
+
+// maxLoggedErrors bounds the rejected rows logged for each loaded file
+const maxLoggedErrors = 20
+
 func main() {
 	flag.Parse()
 	godotenv.Load()
 
+	cfg := config.Load()
+
+	// Size parallelism to the container CPU quota rather than the host's CPUs
+	procsInfo := procs.Apply(cfg.Worker.MaxProcs)
+	if *workerCount <= 0 {
+		*workerCount = cfg.Worker.WriterCount
+	}
+	if *workerCount <= 0 {
+		*workerCount = procsInfo.GOMAXPROCS
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	if *distribution != "uniform" && *distribution != "pareto" {
+		log.Fatalf("--distribution must be uniform or pareto")
+	}
+	if *distribution == "pareto" && *skew <= 1 {
+		log.Fatalf("--skew must be above 1")
+	}
+
 	// Print header
 	fmt.Println("╔═══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║          TEXTILE COSTING ENGINE - DATA SEEDER                 ║")
 	fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
 	fmt.Println()
 
-	totalVariants := *masterCount * *childrenCount
-	log.Printf("Configuration:")
-	log.Printf("  Masters:       %d", *masterCount)
-	log.Printf("  Children/Master: %d", *childrenCount)
-	log.Printf("  Total Variants:  %d", totalVariants)
-	log.Printf("  Batch Size:      %d", *batchSize)
-	log.Printf("  Workers:         %d", *workerCount)
-	log.Printf("  CPU Cores:       %d", runtime.NumCPU())
-	fmt.Println()
-
-	cfg := config.Load()
 	ctx := context.Background()
 
+	// The embedded driver runs a local PostgreSQL for development without a server
+	if cfg.Database.Driver == database.DriverEmbedded {
+		embedded, err := database.StartEmbedded(ctx, &cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to start embedded database: %v", err)
+		}
+		defer embedded.Stop()
+	}
+
 	pool, err := database.NewPool(ctx, &cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer pool.Close()
 
+	// A resumed run restores the seed and options of the run it continues, so it starts
+	// before anything is generated from them
+	var run *seedRun
+	first := 0 // the first master this invocation generates
+	if *fromDir == "" {
+		if run, err = startSeedRun(ctx, pool); err != nil {
+			log.Fatalf("Failed to start seed run: %v", err)
+		}
+		first = run.start
+	}
+
+	children := childrenPerMaster()
+	totalVariants := 0
+	for _, n := range children[first:] {
+		totalVariants += n
+	}
+	log.Printf("Configuration:")
+	if *fromDir != "" {
+		log.Printf("  Files:           %s", *fromDir)
+	} else {
+		log.Printf("  Masters:       %d", *masterCount)
+		log.Printf("  Children/Master: %d (%s)", *childrenCount, *distribution)
+		log.Printf("  Total Variants:  %d", totalVariants)
+		log.Printf("  Routings:        %d", *routingCount)
+		log.Printf("  Batch Size:      %d", *batchSize)
+		log.Printf("  Workers:         %d", *workerCount)
+		log.Printf("  Seed:            %d", *seed)
+		if first > 0 {
+			log.Printf("  Resuming From:   master %d", first)
+		}
+	}
+	log.Printf("  CPU Cores:       %d", procsInfo.NumCPU)
+	log.Printf("  Parallelism:     %s", procsInfo)
+	fmt.Println()
+
+	// Real data from files replaces the generated price rates and yarns
+	if *fromDir != "" {
+		start := time.Now()
+		if err := loadFiles(ctx, pool, *fromDir); err != nil {
+			log.Fatalf("Failed to load files: %v", err)
+		}
+		log.Printf("Loaded %s in %v", *fromDir, time.Since(start).Round(time.Millisecond))
+		return
+	}
+
 	overallStart := time.Now()
 	var metrics PerformanceMetrics
 
-	// Phase 1: Master Data
-	log.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	phaseStart := time.Now()
-	if err := seedMasterData(ctx, pool); err != nil {
-		log.Fatalf("Failed to seed master data: %v", err)
+	// Parameters, processes and the routing come from the reference data migration
+	routingID, err := standardRouting(ctx, pool)
+	if err != nil {
+		log.Fatalf("Failed to load reference data: %v", err)
+	}
+	routings, err := seedRoutings(ctx, pool, routingID, *routingCount)
+	if err != nil {
+		log.Fatalf("Failed to seed routings: %v", err)
 	}
-	metrics.MasterDataTime = time.Since(phaseStart)
 
-	// Phase 1.5: Price Rates
+	// Phase 1: Price Rates
 	log.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	if err := seedPriceRates(ctx, pool); err != nil {
 		log.Fatalf("Failed to seed price rates: %v", err)
 	}
 
-	// Phase 2: Routing Data
-	log.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	phaseStart = time.Now()
-	routingID, err := seedRoutingData(ctx, pool)
-	if err != nil {
-		log.Fatalf("Failed to seed routing data: %v", err)
+	// Process costs are generated with the variants, from the rates just seeded
+	var costs *costSeeder
+	if *processCosts {
+		if costs, err = newCostSeeder(ctx, pool, cfg, routings); err != nil {
+			log.Fatalf("Failed to prepare process costs: %v", err)
+		}
 	}
-	metrics.RoutingDataTime = time.Since(phaseStart)
 
-	// Phase 3: Yarn Data
+	// Phase 2: Yarn Data
 	log.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	phaseStart = time.Now()
-	if err := seedYarnData(ctx, pool, routingID); err != nil {
+	phaseStart := time.Now()
+	if err := seedYarnData(ctx, pool, children, routings, costs, run); err != nil {
 		log.Fatalf("Failed to seed yarn data: %v", err)
 	}
 	metrics.YarnDataTime = time.Since(phaseStart)
 
 	metrics.TotalTime = time.Since(overallStart)
-	metrics.TotalMasters = int64(*masterCount)
+	metrics.TotalMasters = int64(*masterCount - first)
 	metrics.TotalVariants = int64(totalVariants)
 
 	// Print performance summary
@@ -101,12 +193,10 @@ func main() {
 
 // PerformanceMetrics holds timing and throughput data
 type PerformanceMetrics struct {
-	TotalMasters    int64
-	TotalVariants   int64
-	MasterDataTime  time.Duration
-	RoutingDataTime time.Duration
-	YarnDataTime    time.Duration
-	TotalTime       time.Duration
+	TotalMasters  int64
+	TotalVariants int64
+	YarnDataTime  time.Duration
+	TotalTime     time.Duration
 }
 
 func printPerformanceSummary(m PerformanceMetrics) {
@@ -119,8 +209,6 @@ func printPerformanceSummary(m PerformanceMetrics) {
 	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
 	fmt.Printf("║  %-20s %38v ║\n", "Total Time:", m.TotalTime.Round(time.Millisecond))
 	fmt.Println("╠───────────────────────────────────────────────────────────────╣")
-	fmt.Printf("║  %-20s %38v ║\n", "Master Data:", m.MasterDataTime.Round(time.Millisecond))
-	fmt.Printf("║  %-20s %38v ║\n", "Routing Data:", m.RoutingDataTime.Round(time.Millisecond))
 	fmt.Printf("║  %-20s %38v ║\n", "Yarn Data:", m.YarnDataTime.Round(time.Millisecond))
 	fmt.Println("╠───────────────────────────────────────────────────────────────╣")
 	fmt.Printf("║  %-20s %38s ║\n", "Total Masters:", formatNumber(m.TotalMasters))
@@ -155,42 +243,6 @@ func formatNumber(n int64) string {
 	return string(result)
 }
 
-func seedMasterData(ctx context.Context, pool *pgxpool.Pool) error {
-	log.Println("Seeding parameter groups and master parameters...")
-
-	// Parameter groups
-	groups := []string{
-		"raw_material", "electricity", "labor", "machine", "quality", "packaging", "overhead",
-	}
-
-	for _, g := range groups {
-		_, err := pool.Exec(ctx, `
-			INSERT INTO parameter_groups (code, name) VALUES ($1, $2)
-			ON CONFLICT (code) DO NOTHING
-		`, g, g+" Parameters")
-		if err != nil {
-			return fmt.Errorf("failed to insert parameter group %s: %w", g, err)
-		}
-	}
-
-	// Master parameters (250 parameters)
-	parameterNames := generateParameterNames(250)
-	for i, name := range parameterNames {
-		groupCode := groups[i%len(groups)]
-		_, err := pool.Exec(ctx, `
-			INSERT INTO master_parameters (key, label, data_type, default_value, group_code, sequence_order)
-			VALUES ($1, $2, 'float', '0', $3, $4)
-			ON CONFLICT (key) DO NOTHING
-		`, name, name, groupCode, i)
-		if err != nil {
-			return fmt.Errorf("failed to insert parameter %s: %w", name, err)
-		}
-	}
-
-	log.Printf("Created %d parameter groups and %d master parameters", len(groups), len(parameterNames))
-	return nil
-}
-
 func seedPriceRates(ctx context.Context, pool *pgxpool.Pool) error {
 	log.Println("Seeding price rates...")
 
@@ -238,82 +290,233 @@ func seedPriceRates(ctx context.Context, pool *pgxpool.Pool) error {
 	return nil
 }
 
-func seedRoutingData(ctx context.Context, pool *pgxpool.Pool) (uuid.UUID, error) {
-	log.Println("Seeding process masters and routing templates...")
-
-	// Process masters
-	processes := []struct {
-		code     string
-		name     string
-		sequence int
-	}{
-		{"SMELTING", "Smelting Process", 1},
-		{"SPINNING", "Spinning Process", 2},
-		{"WEAVING", "Weaving Process", 3},
-		{"DYEING", "Dyeing Process", 4},
-		{"FINISHING", "Finishing Process", 5},
-		{"PACKING", "Packing Process", 6},
-	}
-
-	processIDs := make([]uuid.UUID, len(processes))
-	for i, p := range processes {
-		id := uuid.New()
-		processIDs[i] = id
-		_, err := pool.Exec(ctx, `
-			INSERT INTO process_masters (id, code, name, default_sequence, created_at)
-			VALUES ($1, $2, $3, $4, NOW())
-			ON CONFLICT (code) DO UPDATE SET id = EXCLUDED.id RETURNING id
-		`, id, p.code, p.name, p.sequence)
+// standardRouting returns the ID of the standard routing template. The 000033 migration
+// creates it along with the rest of the reference data.
+func standardRouting(ctx context.Context, pool *pgxpool.Pool) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := pool.QueryRow(ctx, "SELECT id FROM routing_templates WHERE name = 'Standard Textile Route'").Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, errors.New("routing template 'Standard Textile Route' not found; run the migrations first")
+	}
+	return id, err
+}
+
+// routingInputs are the quantity and rate parameter pairs generated step formulas
+// charge for, all of them base parameters
+var routingInputs = [][2]string{
+	{"raw_material_kg", "material_price"},
+	{"electricity_kwh_1", "electricity_rate"},
+	{"labor_hours_1", "labor_rate"},
+	{"labor_hours_2", "labor_rate"},
+	{"labor_hours_3", "labor_rate"},
+	{"labor_hours_6", "labor_rate"},
+	{"spindle_hours", "spindle_rate"},
+	{"loom_hours", "loom_rate"},
+	{"dye_kg", "dye_price"},
+	{"water_liters", "water_rate"},
+	{"steam_hours", "steam_rate"},
+	{"finishing_hours", "finishing_rate"},
+	{"chemical_kg", "chemical_price"},
+	{"packaging_units", "packaging_price"},
+}
+
+// generatedStep is a step of a generated routing
+type generatedStep struct {
+	code    string // process master code
+	formula string
+}
+
+// seedRoutings returns n routings for generated variants: the standard one, then
+// "Generated Route NN" routings of 3 to 8 steps, created from the seed when missing.
+// An existing generated routing is used as it is, whatever seed created it.
+func seedRoutings(ctx context.Context, pool *pgxpool.Pool, standardID uuid.UUID, n int) ([]uuid.UUID, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT pm.code FROM process_steps ps JOIN process_masters pm ON pm.id = ps.process_master_id
+		WHERE ps.routing_template_id = $1 ORDER BY ps.sequence_order
+	`, standardID)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load standard route steps: %w", err)
+	}
+
+	routings := []uuid.UUID{standardID}
+	rng := rand.New(rand.NewSource(*seed))
+	for i := 1; i < n; i++ {
+		name := fmt.Sprintf("Generated Route %02d", i)
+		steps := generateRouting(rng, codes)
+		var id uuid.UUID
+		var created bool
+		err := pool.QueryRow(ctx, `
+			WITH inserted AS (
+				INSERT INTO routing_templates (name, description, is_active)
+				VALUES ($1, $2, true)
+				ON CONFLICT (name) DO NOTHING RETURNING id
+			)
+			SELECT id, true FROM inserted UNION ALL SELECT id, false FROM routing_templates WHERE name = $1
+		`, name, fmt.Sprintf("Generated %d-step route", len(steps))).Scan(&id, &created)
 		if err != nil {
-			return uuid.Nil, fmt.Errorf("failed to insert process %s: %w", p.code, err)
+			return nil, fmt.Errorf("failed to insert routing %s: %w", name, err)
+		}
+		if created {
+			for j, step := range steps {
+				_, err := pool.Exec(ctx, `
+					INSERT INTO process_steps (routing_template_id, process_master_id, sequence_order, formula_expression)
+					SELECT $1, id, $2, $3 FROM process_masters WHERE code = $4
+				`, id, j+1, step.formula, step.code)
+				if err != nil {
+					return nil, fmt.Errorf("failed to insert step %d of routing %s: %w", j+1, name, err)
+				}
+			}
 		}
+		routings = append(routings, id)
 	}
+	return routings, nil
+}
 
-	// Routing template
-	routingID := uuid.New()
-	_, err := pool.Exec(ctx, `
-		INSERT INTO routing_templates (id, name, description, is_active, created_at)
-		VALUES ($1, 'Standard Textile Route', 'Full textile production route', true, NOW())
-		ON CONFLICT (name) DO UPDATE SET id = EXCLUDED.id RETURNING id
-	`, routingID)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("failed to insert routing template: %w", err)
+// generateRouting returns 3 to 8 steps over the processes in codes, kept in their order:
+// shorter routes skip processes and longer ones run some twice. Each step carries on
+// the cost of the step before it and charges for one to three inputs at a markup, so
+// routings differ in their formulas as well as their lengths.
+func generateRouting(rng *rand.Rand, codes []string) []generatedStep {
+	count := 3 + rng.Intn(6)
+	route := append([]string(nil), codes...)
+	for len(route) > count {
+		i := rng.Intn(len(route))
+		route = append(route[:i], route[i+1:]...)
+	}
+	for len(route) < count {
+		i := rng.Intn(len(route))
+		route = append(route[:i+1], route[i:]...) // a second pass of process i
 	}
 
-	// Process steps with formulas
-	formulas := []string{
-		"(raw_material_kg * material_price) + (electricity_kwh_1 * electricity_rate) + (labor_hours_1 * labor_rate)",
-		"(input_cost_1 * 1.0) + (spindle_hours * spindle_rate) + (labor_hours_2 * labor_rate)",
-		"(input_cost_2 * 1.0) + (loom_hours * loom_rate) + (labor_hours_3 * labor_rate)",
-		"(input_cost_3 * 1.0) + (dye_kg * dye_price) + (water_liters * water_rate) + (steam_hours * steam_rate)",
-		"(input_cost_4 * 1.0) + (finishing_hours * finishing_rate) + (chemical_kg * chemical_price)",
-		"(input_cost_5 * 1.0) + (packaging_units * packaging_price) + (labor_hours_6 * labor_rate)",
+	steps := make([]generatedStep, len(route))
+	for i, code := range route {
+		var terms []string
+		if i > 0 {
+			terms = append(terms, fmt.Sprintf("steps.%s.cost", route[i-1]))
+		}
+		for n := 1 + rng.Intn(3); n > 0; n-- {
+			input := routingInputs[rng.Intn(len(routingInputs))]
+			terms = append(terms, fmt.Sprintf("(%s * %s * %.2f)", input[0], input[1], 0.8+0.6*rng.Float64()))
+		}
+		steps[i] = generatedStep{code: code, formula: strings.Join(terms, " + ")}
 	}
+	return steps
+}
 
-	for i, processID := range processIDs {
-		stepID := uuid.New()
-		_, err := pool.Exec(ctx, `
-			INSERT INTO process_steps (id, routing_template_id, process_master_id, sequence_order, formula_expression, created_at)
-			VALUES ($1, $2, $3, $4, $5, NOW())
-			ON CONFLICT (routing_template_id, sequence_order) DO NOTHING
-		`, stepID, routingID, processID, i+1, formulas[i])
-		if err != nil {
-			return uuid.Nil, fmt.Errorf("failed to insert process step %d: %w", i+1, err)
+// pickRouting picks one of n routings with Zipf weights 1, 1/2, 1/3, ..., so the
+// standard route is the most common and later routings get fewer variants
+func pickRouting(rng *rand.Rand, n int) int {
+	total := 0.0
+	for i := 1; i <= n; i++ {
+		total += 1 / float64(i)
+	}
+	r := rng.Float64() * total
+	for i := 1; i < n; i++ {
+		if r -= 1 / float64(i); r < 0 {
+			return i - 1
 		}
 	}
+	return n - 1
+}
+
+// childrenPerMaster returns the number of variants of each master. A Pareto
+// distribution with shape --skew, scaled to average --children before the
+// --max-children cap, gives most masters a handful and a few masters thousands, as in
+// real catalogs.
+func childrenPerMaster() []int {
+	children := make([]int, *masterCount)
+	if *distribution == "uniform" {
+		for i := range children {
+			children[i] = *childrenCount
+		}
+		return children
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	scale := float64(*childrenCount) * (*skew - 1) / *skew
+	for i := range children {
+		n := int(math.Ceil(scale / math.Pow(1-rng.Float64(), 1 / *skew)))
+		children[i] = min(max(n, 1), *maxChildren)
+	}
+	return children
+}
+
+// loadFiles loads the file of each kind in dir, named after the kind, e.g. masters.csv or
+// variants.xlsx, through the importer's validation and COPY batches. Kinds without a
+// file are skipped; rejected rows are logged and do not stop the load.
+func loadFiles(ctx context.Context, pool *pgxpool.Pool, dir string) error {
+	masterRepo := persistence.NewMasterYarnRepository(pool)
+	variantService := catalog.NewVariantService(masterRepo, persistence.NewYarnVariantRepository(pool), persistence.NewRoutingRuleRepository(pool))
+	importer := dataio.NewImporter(
+		persistence.NewImportRepository(pool),
+		persistence.NewBatchJobRepository(pool),
+		masterRepo,
+		variantService,
+		persistence.NewPriceRateRepository(pool),
+		persistence.NewMasterParameterRepository(pool),
+		persistence.NewRoutingTemplateRepository(pool),
+		persistence.NewCacheEvents(pool),
+	)
+
+	loaded := 0
+	for _, kind := range loadKinds {
+		path, format := "", ""
+		for _, f := range []string{entity.FileFormatCSV, entity.FileFormatNDJSON, entity.FileFormatXLSX} {
+			candidate := filepath.Join(dir, kind+"."+f)
+			if _, err := os.Stat(candidate); err == nil {
+				path, format = candidate, f
+				break
+			}
+		}
+		if path == "" {
+			log.Printf("No %s file in %s, skipping", kind, dir)
+			continue
+		}
 
-	log.Printf("Created %d process masters, 1 routing template, and %d process steps", len(processes), len(processes))
-	return routingID, nil
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loading %s from %s...", kind, path)
+		report, err := importer.Load(ctx, kind, format, content)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		for i, rowErr := range report.Errors {
+			if i == maxLoggedErrors {
+				log.Printf("  ... and %d more rejected rows", report.Failed-int64(i))
+				break
+			}
+			log.Printf("  row %d: %s", rowErr.Row, rowErr.Error)
+		}
+		log.Printf("Loaded %s: %d rows, %d imported, %d rejected", kind, report.Rows, report.Imported, report.Failed)
+		loaded++
+	}
+	if loaded == 0 {
+		return fmt.Errorf("no parameters, rates, masters or variants file found in %s", dir)
+	}
+	return nil
 }
 
-func seedYarnData(ctx context.Context, pool *pgxpool.Pool, routingID uuid.UUID) error {
+// seedYarnData generates the masters of run from its first unwritten one on, with their
+// variants and, when costs is not nil, the variants' process costs. Rows that already
+// exist are skipped, so masters a failed run wrote in part are completed rather than
+// colliding on their codes and SKUs.
+func seedYarnData(ctx context.Context, pool *pgxpool.Pool, children []int, routings []uuid.UUID, costs *costSeeder, run *seedRun) error {
 	log.Println("Seeding master yarns and variants...")
 
 	masterRepo := persistence.NewMasterYarnRepository(pool)
 	variantRepo := persistence.NewYarnVariantRepository(pool)
 
-	totalVariants := *masterCount * *childrenCount
-	log.Printf("Will create %d master yarns and %d total variants", *masterCount, totalVariants)
+	totalMasters := *masterCount - run.start
+	totalVariants := 0
+	for _, n := range children[run.start:] {
+		totalVariants += n
+	}
+	log.Printf("Will create %d master yarns and %d total variants", totalMasters, totalVariants)
 
 	// Use worker pool for parallel seeding
 	numWorkers := *workerCount
@@ -325,37 +528,76 @@ func seedYarnData(ctx context.Context, pool *pgxpool.Pool, routingID uuid.UUID)
 		wg                sync.WaitGroup
 	)
 
-	// Progress reporter
+	// Progress reporter, which also records how far the run got
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 		for range ticker.C {
 			m := atomic.LoadInt64(&completedMasters)
 			v := atomic.LoadInt64(&completedVariants)
-			if m >= int64(*masterCount) {
+			if m >= int64(totalMasters) {
 				return
 			}
 			log.Printf("Progress: masters=%d/%d (%.1f%%), variants=%d/%d (%.1f%%)",
-				m, *masterCount, float64(m)/float64(*masterCount)*100,
+				m, totalMasters, float64(m)/float64(totalMasters)*100,
 				v, totalVariants, float64(v)/float64(totalVariants)*100)
+			if err := run.save(ctx); err != nil {
+				log.Printf("Failed to record seed run progress: %v", err)
+			}
 		}
 	}()
 
+	// flush writes a worker's batches, masters first, and records their masters as
+	// written when every insert succeeded. A master left unrecorded is generated again
+	// by --resume.
+	flush := func(workerID int, masters []*entity.MasterYarn, variants []*entity.YarnVariant, costBatch []*entity.VariantProcessCost, idx []int, what string) {
+		written := true
+		if _, err := masterRepo.CreateBatchSkipExisting(ctx, masters); err != nil {
+			log.Printf("Worker %d: failed to insert %smasters: %v", workerID, what, err)
+			written = false
+		}
+		atomic.AddInt64(&completedMasters, int64(len(masters)))
+
+		if len(variants) > 0 {
+			if _, err := variantRepo.CreateBatchSkipExisting(ctx, variants); err != nil {
+				log.Printf("Worker %d: failed to insert %svariants: %v", workerID, what, err)
+				written = false
+			}
+			atomic.AddInt64(&completedVariants, int64(len(variants)))
+		}
+
+		if len(costBatch) > 0 {
+			if _, err := costs.repo.UpsertBatch(ctx, costBatch); err != nil {
+				log.Printf("Worker %d: failed to insert %sprocess costs: %v", workerID, what, err)
+				written = false
+			}
+		}
+
+		if written {
+			run.markWritten(idx)
+		}
+	}
+
 	// Start workers
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
 
-			masterBatch := make([]*entity.MasterYarn, 0, *batchSize / *childrenCount)
+			masterBatch := make([]*entity.MasterYarn, 0, max(*batchSize / *childrenCount, 1))
 			variantBatch := make([]*entity.YarnVariant, 0, *batchSize)
+			var costBatch []*entity.VariantProcessCost
+			var batchIdx []int // the indexes of the masters in masterBatch
 
 			for masterIdx := range masterChan {
+				// Each master draws from its own generator, so what it gets does not depend
+				// on which worker runs it
+				rng := rand.New(rand.NewSource(masterSeed(*seed, masterIdx)))
 				now := time.Now()
-				masterID := uuid.New()
+				masterID := newID(rng)
 
 				// Create master yarn with fixed attrs
-				fixedAttrs := generateFixedAttrs()
+				fixedAttrs := generateFixedAttrs(rng)
 				master := &entity.MasterYarn{
 					ID:         masterID,
 					Code:       fmt.Sprintf("YARN-%06d", masterIdx),
@@ -366,60 +608,54 @@ func seedYarnData(ctx context.Context, pool *pgxpool.Pool, routingID uuid.UUID)
 					UpdatedAt:  now,
 				}
 				masterBatch = append(masterBatch, master)
+				batchIdx = append(batchIdx, masterIdx)
+
+				// Input values come from a generator of their own, so seeding them does not
+				// change the IDs and routings a seed gives
+				var costRng *rand.Rand
+				if costs != nil {
+					costRng = rand.New(rand.NewSource(^masterSeed(*seed, masterIdx)))
+				}
 
 				// Create variants for this master
-				for j := 0; j < *childrenCount; j++ {
+				for j := 0; j < children[masterIdx]; j++ {
 					variant := &entity.YarnVariant{
-						ID:                uuid.New(),
+						ID:                newID(rng),
 						MasterYarnID:      masterID,
 						SKU:               fmt.Sprintf("SKU-%06d-%04d", masterIdx, j),
 						BatchNo:           fmt.Sprintf("BATCH-%d", j%100),
-						RoutingTemplateID: routingID,
+						RoutingTemplateID: routings[pickRouting(rng, len(routings))],
 						IsActive:          true,
 						CreatedAt:         now,
 						UpdatedAt:         now,
 					}
 					variantBatch = append(variantBatch, variant)
-				}
 
-				// Flush batches when full
-				if len(variantBatch) >= *batchSize {
-					// Insert masters first
-					if len(masterBatch) > 0 {
-						if _, err := masterRepo.CreateBatch(ctx, masterBatch); err != nil {
-							log.Printf("Worker %d: failed to insert masters: %v", workerID, err)
+					if costs != nil {
+						variantCosts, err := costs.generate(costRng, variant, fixedAttrs, now)
+						if err != nil {
+							log.Printf("Worker %d: no process costs for %s: %v", workerID, variant.SKU, err)
 						}
-						atomic.AddInt64(&completedMasters, int64(len(masterBatch)))
-						masterBatch = masterBatch[:0]
+						costBatch = append(costBatch, variantCosts...)
 					}
+				}
 
-					// Insert variants
-					if _, err := variantRepo.CreateBatch(ctx, variantBatch); err != nil {
-						log.Printf("Worker %d: failed to insert variants: %v", workerID, err)
-					}
-					atomic.AddInt64(&completedVariants, int64(len(variantBatch)))
-					variantBatch = variantBatch[:0]
+				// Flush batches when full
+				if len(variantBatch) >= *batchSize {
+					flush(workerID, masterBatch, variantBatch, costBatch, batchIdx, "")
+					masterBatch, variantBatch, costBatch, batchIdx = masterBatch[:0], variantBatch[:0], costBatch[:0], batchIdx[:0]
 				}
 			}
 
 			// Flush remaining
 			if len(masterBatch) > 0 {
-				if _, err := masterRepo.CreateBatch(ctx, masterBatch); err != nil {
-					log.Printf("Worker %d: failed to insert remaining masters: %v", workerID, err)
-				}
-				atomic.AddInt64(&completedMasters, int64(len(masterBatch)))
-			}
-			if len(variantBatch) > 0 {
-				if _, err := variantRepo.CreateBatch(ctx, variantBatch); err != nil {
-					log.Printf("Worker %d: failed to insert remaining variants: %v", workerID, err)
-				}
-				atomic.AddInt64(&completedVariants, int64(len(variantBatch)))
+				flush(workerID, masterBatch, variantBatch, costBatch, batchIdx, "remaining ")
 			}
 		}(w)
 	}
 
 	// Send work to workers
-	for i := 0; i < *masterCount; i++ {
+	for i := run.start; i < *masterCount; i++ {
 		masterChan <- i
 	}
 	close(masterChan)
@@ -428,51 +664,262 @@ func seedYarnData(ctx context.Context, pool *pgxpool.Pool, routingID uuid.UUID)
 
 	log.Printf("Completed: %d masters and %d variants created",
 		atomic.LoadInt64(&completedMasters), atomic.LoadInt64(&completedVariants))
+
+	complete, err := run.finish(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to record seed run: %w", err)
+	}
+	if !complete {
+		log.Printf("Some batches failed; run the seeder with --resume to write the masters from %d on again", run.next)
+	}
 	return nil
 }
 
-func generateParameterNames(count int) []string {
-	prefixes := []string{
-		"raw_material", "electricity_kwh", "labor_hours", "machine_hours",
-		"water_liters", "steam_hours", "chemical_kg", "dye_kg",
-		"spindle_hours", "loom_hours", "finishing_hours", "packaging_units",
-		"waste_percentage", "quality_factor", "efficiency_rate", "overhead_rate",
-		"input_cost", "output_cost", "material_price", "labor_rate",
+// seedOptions are the flags the generated data depends on besides the seed. A seed run
+// stores them, so --resume generates the rest of the data the run started.
+type seedOptions struct {
+	Masters      int     `json:"masters"`
+	Children     int     `json:"children"`
+	Distribution string  `json:"distribution"`
+	Skew         float64 `json:"skew"`
+	MaxChildren  int     `json:"max_children"`
+	Routings     int     `json:"routings"`
+	ProcessCosts bool    `json:"process_costs"`
+}
+
+// seedRun tracks a run's progress in seed_runs as the index of the first master not
+// known to be written. Workers write masters out of order, so it only advances past
+// masters whose batches all succeeded.
+type seedRun struct {
+	pool  *pgxpool.Pool
+	id    uuid.UUID
+	start int // the first master of this invocation
+
+	mu      sync.Mutex
+	written []bool // by master index
+	next    int    // every master before it is written
+}
+
+// startSeedRun records a new seed run from the flags, or with --resume loads the latest
+// unfinished run and replaces the seed and generation flags with its own
+func startSeedRun(ctx context.Context, pool *pgxpool.Pool) (*seedRun, error) {
+	run := &seedRun{pool: pool}
+	if *resume {
+		var runSeed int64
+		var options []byte
+		err := pool.QueryRow(ctx, `
+			SELECT id, seed, options, next_master FROM seed_runs
+			WHERE completed_at IS NULL ORDER BY created_at DESC LIMIT 1
+		`).Scan(&run.id, &runSeed, &options, &run.start)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("no unfinished seed run to resume")
+		}
+		if err != nil {
+			return nil, err
+		}
+		var opts seedOptions
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, fmt.Errorf("invalid options of seed run %s: %w", run.id, err)
+		}
+		*seed = runSeed
+		*masterCount, *childrenCount, *distribution, *skew = opts.Masters, opts.Children, opts.Distribution, opts.Skew
+		*maxChildren, *routingCount, *processCosts = opts.MaxChildren, opts.Routings, opts.ProcessCosts
+		log.Printf("Resuming seed run %s from master %d with its seed and options", run.id, run.start)
+	} else {
+		var unfinished bool
+		if err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM seed_runs WHERE completed_at IS NULL)").Scan(&unfinished); err != nil {
+			return nil, err
+		}
+		if unfinished {
+			log.Printf("An earlier seed run did not finish; --resume continues it instead of starting over")
+		}
+		options, err := json.Marshal(seedOptions{
+			Masters:      *masterCount,
+			Children:     *childrenCount,
+			Distribution: *distribution,
+			Skew:         *skew,
+			MaxChildren:  *maxChildren,
+			Routings:     *routingCount,
+			ProcessCosts: *processCosts,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := pool.QueryRow(ctx, "INSERT INTO seed_runs (seed, options) VALUES ($1, $2) RETURNING id", *seed, options).Scan(&run.id); err != nil {
+			return nil, err
+		}
+	}
+	run.written = make([]bool, *masterCount)
+	run.next = run.start
+	return run, nil
+}
+
+// markWritten records the masters at idx as written
+func (r *seedRun) markWritten(idx []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range idx {
+		r.written[i] = true
+	}
+	for r.next < len(r.written) && r.written[r.next] {
+		r.next++
+	}
+}
+
+// save stores how far the run got
+func (r *seedRun) save(ctx context.Context) error {
+	r.mu.Lock()
+	next := r.next
+	r.mu.Unlock()
+	_, err := r.pool.Exec(ctx, "UPDATE seed_runs SET next_master = $2, updated_at = NOW() WHERE id = $1", r.id, next)
+	return err
+}
+
+// finish stores how far the run got and marks it completed when every master is
+// written, reporting whether it is
+func (r *seedRun) finish(ctx context.Context) (bool, error) {
+	if err := r.save(ctx); err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	complete := r.next == len(r.written)
+	r.mu.Unlock()
+	if !complete {
+		return false, nil
+	}
+	_, err := r.pool.Exec(ctx, "UPDATE seed_runs SET completed_at = NOW() WHERE id = $1", r.id)
+	return true, err
+}
+
+// costSeeder generates the input values and per-step costs of seeded variants. Each
+// variant's inputs are the numeric base parameters its steps read, each drawn within
+// 20% of its base value, and its costs are what the engine computes from them.
+type costSeeder struct {
+	repo   repository.VariantProcessCostRepository
+	engine *costing.CalculationEngine
+	base   map[string]interface{}              // defaults with current price rates applied
+	steps  map[uuid.UUID][]*entity.ProcessStep // by routing
+	inputs map[uuid.UUID][][]string            // by routing, the inputs of each step
+}
+
+func newCostSeeder(ctx context.Context, pool *pgxpool.Pool, cfg *config.Config, routings []uuid.UUID) (*costSeeder, error) {
+	parserOpts := []formula.Option{
+		formula.WithMaxLength(cfg.Formula.MaxLength),
+		formula.WithMaxNodes(cfg.Formula.MaxNodes),
+		formula.WithTimeout(cfg.Formula.EvalTimeout),
+	}
+	if cfg.Formula.DivByZeroFallback != nil {
+		parserOpts = append(parserOpts, formula.WithDivByZeroFallback(*cfg.Formula.DivByZeroFallback))
 	}
+	parser := formula.NewParser(parserOpts...)
 
-	names := make([]string, count)
-	for i := 0; i < count; i++ {
-		prefix := prefixes[i%len(prefixes)]
-		suffix := i / len(prefixes)
-		if suffix > 0 {
-			names[i] = fmt.Sprintf("%s_%d", prefix, suffix)
-		} else {
-			names[i] = prefix
+	rates, err := persistence.NewPriceRateRepository(pool).ListCurrent(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load current rates: %w", err)
+	}
+	base, err := costing.ResolveRates(parser, costing.DefaultBaseParams(), rates)
+	if err != nil {
+		return nil, err
+	}
+
+	stepRepo := persistence.NewProcessStepRepository(pool)
+	c := &costSeeder{
+		repo:   persistence.NewVariantProcessCostRepository(pool, database.NewRetryPolicy(&cfg.Database)),
+		engine: costing.NewCalculationEngine(nil, nil, stepRepo, nil, nil, parser),
+		base:   base,
+		steps:  make(map[uuid.UUID][]*entity.ProcessStep, len(routings)),
+		inputs: make(map[uuid.UUID][][]string, len(routings)),
+	}
+	for _, routingID := range routings {
+		steps, err := stepRepo.GetByRoutingID(ctx, routingID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load steps of routing %s: %w", routingID, err)
+		}
+		inputs := make([][]string, len(steps))
+		for i, step := range steps {
+			names, err := formula.Identifiers(step.FormulaExpression)
+			if err != nil {
+				return nil, fmt.Errorf("step %d of routing %s: %w", step.SequenceOrder, routingID, err)
+			}
+			for _, name := range names {
+				if _, ok := base[name].(float64); ok {
+					inputs[i] = append(inputs[i], name)
+				}
+			}
 		}
+		c.steps[routingID], c.inputs[routingID] = steps, inputs
 	}
-	return names
+	return c, nil
 }
 
-func generateFixedAttrs() map[string]interface{} {
+// generate returns the process costs of a new variant of a master with attrs
+func (c *costSeeder) generate(rng *rand.Rand, variant *entity.YarnVariant, attrs map[string]interface{}, now time.Time) ([]*entity.VariantProcessCost, error) {
+	steps, inputs := c.steps[variant.RoutingTemplateID], c.inputs[variant.RoutingTemplateID]
+	values := make(map[string]interface{})
+	for _, names := range inputs {
+		for _, name := range names {
+			if _, ok := values[name]; !ok {
+				values[name] = c.base[name].(float64) * (0.8 + 0.4*rng.Float64())
+			}
+		}
+	}
+	params := costing.MergeParams(costing.MergeParams(c.base, costing.AttrParams(attrs)), values)
+	stepCosts, err := c.engine.StepCosts(steps, params)
+	if err != nil {
+		return nil, err
+	}
+
+	costs := make([]*entity.VariantProcessCost, len(steps))
+	for i, step := range steps {
+		input := make(map[string]interface{}, len(inputs[i]))
+		for _, name := range inputs[i] {
+			input[name] = values[name]
+		}
+		costs[i] = &entity.VariantProcessCost{
+			ID:             newID(rng),
+			YarnVariantID:  variant.ID,
+			ProcessStepID:  step.ID,
+			InputValues:    input,
+			CalculatedCost: stepCosts[i],
+			UpdatedAt:      now,
+		}
+	}
+	return costs, nil
+}
+
+func generateFixedAttrs(rng *rand.Rand) map[string]interface{} {
 	return map[string]interface{}{
-		"fiber_type":     randomChoice([]string{"cotton", "polyester", "wool", "silk", "blend"}),
-		"yarn_count":     rand.Intn(100) + 10,
-		"twist_per_inch": rand.Float64()*20 + 5,
-		"strength_gf":    rand.Float64()*500 + 100,
-		"elongation_pct": rand.Float64()*15 + 5,
-		"moisture_pct":   rand.Float64()*3 + 5,
-		"grade":          randomChoice([]string{"A", "B", "C", "Premium"}),
-		"color_code":     fmt.Sprintf("#%06x", rand.Intn(0xFFFFFF)),
-		"weight_grams":   rand.Float64()*100 + 50,
-		"diameter_mm":    rand.Float64()*2 + 0.5,
+		"fiber_type":     randomChoice(rng, []string{"cotton", "polyester", "wool", "silk", "blend"}),
+		"yarn_count":     rng.Intn(100) + 10,
+		"twist_per_inch": rng.Float64()*20 + 5,
+		"strength_gf":    rng.Float64()*500 + 100,
+		"elongation_pct": rng.Float64()*15 + 5,
+		"moisture_pct":   rng.Float64()*3 + 5,
+		"grade":          randomChoice(rng, []string{"A", "B", "C", "Premium"}),
+		"color_code":     fmt.Sprintf("#%06x", rng.Intn(0xFFFFFF)),
+		"weight_grams":   rng.Float64()*100 + 50,
+		"diameter_mm":    rng.Float64()*2 + 0.5,
 	}
 }
 
-func randomChoice(choices []string) string {
-	return choices[rand.Intn(len(choices))]
+func randomChoice(rng *rand.Rand, choices []string) string {
+	return choices[rng.Intn(len(choices))]
+}
+
+// masterSeed derives the generator seed of master idx from the run's seed, scrambled so
+// runs with nearby seeds share no masters
+func masterSeed(seed int64, idx int) int64 {
+	x := uint64(seed) + uint64(idx)*0x9E3779B97F4A7C15
+	x = (x ^ x>>30) * 0xBF58476D1CE4E5B9
+	x = (x ^ x>>27) * 0x94D049BB133111EB
+	return int64(x ^ x>>31)
 }
 
-func init() {
-	runtime.GOMAXPROCS(runtime.NumCPU())
-	rand.Seed(time.Now().UnixNano())
+// newID returns a random (version 4) UUID drawn from rng
+func newID(rng *rand.Rand) uuid.UUID {
+	id, err := uuid.NewRandomFromReader(rng)
+	if err != nil {
+		panic(err) // rand.Rand reads never fail
+	}
+	return id
 }
@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 
 	"github.com/ilramdhan/costing-mvp/config"
 	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
-	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/internal/infrastructure/cache"
 	"github.com/ilramdhan/costing-mvp/internal/infrastructure/persistence"
+	"github.com/ilramdhan/costing-mvp/internal/modules/catalog"
 	"github.com/ilramdhan/costing-mvp/internal/modules/costing"
+	"github.com/ilramdhan/costing-mvp/internal/modules/dataio"
 	"github.com/ilramdhan/costing-mvp/pkg/database"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+	"github.com/ilramdhan/costing-mvp/pkg/procs"
 )
 
 func main() {
@@ -25,108 +35,497 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	log.Printf("Starting worker service with %d workers and batch size %d",
-		cfg.Worker.Count, cfg.Worker.BatchSize)
+	// Size parallelism to the container CPU quota rather than the host's CPUs
+	procsInfo := procs.Apply(cfg.Worker.MaxProcs)
+	cfg.Worker.Resolve(procsInfo.GOMAXPROCS)
+	log.Printf("Effective parallelism: %s", procsInfo)
 
-	// Database connection
+	log.Printf("Starting worker service with %d workers, %d writers and batch size %d",
+		cfg.Worker.Count, cfg.Worker.WriterCount, cfg.Worker.BatchSize)
+
+	// The embedded driver runs a local PostgreSQL for development without a server
+	if cfg.Database.Driver == database.DriverEmbedded {
+		embedded, err := database.StartEmbedded(ctx, &cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to start embedded database: %v", err)
+		}
+		defer embedded.Stop()
+	}
+
+	// Database connection; job events record the application name as their actor
+	cfg.Database.ApplicationName = "costing-worker/" + cfg.Worker.ID
 	pool, err := database.NewPool(ctx, &cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer pool.Close()
 
+	// Pending migrations are applied on start when DB_AUTO_MIGRATE is set; the migration
+	// lock keeps replicas starting together from applying them twice
+	if cfg.Database.AutoMigrate {
+		if _, err := database.MigrateUp(ctx, pool, database.Migrations(&cfg.Database)); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+
+	// Exports read from the replica when one is configured
+	readPool, err := database.NewReadPool(ctx, &cfg.Database, pool)
+	if err != nil {
+		log.Printf("Failed to connect to the read replica, reading from the primary: %v", err)
+		readPool = pool
+	}
+	if readPool != pool {
+		defer readPool.Close()
+	}
+	pools := database.Pools(pool, readPool)
+	database.WatchPools(ctx, pools, cfg.Database.PoolStallThreshold)
+	if cfg.Worker.MetricsAddr != "" {
+		go serveMetrics(ctx, cfg.Worker.MetricsAddr, pools)
+	}
+
+	upsertMode, err := persistence.ResolveSummaryUpsertMode(ctx, pool, cfg.Database.SummaryUpsert)
+	if err != nil {
+		log.Fatalf("Failed to resolve summary upsert mode: %v", err)
+	}
+	log.Printf("Summary upsert mode: %s", upsertMode)
+
 	// Initialize repositories
+	masterYarnRepo := persistence.NewMasterYarnRepository(pool)
 	variantRepo := persistence.NewYarnVariantRepository(pool)
 	processStepRepo := persistence.NewProcessStepRepository(pool)
-	costRepo := persistence.NewVariantProcessCostRepository(pool)
-	summaryRepo := persistence.NewVariantCostSummaryRepository(pool)
+	dbRetry := database.NewRetryPolicy(&cfg.Database)
+	costRepo := persistence.NewVariantProcessCostRepository(pool, dbRetry)
+	summaryStore, err := cache.OpenSummaryStore(ctx, &cfg.SummaryCache, persistence.NewSummaryEvents(pool))
+	if err != nil {
+		log.Fatalf("Failed to open summary cache: %v", err)
+	}
+	summaryRepo := cache.NewSummaryRepository(persistence.NewVariantCostSummaryRepository(pool, upsertMode, dbRetry), summaryStore)
 	jobRepo := persistence.NewBatchJobRepository(pool)
+	partitionRepo := persistence.NewJobPartitionRepository(pool)
+	chunkRepo := persistence.NewJobChunkRepository(pool)
+	deadLetterRepo := persistence.NewVariantDeadLetterRepository(pool)
+	rateRepo := persistence.NewPriceRateRepository(pool)
+	scheduleRepo := persistence.NewJobScheduleRepository(pool)
+	webhookRepo := persistence.NewWebhookRepository(pool)
+	jobEventRepo := persistence.NewJobEventRepository(pool)
+	importRepo := persistence.NewImportRepository(pool)
+	paramRepo := persistence.NewMasterParameterRepository(pool)
+	routingTemplateRepo := persistence.NewRoutingTemplateRepository(pool)
+	processMasterRepo := persistence.NewProcessMasterRepository(pool)
+	ruleRepo := persistence.NewRoutingRuleRepository(pool)
 
 	// Initialize calculation engine and worker pool
-	engine := costing.NewCalculationEngine(variantRepo, processStepRepo, costRepo, summaryRepo)
-	workerPool := costing.NewWorkerPool(engine, variantRepo, summaryRepo, jobRepo, cfg.Worker.Count, cfg.Worker.BatchSize)
+	parserOpts := []formula.Option{
+		formula.WithMaxLength(cfg.Formula.MaxLength),
+		formula.WithMaxNodes(cfg.Formula.MaxNodes),
+		formula.WithTimeout(cfg.Formula.EvalTimeout),
+	}
+	if cfg.Formula.DivByZeroFallback != nil {
+		parserOpts = append(parserOpts, formula.WithDivByZeroFallback(*cfg.Formula.DivByZeroFallback))
+	}
+	parser := formula.NewParser(parserOpts...)
+	engine := costing.NewCalculationEngine(masterYarnRepo, variantRepo, processStepRepo, costRepo, summaryRepo, parser)
+
+	// Prewarm routings, compiled formulas and rates so the first job starts immediately
+	routingCache := costing.NewRoutingCache(variantRepo, processStepRepo, rateRepo, parser)
+	if err := routingCache.Warm(ctx); err != nil {
+		log.Printf("Failed to prewarm cache, will warm on first job: %v", err)
+	}
+	cacheEvents := persistence.NewCacheEvents(pool)
+	go routingCache.Watch(ctx, cacheEvents)
+
+	batchTuning := costing.BatchTuning{TargetLatency: cfg.Worker.BatchTargetLatency, MinSize: cfg.Worker.MinBatchSize}
+	workerTuning := costing.WorkerTuning{MaxWorkers: cfg.Worker.MaxWorkerCount}
+	if cfg.Worker.AutoWorkers {
+		workerTuning.Window = cfg.Worker.AutoTuneWindow
+	}
+	writeThrottle := costing.WriteThrottle{MaxRowsPerSecond: cfg.Worker.MaxWriteRowsPerSecond, MaxConcurrentUpserts: cfg.Worker.MaxConcurrentUpserts}
+	workerPool := costing.NewWorkerPool(engine, variantRepo, summaryRepo, jobRepo, partitionRepo, chunkRepo, deadLetterRepo, routingCache, cfg.Worker.Count, cfg.Worker.WriterCount, cfg.Worker.ScanCount, cfg.Worker.BatchSize, cfg.Worker.DeadLetterAfter, batchTuning, writeThrottle, workerTuning)
+	retryPolicy := costing.RetryPolicy{BaseDelay: cfg.Worker.RetryBaseDelay, MaxDelay: cfg.Worker.RetryMaxDelay}
+	variantService := catalog.NewVariantService(masterYarnRepo, variantRepo, ruleRepo)
+	importer := dataio.NewImporter(importRepo, jobRepo, masterYarnRepo, variantService, rateRepo, paramRepo, routingTemplateRepo, cacheEvents)
+	exportStorage, err := dataio.NewDirStorage(cfg.Export.Dir)
+	if err != nil {
+		log.Fatalf("Failed to open export storage: %v", err)
+	}
+	exporter := dataio.NewExporter(persistence.NewVariantCostSummaryRepository(readPool, upsertMode, dbRetry), processMasterRepo, jobRepo, exportStorage)
+	archiver := costing.NewArchiver(persistence.NewCostHistoryRepository(pool), jobRepo, cfg.Worker.ArchiveKeepVersions)
+	scheduler := costing.NewScheduler(scheduleRepo, cfg.Worker.MaxAttempts)
+	reaper := costing.NewStaleJobReaper(jobRepo, cfg.Worker.StaleAfter)
+	webhookSender := costing.NewWebhookSender(webhookRepo, jobRepo, cfg.Webhook.Timeout,
+		costing.RetryPolicy{BaseDelay: cfg.Webhook.RetryBaseDelay, MaxDelay: cfg.Webhook.RetryMaxDelay}, cfg.Webhook.MaxAttempts)
 
-	// Graceful shutdown
+	// Graceful shutdown: the first signal drains the running job, which stops dispatching,
+	// writes and checkpoints what it dispatched and is released for another worker; a second
+	// signal or the drain timeout stops immediately, leaving the job to the stale reaper
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	drainCtx, startDrain := context.WithCancel(ctx)
+	defer startDrain()
+	go func() {
+		select {
+		case <-quit:
+		case <-ctx.Done():
+			return
+		}
+		log.Printf("Draining worker service (up to %v)...", cfg.Worker.DrainTimeout)
+		startDrain()
+		workerPool.Drain()
+		select {
+		case <-quit:
+			log.Println("Second signal received, stopping immediately")
+		case <-time.After(cfg.Worker.DrainTimeout):
+			log.Println("Drain timed out, stopping immediately")
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+	}()
 
 	// Worker mode: process pending jobs or wait for manual trigger
-	log.Println("Worker service ready. Waiting for jobs...")
+	log.Printf("Worker service %s ready. Waiting for jobs...", cfg.Worker.ID)
+
+	// Wake up as soon as the API queues a job; polling remains as a fallback for
+	// missed notifications and jobs queued while no worker was listening
+	jobEvents := persistence.NewJobEvents(pool)
+	wake := make(chan struct{}, 1)
+	go listenForJobs(ctx, jobEvents, wake)
 
-	// Check for pending jobs periodically
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for {
-		select {
-		case <-quit:
-			log.Println("Shutting down worker service...")
-			cancel()
+	// Every instance checks schedules; Fire lets only one of them create each run's job
+	scheduleTicker := time.NewTicker(cfg.Worker.ScheduleInterval)
+	defer scheduleTicker.Stop()
+
+	runSchedules := func() {
+		jobs, err := scheduler.RunDue(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to run job schedules: %v", err)
+		}
+		for _, job := range jobs {
+			log.Printf("Scheduled %s job %s created (schedule %v)", job.JobType, job.ID, job.Metadata["schedule_id"])
+			if err := jobEvents.Notify(ctx, job.ID.String()); err != nil {
+				log.Printf("Failed to notify workers: %v", err)
+			}
+		}
+		if len(jobs) > 0 {
+			notifyWake(wake)
+		}
+	}
+	runSchedules()
+
+	// Job webhooks are queued by the database as jobs complete or fail; every instance
+	// sends due ones, each delivery claimed by one of them. This runs apart from the claim
+	// loop so callbacks are not held up while this instance runs a long job.
+	go func() {
+		webhookTicker := time.NewTicker(cfg.Webhook.Interval)
+		defer webhookTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-webhookTicker.C:
+				delivered, failed, err := webhookSender.DeliverDue(ctx)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Failed to deliver webhooks: %v", err)
+				}
+				if delivered > 0 || failed > 0 {
+					log.Printf("Webhooks: %d delivered, %d failed", delivered, failed)
+				}
+			}
+		}
+	}()
+
+	// Delete jobs past the retention period so batch_jobs does not grow with every nightly
+	// run; instances skip jobs another one is deleting
+	if cfg.Worker.JobRetention > 0 {
+		go func() {
+			cleanupTicker := time.NewTicker(cfg.Worker.CleanupInterval)
+			defer cleanupTicker.Stop()
+			for {
+				cleanup, err := costing.PruneJobs(ctx, jobRepo, jobEventRepo, cfg.Worker.JobRetention, time.Now())
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Failed to clean up old jobs: %v", err)
+				}
+				if cleanup.Jobs > 0 || cleanup.Events > 0 {
+					log.Printf("Cleanup: deleted %d jobs and %d job events before %s",
+						cleanup.Jobs, cleanup.Events, cleanup.Before.Format(time.RFC3339))
+				}
+				if n, err := exportStorage.Prune(cleanup.Before); err != nil {
+					log.Printf("Failed to clean up old export files: %v", err)
+				} else if n > 0 {
+					log.Printf("Cleanup: deleted %d export files", n)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-cleanupTicker.C:
+				}
+			}
+		}()
+	}
+
+	// Release jobs left RUNNING by crashed workers so they are retried or reported as failed
+	reapStale := func() {
+		jobs, err := reaper.Reap(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to reap stale jobs: %v", err)
+		}
+		for _, job := range jobs {
+			log.Printf("Reaped stale job %s -> %s: %s", job.ID, job.Status, job.ErrorMessage)
+		}
+	}
+	reapStale()
+
+	handleFailure := func(job *entity.BatchJob, err error) {
+		if errors.Is(err, costing.ErrJobPaused) {
+			log.Printf("Job %s paused; resume it to continue from its checkpoint", job.ID)
+			return
+		}
+		if errors.Is(err, costing.ErrWorkerDraining) {
+			// Hand it back without using up an attempt; another worker resumes it
+			if err := jobRepo.Release(ctx, job.ID); err != nil {
+				log.Printf("Failed to release job %s: %v", job.ID, err)
+				return
+			}
+			log.Printf("Job %s released at its checkpoint", job.ID)
+			if err := jobEvents.Notify(ctx, job.ID.String()); err != nil {
+				log.Printf("Failed to notify workers: %v", err)
+			}
 			return
+		}
+		delay, failErr := retryPolicy.HandleFailure(ctx, jobRepo, job, err)
+		switch {
+		case failErr != nil:
+			log.Printf("Failed to record failure of job %s: %v", job.ID, failErr)
+		case delay > 0:
+			log.Printf("Job %s will be retried in %v", job.ID, delay)
+			// Claim it when due rather than at the next poll
+			time.AfterFunc(delay, func() { notifyWake(wake) })
+		default:
+			log.Printf("Job %s failed after %d attempts", job.ID, job.Attempts)
+		}
+	}
 
-		case <-ticker.C:
-			// Check for pending jobs
-			jobs, err := jobRepo.ListRecent(ctx, 10)
-			if err != nil {
-				log.Printf("Failed to list jobs: %v", err)
+	// claimPartition runs one partition of a partitioned job if any is free, reporting
+	// whether it found one. The advisory lock taken by the claim is held until release.
+	claimPartition := func() bool {
+		partition, release, err := partitionRepo.Claim(ctx, cfg.Worker.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false
+		}
+		if err != nil {
+			log.Printf("Failed to claim partition: %v", err)
+			return false
+		}
+		defer release()
+
+		job, err := jobRepo.GetByID(ctx, partition.JobID)
+		if err != nil {
+			log.Printf("Failed to load job %s: %v", partition.JobID, err)
+			return true
+		}
+		log.Printf("Claimed partition %d of job %s", partition.PartitionNo, job.ID)
+		// Every replica on a partition keeps the job's heartbeat alive
+		jobCtx := database.WithJobID(ctx, job.ID.String())
+		stopHeartbeat := costing.StartHeartbeat(jobCtx, jobRepo, job.ID, cfg.Worker.HeartbeatInterval)
+		err = processPartition(jobCtx, workerPool, routingCache, job, partition)
+		stopHeartbeat()
+		if err != nil {
+			handleFailure(job, err)
+		}
+		return true
+	}
+
+	claimPending := func() {
+		// Work on partitions of running jobs first, then claim pending jobs one at a time;
+		// other worker instances skip claimed jobs
+		for drainCtx.Err() == nil {
+			if claimPartition() {
 				continue
 			}
 
-			for _, job := range jobs {
-				if job.Status == entity.JobStatusPending {
-					log.Printf("Found pending job: %s", job.ID)
-					processJob(ctx, workerPool, jobRepo, job)
+			job, err := jobRepo.ClaimNextPending(ctx, cfg.Worker.ID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to claim job: %v", err)
+				return
+			}
+			log.Printf("Claimed pending job: %s (attempt %d/%d)", job.ID, job.Attempts, job.MaxAttempts)
+			// Queries made for the job are logged with its ID
+			jobCtx := database.WithJobID(ctx, job.ID.String())
+
+			if job.JobType == entity.JobTypeRecalculateAll && cfg.Worker.Partitions > 1 {
+				// Split it so every replica can take a share; this one claims a partition next
+				if err := workerPool.PartitionJob(jobCtx, job.ID, cfg.Worker.Partitions); err != nil {
+					handleFailure(job, err)
+					continue
 				}
+				log.Printf("Job %s split into %d partitions", job.ID, cfg.Worker.Partitions)
+				if err := jobEvents.Notify(ctx, job.ID.String()); err != nil {
+					log.Printf("Failed to notify workers: %v", err)
+				}
+				continue
+			}
+
+			stopHeartbeat := costing.StartHeartbeat(jobCtx, jobRepo, job.ID, cfg.Worker.HeartbeatInterval)
+			err = processJob(jobCtx, workerPool, routingCache, importer, exporter, archiver, job)
+			stopHeartbeat()
+			if err != nil {
+				handleFailure(job, err)
 			}
 		}
 	}
+	claimPending()
+
+	for {
+		select {
+		case <-drainCtx.Done():
+			log.Println("Worker service drained, shutting down")
+			return
+
+		case <-wake:
+			claimPending()
+
+		case <-ticker.C:
+			reapStale()
+			claimPending()
+
+		case <-scheduleTicker.C:
+			runSchedules()
+		}
+	}
+}
+
+// listenForJobs signals wake for every queued job notification, re-subscribing after
+// listener errors until ctx is done
+func listenForJobs(ctx context.Context, jobEvents *persistence.NotifyChannel, wake chan<- struct{}) {
+	for ctx.Err() == nil {
+		err := jobEvents.Listen(ctx, func(jobID string) {
+			log.Printf("Job queued: %s", jobID)
+			notifyWake(wake)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Job listener stopped: %v; retrying in 5s", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// notifyWake wakes the claim loop without blocking when a wake-up is already pending
+func notifyWake(wake chan<- struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// processPartition runs a claimed partition of job; the caller records a returned error
+// against job with the retry policy
+func processPartition(ctx context.Context, workerPool *costing.WorkerPool, routingCache *costing.RoutingCache, job *entity.BatchJob, partition *entity.JobPartition) error {
+	// As in processJob, but each replica refreshes only if it warmed before the job was created
+	if _, scheduled := job.Metadata["schedule_id"]; scheduled && routingCache.Stats().WarmedAt.Before(job.CreatedAt) {
+		if err := routingCache.Warm(ctx); err != nil {
+			log.Printf("Job %s failed to refresh cache: %v", job.ID, err)
+			return err
+		}
+	}
+
+	baseParams, err := routingCache.BaseParams(ctx)
+	if err != nil {
+		log.Printf("Job %s failed to load base params: %v", job.ID, err)
+		return err
+	}
+
+	completed, err := workerPool.RecalculatePartition(ctx, job, partition, baseParams)
+	if err != nil {
+		log.Printf("Partition %d of job %s failed: %v", partition.PartitionNo, job.ID, err)
+		return err
+	}
+	if completed {
+		log.Printf("Job %s completed with partition %d", job.ID, partition.PartitionNo)
+	}
+	return nil
 }
 
-func processJob(ctx context.Context, workerPool *costing.WorkerPool, jobRepo repository.BatchJobRepository, job *entity.BatchJob) {
-	// Base parameters (in production, fetch from price_rates table)
-	baseParams := map[string]interface{}{
-		"material_price":      50.0,
-		"electricity_rate":    1.5,
-		"labor_rate":          25.0,
-		"spindle_rate":        15.0,
-		"loom_rate":           20.0,
-		"dye_price":           100.0,
-		"water_rate":          0.02,
-		"steam_rate":          10.0,
-		"finishing_rate":      12.0,
-		"chemical_price":      80.0,
-		"packaging_price":     5.0,
-		"overhead_percentage": 0.1,
-		"raw_material_kg":     100.0,
-		"electricity_kwh_1":   50.0,
-		"labor_hours_1":       8.0,
-		"input_cost_1":        5000.0,
-		"spindle_hours":       10.0,
-		"labor_hours_2":       6.0,
-		"input_cost_2":        6000.0,
-		"loom_hours":          8.0,
-		"labor_hours_3":       5.0,
-		"input_cost_3":        7000.0,
-		"dye_kg":              2.5,
-		"water_liters":        500.0,
-		"steam_hours":         5.0,
-		"input_cost_4":        8000.0,
-		"finishing_hours":     4.0,
-		"chemical_kg":         1.5,
-		"input_cost_5":        9000.0,
-		"packaging_units":     10.0,
-		"labor_hours_6":       3.0,
-		"material_cost":       1000.0,
+// processJob runs a claimed job; the caller records a returned error with the retry policy
+func processJob(ctx context.Context, workerPool *costing.WorkerPool, routingCache *costing.RoutingCache, importer *dataio.Importer, exporter *dataio.Exporter, archiver *costing.Archiver, job *entity.BatchJob) error {
+	// Scheduled runs (e.g. the monthly rate refresh) re-warm first so rates that became
+	// effective since the cache was warmed are used without an explicit invalidation
+	if _, scheduled := job.Metadata["schedule_id"]; scheduled {
+		if err := routingCache.Warm(ctx); err != nil {
+			log.Printf("Job %s failed to refresh cache: %v", job.ID, err)
+			return err
+		}
+	}
+
+	baseParams, err := routingCache.BaseParams(ctx)
+	if err != nil {
+		log.Printf("Job %s failed to load base params: %v", job.ID, err)
+		return err
 	}
 
 	startTime := time.Now()
-	log.Printf("Starting job %s at %s", job.ID, startTime.Format(time.RFC3339))
+	log.Printf("Starting %s job %s (priority %d) at %s", job.JobType, job.ID, job.Priority, startTime.Format(time.RFC3339))
 
-	if err := workerPool.RecalculateAll(ctx, job.ID, baseParams); err != nil {
+	switch job.JobType {
+	case entity.JobTypeRecalculateAll:
+		err = workerPool.RecalculateAll(ctx, job.ID, baseParams)
+	case entity.JobTypeRecalculateVariant:
+		var variantID uuid.UUID
+		if variantID, err = uuid.Parse(fmt.Sprint(job.Metadata["variant_id"])); err == nil {
+			err = workerPool.RecalculateVariant(ctx, job.ID, variantID, baseParams)
+		}
+	case entity.JobTypeRecalculateRouting:
+		var routingID uuid.UUID
+		if routingID, err = uuid.Parse(fmt.Sprint(job.Metadata["routing_template_id"])); err == nil {
+			err = workerPool.RecalculateRouting(ctx, job.ID, routingID, baseParams)
+		}
+	case entity.JobTypeImportData:
+		err = importer.Run(ctx, job)
+	case entity.JobTypeExportData:
+		err = exporter.Run(ctx, job)
+	case entity.JobTypeArchiveHistory:
+		err = archiver.Run(ctx, job)
+	default:
+		err = fmt.Errorf("unsupported job type %s", job.JobType)
+	}
+	if errors.Is(err, costing.ErrJobPaused) || errors.Is(err, costing.ErrWorkerDraining) {
+		return err
+	}
+	if err != nil {
 		log.Printf("Job %s failed: %v", job.ID, err)
-		return
+		return err
 	}
 
 	elapsed := time.Since(startTime)
 	log.Printf("Job %s completed in %v", job.ID, elapsed)
+	return nil
+}
+
+// serveMetrics serves the connection pool statistics and query latencies on addr until
+// ctx is done; the worker has no other HTTP endpoint
+func serveMetrics(ctx context.Context, addr string, pools map[string]*pgxpool.Pool) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		database.WriteMetrics(w, pools)
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Printf("Serving metrics on %s/metrics", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Metrics server stopped: %v", err)
+	}
 }
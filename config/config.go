@@ -11,6 +11,13 @@ type Config struct {
 	App      AppConfig
 	Database DatabaseConfig
 	Worker   WorkerConfig
+	Formula  FormulaConfig
+	Catalog  CatalogConfig
+	Webhook  WebhookConfig
+	Import   ImportConfig
+	Export   ExportConfig
+
+	SummaryCache SummaryCacheConfig
 }
 
 // AppConfig holds application configuration
@@ -21,6 +28,8 @@ type AppConfig struct {
 
 // DatabaseConfig holds database configuration
 type DatabaseConfig struct {
+	Driver          string // postgres, or embedded to run a throwaway local PostgreSQL
+	URL             string // connection string used instead of Host, Port, User, Password and Name
 	Host            string
 	Port            string
 	User            string
@@ -29,12 +38,121 @@ type DatabaseConfig struct {
 	PoolMax         int
 	PoolMinConns    int
 	PoolMaxConnLife time.Duration
+	ApplicationName string // reported to PostgreSQL as application_name; set by each binary
+	SummaryUpsert   string // auto, insert or merge; how summary batches are written
+	ReplicaDSN      string // read replica for heavy reads; empty reads from the primary
+	TenantID        string // tenant every connection is limited to by row-level security
+
+	PoolStallThreshold time.Duration // average connection wait over which the pool is logged as stalled
+	QueryLog           string        // off, slow or all: which queries are logged
+	SlowQueryThreshold time.Duration // duration from which a query is logged as slow, 0 for none
+	MigrationsDir      string        // directory of migrations to run instead of those built in
+	AutoMigrate        bool          // apply pending migrations when the API or worker starts
+
+	EmbeddedDir string // data directory of the embedded database; empty uses a temporary one
+	EmbeddedBin string // directory of initdb and postgres; empty searches PATH and common locations
+
+	RetryMaxAttempts int           // tries of a batch write failing on transient errors; 1 disables retries
+	RetryBaseDelay   time.Duration // upper bound of the random wait before the first retry, doubled for each further one
+	RetryMaxDelay    time.Duration // cap on the retry wait
 }
 
 // WorkerConfig holds worker configuration
 type WorkerConfig struct {
-	Count     int
-	BatchSize int
+	ID          string // identifies this instance in claimed jobs
+	MaxProcs    int    // GOMAXPROCS override; 0 detects the container CPU quota
+	Count       int    // calculation goroutines; 0 uses GOMAXPROCS
+	AutoWorkers bool   // WORKER_COUNT=auto: start at GOMAXPROCS and tune during each run
+	WriterCount int    // concurrent result writers; 0 uses GOMAXPROCS/4, at least 1
+	ScanCount   int    // lanes of a recalculation's variant range dispatched in parallel
+	BatchSize   int
+
+	AutoTuneWindow time.Duration // how long into a run AutoWorkers tunes the worker count
+
+	BatchTargetLatency time.Duration // upsert time batches adapt toward; 0 keeps BatchSize static
+	MinBatchSize       int           // smallest size adaptive batches shrink to
+
+	MaxWriteRowsPerSecond int // summaries a worker process upserts per second; 0 for no limit
+	MaxConcurrentUpserts  int // summary upserts a worker process runs at once; 0 for no limit
+
+	MaxWorkerCount int // upper bound on a job's worker_count override and on auto workers
+	MaxBatchSize   int // upper bound on a job's batch_size override
+
+	MaxAttempts    int           // runs per job before it stays FAILED
+	RetryBaseDelay time.Duration // wait before the first retry, doubled for each further one
+	RetryMaxDelay  time.Duration // cap on the retry wait
+
+	DeadLetterAfter int // consecutive failed calculations after which a variant is dead-lettered
+
+	ScheduleInterval time.Duration // how often due job schedules are checked
+
+	HeartbeatInterval time.Duration // how often the worker running a job refreshes its heartbeat
+	StaleAfter        time.Duration // a RUNNING job without a heartbeat for this long is reaped
+
+	Partitions int // variant ranges a full recalculation is split into for worker replicas; 1 disables
+
+	DrainTimeout time.Duration // how long a stopping worker waits for its running job to checkpoint
+
+	MetricsAddr string // address serving GET /metrics, e.g. :9090; empty serves none
+
+	JobRetention    time.Duration // finished jobs and job events older than this are deleted; 0 keeps them
+	CleanupInterval time.Duration // how often old jobs are deleted
+
+	ArchiveKeepVersions int // superseded versions of each summary and step ARCHIVE_HISTORY jobs keep in the history
+}
+
+// Resolve fills counts left at 0 from the effective parallelism; auto workers start at it
+func (w *WorkerConfig) Resolve(gomaxprocs int) {
+	if w.Count <= 0 || w.AutoWorkers {
+		w.Count = gomaxprocs
+	}
+	if w.WriterCount <= 0 {
+		w.WriterCount = max(1, gomaxprocs/4)
+	}
+}
+
+// FormulaConfig holds formula evaluation configuration
+type FormulaConfig struct {
+	DivByZeroFallback *float64 // nil keeps division by zero an evaluation error
+	MaxLength         int      // maximum expression length in bytes, 0 disables
+	MaxNodes          int      // maximum AST node count, 0 disables
+	EvalTimeout       time.Duration
+}
+
+// CatalogConfig holds master data configuration
+type CatalogConfig struct {
+	DuplicateMode           string  // off, warn or block
+	DuplicateNameSimilarity float64 // 0..1, minimum normalized name similarity
+	DuplicateAttrTolerance  float64 // relative tolerance for numeric fixed attributes
+}
+
+// WebhookConfig holds job webhook delivery configuration
+type WebhookConfig struct {
+	Interval       time.Duration // how often workers send due deliveries
+	Timeout        time.Duration // per request
+	MaxAttempts    int           // attempts per delivery before it is FAILED
+	RetryBaseDelay time.Duration // wait before the first retry, doubled for each further one
+	RetryMaxDelay  time.Duration // cap on the retry wait
+}
+
+// ImportConfig holds data import configuration
+type ImportConfig struct {
+	MaxBytes int // largest file the API accepts for an import
+}
+
+// ExportConfig holds data export configuration
+type ExportConfig struct {
+	Dir string // where export files are written; shared by the API and the workers
+}
+
+// SummaryCacheConfig holds cost summary cache configuration
+type SummaryCacheConfig struct {
+	Mode          string        // off, memory or redis
+	Size          int           // summaries a memory cache holds per process
+	TTL           time.Duration // how long a summary stays cached, bounding staleness
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
 }
 
 // Load loads configuration from environment variables
@@ -45,6 +163,8 @@ func Load() *Config {
 			Port: getEnv("APP_PORT", "8080"),
 		},
 		Database: DatabaseConfig{
+			Driver:          getEnv("DB_DRIVER", "postgres"),
+			URL:             getEnv("DATABASE_URL", ""),
 			Host:            getEnv("DB_HOST", "localhost"),
 			Port:            getEnv("DB_PORT", "5432"),
 			User:            getEnv("DB_USER", "postgres"),
@@ -53,19 +173,117 @@ func Load() *Config {
 			PoolMax:         getEnvInt("DB_POOL_MAX", 50),
 			PoolMinConns:    getEnvInt("DB_POOL_MIN", 10),
 			PoolMaxConnLife: time.Duration(getEnvInt("DB_POOL_MAX_CONN_LIFE_MINUTES", 30)) * time.Minute,
+			SummaryUpsert:   getEnv("SUMMARY_UPSERT_MODE", "auto"),
+			ReplicaDSN:      getEnv("DB_REPLICA_DSN", ""),
+			TenantID:        getEnv("DB_TENANT_ID", "default"),
+
+			PoolStallThreshold: time.Duration(getEnvInt("DB_POOL_STALL_THRESHOLD_MS", 100)) * time.Millisecond,
+			QueryLog:           getEnv("DB_QUERY_LOG", "slow"),
+			SlowQueryThreshold: time.Duration(getEnvInt("DB_SLOW_QUERY_MS", 500)) * time.Millisecond,
+			MigrationsDir:      getEnv("DB_MIGRATIONS_DIR", ""),
+			AutoMigrate:        getEnvBool("DB_AUTO_MIGRATE", false),
+
+			EmbeddedDir: getEnv("DB_EMBEDDED_DIR", ""),
+			EmbeddedBin: getEnv("DB_EMBEDDED_BIN", ""),
+
+			RetryMaxAttempts: getEnvInt("DB_RETRY_MAX_ATTEMPTS", 5),
+			RetryBaseDelay:   time.Duration(getEnvInt("DB_RETRY_BASE_DELAY_MS", 100)) * time.Millisecond,
+			RetryMaxDelay:    time.Duration(getEnvInt("DB_RETRY_MAX_DELAY_MS", 5000)) * time.Millisecond,
 		},
 		Worker: WorkerConfig{
-			Count:     getEnvInt("WORKER_COUNT", 100),
-			BatchSize: getEnvInt("BATCH_SIZE", 1000),
+			ID:          getEnv("WORKER_ID", defaultWorkerID()),
+			MaxProcs:    getEnvInt("WORKER_MAXPROCS", 0),
+			Count:       getEnvInt("WORKER_COUNT", 100),
+			AutoWorkers: getEnv("WORKER_COUNT", "") == "auto",
+			WriterCount: getEnvInt("WRITER_COUNT", 0),
+			ScanCount:   getEnvInt("SCANNER_COUNT", 4),
+			BatchSize:   getEnvInt("BATCH_SIZE", 1000),
+
+			AutoTuneWindow: time.Duration(getEnvInt("WORKER_AUTO_TUNE_SECONDS", 60)) * time.Second,
+
+			BatchTargetLatency: time.Duration(getEnvInt("BATCH_TARGET_LATENCY_MS", 500)) * time.Millisecond,
+			MinBatchSize:       getEnvInt("BATCH_MIN_SIZE", 100),
+
+			MaxWriteRowsPerSecond: getEnvInt("WRITE_MAX_ROWS_PER_SECOND", 0),
+			MaxConcurrentUpserts:  getEnvInt("WRITE_MAX_CONCURRENT_UPSERTS", 0),
+
+			MaxWorkerCount: getEnvInt("JOB_MAX_WORKER_COUNT", 500),
+			MaxBatchSize:   getEnvInt("JOB_MAX_BATCH_SIZE", 20000),
+
+			MaxAttempts:    getEnvInt("JOB_MAX_ATTEMPTS", 3),
+			RetryBaseDelay: time.Duration(getEnvInt("JOB_RETRY_BASE_DELAY_SECONDS", 30)) * time.Second,
+			RetryMaxDelay:  time.Duration(getEnvInt("JOB_RETRY_MAX_DELAY_SECONDS", 900)) * time.Second,
+
+			DeadLetterAfter: getEnvInt("VARIANT_DEAD_LETTER_AFTER", 3),
+
+			ScheduleInterval: time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 30)) * time.Second,
+
+			HeartbeatInterval: time.Duration(getEnvInt("JOB_HEARTBEAT_INTERVAL_SECONDS", 10)) * time.Second,
+			StaleAfter:        time.Duration(getEnvInt("JOB_STALE_AFTER_SECONDS", 120)) * time.Second,
+
+			Partitions: getEnvInt("JOB_PARTITIONS", 1),
+
+			DrainTimeout: time.Duration(getEnvInt("WORKER_DRAIN_TIMEOUT_SECONDS", 60)) * time.Second,
+
+			MetricsAddr: getEnv("WORKER_METRICS_ADDR", ""),
+
+			JobRetention:    time.Duration(getEnvInt("JOB_RETENTION_DAYS", 90)) * 24 * time.Hour,
+			CleanupInterval: time.Duration(getEnvInt("JOB_CLEANUP_INTERVAL_MINUTES", 60)) * time.Minute,
+
+			ArchiveKeepVersions: getEnvInt("ARCHIVE_KEEP_VERSIONS", 12),
+		},
+		Formula: FormulaConfig{
+			DivByZeroFallback: getEnvFloatPtr("FORMULA_DIV_BY_ZERO_FALLBACK"),
+			MaxLength:         getEnvInt("FORMULA_MAX_LENGTH", 2000),
+			MaxNodes:          getEnvInt("FORMULA_MAX_NODES", 500),
+			EvalTimeout:       time.Duration(getEnvInt("FORMULA_EVAL_TIMEOUT_MS", 100)) * time.Millisecond,
+		},
+		Catalog: CatalogConfig{
+			DuplicateMode:           getEnv("DUPLICATE_MASTER_MODE", "warn"),
+			DuplicateNameSimilarity: getEnvFloat("DUPLICATE_NAME_SIMILARITY", 0.85),
+			DuplicateAttrTolerance:  getEnvFloat("DUPLICATE_ATTR_TOLERANCE", 0.02),
+		},
+		Webhook: WebhookConfig{
+			Interval:       time.Duration(getEnvInt("WEBHOOK_INTERVAL_SECONDS", 10)) * time.Second,
+			Timeout:        time.Duration(getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+			MaxAttempts:    getEnvInt("WEBHOOK_MAX_ATTEMPTS", 6),
+			RetryBaseDelay: time.Duration(getEnvInt("WEBHOOK_RETRY_BASE_DELAY_SECONDS", 30)) * time.Second,
+			RetryMaxDelay:  time.Duration(getEnvInt("WEBHOOK_RETRY_MAX_DELAY_SECONDS", 3600)) * time.Second,
+		},
+		Import: ImportConfig{
+			MaxBytes: getEnvInt("IMPORT_MAX_MB", 64) << 20,
+		},
+		Export: ExportConfig{
+			Dir: getEnv("EXPORT_DIR", "exports"),
+		},
+		SummaryCache: SummaryCacheConfig{
+			Mode:          getEnv("SUMMARY_CACHE", "memory"),
+			Size:          getEnvInt("SUMMARY_CACHE_SIZE", 100000),
+			TTL:           time.Duration(getEnvInt("SUMMARY_CACHE_TTL_SECONDS", 300)) * time.Second,
+			RedisAddr:     getEnv("REDIS_ADDR", "localhost:6379"),
+			RedisPassword: getEnv("REDIS_PASSWORD", ""),
+			RedisDB:       getEnvInt("REDIS_DB", 0),
 		},
 	}
 }
 
 // DSN returns the database connection string
 func (c *DatabaseConfig) DSN() string {
+	if c.URL != "" {
+		return c.URL
+	}
 	return "postgres://" + c.User + ":" + c.Password + "@" + c.Host + ":" + c.Port + "/" + c.Name + "?sslmode=disable"
 }
 
+// defaultWorkerID returns hostname-pid, unique per process on a host
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -73,6 +291,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -81,3 +308,21 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloatPtr(key string) *float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return &floatValue
+		}
+	}
+	return nil
+}
@@ -1,7 +1,12 @@
 package entity
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -45,6 +50,98 @@ func (m *MasterYarn) FixedAttrsJSON() ([]byte, error) {
 	return json.Marshal(m.FixedAttrs)
 }
 
+// Audit log entity types and actions
+const (
+	AuditEntityMasterYarn    = "master_yarn"
+	AuditEntityYarnVariant   = "yarn_variant"
+	AuditEntityFormula       = "formula"
+	AuditEntityProcessStep   = "process_step"
+	AuditEntityRoutingRule   = "routing_rule"
+	AuditEntityPriceRate     = "price_rate"
+	AuditEntityCostingConfig = "costing_config" // a config apply, with entity ID uuid.Nil
+
+	AuditActionCreate    = "CREATE"
+	AuditActionUpdate    = "UPDATE"
+	AuditActionDelete    = "DELETE"
+	AuditActionApply     = "APPLY"
+	AuditActionMergeInto = "MERGE_INTO"
+	AuditActionSplit     = "SPLIT"
+
+	AuditActionDeactivateVariants = "DEACTIVATE_VARIANTS" // every variant of a master
+	AuditActionReassignRouting    = "REASSIGN_ROUTING"    // every variant of a master
+)
+
+// AuditLog records a change to master data, formulas or price rates
+type AuditLog struct {
+	ID         uuid.UUID              `json:"id"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   uuid.UUID              `json:"entity_id"`
+	Action     string                 `json:"action"`
+	Actor      string                 `json:"actor,omitempty"`
+	Changes    map[string]AuditChange `json:"changes,omitempty"` // by field
+	Details    map[string]interface{} `json:"details"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// AuditChange is the value of a field before and after a change; Before is nil when
+// the entity was created and After when it was deleted
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditLogFilter narrows an audit log listing; zero fields match everything
+type AuditLogFilter struct {
+	EntityType string
+	EntityID   uuid.UUID
+	Actor      string
+}
+
+// AuditDiff returns the fields, by JSON name, whose values differ between two versions
+// of an entity. Either version may be nil, for a creation or a deletion. Timestamps the
+// database maintains are left out.
+func AuditDiff(before, after interface{}) map[string]AuditChange {
+	old, updated := auditFields(before), auditFields(after)
+	changes := make(map[string]AuditChange)
+	for field, value := range updated {
+		if prev, ok := old[field]; !ok || !reflect.DeepEqual(prev, value) {
+			changes[field] = AuditChange{Before: prev, After: value}
+		}
+	}
+	for field, prev := range old {
+		if _, ok := updated[field]; !ok {
+			changes[field] = AuditChange{Before: prev}
+		}
+	}
+	delete(changes, "created_at")
+	delete(changes, "updated_at")
+	return changes
+}
+
+// auditFields decodes the JSON form of v into its fields
+func auditFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields
+	}
+	if data, err := json.Marshal(v); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	return fields
+}
+
+// MasterRestructure is the outcome of moving variants between master yarns. Cost
+// summaries are keyed by variant, so they move with their variants unchanged; the
+// summary count and total are recorded to make that verifiable from the audit log.
+type MasterRestructure struct {
+	SourceID        uuid.UUID `json:"source_id"`
+	TargetID        uuid.UUID `json:"target_id"`
+	VariantsMoved   int64     `json:"variants_moved"`
+	SummariesMoved  int64     `json:"summaries_moved"`
+	GrandTotalMoved float64   `json:"grand_total_moved"`
+	AuditLogID      uuid.UUID `json:"audit_log_id"`
+}
+
 // YarnVariant represents a child of MasterYarn
 type YarnVariant struct {
 	ID                uuid.UUID `json:"id"`
@@ -64,9 +161,20 @@ type ProcessMaster struct {
 	Name            string    `json:"name"`
 	Description     string    `json:"description,omitempty"`
 	DefaultSequence int       `json:"default_sequence"`
+	OutputKgPerHour float64   `json:"output_kg_per_hour,omitempty"` // machine throughput, 0 when unknown
+	SetupHours      float64   `json:"setup_hours"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
+// Duration returns the machine time needed to process quantityKg, including setup.
+// ok is false when the process has no throughput rate.
+func (p *ProcessMaster) Duration(quantityKg float64) (hours float64, ok bool) {
+	if p.OutputKgPerHour <= 0 {
+		return p.SetupHours, false
+	}
+	return p.SetupHours + quantityKg/p.OutputKgPerHour, true
+}
+
 // RoutingTemplate represents a combination of processes for a product
 type RoutingTemplate struct {
 	ID          uuid.UUID `json:"id"`
@@ -76,15 +184,78 @@ type RoutingTemplate struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// RoutingRule assigns a default routing template to variants based on their master's attributes
+type RoutingRule struct {
+	ID                uuid.UUID `json:"id"`
+	FiberType         string    `json:"fiber_type,omitempty"` // empty matches any fiber type
+	Grade             string    `json:"grade,omitempty"`      // empty matches any grade
+	RoutingTemplateID uuid.UUID `json:"routing_template_id"`
+	Priority          int       `json:"priority"`
+	IsActive          bool      `json:"is_active"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Matches reports whether the rule applies to the given master fixed_attrs
+func (r *RoutingRule) Matches(attrs map[string]interface{}) bool {
+	if r.FiberType != "" && !strings.EqualFold(r.FiberType, attrString(attrs, "fiber_type")) {
+		return false
+	}
+	if r.Grade != "" && !strings.EqualFold(r.Grade, attrString(attrs, "grade")) {
+		return false
+	}
+	return true
+}
+
+// Specificity returns the number of attributes the rule constrains
+func (r *RoutingRule) Specificity() int {
+	n := 0
+	if r.FiberType != "" {
+		n++
+	}
+	if r.Grade != "" {
+		n++
+	}
+	return n
+}
+
+func attrString(attrs map[string]interface{}, key string) string {
+	if v, ok := attrs[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
 // ProcessStep represents a step in a routing with its formula
 type ProcessStep struct {
-	ID                uuid.UUID `json:"id"`
+	ID                uuid.UUID  `json:"id"`
+	RoutingTemplateID uuid.UUID  `json:"routing_template_id"`
+	ProcessMasterID   uuid.UUID  `json:"process_master_id"`
+	ProcessCode       string     `json:"process_code,omitempty"` // later steps reference this step as steps.<code>
+	SequenceOrder     int        `json:"sequence_order"`
+	FormulaExpression string     `json:"formula_expression"`   // e.g., "(electricity_kwh * 1.5) + labor_cost"
+	FormulaID         *uuid.UUID `json:"formula_id,omitempty"` // library formula, overrides the inline expression
+	Description       string     `json:"description,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// Formula is a named, versioned expression shared across process steps
+type Formula struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"` // e.g., "standard_dyeing_cost"
+	Version     int       `json:"version"`
+	Expression  string    `json:"expression"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// FormulaUsage identifies a process step that references a library formula
+type FormulaUsage struct {
+	ProcessStepID     uuid.UUID `json:"process_step_id"`
 	RoutingTemplateID uuid.UUID `json:"routing_template_id"`
-	ProcessMasterID   uuid.UUID `json:"process_master_id"`
+	RoutingName       string    `json:"routing_name"`
+	ProcessCode       string    `json:"process_code"`
 	SequenceOrder     int       `json:"sequence_order"`
-	FormulaExpression string    `json:"formula_expression"` // e.g., "(electricity_kwh * 1.5) + labor_cost"
-	Description       string    `json:"description,omitempty"`
-	CreatedAt         time.Time `json:"created_at"`
+	VariantCount      int64     `json:"variant_count"`
 }
 
 // VariantProcessCost represents the calculated cost for a variant's process step
@@ -104,15 +275,129 @@ func (v *VariantProcessCost) InputValuesJSON() ([]byte, error) {
 
 // VariantCostSummary represents the aggregated cost summary for a variant (Read Model)
 type VariantCostSummary struct {
-	YarnVariantID      uuid.UUID `json:"yarn_variant_id"`
-	TotalMaterialCost  float64   `json:"total_material_cost"`
-	TotalProcessCost   float64   `json:"total_process_cost"`
-	TotalOverhead      float64   `json:"total_overhead"`
-	GrandTotal         float64   `json:"grand_total"`
-	LastRecalculatedAt time.Time `json:"last_recalculated_at,omitempty"`
-	VersionHash        string    `json:"version_hash,omitempty"`
-	CreatedAt          time.Time `json:"created_at"`
-	UpdatedAt          time.Time `json:"updated_at"`
+	YarnVariantID      uuid.UUID          `json:"yarn_variant_id"`
+	TotalMaterialCost  float64            `json:"total_material_cost"`
+	TotalProcessCost   float64            `json:"total_process_cost"`
+	TotalOverhead      float64            `json:"total_overhead"`
+	GrandTotal         float64            `json:"grand_total"`
+	CategoryBreakdown  map[string]float64 `json:"category_breakdown,omitempty"` // process cost per process code, nil until computed
+	LastRecalculatedAt time.Time          `json:"last_recalculated_at,omitempty"`
+	VersionHash        string             `json:"version_hash,omitempty"`
+	CreatedAt          time.Time          `json:"created_at"`
+	UpdatedAt          time.Time          `json:"updated_at"`
+}
+
+// CostSummaryVersion is a cost summary a later recalculation superseded
+type CostSummaryVersion struct {
+	YarnVariantID     uuid.UUID          `json:"yarn_variant_id"`
+	TotalMaterialCost float64            `json:"total_material_cost"`
+	TotalProcessCost  float64            `json:"total_process_cost"`
+	TotalOverhead     float64            `json:"total_overhead"`
+	GrandTotal        float64            `json:"grand_total"`
+	CategoryBreakdown map[string]float64 `json:"category_breakdown,omitempty"`
+	VersionHash       string             `json:"version_hash,omitempty"`
+	RecalculatedAt    *time.Time         `json:"recalculated_at,omitempty"` // when the version was calculated
+	SupersededAt      time.Time          `json:"superseded_at"`
+	Archived          bool               `json:"archived"`
+}
+
+// ProcessCostVersion is a per-step cost a later recalculation superseded
+type ProcessCostVersion struct {
+	YarnVariantID  uuid.UUID              `json:"yarn_variant_id"`
+	ProcessStepID  uuid.UUID              `json:"process_step_id"`
+	InputValues    map[string]interface{} `json:"input_values,omitempty"`
+	CalculatedCost float64                `json:"calculated_cost"`
+	CalculatedAt   *time.Time             `json:"calculated_at,omitempty"`
+	SupersededAt   time.Time              `json:"superseded_at"`
+	Archived       bool                   `json:"archived"`
+}
+
+// LotStatus represents the lifecycle state of a production lot
+type LotStatus string
+
+const (
+	LotStatusPlanned      LotStatus = "PLANNED"
+	LotStatusInProduction LotStatus = "IN_PRODUCTION"
+	LotStatusCompleted    LotStatus = "COMPLETED"
+	LotStatusClosed       LotStatus = "CLOSED"
+)
+
+// lotTransitions lists the statuses each lot status may move to
+var lotTransitions = map[LotStatus][]LotStatus{
+	LotStatusPlanned:      {LotStatusInProduction, LotStatusClosed},
+	LotStatusInProduction: {LotStatusCompleted},
+	LotStatusCompleted:    {LotStatusClosed},
+}
+
+// CanTransitionTo reports whether a lot in status s may move to next
+func (s LotStatus) CanTransitionTo(next LotStatus) bool {
+	for _, allowed := range lotTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductionLot represents a produced batch of a variant with its lot-specific actuals
+type ProductionLot struct {
+	ID             uuid.UUID              `json:"id"`
+	YarnVariantID  uuid.UUID              `json:"yarn_variant_id"`
+	BatchNo        string                 `json:"batch_no"`
+	ProductionDate time.Time              `json:"production_date"`
+	Quantity       float64                `json:"quantity"`
+	Status         LotStatus              `json:"status"`
+	ActualValues   map[string]interface{} `json:"actual_values"`
+	StandardCost   *float64               `json:"standard_cost,omitempty"`
+	ActualCost     *float64               `json:"actual_cost,omitempty"`
+	CostedAt       *time.Time             `json:"costed_at,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+}
+
+// LotCostLine is a traceability report row comparing a lot's actual cost to standard
+type LotCostLine struct {
+	LotID             uuid.UUID `json:"lot_id"`
+	BatchNo           string    `json:"batch_no"`
+	ProductionDate    time.Time `json:"production_date"`
+	Status            LotStatus `json:"status"`
+	YarnVariantID     uuid.UUID `json:"yarn_variant_id"`
+	SKU               string    `json:"sku"`
+	MasterYarnCode    string    `json:"master_yarn_code"`
+	Quantity          float64   `json:"quantity"`
+	StandardCost      float64   `json:"standard_cost"`
+	ActualCost        float64   `json:"actual_cost"`
+	Variance          float64   `json:"variance"`
+	VariancePct       float64   `json:"variance_pct"`
+	TotalStandardCost float64   `json:"total_standard_cost"`
+	TotalActualCost   float64   `json:"total_actual_cost"`
+}
+
+// NewLotCostLine builds a report row, deriving variance and lot totals from unit costs
+func NewLotCostLine(lot *ProductionLot, sku, masterCode string) *LotCostLine {
+	line := &LotCostLine{
+		LotID:          lot.ID,
+		BatchNo:        lot.BatchNo,
+		ProductionDate: lot.ProductionDate,
+		Status:         lot.Status,
+		YarnVariantID:  lot.YarnVariantID,
+		SKU:            sku,
+		MasterYarnCode: masterCode,
+		Quantity:       lot.Quantity,
+	}
+	if lot.StandardCost != nil {
+		line.StandardCost = *lot.StandardCost
+	}
+	if lot.ActualCost != nil {
+		line.ActualCost = *lot.ActualCost
+	}
+	line.Variance = line.ActualCost - line.StandardCost
+	if line.StandardCost != 0 {
+		line.VariancePct = line.Variance / line.StandardCost * 100
+	}
+	line.TotalStandardCost = line.StandardCost * line.Quantity
+	line.TotalActualCost = line.ActualCost * line.Quantity
+	return line
 }
 
 // JobStatus represents the status of a batch job
@@ -124,8 +409,14 @@ const (
 	JobStatusCompleted JobStatus = "COMPLETED"
 	JobStatusFailed    JobStatus = "FAILED"
 	JobStatusCancelled JobStatus = "CANCELLED"
+	JobStatusPaused    JobStatus = "PAUSED" // left alone by workers until resumed
 )
 
+// Finished reports whether a job in this status will not run again on its own
+func (s JobStatus) Finished() bool {
+	return s == JobStatusCompleted || s == JobStatusFailed || s == JobStatusCancelled
+}
+
 // JobType represents the type of batch job
 type JobType string
 
@@ -133,20 +424,116 @@ const (
 	JobTypeRecalculateAll     JobType = "RECALCULATE_ALL"
 	JobTypeRecalculateMaster  JobType = "RECALCULATE_MASTER"
 	JobTypeRecalculateVariant JobType = "RECALCULATE_VARIANT"
+	JobTypeRecalculateRouting JobType = "RECALCULATE_ROUTING"
 	JobTypeImportData         JobType = "IMPORT_DATA"
 	JobTypeExportData         JobType = "EXPORT_DATA"
+	JobTypeArchiveHistory     JobType = "ARCHIVE_HISTORY"
+)
+
+// Job priorities; workers claim higher priorities first. A running job is never preempted.
+const (
+	JobPriorityLow    = 0   // full recalculations and archival
+	JobPriorityNormal = 50  // master- and routing-level recalculations and data transfers
+	JobPriorityHigh   = 100 // single-variant recalculations requested by a user
 )
 
+// DefaultPriority returns the priority a job of type t is queued with unless overridden
+func (t JobType) DefaultPriority() int {
+	switch t {
+	case JobTypeRecalculateVariant:
+		return JobPriorityHigh
+	case JobTypeRecalculateAll, JobTypeArchiveHistory:
+		return JobPriorityLow
+	default:
+		return JobPriorityNormal
+	}
+}
+
+// Exclusive reports whether at most one job of type t may run at a time. Enforced by the
+// idx_batch_jobs_one_running_exclusive index, which lists the same types.
+func (t JobType) Exclusive() bool {
+	return t == JobTypeRecalculateAll
+}
+
+// TimelineStep is one process step of a variant's schedule, placed back to back
+// after the previous step
+type TimelineStep struct {
+	ProcessStepID uuid.UUID `json:"process_step_id"`
+	SequenceOrder int       `json:"sequence_order"`
+	ProcessCode   string    `json:"process_code"`
+	ProcessName   string    `json:"process_name"`
+	StartHour     float64   `json:"start_hour"`
+	DurationHours float64   `json:"duration_hours"`
+	EndHour       float64   `json:"end_hour"`
+	HasRate       bool      `json:"has_rate"` // false when duration is setup time only
+	Cost          float64   `json:"cost"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// ProcessTimeline is the Gantt-style schedule and cost of producing a variant
+type ProcessTimeline struct {
+	YarnVariantID uuid.UUID       `json:"yarn_variant_id"`
+	SKU           string          `json:"sku"`
+	QuantityKg    float64         `json:"quantity_kg"`
+	TotalHours    float64         `json:"total_hours"`
+	TotalCost     float64         `json:"total_cost"`
+	Steps         []*TimelineStep `json:"steps"`
+}
+
+// ParamContribution is the cost change caused by changing one parameter on its own
+type ParamContribution struct {
+	Parameter string      `json:"parameter"`
+	OldValue  interface{} `json:"old_value"`
+	NewValue  interface{} `json:"new_value"`
+	Delta     float64     `json:"delta"`
+}
+
+// StepCostDiff compares one process step's cost before and after a what-if change.
+// Interaction is the part of Delta not explained by single-parameter contributions.
+type StepCostDiff struct {
+	ProcessStepID uuid.UUID            `json:"process_step_id"`
+	SequenceOrder int                  `json:"sequence_order"`
+	ProcessCode   string               `json:"process_code"`
+	OldCost       float64              `json:"old_cost"`
+	NewCost       float64              `json:"new_cost"`
+	Delta         float64              `json:"delta"`
+	PctChange     *float64             `json:"pct_change"` // nil when the old cost is zero
+	Contributions []*ParamContribution `json:"contributions,omitempty"`
+	Interaction   float64              `json:"interaction"`
+	Error         string               `json:"error,omitempty"`
+}
+
+// SimulationResult is a what-if costing of a variant with a structured diff against
+// the current parameters, shaped for waterfall charts
+type SimulationResult struct {
+	YarnVariantID uuid.UUID            `json:"yarn_variant_id"`
+	Baseline      *VariantCostSummary  `json:"baseline"`
+	Simulated     *VariantCostSummary  `json:"simulated"`
+	Delta         float64              `json:"delta"`
+	PctChange     *float64             `json:"pct_change"`
+	ChangedParams []string             `json:"changed_params"`
+	Contributions []*ParamContribution `json:"contributions"` // to the grand total
+	Interaction   float64              `json:"interaction"`
+	Steps         []*StepCostDiff      `json:"steps"`
+}
+
 // BatchJob represents a background job for large operations
 type BatchJob struct {
 	ID               uuid.UUID              `json:"id"`
 	JobType          JobType                `json:"job_type"`
 	Status           JobStatus              `json:"status"`
+	Priority         int                    `json:"priority"` // higher is claimed first
 	TotalRecords     int64                  `json:"total_records"`
 	ProcessedRecords int64                  `json:"processed_records"`
 	FailedRecords    int64                  `json:"failed_records"`
 	Metadata         map[string]interface{} `json:"metadata,omitempty"`
 	ErrorMessage     string                 `json:"error_message,omitempty"`
+	ClaimedBy        string                 `json:"claimed_by,omitempty"` // worker instance processing the job
+	ClaimedAt        *time.Time             `json:"claimed_at,omitempty"`
+	HeartbeatAt      *time.Time             `json:"heartbeat_at,omitempty"` // refreshed by the claiming worker while RUNNING
+	Attempts         int                    `json:"attempts"`               // runs started so far
+	MaxAttempts      int                    `json:"max_attempts"`
+	NextRetryAt      *time.Time             `json:"next_retry_at,omitempty"` // set while a failed job waits to be retried
 	StartedAt        *time.Time             `json:"started_at,omitempty"`
 	FinishedAt       *time.Time             `json:"finished_at,omitempty"`
 	CreatedAt        time.Time              `json:"created_at"`
@@ -160,13 +547,569 @@ func (b *BatchJob) Progress() float64 {
 	return float64(b.ProcessedRecords) / float64(b.TotalRecords) * 100
 }
 
+// CanRetry reports whether a failed run may be retried rather than failing the job
+func (b *BatchJob) CanRetry() bool {
+	return b.Attempts < b.MaxAttempts
+}
+
+// Metadata keys overriding the worker pool's settings for one job
+const (
+	JobWorkerCountKey = "worker_count"
+	JobBatchSizeKey   = "batch_size"
+)
+
+// Overrides returns the job's worker count and batch size overrides, 0 where not set
+func (b *BatchJob) Overrides() (workerCount, batchSize int) {
+	return metadataInt(b.Metadata, JobWorkerCountKey), metadataInt(b.Metadata, JobBatchSizeKey)
+}
+
+// JobKeepVersionsKey is the metadata key of the superseded versions an ARCHIVE_HISTORY
+// job keeps in the history of each summary and step
+const JobKeepVersionsKey = "keep_versions"
+
+// KeepVersions returns the job's keep_versions setting, 0 where not set
+func (b *BatchJob) KeepVersions() int {
+	return metadataInt(b.Metadata, JobKeepVersionsKey)
+}
+
+// metadataInt reads an integer set in process or decoded from JSON (as float64)
+func metadataInt(metadata map[string]interface{}, key string) int {
+	switch v := metadata[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// JobCheckpointKey is the metadata key a recalculation stores its JobCheckpoint under
+const JobCheckpointKey = "checkpoint"
+
+// JobCheckpoint records how far a recalculation got: every variant up to and including
+// AfterVariantID (in ID order) has been written, with the counts at that point
+type JobCheckpoint struct {
+	AfterVariantID uuid.UUID `json:"after_variant_id"`
+	Processed      int64     `json:"processed"`
+	Failed         int64     `json:"failed"`
+}
+
+// Checkpoint returns the checkpoint stored in the job's metadata, if any
+func (b *BatchJob) Checkpoint() (*JobCheckpoint, bool) {
+	var checkpoint JobCheckpoint
+	if !decodeMetadata(b.Metadata, JobCheckpointKey, &checkpoint) || checkpoint.AfterVariantID == uuid.Nil {
+		return nil, false
+	}
+	return &checkpoint, true
+}
+
+// JobMetricsKey is the metadata key a recalculation stores its JobMetrics under
+const JobMetricsKey = "metrics"
+
+// JobMetrics records the performance of a job's latest recalculation run
+type JobMetrics struct {
+	Processed       int64     `json:"processed"` // variants written by the run
+	Failed          int64     `json:"failed"`
+	Throughput      float64   `json:"throughput"` // variants written per second
+	ElapsedSeconds  float64   `json:"elapsed_seconds"`
+	CacheSeconds    float64   `json:"cache_seconds"`   // loading routings and formulas
+	ProcessSeconds  float64   `json:"process_seconds"` // calculating and writing variants
+	Procs           int       `json:"procs"`
+	Workers         int       `json:"workers"`      // at the end of the run
+	AutoWorkers     bool      `json:"auto_workers"` // worker count tuned during the run
+	Writers         int       `json:"writers"`
+	Scanners        int       `json:"scanners"`         // lanes of the range dispatched in parallel
+	BatchSize       int       `json:"batch_size"`       // at the start of the run
+	MinBatchSize    int       `json:"min_batch_size"`   // smallest adaptive batch size used
+	MaxBatchSize    int       `json:"max_batch_size"`   // largest adaptive batch size used
+	StalledSeconds  float64   `json:"stalled_seconds"`  // workers waiting on writers, summed over workers
+	ThrottleSeconds float64   `json:"throttle_seconds"` // writers waiting on the write throttle, summed over writers
+	HeapAllocBytes  uint64    `json:"heap_alloc_bytes"` // at the end of the run
+	HeapPeakBytes   uint64    `json:"heap_peak_bytes"`  // highest sampled during the run
+	SysBytes        uint64    `json:"sys_bytes"`
+	TotalAllocBytes uint64    `json:"total_alloc_bytes"` // allocated during the run
+	NumGC           uint32    `json:"num_gc"`            // collections during the run
+	Stopped         bool      `json:"stopped"`           // paused or stopped before the end
+	RecordedAt      time.Time `json:"recorded_at"`
+}
+
+// Metrics returns the metrics stored in the job's metadata, if any
+func (b *BatchJob) Metrics() (*JobMetrics, bool) {
+	var metrics JobMetrics
+	if !decodeMetadata(b.Metadata, JobMetricsKey, &metrics) {
+		return nil, false
+	}
+	return &metrics, true
+}
+
+// Kinds of records an IMPORT_DATA job loads
+const (
+	ImportKindMasters    = "masters"
+	ImportKindVariants   = "variants"
+	ImportKindRates      = "rates"
+	ImportKindParameters = "parameters"
+)
+
+// Formats of imported and exported files
+const (
+	FileFormatCSV    = "csv"    // a header row naming the columns, then one record per row
+	FileFormatNDJSON = "ndjson" // one JSON object per line
+	FileFormatXLSX   = "xlsx"   // an Excel workbook whose first sheet is laid out like a CSV file
+)
+
+// ImportFile is the file staged for an IMPORT_DATA job
+type ImportFile struct {
+	JobID     uuid.UUID `json:"job_id"`
+	Kind      string    `json:"kind"`
+	Format    string    `json:"format"`
+	Filename  string    `json:"filename,omitempty"`
+	Content   []byte    `json:"-"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JobImportKey is the metadata key an import stores its ImportReport under
+const JobImportKey = "import"
+
+// ImportRowError is a rejected row of an import, numbered from 1 after the CSV header
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportReport is the progress and outcome of an import, saved after every batch so a
+// retried job continues after the last row it reached
+type ImportReport struct {
+	Kind            string           `json:"kind"`
+	Format          string           `json:"format"`
+	Rows            int              `json:"rows"` // rows read so far
+	Imported        int64            `json:"imported"`
+	Failed          int64            `json:"failed"`
+	Errors          []ImportRowError `json:"errors"` // the first rejected rows
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+}
+
+// ImportReport returns the import report stored in the job's metadata, if any
+func (b *BatchJob) ImportReport() (*ImportReport, bool) {
+	var report ImportReport
+	if !decodeMetadata(b.Metadata, JobImportKey, &report) {
+		return nil, false
+	}
+	return &report, true
+}
+
+// CostExportRow is a variant's cost summary as exported, with its SKU and master code
+type CostExportRow struct {
+	VariantCostSummary
+	SKU        string `json:"sku"`
+	MasterCode string `json:"master_code"`
+}
+
+// JobExportKey is the metadata key an export stores its ExportInfo under
+const JobExportKey = "export"
+
+// ExportInfo describes the file an EXPORT_DATA job writes
+type ExportInfo struct {
+	Format       string `json:"format"`
+	IncludeSteps bool   `json:"include_steps"` // per-process cost breakdown included
+	File         string `json:"file"`          // name in the export storage, set once written
+	Rows         int64  `json:"rows"`
+	SizeBytes    int64  `json:"size_bytes"`
+}
+
+// ExportInfo returns the export info stored in the job's metadata, if any
+func (b *BatchJob) ExportInfo() (*ExportInfo, bool) {
+	var info ExportInfo
+	if !decodeMetadata(b.Metadata, JobExportKey, &info) {
+		return nil, false
+	}
+	return &info, true
+}
+
+// decodeMetadata decodes the value stored under key, set in process or decoded from
+// JSON, into v. Reports false when it is missing or does not decode.
+func decodeMetadata(metadata map[string]interface{}, key string, v interface{}) bool {
+	raw, ok := metadata[key]
+	if !ok {
+		return false
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// JobMetricsSummary aggregates the metrics of recent jobs of one type, with a point per
+// job in finishing order for tracking trends
+type JobMetricsSummary struct {
+	JobType           JobType           `json:"job_type"`
+	Jobs              int               `json:"jobs"`
+	Processed         int64             `json:"processed"`
+	Failed            int64             `json:"failed"`
+	AvgThroughput     float64           `json:"avg_throughput"`
+	AvgElapsedSeconds float64           `json:"avg_elapsed_seconds"`
+	MaxHeapPeakBytes  uint64            `json:"max_heap_peak_bytes"`
+	Trend             []JobMetricsPoint `json:"trend"`
+}
+
+// JobMetricsPoint is one job's run in a JobMetricsSummary trend
+type JobMetricsPoint struct {
+	JobID          uuid.UUID `json:"job_id"`
+	RecordedAt     time.Time `json:"recorded_at"`
+	Processed      int64     `json:"processed"`
+	Failed         int64     `json:"failed"`
+	Throughput     float64   `json:"throughput"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+}
+
+// JobPartition is one variant ID range of a partitioned job, claimed and checkpointed
+// independently so several worker replicas can run one large job
+type JobPartition struct {
+	JobID            uuid.UUID  `json:"job_id"`
+	PartitionNo      int        `json:"partition_no"`
+	AfterVariantID   uuid.UUID  `json:"after_variant_id"`   // exclusive lower bound
+	ThroughVariantID uuid.UUID  `json:"through_variant_id"` // inclusive upper bound
+	Status           JobStatus  `json:"status"`
+	ClaimedBy        string     `json:"claimed_by,omitempty"`
+	Checkpoint       *uuid.UUID `json:"checkpoint,omitempty"` // last variant written, in ID order
+	ProcessedRecords int64      `json:"processed_records"`
+	FailedRecords    int64      `json:"failed_records"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+}
+
+// ResumeCheckpoint returns where a run of the partition starts: after its checkpoint
+// with the counts so far, or at the start of its range
+func (p *JobPartition) ResumeCheckpoint() JobCheckpoint {
+	checkpoint := JobCheckpoint{AfterVariantID: p.AfterVariantID}
+	if p.Checkpoint != nil {
+		checkpoint = JobCheckpoint{AfterVariantID: *p.Checkpoint, Processed: p.ProcessedRecords, Failed: p.FailedRecords}
+	}
+	return checkpoint
+}
+
+// JobChunk is one keyset page of variants dispatched by a recalculation. Chunks that
+// completed are skipped when an interrupted job resumes.
+type JobChunk struct {
+	JobID            uuid.UUID  `json:"job_id"`
+	AfterVariantID   uuid.UUID  `json:"after_variant_id"`   // exclusive start
+	ThroughVariantID uuid.UUID  `json:"through_variant_id"` // inclusive end
+	Status           JobStatus  `json:"status"`
+	ProcessedRecords int64      `json:"processed_records"`
+	FailedRecords    int64      `json:"failed_records"`
+	StartedAt        time.Time  `json:"started_at"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+}
+
+// Job event types
+const (
+	JobEventStatus   = "STATUS"   // the job changed status
+	JobEventProgress = "PROGRESS" // the job crossed another 10% of its total
+)
+
+// JobEvent is an entry of a job's event log, recorded by the database on every status
+// transition and progress milestone with the job's counts at that moment
+type JobEvent struct {
+	ID               uuid.UUID  `json:"id"`
+	JobID            uuid.UUID  `json:"job_id"`
+	EventType        string     `json:"event_type"`
+	FromStatus       *JobStatus `json:"from_status,omitempty"` // nil for the job's creation
+	ToStatus         JobStatus  `json:"to_status"`
+	ProcessedRecords int64      `json:"processed_records"`
+	FailedRecords    int64      `json:"failed_records"`
+	TotalRecords     int64      `json:"total_records"`
+	Message          string     `json:"message,omitempty"`
+	Actor            string     `json:"actor,omitempty"` // application name of the process that made the change
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// VariantDeadLetter tracks a variant whose calculation failed. Once it has failed
+// DeadLetterAfter times in a row it is dead-lettered and left out of full recalculations.
+type VariantDeadLetter struct {
+	VariantID      uuid.UUID  `json:"variant_id"`
+	SKU            string     `json:"sku,omitempty"`
+	FailureCount   int        `json:"failure_count"`
+	LastError      string     `json:"last_error"`
+	LastJobID      *uuid.UUID `json:"last_job_id,omitempty"`
+	FirstFailedAt  time.Time  `json:"first_failed_at"`
+	LastFailedAt   time.Time  `json:"last_failed_at"`
+	DeadLetteredAt *time.Time `json:"dead_lettered_at,omitempty"`
+}
+
+// Webhook event types, sent when a job reaches the status
+const (
+	WebhookEventJobCompleted = "job.completed"
+	WebhookEventJobFailed    = "job.failed"
+)
+
+// WebhookEventTypes lists the event types a subscription can receive
+var WebhookEventTypes = []string{WebhookEventJobCompleted, WebhookEventJobFailed}
+
+// WebhookSubscription asks for an HTTP callback to URL for jobs reaching one of EventTypes
+type WebhookSubscription struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"` // signs deliveries; only returned when the subscription is created
+	EventTypes []string  `json:"event_types"`
+	IsActive   bool      `json:"is_active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDeliveryStatus represents the status of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "DELIVERED"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "FAILED" // gave up after the last attempt
+)
+
+// WebhookDelivery is one event of a job to send to a subscription, retried with backoff
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id"`
+	SubscriptionID uuid.UUID             `json:"subscription_id"`
+	JobID          uuid.UUID             `json:"job_id"`
+	EventType      string                `json:"event_type"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  time.Time             `json:"next_attempt_at"`
+	ResponseStatus *int                  `json:"response_status,omitempty"` // HTTP status of the last attempt
+	LastError      string                `json:"last_error,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+}
+
+// JobSchedule materializes a batch job of JobType whenever its cron expression fires
+type JobSchedule struct {
+	ID             uuid.UUID              `json:"id"`
+	Name           string                 `json:"name"`
+	CronExpression string                 `json:"cron_expression"` // minute hour day-of-month month day-of-week
+	Timezone       string                 `json:"timezone"`        // IANA zone the expression is evaluated in
+	JobType        JobType                `json:"job_type"`
+	Params         map[string]interface{} `json:"params,omitempty"`   // copied into the job's metadata
+	Priority       *int                   `json:"priority,omitempty"` // nil uses the job type's default
+	IsActive       bool                   `json:"is_active"`
+	NextRunAt      time.Time              `json:"next_run_at"`
+	LastRunAt      *time.Time             `json:"last_run_at,omitempty"`
+	LastJobID      *uuid.UUID             `json:"last_job_id,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+}
+
 // PriceRate represents a pricing rate for a parameter
 type PriceRate struct {
-	ID            uuid.UUID  `json:"id"`
-	ParameterKey  string     `json:"parameter_key"`
-	RateValue     float64    `json:"rate_value"`
-	EffectiveDate time.Time  `json:"effective_date"`
-	ExpiredDate   *time.Time `json:"expired_date,omitempty"`
-	Notes         string     `json:"notes,omitempty"`
-	CreatedAt     time.Time  `json:"created_at"`
+	ID             uuid.UUID  `json:"id"`
+	ParameterKey   string     `json:"parameter_key"`
+	RateValue      float64    `json:"rate_value"`
+	RateExpression string     `json:"rate_expression,omitempty"` // derived from other rates, e.g. "base_oil_index * 0.8 + 5"
+	EffectiveDate  time.Time  `json:"effective_date"`
+	ExpiredDate    *time.Time `json:"expired_date,omitempty"`
+	Notes          string     `json:"notes,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// CostingConfig is the declarative costing configuration exported and applied as code.
+// Overhead is driven by the overhead_percentage parameter and travels with Parameters.
+type CostingConfig struct {
+	Version         int                     `json:"version" yaml:"version"`
+	ParameterGroups []*ConfigParameterGroup `json:"parameter_groups" yaml:"parameter_groups"`
+	Parameters      []*ConfigParameter      `json:"parameters" yaml:"parameters"`
+	Processes       []*ConfigProcess        `json:"processes" yaml:"processes"`
+	Formulas        []*ConfigFormula        `json:"formulas" yaml:"formulas"` // every version, which is immutable once created
+	Routings        []*ConfigRouting        `json:"routings" yaml:"routings"`
+	RoutingRules    []*ConfigRoutingRule    `json:"routing_rules" yaml:"routing_rules"` // the complete set of active rules
+}
+
+// ConfigParameterGroup is a parameter group in a CostingConfig, keyed by Code
+type ConfigParameterGroup struct {
+	Code        string `json:"code" yaml:"code"`
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// ConfigParameter is a master parameter in a CostingConfig, keyed by Key
+type ConfigParameter struct {
+	Key      string `json:"key" yaml:"key"`
+	Label    string `json:"label" yaml:"label"`
+	DataType string `json:"data_type" yaml:"data_type"`
+	Default  string `json:"default,omitempty" yaml:"default,omitempty"`
+	Group    string `json:"group,omitempty" yaml:"group,omitempty"`
+	Unit     string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Required bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Sequence int    `json:"sequence,omitempty" yaml:"sequence,omitempty"`
+}
+
+// ConfigProcess is a process master in a CostingConfig, keyed by Code
+type ConfigProcess struct {
+	Code            string  `json:"code" yaml:"code"`
+	Name            string  `json:"name" yaml:"name"`
+	Description     string  `json:"description,omitempty" yaml:"description,omitempty"`
+	DefaultSequence int     `json:"default_sequence,omitempty" yaml:"default_sequence,omitempty"`
+	OutputKgPerHour float64 `json:"output_kg_per_hour,omitempty" yaml:"output_kg_per_hour,omitempty"`
+	SetupHours      float64 `json:"setup_hours,omitempty" yaml:"setup_hours,omitempty"`
+}
+
+// ConfigFormula is one version of a library formula in a CostingConfig
+type ConfigFormula struct {
+	Name        string `json:"name" yaml:"name"`
+	Version     int    `json:"version" yaml:"version"`
+	Expression  string `json:"expression" yaml:"expression"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// ConfigRouting is a routing template with its complete list of steps, keyed by Name
+type ConfigRouting struct {
+	Name        string        `json:"name" yaml:"name"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Inactive    bool          `json:"inactive,omitempty" yaml:"inactive,omitempty"`
+	Steps       []*ConfigStep `json:"steps" yaml:"steps"`
+}
+
+// ConfigStep is a routing step keyed by Sequence. Exactly one of Expression and
+// Formula (a library reference, "name@version") is set.
+type ConfigStep struct {
+	Sequence    int    `json:"sequence" yaml:"sequence"`
+	Process     string `json:"process" yaml:"process"` // process code
+	Expression  string `json:"expression,omitempty" yaml:"expression,omitempty"`
+	Formula     string `json:"formula,omitempty" yaml:"formula,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// FormulaRef splits Formula into the library formula name and version
+func (s *ConfigStep) FormulaRef() (name string, version int, err error) {
+	name, v, ok := strings.Cut(s.Formula, "@")
+	if ok {
+		version, err = strconv.Atoi(v)
+	}
+	if !ok || err != nil || name == "" || version < 1 {
+		return "", 0, fmt.Errorf("formula reference %q must be name@version", s.Formula)
+	}
+	return name, version, nil
+}
+
+// ConfigRoutingRule is an active routing rule keyed by its FiberType and Grade
+type ConfigRoutingRule struct {
+	FiberType string `json:"fiber_type,omitempty" yaml:"fiber_type,omitempty"`
+	Grade     string `json:"grade,omitempty" yaml:"grade,omitempty"`
+	Routing   string `json:"routing" yaml:"routing"` // routing name
+	Priority  int    `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// ConfigChangeAction is what applying a CostingConfig does to one item
+type ConfigChangeAction string
+
+const (
+	ConfigChangeCreate ConfigChangeAction = "create"
+	ConfigChangeUpdate ConfigChangeAction = "update"
+	ConfigChangeDelete ConfigChangeAction = "delete"
+)
+
+// ConfigChange is one line of the diff between the stored and a desired CostingConfig
+type ConfigChange struct {
+	Action ConfigChangeAction `json:"action"`
+	Kind   string             `json:"kind"` // parameter_group, parameter, process, formula, routing, step, routing_rule
+	Key    string             `json:"key"`
+	Fields []string           `json:"fields,omitempty"` // changed fields of an update
+}
+
+func (c *ConfigChange) String() string {
+	symbol := map[ConfigChangeAction]string{ConfigChangeCreate: "+", ConfigChangeUpdate: "~", ConfigChangeDelete: "-"}[c.Action]
+	s := fmt.Sprintf("%s %s %s", symbol, c.Kind, c.Key)
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	return s
+}
+
+// FilterOp compares a field with the value of a Filter
+type FilterOp string
+
+const (
+	FilterEq       FilterOp = "eq"
+	FilterNe       FilterOp = "ne"
+	FilterLt       FilterOp = "lt"
+	FilterLte      FilterOp = "lte"
+	FilterGt       FilterOp = "gt"
+	FilterGte      FilterOp = "gte"
+	FilterContains FilterOp = "contains" // case-insensitive substring, text fields only
+	FilterIn       FilterOp = "in"       // Value is a comma-separated list
+	FilterBetween  FilterOp = "between"  // Value is low,high, both inclusive
+)
+
+// Filter restricts a search to rows whose Field compares to Value by Op. Value is text;
+// the repository checks it against the field's type and binds it as a parameter.
+type Filter struct {
+	Field string   `json:"field"`
+	Op    FilterOp `json:"op"`
+	Value string   `json:"value"`
+}
+
+// ParseFilter parses a filter written as field:op:value, e.g. grand_total:gte:1000. The
+// value may itself contain colons, as timestamps do.
+func ParseFilter(s string) (Filter, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return Filter{}, fmt.Errorf("invalid filter %q, expected field:op:value", s)
+	}
+	return Filter{Field: parts[0], Op: FilterOp(parts[1]), Value: parts[2]}, nil
+}
+
+// Cursor is a position in a list ordered by (created_at, id), newest first. Listing after
+// a cursor seeks straight to it, where an offset makes the database read and discard
+// every row before it. The zero Cursor is the start of a list.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// IsZero reports whether c is the start of a list
+func (c Cursor) IsZero() bool {
+	return c.ID == uuid.Nil
+}
+
+// String encodes c as an opaque token for API clients; the zero Cursor is ""
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + c.ID.String()))
+}
+
+// ParseCursor decodes a token made by Cursor.String; "" is the zero Cursor
+func ParseCursor(token string) (Cursor, error) {
+	if token == "" {
+		return Cursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	createdAt, id, ok := strings.Cut(string(raw), ",")
+	if err != nil || !ok {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	var c Cursor
+	c.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt)
+	if err == nil {
+		c.ID, err = uuid.Parse(id)
+	}
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", token)
+	}
+	return c, nil
+}
+
+// Search hit types
+const (
+	SearchHitMasterYarn  = "master_yarn"
+	SearchHitYarnVariant = "yarn_variant"
+)
+
+// SearchHit is a master yarn or variant matching a full-text search
+type SearchHit struct {
+	Type         string    `json:"type"`
+	ID           uuid.UUID `json:"id"`
+	Code         string    `json:"code"` // master yarn code or variant SKU
+	Name         string    `json:"name"` // name of the master yarn
+	MasterYarnID uuid.UUID `json:"master_yarn_id,omitempty"`
+	Rank         float64   `json:"rank"`
 }
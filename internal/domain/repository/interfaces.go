@@ -2,53 +2,129 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
 )
 
+// ErrExclusiveJobRunning is returned when a job of an exclusive type would run while
+// another job of that type is already RUNNING
+var ErrExclusiveJobRunning = errors.New("a job of this type is already running")
+
+// ErrInvalidFilter is returned by searches given a filter on an unknown field, with an
+// operator the field does not support, or with a value not of the field's type
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// ErrRateOverlap is returned when a new price rate's effective range overlaps a rate of
+// the same parameter
+var ErrRateOverlap = errors.New("price rate overlaps an existing rate of the parameter")
+
+type actorKey struct{}
+
+// WithActor returns ctx carrying who makes the changes written with it. Repositories
+// record the actor in the audit log of every change to master data, formulas and rates.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor ctx carries, or "" when there is none
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
 // MasterYarnRepository defines the interface for master yarn operations
 type MasterYarnRepository interface {
-	// Create creates a new master yarn
+	// Create creates a new master yarn, recording it in the audit log
 	Create(ctx context.Context, yarn *entity.MasterYarn) error
 	// CreateBatch creates multiple master yarns using COPY protocol
 	CreateBatch(ctx context.Context, yarns []*entity.MasterYarn) (int64, error)
+	// CreateBatchSkipExisting creates the master yarns whose ID or code is not taken yet,
+	// leaving existing ones as they are. Returns the number created.
+	CreateBatchSkipExisting(ctx context.Context, yarns []*entity.MasterYarn) (int64, error)
 	// GetByID retrieves a master yarn by ID
 	GetByID(ctx context.Context, id uuid.UUID) (*entity.MasterYarn, error)
 	// GetByCode retrieves a master yarn by code
 	GetByCode(ctx context.Context, code string) (*entity.MasterYarn, error)
 	// List retrieves master yarns with pagination
+	//
+	// Deprecated: use ListAfter; an offset reads every row it skips.
 	List(ctx context.Context, limit, offset int) ([]*entity.MasterYarn, error)
+	// ListAfter retrieves up to limit master yarns after cursor, newest first
+	ListAfter(ctx context.Context, cursor entity.Cursor, limit int) ([]*entity.MasterYarn, error)
+	// Search retrieves up to limit master yarns matching every filter after cursor, newest
+	// first. Returns ErrInvalidFilter for a filter it cannot apply.
+	Search(ctx context.Context, filters []entity.Filter, cursor entity.Cursor, limit int) ([]*entity.MasterYarn, error)
 	// Count returns the total count of master yarns
 	Count(ctx context.Context) (int64, error)
-	// Update updates a master yarn
+	// Update updates a master yarn, recording the changed fields in the audit log. Returns
+	// pgx.ErrNoRows when it does not exist.
 	Update(ctx context.Context, yarn *entity.MasterYarn) error
-	// Delete deletes a master yarn
+	// Delete deletes a master yarn, recording it in the audit log. Returns pgx.ErrNoRows
+	// when it does not exist.
 	Delete(ctx context.Context, id uuid.UUID) error
+	// GetFixedAttrs retrieves the fixed attributes of the given master yarns, keyed by ID
+	GetFixedAttrs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]map[string]interface{}, error)
+	// FindSimilarByName retrieves active master yarns whose names are trigram-similar, most similar first
+	FindSimilarByName(ctx context.Context, name string, limit int) ([]*entity.MasterYarn, error)
+	// MergeInto moves all variants of source to target and deactivates source, in one
+	// transaction with an audit log entry
+	MergeInto(ctx context.Context, sourceID, targetID uuid.UUID, actor string) (*entity.MasterRestructure, error)
+	// Split creates newMaster and moves the given variants of source to it, in one
+	// transaction with an audit log entry
+	Split(ctx context.Context, sourceID uuid.UUID, newMaster *entity.MasterYarn, variantIDs []uuid.UUID, actor string) (*entity.MasterRestructure, error)
 }
 
 // YarnVariantRepository defines the interface for yarn variant operations
 type YarnVariantRepository interface {
-	// Create creates a new yarn variant
+	// Create creates a new yarn variant, recording it in the audit log
 	Create(ctx context.Context, variant *entity.YarnVariant) error
 	// CreateBatch creates multiple variants using COPY protocol
 	CreateBatch(ctx context.Context, variants []*entity.YarnVariant) (int64, error)
+	// CreateBatchSkipExisting creates the variants whose ID or SKU is not taken yet,
+	// leaving existing ones as they are. Returns the number created.
+	CreateBatchSkipExisting(ctx context.Context, variants []*entity.YarnVariant) (int64, error)
 	// GetByID retrieves a variant by ID
 	GetByID(ctx context.Context, id uuid.UUID) (*entity.YarnVariant, error)
 	// GetBySKU retrieves a variant by SKU
 	GetBySKU(ctx context.Context, sku string) (*entity.YarnVariant, error)
 	// ListByMasterID retrieves variants by master yarn ID
+	//
+	// Deprecated: use ListAfter; an offset reads every row it skips.
 	ListByMasterID(ctx context.Context, masterID uuid.UUID, limit, offset int) ([]*entity.YarnVariant, error)
+	// ListAfter retrieves up to limit variants after cursor, newest first, of one master
+	// yarn or of all when masterID is uuid.Nil
+	ListAfter(ctx context.Context, masterID uuid.UUID, cursor entity.Cursor, limit int) ([]*entity.YarnVariant, error)
+	// Search retrieves up to limit variants matching every filter after cursor, newest
+	// first. Returns ErrInvalidFilter for a filter it cannot apply.
+	Search(ctx context.Context, filters []entity.Filter, cursor entity.Cursor, limit int) ([]*entity.YarnVariant, error)
 	// ListIDs retrieves variant IDs with pagination (for batch processing)
+	//
+	// Deprecated: use StreamWithRouting; an offset reads every row it skips.
 	ListIDs(ctx context.Context, limit, offset int) ([]uuid.UUID, error)
-	// ListWithRouting retrieves variants with their routing IDs (optimized for batch calc)
-	ListWithRouting(ctx context.Context, limit, offset int) ([]*entity.YarnVariant, error)
+	// StreamWithRouting calls fn, in ID order, with each active variant with an ID greater
+	// than afterID and up to throughID, with its master and routing IDs (optimized for
+	// batch calc; uuid.Nil and uuid.Max cover every variant), as rows arrive from a single
+	// query. A routingID other than uuid.Nil keeps only that routing template's variants.
+	// Dead-lettered variants are left out. An error from fn stops the stream and is returned.
+	StreamWithRouting(ctx context.Context, routingID, afterID, throughID uuid.UUID, fn func(*entity.YarnVariant) error) error
 	// ListUniqueRoutingIDs retrieves all unique routing template IDs
 	ListUniqueRoutingIDs(ctx context.Context) ([]uuid.UUID, error)
 	// Count returns the total count of variants
 	Count(ctx context.Context) (int64, error)
 	// CountByMasterID returns the count of variants for a master
 	CountByMasterID(ctx context.Context, masterID uuid.UUID) (int64, error)
+	// CountByRouting returns the count of active variants of a routing template that are
+	// not dead-lettered, the variants a routing recalculation covers
+	CountByRouting(ctx context.Context, routingID uuid.UUID) (int64, error)
+	// DeactivateByMasterID deactivates every active variant of a master in one statement,
+	// recording it in the master's audit log. Returns how many were deactivated.
+	DeactivateByMasterID(ctx context.Context, masterID uuid.UUID) (int64, error)
+	// ReassignRoutingByMasterID moves every variant of a master to a routing template in one
+	// statement, recording it in the master's audit log. Returns how many moved.
+	ReassignRoutingByMasterID(ctx context.Context, masterID, routingID uuid.UUID) (int64, error)
 }
 
 // ProcessStepRepository defines the interface for process step operations
@@ -57,13 +133,47 @@ type ProcessStepRepository interface {
 	GetByRoutingID(ctx context.Context, routingID uuid.UUID) ([]*entity.ProcessStep, error)
 	// GetByID retrieves a step by ID
 	GetByID(ctx context.Context, id uuid.UUID) (*entity.ProcessStep, error)
+	// UpdateFormula replaces the formula expression of a step and detaches any library
+	// formula, recording the change in the audit log
+	UpdateFormula(ctx context.Context, id uuid.UUID, expression string) error
+	// SetFormulaRef points a step at a library formula, recording the change in the audit log
+	SetFormulaRef(ctx context.Context, id uuid.UUID, formulaID uuid.UUID) error
+}
+
+// FormulaRepository defines the interface for the named formula library
+type FormulaRepository interface {
+	// Create creates a new formula version, recording it in the audit log
+	Create(ctx context.Context, f *entity.Formula) error
+	// GetByID retrieves a formula version by ID
+	GetByID(ctx context.Context, id uuid.UUID) (*entity.Formula, error)
+	// GetByName retrieves a formula by name; version 0 returns the latest version
+	GetByName(ctx context.Context, name string, version int) (*entity.Formula, error)
+	// List retrieves the latest version of every formula
+	List(ctx context.Context) ([]*entity.Formula, error)
+	// ListVersions retrieves all versions of a formula, newest first
+	ListVersions(ctx context.Context, name string) ([]*entity.Formula, error)
+	// Usage retrieves the process steps (and their routings) that reference a formula version
+	Usage(ctx context.Context, id uuid.UUID) ([]*entity.FormulaUsage, error)
+}
+
+// MasterParameterRepository defines the interface for parameter catalog operations
+type MasterParameterRepository interface {
+	// List retrieves all master parameters
+	List(ctx context.Context) ([]*entity.MasterParameter, error)
+	// Units returns the unit of every parameter that has one, keyed by parameter key
+	Units(ctx context.Context) (map[string]string, error)
+	// GroupCodes lists the codes of the parameter groups
+	GroupCodes(ctx context.Context) ([]string, error)
+	// CreateBatch inserts multiple parameters using COPY protocol
+	CreateBatch(ctx context.Context, params []*entity.MasterParameter) (int64, error)
 }
 
 // VariantProcessCostRepository defines the interface for variant process cost operations
 type VariantProcessCostRepository interface {
-	// Upsert creates or updates a variant process cost
+	// Upsert creates or updates the cost of a variant's step; a variant has one cost per step
 	Upsert(ctx context.Context, cost *entity.VariantProcessCost) error
-	// UpsertBatch creates or updates multiple costs using COPY protocol
+	// UpsertBatch creates or updates multiple costs using COPY protocol. Of two costs of
+	// the same step, the one updated last is kept.
 	UpsertBatch(ctx context.Context, costs []*entity.VariantProcessCost) (int64, error)
 	// GetByVariantID retrieves all costs for a variant
 	GetByVariantID(ctx context.Context, variantID uuid.UUID) ([]*entity.VariantProcessCost, error)
@@ -73,20 +183,68 @@ type VariantProcessCostRepository interface {
 
 // VariantCostSummaryRepository defines the interface for cost summary operations
 type VariantCostSummaryRepository interface {
-	// Upsert creates or updates a cost summary
+	// Upsert creates or updates a cost summary. A summary whose version hash is unchanged
+	// is not rewritten.
 	Upsert(ctx context.Context, summary *entity.VariantCostSummary) error
-	// UpsertBatch creates or updates multiple summaries
+	// UpsertBatch creates or updates multiple summaries, skipping those whose version hash
+	// is unchanged
 	UpsertBatch(ctx context.Context, summaries []*entity.VariantCostSummary) (int64, error)
 	// GetByVariantID retrieves a summary by variant ID
 	GetByVariantID(ctx context.Context, variantID uuid.UUID) (*entity.VariantCostSummary, error)
-	// List retrieves summaries with pagination
+	// List retrieves summaries with pagination, most recently updated first
+	//
+	// Deprecated: use ListAfter; an offset reads every row it skips.
 	List(ctx context.Context, limit, offset int) ([]*entity.VariantCostSummary, error)
+	// ListAfter retrieves up to limit summaries after cursor, newest first
+	ListAfter(ctx context.Context, cursor entity.Cursor, limit int) ([]*entity.VariantCostSummary, error)
+	// Search retrieves up to limit summaries matching every filter after cursor, newest
+	// first. Returns ErrInvalidFilter for a filter it cannot apply.
+	Search(ctx context.Context, filters []entity.Filter, cursor entity.Cursor, limit int) ([]*entity.VariantCostSummary, error)
+	// Count counts all summaries
+	Count(ctx context.Context) (int64, error)
+	// ListForExport retrieves up to limit summaries of variants after afterID, in variant ID
+	// order, with their SKU and master code
+	ListForExport(ctx context.Context, afterID uuid.UUID, limit int) ([]*entity.CostExportRow, error)
+	// CountMissing counts summaries whose field has not been computed yet
+	CountMissing(ctx context.Context, field string) (int64, error)
+	// Backfill computes field from the stored per-step costs for up to limit summaries
+	// missing it and returns how many were updated
+	Backfill(ctx context.Context, field string, limit int) (int64, error)
+}
+
+// ProductionLotRepository defines the interface for production lot operations
+type ProductionLotRepository interface {
+	// Create creates a new production lot
+	Create(ctx context.Context, lot *entity.ProductionLot) error
+	// GetByID retrieves a lot by ID
+	GetByID(ctx context.Context, id uuid.UUID) (*entity.ProductionLot, error)
+	// ListByVariantID retrieves the lots of a variant, newest production date first
+	ListByVariantID(ctx context.Context, variantID uuid.UUID) ([]*entity.ProductionLot, error)
+	// UpdateStatus moves a lot to a new lifecycle status
+	UpdateStatus(ctx context.Context, id uuid.UUID, status entity.LotStatus) error
+	// UpdateCosts stores lot actuals with the resulting standard and actual unit costs
+	UpdateCosts(ctx context.Context, id uuid.UUID, actualValues map[string]interface{}, standardCost, actualCost float64) error
+	// CostReportByMaster retrieves standard vs actual cost lines for all lots of a master's variants
+	CostReportByMaster(ctx context.Context, masterID uuid.UUID) ([]*entity.LotCostLine, error)
+}
+
+// AuditLogRepository defines the interface for reading the audit log. Entries are
+// written by the repositories of the audited entities, in the transaction of the change.
+type AuditLogRepository interface {
+	// ListByEntity retrieves the audit entries of an entity, newest first
+	ListByEntity(ctx context.Context, entityType string, entityID uuid.UUID, limit int) ([]*entity.AuditLog, error)
+	// List retrieves up to limit entries matching filter after cursor, newest first
+	List(ctx context.Context, filter entity.AuditLogFilter, cursor entity.Cursor, limit int) ([]*entity.AuditLog, error)
 }
 
 // BatchJobRepository defines the interface for batch job operations
 type BatchJobRepository interface {
-	// Create creates a new batch job
+	// Create creates a new batch job. Returns ErrExclusiveJobRunning when job is RUNNING and
+	// of an exclusive type another RUNNING job already has.
 	Create(ctx context.Context, job *entity.BatchJob) error
+	// GetRunning retrieves the RUNNING job of jobType, the oldest if there are several.
+	// Returns pgx.ErrNoRows when none is running.
+	GetRunning(ctx context.Context, jobType entity.JobType) (*entity.BatchJob, error)
 	// GetByID retrieves a job by ID
 	GetByID(ctx context.Context, id uuid.UUID) (*entity.BatchJob, error)
 	// UpdateStatus updates a job's status and progress
@@ -95,10 +253,165 @@ type BatchJobRepository interface {
 	UpdateProgress(ctx context.Context, id uuid.UUID, processed, failed int64) error
 	// Complete marks a job as completed
 	Complete(ctx context.Context, id uuid.UUID) error
-	// Fail marks a job as failed
+	// Fail marks a job as terminally failed
 	Fail(ctx context.Context, id uuid.UUID, errorMsg string) error
+	// Retry returns a failed job to PENDING, unclaimed and not claimable before nextRetryAt
+	Retry(ctx context.Context, id uuid.UUID, errorMsg string, nextRetryAt time.Time) error
+	// SaveCheckpoint stores checkpoint in the job's metadata
+	SaveCheckpoint(ctx context.Context, id uuid.UUID, checkpoint *entity.JobCheckpoint) error
+	// SaveMetrics stores a run's metrics in the job's metadata
+	SaveMetrics(ctx context.Context, id uuid.UUID, metrics *entity.JobMetrics) error
+	// ListWithMetrics retrieves the latest jobs of jobType with metrics recorded since since,
+	// most recent first
+	ListWithMetrics(ctx context.Context, jobType entity.JobType, since time.Time, limit int) ([]*entity.BatchJob, error)
+	// Pause marks a PENDING or RUNNING job PAUSED. Returns pgx.ErrNoRows when the job is in
+	// neither status.
+	Pause(ctx context.Context, id uuid.UUID) error
+	// Resume returns a PAUSED or FAILED job to PENDING, unclaimed. Returns pgx.ErrNoRows when
+	// the job is in neither status.
+	Resume(ctx context.Context, id uuid.UUID) error
+	// Release returns a RUNNING job its worker stopped early to PENDING, unclaimed, without
+	// counting the interrupted run as an attempt
+	Release(ctx context.Context, id uuid.UUID) error
+	// Heartbeat refreshes a RUNNING job's heartbeat_at
+	Heartbeat(ctx context.Context, id uuid.UUID) error
+	// ListStale retrieves RUNNING jobs whose last heartbeat is before staleBefore
+	ListStale(ctx context.Context, staleBefore time.Time) ([]*entity.BatchJob, error)
+	// ReleaseStale moves a job that is still RUNNING with a heartbeat before staleBefore to
+	// status (PENDING or FAILED), unclaimed, with errorMsg. Reports whether it was released;
+	// false means it heartbeated meanwhile or another reaper got there first.
+	ReleaseStale(ctx context.Context, id uuid.UUID, staleBefore time.Time, status entity.JobStatus, errorMsg string) (bool, error)
 	// ListRecent retrieves recent jobs
 	ListRecent(ctx context.Context, limit int) ([]*entity.BatchJob, error)
+	// ClaimNextPending marks the highest priority, then oldest, PENDING job whose retry time
+	// has passed RUNNING and claimed by workerID, counting an attempt and skipping jobs
+	// locked by other workers and jobs of an exclusive type that already has one RUNNING.
+	// Returns pgx.ErrNoRows when none is pending.
+	ClaimNextPending(ctx context.Context, workerID string) (*entity.BatchJob, error)
+	// SetTotal sets the number of records a job will process
+	SetTotal(ctx context.Context, id uuid.UUID, total int64) error
+	// SaveImportReport stores an import's report in the job's metadata
+	SaveImportReport(ctx context.Context, id uuid.UUID, report *entity.ImportReport) error
+	// SaveExportInfo stores an export's file details in the job's metadata
+	SaveExportInfo(ctx context.Context, id uuid.UUID, info *entity.ExportInfo) error
+	// DeleteFinishedBefore deletes up to limit COMPLETED, FAILED or CANCELLED jobs that
+	// finished before before, with their partitions, chunks, events and webhook deliveries.
+	// Returns how many were deleted.
+	DeleteFinishedBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// JobPartitionRepository defines the interface for partitioned job operations
+type JobPartitionRepository interface {
+	// Create sets the job's total and creates its partitions, unless it already has some
+	// from an earlier run, which then continue from their checkpoints
+	Create(ctx context.Context, jobID uuid.UUID, total int64, partitions []*entity.JobPartition) error
+	// Claim claims an unfinished partition of a RUNNING job, highest job priority first,
+	// holding an advisory lock until release is called. A RUNNING partition whose lock is
+	// free lost its worker and is taken over. Returns pgx.ErrNoRows when none is available.
+	Claim(ctx context.Context, workerID string) (partition *entity.JobPartition, release func(), err error)
+	// SaveCheckpoint stores a partition's checkpoint and counts and refreshes the job's
+	// processed and failed records from all of its partitions
+	SaveCheckpoint(ctx context.Context, jobID uuid.UUID, partitionNo int, checkpoint *entity.JobCheckpoint) error
+	// Complete marks a partition COMPLETED with its final checkpoint and completes the job
+	// when it was the last one. Reports whether the job completed.
+	Complete(ctx context.Context, jobID uuid.UUID, partitionNo int, checkpoint *entity.JobCheckpoint) (bool, error)
+	// ListByJob retrieves a job's partitions
+	ListByJob(ctx context.Context, jobID uuid.UUID) ([]*entity.JobPartition, error)
+}
+
+// JobChunkRepository defines the interface for recalculation chunk operations
+type JobChunkRepository interface {
+	// Start records a dispatched chunk as RUNNING, replacing an unfinished chunk of an
+	// earlier run that started at the same variant
+	Start(ctx context.Context, chunk *entity.JobChunk) error
+	// Complete marks chunks COMPLETED with their counts
+	Complete(ctx context.Context, chunks []*entity.JobChunk) error
+	// ListCompleted retrieves a job's completed chunks within (after, through], in ID order
+	ListCompleted(ctx context.Context, jobID, after, through uuid.UUID) ([]*entity.JobChunk, error)
+	// CountByStatus counts a job's chunks per status
+	CountByStatus(ctx context.Context, jobID uuid.UUID) (map[entity.JobStatus]int64, error)
+}
+
+// ImportRepository defines the interface for staged data imports
+type ImportRepository interface {
+	// Stage creates an IMPORT_DATA job together with its staged file
+	Stage(ctx context.Context, job *entity.BatchJob, file *entity.ImportFile) error
+	// GetFile retrieves the file staged for a job. Returns pgx.ErrNoRows once it is deleted.
+	GetFile(ctx context.Context, jobID uuid.UUID) (*entity.ImportFile, error)
+	// DeleteFile deletes the file staged for a job
+	DeleteFile(ctx context.Context, jobID uuid.UUID) error
+	// MasterIDsByCode maps those of codes that belong to a master yarn to its ID
+	MasterIDsByCode(ctx context.Context, codes []string) (map[string]uuid.UUID, error)
+	// ExistingSKUs reports which of skus a variant already has
+	ExistingSKUs(ctx context.Context, skus []string) (map[string]bool, error)
+	// ExistingRates reports, for each parameter key and effective date pair, whether a
+	// price rate already exists for it
+	ExistingRates(ctx context.Context, keys []string, dates []time.Time) ([]bool, error)
+}
+
+// JobEventRepository defines the interface for reading job event logs
+type JobEventRepository interface {
+	// ListByJob retrieves a job's events, oldest first
+	ListByJob(ctx context.Context, jobID uuid.UUID, limit int) ([]*entity.JobEvent, error)
+	// DeleteBefore deletes up to limit events recorded before before, returning how many
+	DeleteBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// VariantDeadLetterRepository defines the interface for failing variant operations
+type VariantDeadLetterRepository interface {
+	// RecordFailures counts a failure of each variant with its error, dead-lettering those
+	// that reach deadLetterAfter failures
+	RecordFailures(ctx context.Context, jobID uuid.UUID, variantIDs []uuid.UUID, errs []string, deadLetterAfter int) error
+	// ListFailingIDs retrieves variants with failures that are not dead-lettered yet
+	ListFailingIDs(ctx context.Context) ([]uuid.UUID, error)
+	// ClearFailures forgets the failures of variants that calculated again
+	ClearFailures(ctx context.Context, variantIDs []uuid.UUID) error
+	// List retrieves dead-lettered variants, most recent first
+	List(ctx context.Context, limit, offset int) ([]*entity.VariantDeadLetter, error)
+	// Count returns the number of dead-lettered variants
+	Count(ctx context.Context) (int64, error)
+	// Requeue removes a variant from the dead letters so it is calculated again. Returns
+	// pgx.ErrNoRows when it is not dead-lettered.
+	Requeue(ctx context.Context, variantID uuid.UUID) error
+}
+
+// WebhookRepository defines the interface for webhook subscription and delivery operations.
+// Deliveries are queued by the database when a job reaches a subscribed status.
+type WebhookRepository interface {
+	// CreateSubscription creates a new subscription
+	CreateSubscription(ctx context.Context, sub *entity.WebhookSubscription) error
+	// GetSubscription retrieves a subscription by ID
+	GetSubscription(ctx context.Context, id uuid.UUID) (*entity.WebhookSubscription, error)
+	// ListSubscriptions retrieves all subscriptions
+	ListSubscriptions(ctx context.Context) ([]*entity.WebhookSubscription, error)
+	// DeleteSubscription deletes a subscription and its deliveries. Returns pgx.ErrNoRows
+	// when it does not exist.
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	// ClaimDue claims up to limit PENDING deliveries that are due, counting an attempt and
+	// holding them for lease, after which a delivery whose sender died is due again
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*entity.WebhookDelivery, error)
+	// MarkDelivered marks a delivery DELIVERED with the response status
+	MarkDelivered(ctx context.Context, id uuid.UUID, responseStatus int) error
+	// MarkFailed records a failed attempt: retried at nextAttemptAt, or FAILED for good
+	// when nextAttemptAt is nil. responseStatus is nil when no response was received.
+	MarkFailed(ctx context.Context, id uuid.UUID, responseStatus *int, errorMsg string, nextAttemptAt *time.Time) error
+	// ListDeliveries retrieves a subscription's most recent deliveries
+	ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit int) ([]*entity.WebhookDelivery, error)
+}
+
+// JobScheduleRepository defines the interface for recurring job schedule operations
+type JobScheduleRepository interface {
+	// Create creates a new schedule
+	Create(ctx context.Context, schedule *entity.JobSchedule) error
+	// List retrieves all schedules
+	List(ctx context.Context) ([]*entity.JobSchedule, error)
+	// Delete deletes a schedule
+	Delete(ctx context.Context, id uuid.UUID) error
+	// ListDue retrieves the active schedules whose next run is at or before now
+	ListDue(ctx context.Context, now time.Time) ([]*entity.JobSchedule, error)
+	// Fire creates job and advances the schedule to nextRunAt in one transaction, unless
+	// another worker already fired this run. Reports whether the job was created.
+	Fire(ctx context.Context, schedule *entity.JobSchedule, job *entity.BatchJob, nextRunAt time.Time) (bool, error)
 }
 
 // RoutingTemplateRepository defines the interface for routing template operations
@@ -111,6 +424,17 @@ type RoutingTemplateRepository interface {
 	Create(ctx context.Context, template *entity.RoutingTemplate) error
 }
 
+// RoutingRuleRepository defines the interface for default routing rule operations
+type RoutingRuleRepository interface {
+	// ListActive retrieves all active routing rules
+	ListActive(ctx context.Context) ([]*entity.RoutingRule, error)
+	// Create creates a new routing rule, recording it in the audit log
+	Create(ctx context.Context, rule *entity.RoutingRule) error
+	// Delete deletes a routing rule, recording it in the audit log. Returns pgx.ErrNoRows
+	// when it does not exist.
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
 // ProcessMasterRepository defines the interface for process master operations
 type ProcessMasterRepository interface {
 	// GetByID retrieves a process master by ID
@@ -127,10 +451,54 @@ type ProcessMasterRepository interface {
 type PriceRateRepository interface {
 	// GetCurrentRate retrieves the current rate for a parameter
 	GetCurrentRate(ctx context.Context, parameterKey string) (*entity.PriceRate, error)
-	// GetAllCurrentRates retrieves all current rates
+	// GetRateAsOf retrieves the rate of a parameter in effect on date
+	GetRateAsOf(ctx context.Context, parameterKey string, date time.Time) (*entity.PriceRate, error)
+	// History retrieves every rate of a parameter, latest effective first
+	History(ctx context.Context, parameterKey string) ([]*entity.PriceRate, error)
+	// GetAllCurrentRates retrieves all current literal rates; derived (expression) rates are omitted
 	GetAllCurrentRates(ctx context.Context) (map[string]float64, error)
-	// Create creates a new price rate
+	// ListCurrent retrieves the current rate of every parameter, including derived rates
+	ListCurrent(ctx context.Context) ([]*entity.PriceRate, error)
+	// Create creates a new price rate, recording it in the audit log. An open-ended rate
+	// of the parameter that took effect earlier expires when rate takes effect; an open
+	// rate expires when the next rate of the parameter does. Returns ErrRateOverlap when
+	// rate still overlaps another rate.
 	Create(ctx context.Context, rate *entity.PriceRate) error
-	// CreateBatch creates multiple rates
+	// CreateBatch creates multiple rates, expiring open-ended rates as Create does. Returns
+	// ErrRateOverlap when a rate overlaps another.
 	CreateBatch(ctx context.Context, rates []*entity.PriceRate) (int64, error)
 }
+
+// ConfigRepository reads and writes the costing configuration as a whole
+type ConfigRepository interface {
+	// Export reads the complete costing configuration
+	Export(ctx context.Context) (*entity.CostingConfig, error)
+	// Apply upserts cfg in one transaction. Steps missing from a routing in cfg are
+	// deleted and active routing rules missing from cfg are deactivated; nothing else
+	// is deleted. changes, the planned diff, is recorded in the audit log.
+	Apply(ctx context.Context, cfg *entity.CostingConfig, changes []*entity.ConfigChange) error
+}
+
+// SearchRepository finds master yarns and variants by free text
+type SearchRepository interface {
+	// Search retrieves up to limit master yarns and up to limit variants matching every word
+	// of query, most relevant first. A variant matches on its SKU and batch number and on
+	// its master yarn's code and name.
+	Search(ctx context.Context, query string, limit int) ([]*entity.SearchHit, error)
+}
+
+// CostHistoryRepository reads and archives the summaries and per-step costs that
+// recalculations superseded
+type CostHistoryRepository interface {
+	// SummaryHistory retrieves up to limit superseded summaries of a variant, latest first,
+	// including archived ones when includeArchived is set
+	SummaryHistory(ctx context.Context, variantID uuid.UUID, includeArchived bool, limit int) ([]*entity.CostSummaryVersion, error)
+	// ProcessCostHistory retrieves up to limit superseded per-step costs of a variant,
+	// latest first, including archived ones when includeArchived is set
+	ProcessCostHistory(ctx context.Context, variantID uuid.UUID, includeArchived bool, limit int) ([]*entity.ProcessCostVersion, error)
+	// ArchiveAfter moves the history of up to limit variants after afterID, in ID order,
+	// to the archive, all but the keep latest versions of each summary and step. Returns
+	// the last variant covered, uuid.Nil when no variant with history is left, and the
+	// summaries and costs moved.
+	ArchiveAfter(ctx context.Context, afterID uuid.UUID, limit, keep int) (lastID uuid.UUID, summaries, costs int64, err error)
+}
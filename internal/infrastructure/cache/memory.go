@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+const (
+	// invalidateAll is the payload that drops every cached summary
+	invalidateAll = "*"
+	// maxPayloadIDs keeps an invalidation payload under PostgreSQL's 8000 byte NOTIFY limit
+	maxPayloadIDs = 200
+	// watchRetryDelay is how long Watch waits before listening again after an error
+	watchRetryDelay = 5 * time.Second
+)
+
+// MemoryStore is a least recently used cache of summaries in the process. Processes
+// publish the IDs they write on a Channel, and each store drops them on receipt.
+type MemoryStore struct {
+	size   int
+	ttl    time.Duration
+	events Channel
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]*list.Element
+	order   *list.List // most recently used first
+}
+
+type memoryEntry struct {
+	summary *entity.VariantCostSummary
+	expires time.Time
+}
+
+// NewMemoryStore creates a store holding up to size summaries, each for up to ttl
+func NewMemoryStore(size int, ttl time.Duration, events Channel) *MemoryStore {
+	return &MemoryStore{
+		size:    max(1, size),
+		ttl:     ttl,
+		events:  events,
+		entries: make(map[uuid.UUID]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, variantID uuid.UUID) (*entity.VariantCostSummary, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[variantID]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		s.order.Remove(el)
+		delete(s.entries, variantID)
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return entry.summary, true
+}
+
+func (s *MemoryStore) Set(ctx context.Context, summary *entity.VariantCostSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := &memoryEntry{summary: summary, expires: time.Now().Add(s.ttl)}
+	if el, ok := s.entries[summary.YarnVariantID]; ok {
+		el.Value = entry
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[summary.YarnVariantID] = s.order.PushFront(entry)
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).summary.YarnVariantID)
+	}
+}
+
+// Invalidate drops variantIDs here and publishes them to the other processes
+func (s *MemoryStore) Invalidate(ctx context.Context, variantIDs []uuid.UUID) error {
+	if variantIDs == nil {
+		s.drop(invalidateAll)
+		return s.events.NotifyAll(ctx, []string{invalidateAll})
+	}
+	payloads := make([]string, 0, (len(variantIDs)+maxPayloadIDs-1)/maxPayloadIDs)
+	var payload strings.Builder
+	for start := 0; start < len(variantIDs); start += maxPayloadIDs {
+		payload.Reset()
+		for i, id := range variantIDs[start:min(start+maxPayloadIDs, len(variantIDs))] {
+			if i > 0 {
+				payload.WriteByte(',')
+			}
+			payload.WriteString(id.String())
+		}
+		s.drop(payload.String())
+		payloads = append(payloads, payload.String())
+	}
+	return s.events.NotifyAll(ctx, payloads)
+}
+
+// Watch drops the summaries other processes invalidate until ctx is done. Everything is
+// dropped whenever listening starts, since invalidations may have been missed meanwhile.
+func (s *MemoryStore) Watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.drop(invalidateAll)
+		err := s.events.Listen(ctx, s.drop)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Summary cache invalidation listener stopped: %v; retrying in %v", err, watchRetryDelay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// drop removes the summaries of an invalidation payload: comma-separated variant IDs, or
+// invalidateAll
+func (s *MemoryStore) drop(payload string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if payload == invalidateAll {
+		clear(s.entries)
+		s.order.Init()
+		return
+	}
+	for _, part := range strings.Split(payload, ",") {
+		id, err := uuid.Parse(part)
+		if err != nil {
+			continue
+		}
+		if el, ok := s.entries[id]; ok {
+			s.order.Remove(el)
+			delete(s.entries, id)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+const (
+	// redisKeyPrefix namespaces summary keys, which are the prefix and the variant ID
+	redisKeyPrefix = "costing:summary:"
+	// redisTimeout bounds a command whose context has no deadline
+	redisTimeout = 2 * time.Second
+	// redisIdleConns is how many connections are kept open between commands
+	redisIdleConns = 16
+	// redisDeleteBatch is how many keys one DEL removes
+	redisDeleteBatch = 500
+)
+
+// RedisStore caches summaries in Redis, shared by every process, so a write invalidates
+// them for all readers at once. It speaks just enough RESP for GET, SET, DEL and SCAN.
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	ttl      time.Duration
+	idle     chan *redisConn
+}
+
+// NewRedisStore creates a store on the Redis at addr, keeping summaries for ttl
+func NewRedisStore(addr, password string, db int, ttl time.Duration) *RedisStore {
+	return &RedisStore{addr: addr, password: password, db: db, ttl: ttl, idle: make(chan *redisConn, redisIdleConns)}
+}
+
+// Ping checks that Redis is reachable and accepts the credentials
+func (s *RedisStore) Ping(ctx context.Context) error {
+	_, err := s.do(ctx, "PING")
+	return err
+}
+
+// Get treats Redis errors as misses, so the database still serves reads while Redis is down
+func (s *RedisStore) Get(ctx context.Context, variantID uuid.UUID) (*entity.VariantCostSummary, bool) {
+	reply, err := s.do(ctx, "GET", redisKeyPrefix+variantID.String())
+	value, ok := reply.(string)
+	if err != nil || !ok {
+		return nil, false
+	}
+	var summary entity.VariantCostSummary
+	if err := json.Unmarshal([]byte(value), &summary); err != nil {
+		return nil, false
+	}
+	return &summary, true
+}
+
+func (s *RedisStore) Set(ctx context.Context, summary *entity.VariantCostSummary) {
+	value, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+	s.do(ctx, "SET", redisKeyPrefix+summary.YarnVariantID.String(), string(value), "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+}
+
+func (s *RedisStore) Invalidate(ctx context.Context, variantIDs []uuid.UUID) error {
+	if variantIDs == nil {
+		return s.deleteAll(ctx)
+	}
+	for start := 0; start < len(variantIDs); start += redisDeleteBatch {
+		args := []string{"DEL"}
+		for _, id := range variantIDs[start:min(start+redisDeleteBatch, len(variantIDs))] {
+			args = append(args, redisKeyPrefix+id.String())
+		}
+		if _, err := s.do(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAll deletes every summary key, scanning rather than blocking Redis with KEYS
+func (s *RedisStore) deleteAll(ctx context.Context) error {
+	cursor := "0"
+	for {
+		reply, err := s.do(ctx, "SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", strconv.Itoa(redisDeleteBatch))
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if k, ok := key.(string); ok {
+					args = append(args, k)
+				}
+			}
+			if _, err := s.do(ctx, args...); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// redisError is an error reply; the connection stays usable after one
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// do runs one command on an idle or new connection and returns its reply: a string,
+// int64, []interface{} or nil
+func (s *RedisStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(ctx, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// conn takes an idle connection or dials, authenticates and selects the database
+func (s *RedisStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+	dialer := net.Dialer{Timeout: redisTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+	if s.password != "" {
+		if _, err := conn.do(ctx, []string{"AUTH", s.password}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := conn.do(ctx, []string{"SELECT", strconv.Itoa(s.db)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *redisConn) do(ctx context.Context, args []string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	c.SetDeadline(deadline)
+
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads one RESP reply
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := c.read()
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				// Keep reading so the connection stays in step
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/config"
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// Summary cache modes
+const (
+	ModeOff    = "off"
+	ModeMemory = "memory" // an LRU per process, invalidated across processes over NOTIFY
+	ModeRedis  = "redis"  // one Redis shared by every process
+)
+
+// Store caches cost summaries by variant ID
+type Store interface {
+	// Get returns the cached summary of a variant, if any
+	Get(ctx context.Context, variantID uuid.UUID) (*entity.VariantCostSummary, bool)
+	// Set caches summary, which must not be modified afterwards
+	Set(ctx context.Context, summary *entity.VariantCostSummary)
+	// Invalidate drops the summaries of variantIDs in every process, or every summary when
+	// variantIDs is nil
+	Invalidate(ctx context.Context, variantIDs []uuid.UUID) error
+}
+
+// Channel carries invalidations between processes sharing the database
+type Channel interface {
+	NotifyAll(ctx context.Context, payloads []string) error
+	Listen(ctx context.Context, handle func(payload string)) error
+}
+
+// OpenSummaryStore returns the store cfg selects, nil when caching is off. A memory store
+// listens on events until ctx is done to drop summaries other processes write.
+func OpenSummaryStore(ctx context.Context, cfg *config.SummaryCacheConfig, events Channel) (Store, error) {
+	switch cfg.Mode {
+	case ModeOff, "":
+		return nil, nil
+	case ModeMemory:
+		store := NewMemoryStore(cfg.Size, cfg.TTL, events)
+		go store.Watch(ctx)
+		return store, nil
+	case ModeRedis:
+		store := NewRedisStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.TTL)
+		if err := store.Ping(ctx); err != nil {
+			return nil, fmt.Errorf("failed to reach Redis at %s: %w", cfg.RedisAddr, err)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown summary cache mode %q", cfg.Mode)
+	}
+}
+
+// summaryRepo serves GetByVariantID from a Store and invalidates summaries as they are
+// written; every other method goes straight to the wrapped repository
+type summaryRepo struct {
+	repository.VariantCostSummaryRepository
+	store Store
+}
+
+// NewSummaryRepository wraps summaries with store, returning it unchanged when store is nil
+func NewSummaryRepository(summaries repository.VariantCostSummaryRepository, store Store) repository.VariantCostSummaryRepository {
+	if store == nil {
+		return summaries
+	}
+	return &summaryRepo{VariantCostSummaryRepository: summaries, store: store}
+}
+
+func (r *summaryRepo) GetByVariantID(ctx context.Context, variantID uuid.UUID) (*entity.VariantCostSummary, error) {
+	if summary, ok := r.store.Get(ctx, variantID); ok {
+		return summary, nil
+	}
+	summary, err := r.VariantCostSummaryRepository.GetByVariantID(ctx, variantID)
+	if err != nil {
+		return nil, err
+	}
+	r.store.Set(ctx, summary)
+	return summary, nil
+}
+
+func (r *summaryRepo) Upsert(ctx context.Context, summary *entity.VariantCostSummary) error {
+	if err := r.VariantCostSummaryRepository.Upsert(ctx, summary); err != nil {
+		return err
+	}
+	r.invalidate(ctx, []uuid.UUID{summary.YarnVariantID})
+	return nil
+}
+
+func (r *summaryRepo) UpsertBatch(ctx context.Context, summaries []*entity.VariantCostSummary) (int64, error) {
+	count, err := r.VariantCostSummaryRepository.UpsertBatch(ctx, summaries)
+	if err != nil || len(summaries) == 0 {
+		return count, err
+	}
+	ids := make([]uuid.UUID, len(summaries))
+	for i, s := range summaries {
+		ids[i] = s.YarnVariantID
+	}
+	r.invalidate(ctx, ids)
+	return count, nil
+}
+
+func (r *summaryRepo) Backfill(ctx context.Context, field string, limit int) (int64, error) {
+	updated, err := r.VariantCostSummaryRepository.Backfill(ctx, field, limit)
+	if updated > 0 {
+		// Backfill does not say which summaries it updated
+		r.invalidate(ctx, nil)
+	}
+	return updated, err
+}
+
+// invalidate drops written summaries. The write already committed, so a failure is only
+// logged; entries expire after the TTL regardless.
+func (r *summaryRepo) invalidate(ctx context.Context, ids []uuid.UUID) {
+	if err := r.store.Invalidate(ctx, ids); err != nil {
+		log.Printf("Failed to invalidate cached summaries: %v", err)
+	}
+}
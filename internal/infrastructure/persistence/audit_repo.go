@@ -0,0 +1,122 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// auditLogRepo implements repository.AuditLogRepository
+type auditLogRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewAuditLogRepository creates a new audit log repository
+func NewAuditLogRepository(pool *pgxpool.Pool) repository.AuditLogRepository {
+	return &auditLogRepo{pool: pool}
+}
+
+const auditLogColumns = `id, entity_type, entity_id, action, COALESCE(actor, ''), changes, details, created_at`
+
+func (r *auditLogRepo) ListByEntity(ctx context.Context, entityType string, entityID uuid.UUID, limit int) ([]*entity.AuditLog, error) {
+	query := `
+		SELECT ` + auditLogColumns + `
+		FROM audit_logs WHERE entity_type = $1 AND entity_id = $2
+		ORDER BY created_at DESC LIMIT $3
+	`
+	return r.query(ctx, query, entityType, entityID, limit)
+}
+
+func (r *auditLogRepo) List(ctx context.Context, filter entity.AuditLogFilter, cursor entity.Cursor, limit int) ([]*entity.AuditLog, error) {
+	query := `SELECT ` + auditLogColumns + ` FROM audit_logs WHERE TRUE `
+	args := []interface{}{limit}
+	if filter.EntityType != "" {
+		args = append(args, filter.EntityType)
+		query += fmt.Sprintf("AND entity_type = $%d ", len(args))
+	}
+	if filter.EntityID != uuid.Nil {
+		args = append(args, filter.EntityID)
+		query += fmt.Sprintf("AND entity_id = $%d ", len(args))
+	}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		query += fmt.Sprintf("AND actor = $%d ", len(args))
+	}
+	if !cursor.IsZero() {
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		query += fmt.Sprintf("AND (created_at, id) < ($%d, $%d) ", len(args)-1, len(args))
+	}
+	query += "ORDER BY created_at DESC, id DESC LIMIT $1"
+	return r.query(ctx, query, args...)
+}
+
+func (r *auditLogRepo) query(ctx context.Context, query string, args ...interface{}) ([]*entity.AuditLog, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*entity.AuditLog
+	for rows.Next() {
+		var l entity.AuditLog
+		if err := rows.Scan(&l.ID, &l.EntityType, &l.EntityID, &l.Action, &l.Actor, &l.Changes, &l.Details, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &l)
+	}
+	return logs, rows.Err()
+}
+
+// audited runs write in a transaction that also records log, so the entry commits
+// exactly when the change does. write may fill in log, e.g. its changes; the actor
+// defaults to the one ctx carries.
+func audited(ctx context.Context, pool *pgxpool.Pool, log *entity.AuditLog, write func(tx pgx.Tx) error) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := write(tx); err != nil {
+		return err
+	}
+	if log.Actor == "" {
+		log.Actor = repository.ActorFrom(ctx)
+	}
+	if err := insertAuditLog(ctx, tx, log); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// insertAuditLog writes an audit entry inside the caller's transaction
+func insertAuditLog(ctx context.Context, tx pgx.Tx, log *entity.AuditLog) error {
+	if log.ID == uuid.Nil {
+		log.ID = uuid.New()
+	}
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
+	}
+	var changes []byte
+	if len(log.Changes) > 0 {
+		changes, _ = json.Marshal(log.Changes)
+	}
+	if log.Details == nil {
+		log.Details = map[string]interface{}{}
+	}
+	details, _ := json.Marshal(log.Details)
+	_, err := tx.Exec(ctx, `
+		INSERT INTO audit_logs (id, entity_type, entity_id, action, actor, changes, details, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+	`, log.ID, log.EntityType, log.EntityID, log.Action, log.Actor, changes, details, log.CreatedAt)
+	return err
+}
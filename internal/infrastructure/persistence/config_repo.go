@@ -0,0 +1,326 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// CostingConfigVersion is the CostingConfig document format version written by Export
+const CostingConfigVersion = 1
+
+// configRepo implements repository.ConfigRepository
+type configRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewConfigRepository creates a new costing configuration repository
+func NewConfigRepository(pool *pgxpool.Pool) repository.ConfigRepository {
+	return &configRepo{pool: pool}
+}
+
+func (r *configRepo) Export(ctx context.Context) (*entity.CostingConfig, error) {
+	cfg := &entity.CostingConfig{Version: CostingConfigVersion}
+
+	err := queryEach(ctx, r.pool, `SELECT code, name, COALESCE(description, '') FROM parameter_groups ORDER BY code`,
+		func(rows pgx.Rows) error {
+			var g entity.ConfigParameterGroup
+			if err := rows.Scan(&g.Code, &g.Name, &g.Description); err != nil {
+				return err
+			}
+			cfg.ParameterGroups = append(cfg.ParameterGroups, &g)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export parameter groups: %w", err)
+	}
+
+	err = queryEach(ctx, r.pool, `
+		SELECT key, label, data_type, COALESCE(default_value, ''), COALESCE(group_code, ''), COALESCE(unit, ''),
+			COALESCE(is_required, false), COALESCE(sequence_order, 0)
+		FROM master_parameters ORDER BY sequence_order, key
+	`, func(rows pgx.Rows) error {
+		var p entity.ConfigParameter
+		if err := rows.Scan(&p.Key, &p.Label, &p.DataType, &p.Default, &p.Group, &p.Unit, &p.Required, &p.Sequence); err != nil {
+			return err
+		}
+		cfg.Parameters = append(cfg.Parameters, &p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export parameters: %w", err)
+	}
+
+	err = queryEach(ctx, r.pool, `
+		SELECT code, name, COALESCE(description, ''), COALESCE(default_sequence, 0), COALESCE(output_kg_per_hour, 0), setup_hours
+		FROM process_masters ORDER BY default_sequence, code
+	`, func(rows pgx.Rows) error {
+		var p entity.ConfigProcess
+		if err := rows.Scan(&p.Code, &p.Name, &p.Description, &p.DefaultSequence, &p.OutputKgPerHour, &p.SetupHours); err != nil {
+			return err
+		}
+		cfg.Processes = append(cfg.Processes, &p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export processes: %w", err)
+	}
+
+	err = queryEach(ctx, r.pool, `SELECT name, version, expression, COALESCE(description, '') FROM formulas ORDER BY name, version`,
+		func(rows pgx.Rows) error {
+			var f entity.ConfigFormula
+			if err := rows.Scan(&f.Name, &f.Version, &f.Expression, &f.Description); err != nil {
+				return err
+			}
+			cfg.Formulas = append(cfg.Formulas, &f)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export formulas: %w", err)
+	}
+
+	routings := make(map[uuid.UUID]*entity.ConfigRouting)
+	err = queryEach(ctx, r.pool, `SELECT id, name, COALESCE(description, ''), COALESCE(is_active, true) FROM routing_templates ORDER BY name`,
+		func(rows pgx.Rows) error {
+			var id uuid.UUID
+			var active bool
+			routing := &entity.ConfigRouting{Steps: []*entity.ConfigStep{}}
+			if err := rows.Scan(&id, &routing.Name, &routing.Description, &active); err != nil {
+				return err
+			}
+			routing.Inactive = !active
+			routings[id] = routing
+			cfg.Routings = append(cfg.Routings, routing)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export routings: %w", err)
+	}
+
+	err = queryEach(ctx, r.pool, `
+		SELECT ps.routing_template_id, ps.sequence_order, pm.code,
+			CASE WHEN f.id IS NULL THEN ps.formula_expression ELSE '' END,
+			COALESCE(f.name || '@' || f.version, ''), COALESCE(ps.description, '')
+		FROM process_steps ps
+		JOIN process_masters pm ON pm.id = ps.process_master_id
+		LEFT JOIN formulas f ON f.id = ps.formula_id
+		ORDER BY ps.routing_template_id, ps.sequence_order
+	`, func(rows pgx.Rows) error {
+		var routingID uuid.UUID
+		var s entity.ConfigStep
+		if err := rows.Scan(&routingID, &s.Sequence, &s.Process, &s.Expression, &s.Formula, &s.Description); err != nil {
+			return err
+		}
+		if routing, ok := routings[routingID]; ok {
+			routing.Steps = append(routing.Steps, &s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export steps: %w", err)
+	}
+
+	err = queryEach(ctx, r.pool, `
+		SELECT COALESCE(r.fiber_type, ''), COALESCE(r.grade, ''), t.name, r.priority
+		FROM routing_rules r JOIN routing_templates t ON t.id = r.routing_template_id
+		WHERE r.is_active = true ORDER BY r.priority, 1, 2
+	`, func(rows pgx.Rows) error {
+		var rule entity.ConfigRoutingRule
+		if err := rows.Scan(&rule.FiberType, &rule.Grade, &rule.Routing, &rule.Priority); err != nil {
+			return err
+		}
+		cfg.RoutingRules = append(cfg.RoutingRules, &rule)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export routing rules: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func (r *configRepo) Apply(ctx context.Context, cfg *entity.CostingConfig, changes []*entity.ConfigChange) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, g := range cfg.ParameterGroups {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO parameter_groups (code, name, description) VALUES ($1, $2, NULLIF($3, ''))
+			ON CONFLICT (code) DO UPDATE SET name = EXCLUDED.name, description = EXCLUDED.description
+		`, g.Code, g.Name, g.Description)
+		if err != nil {
+			return fmt.Errorf("failed to apply parameter group %s: %w", g.Code, err)
+		}
+	}
+
+	for _, p := range cfg.Parameters {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO master_parameters (key, label, data_type, default_value, group_code, unit, is_required, sequence_order)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8)
+			ON CONFLICT (key) DO UPDATE SET
+				label = EXCLUDED.label,
+				data_type = EXCLUDED.data_type,
+				default_value = EXCLUDED.default_value,
+				group_code = EXCLUDED.group_code,
+				unit = EXCLUDED.unit,
+				is_required = EXCLUDED.is_required,
+				sequence_order = EXCLUDED.sequence_order
+		`, p.Key, p.Label, p.DataType, p.Default, p.Group, p.Unit, p.Required, p.Sequence)
+		if err != nil {
+			return fmt.Errorf("failed to apply parameter %s: %w", p.Key, err)
+		}
+	}
+
+	for _, p := range cfg.Processes {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO process_masters (code, name, description, default_sequence, output_kg_per_hour, setup_hours)
+			VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5::numeric, 0), $6)
+			ON CONFLICT (code) DO UPDATE SET
+				name = EXCLUDED.name,
+				description = EXCLUDED.description,
+				default_sequence = EXCLUDED.default_sequence,
+				output_kg_per_hour = EXCLUDED.output_kg_per_hour,
+				setup_hours = EXCLUDED.setup_hours
+		`, p.Code, p.Name, p.Description, p.DefaultSequence, p.OutputKgPerHour, p.SetupHours)
+		if err != nil {
+			return fmt.Errorf("failed to apply process %s: %w", p.Code, err)
+		}
+	}
+
+	// Formula versions are immutable; existing ones are left as they are
+	for _, f := range cfg.Formulas {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO formulas (name, version, expression, description) VALUES ($1, $2, $3, NULLIF($4, ''))
+			ON CONFLICT (name, version) DO NOTHING
+		`, f.Name, f.Version, f.Expression, f.Description)
+		if err != nil {
+			return fmt.Errorf("failed to apply formula %s@%d: %w", f.Name, f.Version, err)
+		}
+	}
+
+	routingIDs := make(map[string]uuid.UUID, len(cfg.Routings))
+	for _, routing := range cfg.Routings {
+		var routingID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			INSERT INTO routing_templates (name, description, is_active) VALUES ($1, NULLIF($2, ''), $3)
+			ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, is_active = EXCLUDED.is_active
+			RETURNING id
+		`, routing.Name, routing.Description, !routing.Inactive).Scan(&routingID)
+		if err != nil {
+			return fmt.Errorf("failed to apply routing %s: %w", routing.Name, err)
+		}
+		routingIDs[routing.Name] = routingID
+
+		if err := applySteps(ctx, tx, routingID, routing); err != nil {
+			return err
+		}
+	}
+
+	ruleIDs := make([]uuid.UUID, 0, len(cfg.RoutingRules))
+	for _, rule := range cfg.RoutingRules {
+		routingID, ok := routingIDs[rule.Routing]
+		if !ok {
+			if err := tx.QueryRow(ctx, "SELECT id FROM routing_templates WHERE name = $1", rule.Routing).Scan(&routingID); err != nil {
+				return fmt.Errorf("failed to find routing %s: %w", rule.Routing, err)
+			}
+		}
+		var ruleID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			INSERT INTO routing_rules (fiber_type, grade, routing_template_id, priority, is_active)
+			VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, true)
+			ON CONFLICT (COALESCE(fiber_type, ''), COALESCE(grade, '')) WHERE is_active = TRUE
+			DO UPDATE SET routing_template_id = EXCLUDED.routing_template_id, priority = EXCLUDED.priority
+			RETURNING id
+		`, rule.FiberType, rule.Grade, routingID, rule.Priority).Scan(&ruleID)
+		if err != nil {
+			return fmt.Errorf("failed to apply routing rule %s/%s: %w", rule.FiberType, rule.Grade, err)
+		}
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+	if _, err := tx.Exec(ctx, "UPDATE routing_rules SET is_active = false WHERE is_active = true AND NOT (id = ANY($1))", ruleIDs); err != nil {
+		return fmt.Errorf("failed to deactivate routing rules: %w", err)
+	}
+
+	err = insertAuditLog(ctx, tx, &entity.AuditLog{
+		EntityType: entity.AuditEntityCostingConfig,
+		Action:     entity.AuditActionApply,
+		Actor:      repository.ActorFrom(ctx),
+		Details:    map[string]interface{}{"changes": changes},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// applySteps upserts the routing's steps by sequence and deletes steps it no longer lists
+func applySteps(ctx context.Context, tx pgx.Tx, routingID uuid.UUID, routing *entity.ConfigRouting) error {
+	sequences := make([]int, 0, len(routing.Steps))
+	for _, step := range routing.Steps {
+		var processID uuid.UUID
+		if err := tx.QueryRow(ctx, "SELECT id FROM process_masters WHERE code = $1", step.Process).Scan(&processID); err != nil {
+			return fmt.Errorf("failed to find process %s: %w", step.Process, err)
+		}
+
+		// A library formula's expression is also kept inline, as the NOT NULL fallback
+		expression := step.Expression
+		var formulaID *uuid.UUID
+		if step.Formula != "" {
+			name, version, err := step.FormulaRef()
+			if err != nil {
+				return err
+			}
+			var id uuid.UUID
+			err = tx.QueryRow(ctx, "SELECT id, expression FROM formulas WHERE name = $1 AND version = $2", name, version).Scan(&id, &expression)
+			if err != nil {
+				return fmt.Errorf("failed to find formula %s: %w", step.Formula, err)
+			}
+			formulaID = &id
+		}
+
+		_, err := tx.Exec(ctx, `
+			INSERT INTO process_steps (routing_template_id, process_master_id, sequence_order, formula_expression, formula_id, description)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+			ON CONFLICT (routing_template_id, sequence_order) DO UPDATE SET
+				process_master_id = EXCLUDED.process_master_id,
+				formula_expression = EXCLUDED.formula_expression,
+				formula_id = EXCLUDED.formula_id,
+				description = EXCLUDED.description
+		`, routingID, processID, step.Sequence, expression, formulaID, step.Description)
+		if err != nil {
+			return fmt.Errorf("failed to apply routing %s step %d: %w", routing.Name, step.Sequence, err)
+		}
+		sequences = append(sequences, step.Sequence)
+	}
+
+	_, err := tx.Exec(ctx, "DELETE FROM process_steps WHERE routing_template_id = $1 AND NOT (sequence_order = ANY($2))", routingID, sequences)
+	if err != nil {
+		return fmt.Errorf("failed to delete removed steps of routing %s: %w", routing.Name, err)
+	}
+	return nil
+}
+
+// queryEach runs query and calls scan for every row
+func queryEach(ctx context.Context, pool *pgxpool.Pool, query string, scan func(pgx.Rows) error) error {
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// copySkipExisting copies rows into a temp table shaped like table's columns, then inserts
+// them into table skipping any that violate a unique constraint, all in one transaction.
+// COPY alone fails the whole batch on the first duplicate. Returns the rows inserted.
+func copySkipExisting(ctx context.Context, pool *pgxpool.Pool, table string, columns []string, rows [][]interface{}) (int64, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tempTable := fmt.Sprintf("temp_%s_%d", table, time.Now().UnixNano())
+	columnList := strings.Join(columns, ", ")
+	_, err = tx.Exec(ctx, fmt.Sprintf(`CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA`,
+		tempTable, columnList, table))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp table: %w", err)
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{tempTable}, columns, pgx.CopyFromRows(rows)); err != nil {
+		return 0, fmt.Errorf("failed to copy to temp table: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT DO NOTHING`,
+		table, columnList, columnList, tempTable))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert from temp table: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
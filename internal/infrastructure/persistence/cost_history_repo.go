@@ -0,0 +1,162 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// costHistoryRepo implements repository.CostHistoryRepository. The history tables are
+// filled by triggers on variant_cost_summaries and variant_process_costs; each archive
+// table has the columns of its history table plus archived_at.
+type costHistoryRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewCostHistoryRepository creates a new cost history repository
+func NewCostHistoryRepository(pool *pgxpool.Pool) repository.CostHistoryRepository {
+	return &costHistoryRepo{pool: pool}
+}
+
+const summaryVersionColumns = `yarn_variant_id, COALESCE(total_material_cost, 0), COALESCE(total_process_cost, 0), COALESCE(total_overhead, 0), COALESCE(grand_total, 0), category_breakdown, COALESCE(version_hash, ''), recalculated_at, superseded_at`
+
+const processCostVersionColumns = `yarn_variant_id, process_step_id, input_values, COALESCE(calculated_cost, 0), calculated_at, superseded_at`
+
+// historyQuery selects columns of a variant's rows from table, and from archive too when
+// includeArchived is set, latest first; $1 is the variant and $2 the limit
+func historyQuery(columns, table, archive string, includeArchived bool) string {
+	query := `SELECT ` + columns + `, FALSE AS archived FROM ` + table + ` WHERE yarn_variant_id = $1`
+	if includeArchived {
+		query = `(` + query + ` ORDER BY superseded_at DESC LIMIT $2)
+			UNION ALL
+			(SELECT ` + columns + `, TRUE FROM ` + archive + ` WHERE yarn_variant_id = $1 ORDER BY superseded_at DESC LIMIT $2)`
+	}
+	return query + ` ORDER BY superseded_at DESC LIMIT $2`
+}
+
+func (r *costHistoryRepo) SummaryHistory(ctx context.Context, variantID uuid.UUID, includeArchived bool, limit int) ([]*entity.CostSummaryVersion, error) {
+	query := historyQuery(summaryVersionColumns, "variant_cost_summary_history", "variant_cost_summary_archive", includeArchived)
+	rows, err := r.pool.Query(ctx, query, variantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*entity.CostSummaryVersion
+	for rows.Next() {
+		var v entity.CostSummaryVersion
+		if err := rows.Scan(&v.YarnVariantID, &v.TotalMaterialCost, &v.TotalProcessCost, &v.TotalOverhead, &v.GrandTotal,
+			&v.CategoryBreakdown, &v.VersionHash, &v.RecalculatedAt, &v.SupersededAt, &v.Archived); err != nil {
+			return nil, err
+		}
+		versions = append(versions, &v)
+	}
+	return versions, rows.Err()
+}
+
+func (r *costHistoryRepo) ProcessCostHistory(ctx context.Context, variantID uuid.UUID, includeArchived bool, limit int) ([]*entity.ProcessCostVersion, error) {
+	query := historyQuery(processCostVersionColumns, "variant_process_cost_history", "variant_process_cost_archive", includeArchived)
+	rows, err := r.pool.Query(ctx, query, variantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*entity.ProcessCostVersion
+	for rows.Next() {
+		var v entity.ProcessCostVersion
+		if err := rows.Scan(&v.YarnVariantID, &v.ProcessStepID, &v.InputValues, &v.CalculatedCost,
+			&v.CalculatedAt, &v.SupersededAt, &v.Archived); err != nil {
+			return nil, err
+		}
+		versions = append(versions, &v)
+	}
+	return versions, rows.Err()
+}
+
+// ArchiveAfter moves one page of variants in a transaction, so a page is either still
+// in the history or wholly archived and a retried job can resume after the last page
+func (r *costHistoryRepo) ArchiveAfter(ctx context.Context, afterID uuid.UUID, limit, keep int) (uuid.UUID, int64, int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Variants with summary or step history, whichever table has them
+	rows, err := tx.Query(ctx, `
+		SELECT yarn_variant_id FROM (
+			(SELECT DISTINCT yarn_variant_id FROM variant_cost_summary_history
+			 WHERE yarn_variant_id > $1 ORDER BY yarn_variant_id LIMIT $2)
+			UNION
+			(SELECT DISTINCT yarn_variant_id FROM variant_process_cost_history
+			 WHERE yarn_variant_id > $1 ORDER BY yarn_variant_id LIMIT $2)
+		) v ORDER BY yarn_variant_id LIMIT $2
+	`, afterID, limit)
+	if err != nil {
+		return uuid.Nil, 0, 0, fmt.Errorf("failed to list variants with history: %w", err)
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return uuid.Nil, 0, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return uuid.Nil, 0, 0, err
+	}
+	if len(ids) == 0 {
+		return uuid.Nil, 0, 0, nil
+	}
+
+	summaries, err := archiveVersions(ctx, tx, "variant_cost_summary_history", "variant_cost_summary_archive", "yarn_variant_id",
+		"tenant_id, yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, version_hash, recalculated_at, superseded_at",
+		ids, keep)
+	if err != nil {
+		return uuid.Nil, 0, 0, fmt.Errorf("failed to archive summaries: %w", err)
+	}
+	costs, err := archiveVersions(ctx, tx, "variant_process_cost_history", "variant_process_cost_archive", "yarn_variant_id, process_step_id",
+		"tenant_id, yarn_variant_id, process_step_id, input_values, calculated_cost, calculated_at, superseded_at",
+		ids, keep)
+	if err != nil {
+		return uuid.Nil, 0, 0, fmt.Errorf("failed to archive process costs: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, 0, 0, err
+	}
+	return ids[len(ids)-1], summaries, costs, nil
+}
+
+// archiveVersions moves the rows of ids from table to archive, all but the keep latest
+// of each group of rows sharing key, and returns the rows moved
+func archiveVersions(ctx context.Context, tx pgx.Tx, table, archive, key, columns string, ids []uuid.UUID, keep int) (int64, error) {
+	query := fmt.Sprintf(`
+		WITH moved AS (
+			DELETE FROM %[1]s WHERE id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (PARTITION BY %[3]s ORDER BY superseded_at DESC, id DESC) AS n
+					FROM %[1]s WHERE yarn_variant_id = ANY($1)
+				) ranked WHERE n > $2
+			)
+			RETURNING id, %[4]s
+		)
+		INSERT INTO %[2]s (id, %[4]s)
+		SELECT id, %[4]s FROM moved
+	`, table, archive, key, columns)
+	tag, err := tx.Exec(ctx, query, ids, keep)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
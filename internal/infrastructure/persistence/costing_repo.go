@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,23 +14,28 @@ import (
 
 	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
 	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/pkg/database"
 )
 
-// variantProcessCostRepo implements repository.VariantProcessCostRepository
+// variantProcessCostRepo implements repository.VariantProcessCostRepository. The table
+// is hash partitioned on yarn_variant_id; every statement filters or conflicts on it, so
+// PostgreSQL prunes reads to one partition and routes each written row to its own.
 type variantProcessCostRepo struct {
-	pool *pgxpool.Pool
+	pool  *pgxpool.Pool
+	retry database.RetryPolicy // retries UpsertBatch on transient errors
 }
 
-// NewVariantProcessCostRepository creates a new variant process cost repository
-func NewVariantProcessCostRepository(pool *pgxpool.Pool) repository.VariantProcessCostRepository {
-	return &variantProcessCostRepo{pool: pool}
+// NewVariantProcessCostRepository creates a new variant process cost repository whose
+// UpsertBatch retries transient errors under retry
+func NewVariantProcessCostRepository(pool *pgxpool.Pool, retry database.RetryPolicy) repository.VariantProcessCostRepository {
+	return &variantProcessCostRepo{pool: pool, retry: retry}
 }
 
 func (r *variantProcessCostRepo) Upsert(ctx context.Context, cost *entity.VariantProcessCost) error {
 	query := `
 		INSERT INTO variant_process_costs (id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (id, yarn_variant_id) DO UPDATE SET
+		ON CONFLICT (yarn_variant_id, process_step_id) DO UPDATE SET
 			input_values = EXCLUDED.input_values,
 			calculated_cost = EXCLUDED.calculated_cost,
 			updated_at = EXCLUDED.updated_at
@@ -40,12 +47,23 @@ func (r *variantProcessCostRepo) Upsert(ctx context.Context, cost *entity.Varian
 }
 
 // UpsertBatch uses PostgreSQL COPY protocol for high-performance bulk inserts
-// For updates, we use a temp table approach
+// For updates, we use a temp table approach. Rows are upserted in partition key order,
+// so the rows of one variant, which share a partition, are written together. A batch
+// failing on a transient error is written again in a new transaction.
 func (r *variantProcessCostRepo) UpsertBatch(ctx context.Context, costs []*entity.VariantProcessCost) (int64, error) {
 	if len(costs) == 0 {
 		return 0, nil
 	}
+	var count int64
+	err := r.retry.Do(ctx, func() (err error) {
+		count, err = r.upsertBatch(ctx, costs)
+		return err
+	})
+	return count, err
+}
 
+// upsertBatch copies costs into a temp table and upserts them from it in one transaction
+func (r *variantProcessCostRepo) upsertBatch(ctx context.Context, costs []*entity.VariantProcessCost) (int64, error) {
 	// Use a transaction for atomic operations
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
@@ -84,11 +102,13 @@ func (r *variantProcessCostRepo) UpsertBatch(ctx context.Context, costs []*entit
 		return 0, fmt.Errorf("failed to copy to temp table: %w", err)
 	}
 
-	// Upsert from temp table to main table
+	// Upsert from temp table to main table; a step written twice in the batch keeps its
+	// latest cost, as one statement cannot update a row twice
 	_, err = tx.Exec(ctx, fmt.Sprintf(`
 		INSERT INTO variant_process_costs (id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at)
-		SELECT id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at FROM %s
-		ON CONFLICT (id, yarn_variant_id) DO UPDATE SET
+		SELECT DISTINCT ON (yarn_variant_id, process_step_id) id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at
+		FROM %s ORDER BY yarn_variant_id, process_step_id, updated_at DESC
+		ON CONFLICT (yarn_variant_id, process_step_id) DO UPDATE SET
 			input_values = EXCLUDED.input_values,
 			calculated_cost = EXCLUDED.calculated_cost,
 			updated_at = EXCLUDED.updated_at
@@ -133,36 +153,106 @@ func (r *variantProcessCostRepo) DeleteByVariantID(ctx context.Context, variantI
 
 // variantCostSummaryRepo implements repository.VariantCostSummaryRepository
 type variantCostSummaryRepo struct {
-	pool *pgxpool.Pool
+	pool  *pgxpool.Pool
+	mode  string               // summary upsert mode UpsertBatch uses, insert or merge
+	retry database.RetryPolicy // retries UpsertBatch on transient errors
 }
 
-// NewVariantCostSummaryRepository creates a new variant cost summary repository
-func NewVariantCostSummaryRepository(pool *pgxpool.Pool) repository.VariantCostSummaryRepository {
-	return &variantCostSummaryRepo{pool: pool}
+// NewVariantCostSummaryRepository creates a new variant cost summary repository whose
+// UpsertBatch uses mode, as returned by ResolveSummaryUpsertMode, and retries transient
+// errors under retry
+func NewVariantCostSummaryRepository(pool *pgxpool.Pool, mode string, retry database.RetryPolicy) repository.VariantCostSummaryRepository {
+	if mode != SummaryUpsertMerge {
+		mode = SummaryUpsertInsert
+	}
+	return &variantCostSummaryRepo{pool: pool, mode: mode, retry: retry}
 }
 
 func (r *variantCostSummaryRepo) Upsert(ctx context.Context, summary *entity.VariantCostSummary) error {
 	query := `
-		INSERT INTO variant_cost_summaries (yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, last_recalculated_at, version_hash)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO variant_cost_summaries (yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (yarn_variant_id) DO UPDATE SET
 			total_material_cost = EXCLUDED.total_material_cost,
 			total_process_cost = EXCLUDED.total_process_cost,
 			total_overhead = EXCLUDED.total_overhead,
 			grand_total = EXCLUDED.grand_total,
+			category_breakdown = EXCLUDED.category_breakdown,
 			last_recalculated_at = EXCLUDED.last_recalculated_at,
 			version_hash = EXCLUDED.version_hash
+		WHERE variant_cost_summaries.version_hash IS DISTINCT FROM EXCLUDED.version_hash
 	`
 	_, err := r.pool.Exec(ctx, query,
-		summary.YarnVariantID, summary.TotalMaterialCost, summary.TotalProcessCost, summary.TotalOverhead, summary.GrandTotal, summary.LastRecalculatedAt, summary.VersionHash)
+		summary.YarnVariantID, summary.TotalMaterialCost, summary.TotalProcessCost, summary.TotalOverhead, summary.GrandTotal, summary.CategoryBreakdown, summary.LastRecalculatedAt, summary.VersionHash)
 	return err
 }
 
+// summaryRows is the COPY source of UpsertBatch. It fills one row buffer per summary in
+// turn, since COPY encodes each row before reading the next, instead of materializing a
+// row slice per summary; sources are pooled across batches.
+type summaryRows struct {
+	summaries []*entity.VariantCostSummary
+	next      int
+	row       []interface{}
+}
+
+var summaryRowPool = sync.Pool{
+	New: func() interface{} { return &summaryRows{row: make([]interface{}, 8)} },
+}
+
+// reset points the source at summaries; nil leaves it holding nothing of the last batch
+func (r *summaryRows) reset(summaries []*entity.VariantCostSummary) {
+	r.summaries, r.next = summaries, 0
+	clear(r.row)
+}
+
+func (r *summaryRows) Next() bool {
+	if r.next == len(r.summaries) {
+		return false
+	}
+	r.next++
+	return true
+}
+
+func (r *summaryRows) Values() ([]interface{}, error) {
+	s := r.summaries[r.next-1]
+	r.row[0], r.row[1], r.row[2], r.row[3] = s.YarnVariantID, s.TotalMaterialCost, s.TotalProcessCost, s.TotalOverhead
+	r.row[4], r.row[5], r.row[6], r.row[7] = s.GrandTotal, s.CategoryBreakdown, s.LastRecalculatedAt, s.VersionHash
+	return r.row, nil
+}
+
+func (r *summaryRows) Err() error { return nil }
+
+// UpsertBatch writes summaries in one transaction, again in a new one when it fails on
+// a transient error
 func (r *variantCostSummaryRepo) UpsertBatch(ctx context.Context, summaries []*entity.VariantCostSummary) (int64, error) {
 	if len(summaries) == 0 {
 		return 0, nil
 	}
+	var count int64
+	err := r.retry.Do(ctx, func() (err error) {
+		count, err = r.upsertByMode(ctx, summaries)
+		return err
+	})
+	return count, err
+}
+
+// upsertByMode upserts summaries with the repository's mode
+func (r *variantCostSummaryRepo) upsertByMode(ctx context.Context, summaries []*entity.VariantCostSummary) (int64, error) {
+	if r.mode == SummaryUpsertMerge {
+		count, err := r.upsertBatch(ctx, summaries, SummaryUpsertMerge)
+		if !isSummaryKeyViolation(err) {
+			return count, err
+		}
+		// Unlike ON CONFLICT, MERGE fails when a variant's summary is inserted by another
+		// transaction after the batch joined against it; ON CONFLICT handles that race
+	}
+	return r.upsertBatch(ctx, summaries, SummaryUpsertInsert)
+}
 
+// upsertBatch copies summaries into a temp table and moves them into
+// variant_cost_summaries with mode's statement
+func (r *variantCostSummaryRepo) upsertBatch(ctx context.Context, summaries []*entity.VariantCostSummary, mode string) (int64, error) {
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		return 0, err
@@ -177,6 +267,7 @@ func (r *variantCostSummaryRepo) UpsertBatch(ctx context.Context, summaries []*e
 			total_process_cost DECIMAL(18,6),
 			total_overhead DECIMAL(18,6),
 			grand_total DECIMAL(18,6),
+			category_breakdown JSONB,
 			last_recalculated_at TIMESTAMPTZ,
 			version_hash VARCHAR(64)
 		) ON COMMIT DROP
@@ -185,30 +276,20 @@ func (r *variantCostSummaryRepo) UpsertBatch(ctx context.Context, summaries []*e
 		return 0, err
 	}
 
-	columns := []string{"yarn_variant_id", "total_material_cost", "total_process_cost", "total_overhead", "grand_total", "last_recalculated_at", "version_hash"}
-	rows := make([][]interface{}, len(summaries))
-	for i, s := range summaries {
-		rows[i] = []interface{}{
-			s.YarnVariantID, s.TotalMaterialCost, s.TotalProcessCost, s.TotalOverhead, s.GrandTotal, s.LastRecalculatedAt, s.VersionHash,
-		}
-	}
+	columns := []string{"yarn_variant_id", "total_material_cost", "total_process_cost", "total_overhead", "grand_total", "category_breakdown", "last_recalculated_at", "version_hash"}
+	rows := summaryRowPool.Get().(*summaryRows)
+	rows.reset(summaries)
+	defer func() {
+		rows.reset(nil)
+		summaryRowPool.Put(rows)
+	}()
 
-	copyCount, err := tx.CopyFrom(ctx, pgx.Identifier{tempTable}, columns, pgx.CopyFromRows(rows))
+	copyCount, err := tx.CopyFrom(ctx, pgx.Identifier{tempTable}, columns, rows)
 	if err != nil {
 		return 0, err
 	}
 
-	_, err = tx.Exec(ctx, fmt.Sprintf(`
-		INSERT INTO variant_cost_summaries (yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, last_recalculated_at, version_hash)
-		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, last_recalculated_at, version_hash FROM %s
-		ON CONFLICT (yarn_variant_id) DO UPDATE SET
-			total_material_cost = EXCLUDED.total_material_cost,
-			total_process_cost = EXCLUDED.total_process_cost,
-			total_overhead = EXCLUDED.total_overhead,
-			grand_total = EXCLUDED.grand_total,
-			last_recalculated_at = EXCLUDED.last_recalculated_at,
-			version_hash = EXCLUDED.version_hash
-	`, tempTable))
+	_, err = tx.Exec(ctx, fmt.Sprintf(summaryUpsertStatements[mode], tempTable))
 	if err != nil {
 		return 0, err
 	}
@@ -218,12 +299,12 @@ func (r *variantCostSummaryRepo) UpsertBatch(ctx context.Context, summaries []*e
 
 func (r *variantCostSummaryRepo) GetByVariantID(ctx context.Context, variantID uuid.UUID) (*entity.VariantCostSummary, error) {
 	query := `
-		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, last_recalculated_at, version_hash, created_at, updated_at
+		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash, created_at, updated_at
 		FROM variant_cost_summaries WHERE yarn_variant_id = $1
 	`
 	var s entity.VariantCostSummary
 	err := r.pool.QueryRow(ctx, query, variantID).Scan(
-		&s.YarnVariantID, &s.TotalMaterialCost, &s.TotalProcessCost, &s.TotalOverhead, &s.GrandTotal, &s.LastRecalculatedAt, &s.VersionHash, &s.CreatedAt, &s.UpdatedAt)
+		&s.YarnVariantID, &s.TotalMaterialCost, &s.TotalProcessCost, &s.TotalOverhead, &s.GrandTotal, &s.CategoryBreakdown, &s.LastRecalculatedAt, &s.VersionHash, &s.CreatedAt, &s.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +313,7 @@ func (r *variantCostSummaryRepo) GetByVariantID(ctx context.Context, variantID u
 
 func (r *variantCostSummaryRepo) List(ctx context.Context, limit, offset int) ([]*entity.VariantCostSummary, error) {
 	query := `
-		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, last_recalculated_at, version_hash, created_at, updated_at
+		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash, created_at, updated_at
 		FROM variant_cost_summaries ORDER BY updated_at DESC LIMIT $1 OFFSET $2
 	`
 	rows, err := r.pool.Query(ctx, query, limit, offset)
@@ -244,10 +325,149 @@ func (r *variantCostSummaryRepo) List(ctx context.Context, limit, offset int) ([
 	var summaries []*entity.VariantCostSummary
 	for rows.Next() {
 		var s entity.VariantCostSummary
-		if err := rows.Scan(&s.YarnVariantID, &s.TotalMaterialCost, &s.TotalProcessCost, &s.TotalOverhead, &s.GrandTotal, &s.LastRecalculatedAt, &s.VersionHash, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		if err := rows.Scan(&s.YarnVariantID, &s.TotalMaterialCost, &s.TotalProcessCost, &s.TotalOverhead, &s.GrandTotal, &s.CategoryBreakdown, &s.LastRecalculatedAt, &s.VersionHash, &s.CreatedAt, &s.UpdatedAt); err != nil {
 			return nil, err
 		}
 		summaries = append(summaries, &s)
 	}
 	return summaries, nil
 }
+
+// summarySearch is what summary searches filter on
+var summarySearch = &searchTable{
+	from: `
+		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash, created_at, updated_at
+		FROM variant_cost_summaries`,
+	key: "yarn_variant_id",
+	columns: map[string]filterColumn{
+		"yarn_variant_id":      {"yarn_variant_id", "uuid"},
+		"total_material_cost":  {"total_material_cost", "numeric"},
+		"total_process_cost":   {"total_process_cost", "numeric"},
+		"total_overhead":       {"total_overhead", "numeric"},
+		"grand_total":          {"grand_total", "numeric"},
+		"version_hash":         {"version_hash", "text"},
+		"last_recalculated_at": {"last_recalculated_at", "timestamptz"},
+		"created_at":           {"created_at", "timestamptz"},
+		"updated_at":           {"updated_at", "timestamptz"},
+	},
+}
+
+func (r *variantCostSummaryRepo) ListAfter(ctx context.Context, cursor entity.Cursor, limit int) ([]*entity.VariantCostSummary, error) {
+	return r.Search(ctx, nil, cursor, limit)
+}
+
+func (r *variantCostSummaryRepo) Search(ctx context.Context, filters []entity.Filter, cursor entity.Cursor, limit int) ([]*entity.VariantCostSummary, error) {
+	query, args, err := summarySearch.query(filters, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*entity.VariantCostSummary
+	for rows.Next() {
+		var s entity.VariantCostSummary
+		if err := rows.Scan(&s.YarnVariantID, &s.TotalMaterialCost, &s.TotalProcessCost, &s.TotalOverhead, &s.GrandTotal, &s.CategoryBreakdown, &s.LastRecalculatedAt, &s.VersionHash, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, &s)
+	}
+	return summaries, rows.Err()
+}
+
+func (r *variantCostSummaryRepo) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.pool.QueryRow(ctx, "SELECT COUNT(*) FROM variant_cost_summaries").Scan(&count)
+	return count, err
+}
+
+func (r *variantCostSummaryRepo) ListForExport(ctx context.Context, afterID uuid.UUID, limit int) ([]*entity.CostExportRow, error) {
+	query := `
+		SELECT s.yarn_variant_id, s.total_material_cost, s.total_process_cost, s.total_overhead, s.grand_total,
+			s.category_breakdown, s.last_recalculated_at, COALESCE(s.version_hash, ''), s.created_at, s.updated_at,
+			v.sku, m.code
+		FROM variant_cost_summaries s
+		JOIN yarn_variants v ON v.id = s.yarn_variant_id
+		JOIN master_yarns m ON m.id = v.master_yarn_id
+		WHERE s.yarn_variant_id > $1
+		ORDER BY s.yarn_variant_id LIMIT $2
+	`
+	rows, err := r.pool.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*entity.CostExportRow
+	for rows.Next() {
+		var row entity.CostExportRow
+		s := &row.VariantCostSummary
+		if err := rows.Scan(&s.YarnVariantID, &s.TotalMaterialCost, &s.TotalProcessCost, &s.TotalOverhead, &s.GrandTotal, &s.CategoryBreakdown,
+			&s.LastRecalculatedAt, &s.VersionHash, &s.CreatedAt, &s.UpdatedAt, &row.SKU, &row.MasterCode); err != nil {
+			return nil, err
+		}
+		result = append(result, &row)
+	}
+	return result, nil
+}
+
+// summaryBackfills maps each backfillable summary column to the query computing it from
+// the stored per-step costs for at most $1 summaries where it is still NULL. Summaries
+// without stored step costs are skipped; they need a full recalculation.
+var summaryBackfills = map[string]string{
+	"category_breakdown": `
+		WITH batch AS (
+			SELECT s.yarn_variant_id FROM variant_cost_summaries s
+			WHERE s.category_breakdown IS NULL
+				AND EXISTS (SELECT 1 FROM variant_process_costs c WHERE c.yarn_variant_id = s.yarn_variant_id)
+			LIMIT $1
+		), breakdown AS (
+			SELECT t.yarn_variant_id, jsonb_object_agg(t.code, t.cost) AS category_breakdown
+			FROM (
+				SELECT c.yarn_variant_id, COALESCE(pm.code, c.process_step_id::text) AS code, SUM(c.calculated_cost) AS cost
+				FROM variant_process_costs c
+				JOIN batch b ON b.yarn_variant_id = c.yarn_variant_id
+				LEFT JOIN process_steps ps ON ps.id = c.process_step_id
+				LEFT JOIN process_masters pm ON pm.id = ps.process_master_id
+				GROUP BY c.yarn_variant_id, 2
+			) t
+			GROUP BY t.yarn_variant_id
+		)
+		UPDATE variant_cost_summaries s SET category_breakdown = b.category_breakdown
+		FROM breakdown b WHERE s.yarn_variant_id = b.yarn_variant_id
+	`,
+}
+
+// SummaryBackfillFields lists the summary columns Backfill supports
+func SummaryBackfillFields() []string {
+	fields := make([]string, 0, len(summaryBackfills))
+	for field := range summaryBackfills {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (r *variantCostSummaryRepo) CountMissing(ctx context.Context, field string) (int64, error) {
+	if _, ok := summaryBackfills[field]; !ok {
+		return 0, fmt.Errorf("unknown summary field %q", field)
+	}
+	var count int64
+	err := r.pool.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM variant_cost_summaries WHERE %s IS NULL", field)).Scan(&count)
+	return count, err
+}
+
+func (r *variantCostSummaryRepo) Backfill(ctx context.Context, field string, limit int) (int64, error) {
+	query, ok := summaryBackfills[field]
+	if !ok {
+		return 0, fmt.Errorf("unknown summary field %q", field)
+	}
+	tag, err := r.pool.Exec(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
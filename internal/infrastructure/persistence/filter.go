@@ -0,0 +1,217 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// attrFieldPrefix prefixes filter fields naming a key of a table's JSONB attributes,
+// e.g. attr.fiber_type
+const attrFieldPrefix = "attr."
+
+// attrKeyPattern is what an attribute key must look like to be written into SQL, which
+// lets expression indexes such as jsonb_attr_numeric(fixed_attrs, 'yarn_count') match
+var attrKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// filterOperators maps each comparison operator to SQL
+var filterOperators = map[entity.FilterOp]string{
+	entity.FilterEq:  "=",
+	entity.FilterNe:  "<>",
+	entity.FilterLt:  "<",
+	entity.FilterLte: "<=",
+	entity.FilterGt:  ">",
+	entity.FilterGte: ">=",
+}
+
+// filterColumn is a field filters may name: its SQL expression and type
+type filterColumn struct {
+	expr string
+	typ  string // text, uuid, numeric, boolean or timestamptz
+}
+
+// searchTable describes what a search may filter on. Only the listed columns and
+// attribute keys matching attrKeyPattern can appear in SQL; values are always bound as
+// parameters.
+type searchTable struct {
+	from    string // SELECT ... FROM clause
+	key     string // column ordering rows created at the same time
+	columns map[string]filterColumn
+	attrs   string // JSONB column searched by attr.<key> fields; "" for none
+}
+
+// query returns the search of t for rows matching every filter after cursor, newest
+// first by (created_at, key), and its arguments. $1 is limit.
+func (t *searchTable) query(filters []entity.Filter, cursor entity.Cursor, limit int) (string, []interface{}, error) {
+	args := []interface{}{limit}
+	var conditions []string
+	for _, f := range filters {
+		condition, err := t.condition(f, &args)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if !cursor.IsZero() {
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, %s) < ($%d, $%d)", t.key, len(args)-1, len(args)))
+	}
+
+	query := t.from
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC, %s DESC LIMIT $1", t.key)
+	return query, args, nil
+}
+
+// condition returns the SQL condition of f, appending its parameters to args
+func (t *searchTable) condition(f entity.Filter, args *[]interface{}) (string, error) {
+	if key, ok := strings.CutPrefix(f.Field, attrFieldPrefix); ok && t.attrs != "" {
+		return t.attrCondition(key, f, args)
+	}
+	column, ok := t.columns[f.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown field %q", repository.ErrInvalidFilter, f.Field)
+	}
+	return column.condition(f, args)
+}
+
+// condition returns the SQL condition of f on column, appending its parameters to args
+func (column filterColumn) condition(f entity.Filter, args *[]interface{}) (string, error) {
+	switch f.Op {
+	case entity.FilterContains:
+		if column.typ != "text" {
+			return "", fmt.Errorf("%w: %s is not a text field", repository.ErrInvalidFilter, f.Field)
+		}
+		*args = append(*args, "%"+escapeLike(f.Value)+"%")
+		return fmt.Sprintf("%s ILIKE $%d", column.expr, len(*args)), nil
+	case entity.FilterIn:
+		values := strings.Split(f.Value, ",")
+		for _, value := range values {
+			if err := checkFilterValue(f.Field, column.typ, value); err != nil {
+				return "", err
+			}
+		}
+		*args = append(*args, values)
+		return fmt.Sprintf("%s = ANY($%d::text[]::%s[])", column.expr, len(*args), column.typ), nil
+	case entity.FilterBetween:
+		low, high, err := filterRange(f)
+		if err != nil {
+			return "", err
+		}
+		for _, value := range []string{low, high} {
+			if err := checkFilterValue(f.Field, column.typ, value); err != nil {
+				return "", err
+			}
+		}
+		*args = append(*args, low, high)
+		return fmt.Sprintf("%s BETWEEN $%d::text::%s AND $%d::text::%s", column.expr, len(*args)-1, column.typ, len(*args), column.typ), nil
+	}
+
+	operator, ok := filterOperators[f.Op]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown operator %q", repository.ErrInvalidFilter, f.Op)
+	}
+	if err := checkFilterValue(f.Field, column.typ, f.Value); err != nil {
+		return "", err
+	}
+	*args = append(*args, f.Value)
+	return fmt.Sprintf("%s %s $%d::text::%s", column.expr, operator, len(*args), column.typ), nil
+}
+
+// attrCondition returns the SQL condition of f on attribute key. Equality is JSONB
+// containment, which the GIN index on the attributes serves; a value that reads as a
+// number or boolean also matches the JSON number or boolean. Ranges compare numeric
+// attributes only, through jsonb_attr_numeric.
+func (t *searchTable) attrCondition(key string, f entity.Filter, args *[]interface{}) (string, error) {
+	if !attrKeyPattern.MatchString(key) {
+		return "", fmt.Errorf("%w: invalid attribute %q", repository.ErrInvalidFilter, key)
+	}
+	literal := "'" + key + "'"
+
+	switch f.Op {
+	case entity.FilterEq, entity.FilterNe, entity.FilterIn:
+		values := []string{f.Value}
+		if f.Op == entity.FilterIn {
+			values = strings.Split(f.Value, ",")
+		}
+		var documents []string
+		for _, value := range values {
+			documents = append(documents, attrDocuments(key, value)...)
+		}
+		*args = append(*args, documents)
+		condition := fmt.Sprintf("%s @> ANY($%d::jsonb[])", t.attrs, len(*args))
+		if f.Op == entity.FilterNe {
+			condition = "NOT (" + condition + ")"
+		}
+		return condition, nil
+	case entity.FilterContains:
+		column := filterColumn{expr: fmt.Sprintf("(%s->>%s)", t.attrs, literal), typ: "text"}
+		return column.condition(f, args)
+	}
+	column := filterColumn{expr: fmt.Sprintf("jsonb_attr_numeric(%s, %s)", t.attrs, literal), typ: "numeric"}
+	return column.condition(f, args)
+}
+
+// attrDocuments returns the JSON objects holding key with value as a string, and as a
+// number or boolean when it reads as one
+func attrDocuments(key, value string) []string {
+	candidates := []interface{}{value}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		candidates = append(candidates, json.Number(strconv.FormatFloat(n, 'f', -1, 64)))
+	}
+	if value == "true" || value == "false" {
+		candidates = append(candidates, value == "true")
+	}
+	documents := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		// NaN and Inf parse as floats but are not JSON
+		if document, err := json.Marshal(map[string]interface{}{key: candidate}); err == nil {
+			documents = append(documents, string(document))
+		}
+	}
+	return documents
+}
+
+// filterRange splits the low,high value of a between filter
+func filterRange(f entity.Filter) (string, string, error) {
+	low, high, ok := strings.Cut(f.Value, ",")
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s between takes low,high, got %q", repository.ErrInvalidFilter, f.Field, f.Value)
+	}
+	return low, high, nil
+}
+
+// checkFilterValue checks that value parses as typ, so a bad value is reported as an
+// invalid filter rather than failing in the database
+func checkFilterValue(field, typ, value string) error {
+	var err error
+	switch typ {
+	case "uuid":
+		_, err = uuid.Parse(value)
+	case "numeric":
+		_, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		_, err = strconv.ParseBool(value)
+	case "timestamptz":
+		_, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s takes a %s value, got %q", repository.ErrInvalidFilter, field, typ, value)
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// formulaRepo implements repository.FormulaRepository
+type formulaRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewFormulaRepository creates a new formula repository
+func NewFormulaRepository(pool *pgxpool.Pool) repository.FormulaRepository {
+	return &formulaRepo{pool: pool}
+}
+
+const formulaColumns = `id, name, version, expression, COALESCE(description, ''), created_at`
+
+func scanFormula(row pgx.Row) (*entity.Formula, error) {
+	var f entity.Formula
+	if err := row.Scan(&f.ID, &f.Name, &f.Version, &f.Expression, &f.Description, &f.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (r *formulaRepo) Create(ctx context.Context, f *entity.Formula) error {
+	query := `
+		INSERT INTO formulas (id, name, version, expression, description, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	log := &entity.AuditLog{EntityType: entity.AuditEntityFormula, EntityID: f.ID, Action: entity.AuditActionCreate, Changes: entity.AuditDiff(nil, f)}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query, f.ID, f.Name, f.Version, f.Expression, f.Description, f.CreatedAt)
+		return err
+	})
+}
+
+func (r *formulaRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.Formula, error) {
+	query := `SELECT ` + formulaColumns + ` FROM formulas WHERE id = $1`
+	return scanFormula(r.pool.QueryRow(ctx, query, id))
+}
+
+func (r *formulaRepo) GetByName(ctx context.Context, name string, version int) (*entity.Formula, error) {
+	if version > 0 {
+		query := `SELECT ` + formulaColumns + ` FROM formulas WHERE name = $1 AND version = $2`
+		return scanFormula(r.pool.QueryRow(ctx, query, name, version))
+	}
+	query := `SELECT ` + formulaColumns + ` FROM formulas WHERE name = $1 ORDER BY version DESC LIMIT 1`
+	return scanFormula(r.pool.QueryRow(ctx, query, name))
+}
+
+func (r *formulaRepo) List(ctx context.Context) ([]*entity.Formula, error) {
+	query := `SELECT DISTINCT ON (name) ` + formulaColumns + ` FROM formulas ORDER BY name, version DESC`
+	return r.query(ctx, query)
+}
+
+func (r *formulaRepo) ListVersions(ctx context.Context, name string) ([]*entity.Formula, error) {
+	query := `SELECT ` + formulaColumns + ` FROM formulas WHERE name = $1 ORDER BY version DESC`
+	return r.query(ctx, query, name)
+}
+
+func (r *formulaRepo) query(ctx context.Context, query string, args ...interface{}) ([]*entity.Formula, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var formulas []*entity.Formula
+	for rows.Next() {
+		f, err := scanFormula(rows)
+		if err != nil {
+			return nil, err
+		}
+		formulas = append(formulas, f)
+	}
+	return formulas, nil
+}
+
+func (r *formulaRepo) Usage(ctx context.Context, id uuid.UUID) ([]*entity.FormulaUsage, error) {
+	query := `
+		SELECT ps.id, rt.id, rt.name, pm.code, ps.sequence_order,
+			(SELECT COUNT(*) FROM yarn_variants v WHERE v.routing_template_id = rt.id AND v.is_active = true)
+		FROM process_steps ps
+		JOIN routing_templates rt ON rt.id = ps.routing_template_id
+		JOIN process_masters pm ON pm.id = ps.process_master_id
+		WHERE ps.formula_id = $1
+		ORDER BY rt.name, ps.sequence_order
+	`
+	rows, err := r.pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []*entity.FormulaUsage
+	for rows.Next() {
+		var u entity.FormulaUsage
+		if err := rows.Scan(&u.ProcessStepID, &u.RoutingTemplateID, &u.RoutingName, &u.ProcessCode, &u.SequenceOrder, &u.VariantCount); err != nil {
+			return nil, err
+		}
+		usages = append(usages, &u)
+	}
+	return usages, nil
+}
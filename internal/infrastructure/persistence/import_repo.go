@@ -0,0 +1,123 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// importRepo implements repository.ImportRepository
+type importRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewImportRepository creates a new import repository
+func NewImportRepository(pool *pgxpool.Pool) repository.ImportRepository {
+	return &importRepo{pool: pool}
+}
+
+func (r *importRepo) Stage(ctx context.Context, job *entity.BatchJob, file *entity.ImportFile) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, insertJobQuery, jobArgs(job)...); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO import_files (job_id, kind, format, filename, content, size_bytes, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+	`, job.ID, file.Kind, file.Format, file.Filename, file.Content, file.SizeBytes, file.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *importRepo) GetFile(ctx context.Context, jobID uuid.UUID) (*entity.ImportFile, error) {
+	query := `
+		SELECT job_id, kind, format, COALESCE(filename, ''), content, size_bytes, created_at
+		FROM import_files WHERE job_id = $1
+	`
+	var f entity.ImportFile
+	err := r.pool.QueryRow(ctx, query, jobID).Scan(&f.JobID, &f.Kind, &f.Format, &f.Filename, &f.Content, &f.SizeBytes, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (r *importRepo) DeleteFile(ctx context.Context, jobID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, "DELETE FROM import_files WHERE job_id = $1", jobID)
+	return err
+}
+
+func (r *importRepo) MasterIDsByCode(ctx context.Context, codes []string) (map[string]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, "SELECT code, id FROM master_yarns WHERE code = ANY($1)", codes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]uuid.UUID)
+	for rows.Next() {
+		var code string
+		var id uuid.UUID
+		if err := rows.Scan(&code, &id); err != nil {
+			return nil, err
+		}
+		ids[code] = id
+	}
+	return ids, nil
+}
+
+func (r *importRepo) ExistingSKUs(ctx context.Context, skus []string) (map[string]bool, error) {
+	rows, err := r.pool.Query(ctx, "SELECT sku FROM yarn_variants WHERE sku = ANY($1)", skus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var sku string
+		if err := rows.Scan(&sku); err != nil {
+			return nil, err
+		}
+		existing[sku] = true
+	}
+	return existing, nil
+}
+
+func (r *importRepo) ExistingRates(ctx context.Context, keys []string, dates []time.Time) ([]bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM price_rates r WHERE r.parameter_key = t.key AND r.effective_date = t.effective_date
+		)
+		FROM unnest($1::text[], $2::date[]) WITH ORDINALITY AS t(key, effective_date, n)
+		ORDER BY t.n
+	`
+	rows, err := r.pool.Query(ctx, query, keys, dates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exists := make([]bool, 0, len(keys))
+	for rows.Next() {
+		var found bool
+		if err := rows.Scan(&found); err != nil {
+			return nil, err
+		}
+		exists = append(exists, found)
+	}
+	return exists, nil
+}
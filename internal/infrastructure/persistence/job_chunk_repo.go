@@ -0,0 +1,106 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// jobChunkRepo implements repository.JobChunkRepository
+type jobChunkRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewJobChunkRepository creates a new job chunk repository
+func NewJobChunkRepository(pool *pgxpool.Pool) repository.JobChunkRepository {
+	return &jobChunkRepo{pool: pool}
+}
+
+func (r *jobChunkRepo) Start(ctx context.Context, chunk *entity.JobChunk) error {
+	query := `
+		INSERT INTO job_chunks (job_id, after_variant_id, through_variant_id, status, started_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (job_id, after_variant_id) DO UPDATE
+		SET through_variant_id = EXCLUDED.through_variant_id, status = EXCLUDED.status,
+		    processed_records = 0, failed_records = 0, started_at = NOW(), finished_at = NULL
+		WHERE job_chunks.status <> $5
+	`
+	_, err := r.pool.Exec(ctx, query, chunk.JobID, chunk.AfterVariantID, chunk.ThroughVariantID,
+		entity.JobStatusRunning, entity.JobStatusCompleted)
+	return err
+}
+
+func (r *jobChunkRepo) Complete(ctx context.Context, chunks []*entity.JobChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, c := range chunks {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO job_chunks (job_id, after_variant_id, through_variant_id, status, processed_records, failed_records, finished_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())
+			ON CONFLICT (job_id, after_variant_id) DO UPDATE
+			SET through_variant_id = EXCLUDED.through_variant_id, status = EXCLUDED.status,
+			    processed_records = EXCLUDED.processed_records, failed_records = EXCLUDED.failed_records,
+			    finished_at = NOW()
+		`, c.JobID, c.AfterVariantID, c.ThroughVariantID, entity.JobStatusCompleted, c.ProcessedRecords, c.FailedRecords)
+		if err != nil {
+			return fmt.Errorf("failed to complete chunk after %s: %w", c.AfterVariantID, err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *jobChunkRepo) ListCompleted(ctx context.Context, jobID, after, through uuid.UUID) ([]*entity.JobChunk, error) {
+	query := `
+		SELECT job_id, after_variant_id, through_variant_id, status, processed_records, failed_records, started_at, finished_at
+		FROM job_chunks
+		WHERE job_id = $1 AND after_variant_id >= $2 AND through_variant_id <= $3 AND status = $4
+		ORDER BY after_variant_id
+	`
+	rows, err := r.pool.Query(ctx, query, jobID, after, through, entity.JobStatusCompleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*entity.JobChunk
+	for rows.Next() {
+		var c entity.JobChunk
+		if err := rows.Scan(&c.JobID, &c.AfterVariantID, &c.ThroughVariantID, &c.Status,
+			&c.ProcessedRecords, &c.FailedRecords, &c.StartedAt, &c.FinishedAt); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &c)
+	}
+	return chunks, nil
+}
+
+func (r *jobChunkRepo) CountByStatus(ctx context.Context, jobID uuid.UUID) (map[entity.JobStatus]int64, error) {
+	rows, err := r.pool.Query(ctx, "SELECT status, COUNT(*) FROM job_chunks WHERE job_id = $1 GROUP BY status", jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[entity.JobStatus]int64)
+	for rows.Next() {
+		var status entity.JobStatus
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,61 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// jobEventRepo implements repository.JobEventRepository. Events are written by the
+// trg_batch_jobs_events trigger, so this repository only reads them.
+type jobEventRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewJobEventRepository creates a new job event repository
+func NewJobEventRepository(pool *pgxpool.Pool) repository.JobEventRepository {
+	return &jobEventRepo{pool: pool}
+}
+
+func (r *jobEventRepo) ListByJob(ctx context.Context, jobID uuid.UUID, limit int) ([]*entity.JobEvent, error) {
+	query := `
+		SELECT id, job_id, event_type, from_status, to_status, processed_records, failed_records,
+			total_records, COALESCE(message, ''), COALESCE(actor, ''), created_at
+		FROM job_events WHERE job_id = $1
+		ORDER BY created_at, id LIMIT $2
+	`
+	rows, err := r.pool.Query(ctx, query, jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*entity.JobEvent
+	for rows.Next() {
+		var e entity.JobEvent
+		if err := rows.Scan(&e.ID, &e.JobID, &e.EventType, &e.FromStatus, &e.ToStatus, &e.ProcessedRecords,
+			&e.FailedRecords, &e.TotalRecords, &e.Message, &e.Actor, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+func (r *jobEventRepo) DeleteBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	query := `
+		DELETE FROM job_events WHERE id IN (
+			SELECT id FROM job_events WHERE created_at < $1 LIMIT $2
+		)
+	`
+	tag, err := r.pool.Exec(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,242 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// claimCandidates bounds how many partitions Claim tries to lock per call
+const claimCandidates = 100
+
+// jobPartitionRepo implements repository.JobPartitionRepository
+type jobPartitionRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewJobPartitionRepository creates a new job partition repository
+func NewJobPartitionRepository(pool *pgxpool.Pool) repository.JobPartitionRepository {
+	return &jobPartitionRepo{pool: pool}
+}
+
+const partitionColumns = `job_id, partition_no, after_variant_id, through_variant_id, status, COALESCE(claimed_by, ''),
+	checkpoint_variant_id, processed_records, failed_records, started_at, finished_at`
+
+func scanPartition(row pgx.Row) (*entity.JobPartition, error) {
+	var p entity.JobPartition
+	err := row.Scan(&p.JobID, &p.PartitionNo, &p.AfterVariantID, &p.ThroughVariantID, &p.Status, &p.ClaimedBy,
+		&p.Checkpoint, &p.ProcessedRecords, &p.FailedRecords, &p.StartedAt, &p.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// aggregatePartitionsQuery refreshes a job's counts from its partitions
+const aggregatePartitionsQuery = `
+	UPDATE batch_jobs j
+	SET processed_records = p.processed, failed_records = p.failed
+	FROM (
+		SELECT COALESCE(SUM(processed_records), 0) AS processed, COALESCE(SUM(failed_records), 0) AS failed
+		FROM job_partitions WHERE job_id = $1
+	) p
+	WHERE j.id = $1
+`
+
+func (r *jobPartitionRepo) Create(ctx context.Context, jobID uuid.UUID, total int64, partitions []*entity.JobPartition) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "UPDATE batch_jobs SET total_records = $2 WHERE id = $1", jobID, total); err != nil {
+		return err
+	}
+	// Ranges of an earlier run stay as they were, even if the partition count changed since
+	var exists bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM job_partitions WHERE job_id = $1)", jobID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		for _, p := range partitions {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO job_partitions (job_id, partition_no, after_variant_id, through_variant_id, status)
+				VALUES ($1, $2, $3, $4, $5)
+			`, jobID, p.PartitionNo, p.AfterVariantID, p.ThroughVariantID, entity.JobStatusPending)
+			if err != nil {
+				return fmt.Errorf("failed to create partition %d: %w", p.PartitionNo, err)
+			}
+		}
+	}
+	if _, err := tx.Exec(ctx, aggregatePartitionsQuery, jobID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *jobPartitionRepo) Claim(ctx context.Context, workerID string) (*entity.JobPartition, func(), error) {
+	// The advisory lock belongs to this connection's session, so it is held until release
+	// returns the connection or the worker dies and the connection drops
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT p.job_id, p.partition_no
+		FROM job_partitions p
+		JOIN batch_jobs j ON j.id = p.job_id
+		WHERE j.status = $1 AND p.status IN ($1, $2)
+		ORDER BY j.priority DESC, j.created_at, p.partition_no
+		LIMIT $3
+	`, entity.JobStatusRunning, entity.JobStatusPending, claimCandidates)
+	if err != nil {
+		conn.Release()
+		return nil, nil, err
+	}
+	type candidate struct {
+		jobID uuid.UUID
+		no    int
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.jobID, &c.no); err != nil {
+			rows.Close()
+			conn.Release()
+			return nil, nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+
+	for _, c := range candidates {
+		var locked bool
+		if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext($1::text), $2)", c.jobID, c.no).Scan(&locked); err != nil {
+			conn.Release()
+			return nil, nil, err
+		}
+		if !locked {
+			continue // another worker is running it
+		}
+
+		// Re-check under the lock; it may have completed since it was listed
+		partition, err := scanPartition(conn.QueryRow(ctx, `
+			UPDATE job_partitions SET status = $3, claimed_by = $4, started_at = COALESCE(started_at, NOW())
+			WHERE job_id = $1 AND partition_no = $2 AND status <> $5
+			RETURNING `+partitionColumns,
+			c.jobID, c.no, entity.JobStatusRunning, workerID, entity.JobStatusCompleted))
+		if err != nil {
+			unlockPartition(conn, c.jobID, c.no)
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			conn.Release()
+			return nil, nil, err
+		}
+
+		release := func() {
+			ctx := context.Background()
+			// If this fails the partition stays RUNNING, which the next claimer takes over
+			// once the lock below is free
+			conn.Exec(ctx, `
+				UPDATE job_partitions SET status = $3, claimed_by = NULL
+				WHERE job_id = $1 AND partition_no = $2 AND status = $4
+			`, c.jobID, c.no, entity.JobStatusPending, entity.JobStatusRunning)
+			unlockPartition(conn, c.jobID, c.no)
+			conn.Release()
+		}
+		return partition, release, nil
+	}
+
+	conn.Release()
+	return nil, nil, pgx.ErrNoRows
+}
+
+// unlockPartition releases a partition's advisory lock, closing the connection if that
+// fails so the lock is not left held by a pooled connection
+func unlockPartition(conn *pgxpool.Conn, jobID uuid.UUID, no int) {
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock(hashtext($1::text), $2)", jobID, no); err != nil {
+		conn.Conn().Close(ctx)
+	}
+}
+
+func (r *jobPartitionRepo) SaveCheckpoint(ctx context.Context, jobID uuid.UUID, partitionNo int, checkpoint *entity.JobCheckpoint) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE job_partitions SET checkpoint_variant_id = $3, processed_records = $4, failed_records = $5
+		WHERE job_id = $1 AND partition_no = $2
+	`, jobID, partitionNo, checkpoint.AfterVariantID, checkpoint.Processed, checkpoint.Failed)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, aggregatePartitionsQuery, jobID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *jobPartitionRepo) Complete(ctx context.Context, jobID uuid.UUID, partitionNo int, checkpoint *entity.JobCheckpoint) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE job_partitions
+		SET status = $3, checkpoint_variant_id = $4, processed_records = $5, failed_records = $6, finished_at = NOW()
+		WHERE job_id = $1 AND partition_no = $2
+	`, jobID, partitionNo, entity.JobStatusCompleted, checkpoint.AfterVariantID, checkpoint.Processed, checkpoint.Failed)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, aggregatePartitionsQuery, jobID); err != nil {
+		return false, err
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE batch_jobs SET status = $2, finished_at = NOW()
+		WHERE id = $1 AND status = $3
+		  AND NOT EXISTS (SELECT 1 FROM job_partitions WHERE job_id = $1 AND status <> $2)
+	`, jobID, entity.JobStatusCompleted, entity.JobStatusRunning)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *jobPartitionRepo) ListByJob(ctx context.Context, jobID uuid.UUID) ([]*entity.JobPartition, error) {
+	query := `SELECT ` + partitionColumns + ` FROM job_partitions WHERE job_id = $1 ORDER BY partition_no`
+	rows, err := r.pool.Query(ctx, query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []*entity.JobPartition
+	for rows.Next() {
+		p, err := scanPartition(rows)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
@@ -2,10 +2,12 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
@@ -22,28 +24,50 @@ func NewBatchJobRepository(pool *pgxpool.Pool) repository.BatchJobRepository {
 	return &batchJobRepo{pool: pool}
 }
 
+// insertJobQuery inserts a batch job from the arguments returned by jobArgs
+const insertJobQuery = `
+	INSERT INTO batch_jobs (id, job_type, status, priority, total_records, processed_records, failed_records, metadata, error_message,
+		claimed_by, claimed_at, heartbeat_at, attempts, max_attempts, next_retry_at, started_at, finished_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, $12, $13, $14, $15, $16, $17, $18)
+`
+
+func jobArgs(job *entity.BatchJob) []interface{} {
+	return []interface{}{
+		job.ID, job.JobType, job.Status, job.Priority, job.TotalRecords, job.ProcessedRecords, job.FailedRecords, job.Metadata, job.ErrorMessage,
+		job.ClaimedBy, job.ClaimedAt, job.HeartbeatAt, job.Attempts, job.MaxAttempts, job.NextRetryAt, job.StartedAt, job.FinishedAt, job.CreatedAt,
+	}
+}
+
 func (r *batchJobRepo) Create(ctx context.Context, job *entity.BatchJob) error {
+	_, err := r.pool.Exec(ctx, insertJobQuery, jobArgs(job)...)
+	if isExclusiveViolation(err) {
+		return repository.ErrExclusiveJobRunning
+	}
+	return err
+}
+
+func (r *batchJobRepo) GetRunning(ctx context.Context, jobType entity.JobType) (*entity.BatchJob, error) {
 	query := `
-		INSERT INTO batch_jobs (id, job_type, status, total_records, processed_records, failed_records, metadata, error_message, started_at, finished_at, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		SELECT ` + jobColumns + `
+		FROM batch_jobs WHERE job_type = $1 AND status = $2
+		ORDER BY started_at LIMIT 1
 	`
-	_, err := r.pool.Exec(ctx, query,
-		job.ID, job.JobType, job.Status, job.TotalRecords, job.ProcessedRecords, job.FailedRecords, job.Metadata, job.ErrorMessage, job.StartedAt, job.FinishedAt, job.CreatedAt)
-	return err
+	return scanJob(r.pool.QueryRow(ctx, query, jobType, entity.JobStatusRunning))
+}
+
+// isExclusiveViolation reports whether err is a violation of the index allowing one
+// RUNNING job per exclusive type
+func isExclusiveViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_batch_jobs_one_running_exclusive"
 }
 
 func (r *batchJobRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.BatchJob, error) {
 	query := `
-		SELECT id, job_type, status, total_records, processed_records, failed_records, metadata, error_message, started_at, finished_at, created_at
+		SELECT ` + jobColumns + `
 		FROM batch_jobs WHERE id = $1
 	`
-	var job entity.BatchJob
-	err := r.pool.QueryRow(ctx, query, id).Scan(
-		&job.ID, &job.JobType, &job.Status, &job.TotalRecords, &job.ProcessedRecords, &job.FailedRecords, &job.Metadata, &job.ErrorMessage, &job.StartedAt, &job.FinishedAt, &job.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &job, nil
+	return scanJob(r.pool.QueryRow(ctx, query, id))
 }
 
 func (r *batchJobRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status entity.JobStatus, processed, failed int64) error {
@@ -84,9 +108,171 @@ func (r *batchJobRepo) Fail(ctx context.Context, id uuid.UUID, errorMsg string)
 	return err
 }
 
+func (r *batchJobRepo) Retry(ctx context.Context, id uuid.UUID, errorMsg string, nextRetryAt time.Time) error {
+	query := `
+		UPDATE batch_jobs SET status = $2, error_message = $3, next_retry_at = $4, claimed_by = NULL, claimed_at = NULL
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, entity.JobStatusPending, errorMsg, nextRetryAt)
+	return err
+}
+
+func (r *batchJobRepo) SaveCheckpoint(ctx context.Context, id uuid.UUID, checkpoint *entity.JobCheckpoint) error {
+	query := `
+		UPDATE batch_jobs SET metadata = jsonb_set(COALESCE(metadata, '{}'), $2, $3)
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, []string{entity.JobCheckpointKey}, checkpoint)
+	return err
+}
+
+func (r *batchJobRepo) SaveMetrics(ctx context.Context, id uuid.UUID, metrics *entity.JobMetrics) error {
+	query := `
+		UPDATE batch_jobs SET metadata = jsonb_set(COALESCE(metadata, '{}'), $2, $3)
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, []string{entity.JobMetricsKey}, metrics)
+	return err
+}
+
+func (r *batchJobRepo) SaveImportReport(ctx context.Context, id uuid.UUID, report *entity.ImportReport) error {
+	query := `
+		UPDATE batch_jobs SET metadata = jsonb_set(COALESCE(metadata, '{}'), $2, $3)
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, []string{entity.JobImportKey}, report)
+	return err
+}
+
+func (r *batchJobRepo) SaveExportInfo(ctx context.Context, id uuid.UUID, info *entity.ExportInfo) error {
+	query := `
+		UPDATE batch_jobs SET metadata = jsonb_set(COALESCE(metadata, '{}'), $2, $3)
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, []string{entity.JobExportKey}, info)
+	return err
+}
+
+func (r *batchJobRepo) SetTotal(ctx context.Context, id uuid.UUID, total int64) error {
+	_, err := r.pool.Exec(ctx, "UPDATE batch_jobs SET total_records = $2 WHERE id = $1", id, total)
+	return err
+}
+
+func (r *batchJobRepo) ListWithMetrics(ctx context.Context, jobType entity.JobType, since time.Time, limit int) ([]*entity.BatchJob, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM batch_jobs
+		WHERE job_type = $1 AND metadata ? '` + entity.JobMetricsKey + `'
+		  AND (metadata->'` + entity.JobMetricsKey + `'->>'recorded_at')::timestamptz >= $2
+		ORDER BY (metadata->'` + entity.JobMetricsKey + `'->>'recorded_at')::timestamptz DESC
+		LIMIT $3
+	`
+	rows, err := r.pool.Query(ctx, query, jobType, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*entity.BatchJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (r *batchJobRepo) Pause(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE batch_jobs SET status = $2
+		WHERE id = $1 AND status IN ($3, $4)
+	`
+	tag, err := r.pool.Exec(ctx, query, id, entity.JobStatusPaused, entity.JobStatusPending, entity.JobStatusRunning)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *batchJobRepo) Resume(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE batch_jobs SET status = $2, claimed_by = NULL, claimed_at = NULL, next_retry_at = NULL, finished_at = NULL
+		WHERE id = $1 AND status IN ($3, $4)
+	`
+	tag, err := r.pool.Exec(ctx, query, id, entity.JobStatusPending, entity.JobStatusPaused, entity.JobStatusFailed)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *batchJobRepo) Release(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE batch_jobs SET status = $2, claimed_by = NULL, claimed_at = NULL, attempts = GREATEST(attempts - 1, 0)
+		WHERE id = $1 AND status = $3
+	`
+	_, err := r.pool.Exec(ctx, query, id, entity.JobStatusPending, entity.JobStatusRunning)
+	return err
+}
+
+func (r *batchJobRepo) Heartbeat(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE batch_jobs SET heartbeat_at = NOW()
+		WHERE id = $1 AND status = $2
+	`
+	_, err := r.pool.Exec(ctx, query, id, entity.JobStatusRunning)
+	return err
+}
+
+func (r *batchJobRepo) ListStale(ctx context.Context, staleBefore time.Time) ([]*entity.BatchJob, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM batch_jobs
+		WHERE status = $1 AND COALESCE(heartbeat_at, claimed_at, started_at, created_at) < $2
+		ORDER BY heartbeat_at
+	`
+	rows, err := r.pool.Query(ctx, query, entity.JobStatusRunning, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*entity.BatchJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (r *batchJobRepo) ReleaseStale(ctx context.Context, id uuid.UUID, staleBefore time.Time, status entity.JobStatus, errorMsg string) (bool, error) {
+	query := `
+		UPDATE batch_jobs
+		SET status = $4, error_message = $5, claimed_by = NULL, claimed_at = NULL, next_retry_at = NULL,
+			finished_at = CASE WHEN $4 = $6 THEN NOW() END
+		WHERE id = $1 AND status = $2 AND COALESCE(heartbeat_at, claimed_at, started_at, created_at) < $3
+	`
+	tag, err := r.pool.Exec(ctx, query, id, entity.JobStatusRunning, staleBefore, status, errorMsg, entity.JobStatusFailed)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func (r *batchJobRepo) ListRecent(ctx context.Context, limit int) ([]*entity.BatchJob, error) {
 	query := `
-		SELECT id, job_type, status, total_records, processed_records, failed_records, metadata, error_message, started_at, finished_at, created_at
+		SELECT ` + jobColumns + `
 		FROM batch_jobs ORDER BY created_at DESC LIMIT $1
 	`
 	rows, err := r.pool.Query(ctx, query, limit)
@@ -97,15 +283,86 @@ func (r *batchJobRepo) ListRecent(ctx context.Context, limit int) ([]*entity.Bat
 
 	var jobs []*entity.BatchJob
 	for rows.Next() {
-		var job entity.BatchJob
-		if err := rows.Scan(&job.ID, &job.JobType, &job.Status, &job.TotalRecords, &job.ProcessedRecords, &job.FailedRecords, &job.Metadata, &job.ErrorMessage, &job.StartedAt, &job.FinishedAt, &job.CreatedAt); err != nil {
+		job, err := scanJob(rows)
+		if err != nil {
 			return nil, err
 		}
-		jobs = append(jobs, &job)
+		jobs = append(jobs, job)
 	}
 	return jobs, nil
 }
 
+func (r *batchJobRepo) ClaimNextPending(ctx context.Context, workerID string) (*entity.BatchJob, error) {
+	query := `
+		UPDATE batch_jobs
+		SET status = $1, claimed_by = $2, claimed_at = NOW(), heartbeat_at = NOW(), started_at = COALESCE(started_at, NOW()),
+			attempts = attempts + 1, next_retry_at = NULL
+		WHERE id = (
+			SELECT id FROM batch_jobs p
+			WHERE status = $3 AND (next_retry_at IS NULL OR next_retry_at <= NOW())
+			  AND NOT (job_type::text = ANY($4::text[]) AND EXISTS (
+				SELECT 1 FROM batch_jobs r WHERE r.job_type = p.job_type AND r.status = $1
+			  ))
+			ORDER BY priority DESC, COALESCE(next_retry_at, created_at)
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+	job, err := scanJob(r.pool.QueryRow(ctx, query, entity.JobStatusRunning, workerID, entity.JobStatusPending, exclusiveJobTypes()))
+	if isExclusiveViolation(err) {
+		// Another worker started a job of the same exclusive type concurrently; this one
+		// stays PENDING until that finishes
+		return nil, pgx.ErrNoRows
+	}
+	return job, err
+}
+
+func (r *batchJobRepo) DeleteFinishedBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	// created_at precedes finished_at, so its index narrows the scan
+	query := `
+		DELETE FROM batch_jobs WHERE id IN (
+			SELECT id FROM batch_jobs
+			WHERE status IN ($1, $2, $3) AND created_at < $4 AND COALESCE(finished_at, created_at) < $4
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+	tag, err := r.pool.Exec(ctx, query, entity.JobStatusCompleted, entity.JobStatusFailed, entity.JobStatusCancelled, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// exclusiveJobTypes lists the job types for which entity.JobType.Exclusive is true
+func exclusiveJobTypes() []string {
+	var types []string
+	for _, t := range []entity.JobType{
+		entity.JobTypeRecalculateAll, entity.JobTypeRecalculateMaster, entity.JobTypeRecalculateVariant,
+		entity.JobTypeRecalculateRouting, entity.JobTypeImportData, entity.JobTypeExportData,
+		entity.JobTypeArchiveHistory,
+	} {
+		if t.Exclusive() {
+			types = append(types, string(t))
+		}
+	}
+	return types
+}
+
+// jobColumns is the batch_jobs select list read by scanJob
+const jobColumns = `id, job_type, status, priority, total_records, processed_records, failed_records, metadata, COALESCE(error_message, ''),
+	COALESCE(claimed_by, ''), claimed_at, heartbeat_at, attempts, max_attempts, next_retry_at, started_at, finished_at, created_at`
+
+func scanJob(row pgx.Row) (*entity.BatchJob, error) {
+	var job entity.BatchJob
+	err := row.Scan(&job.ID, &job.JobType, &job.Status, &job.Priority, &job.TotalRecords, &job.ProcessedRecords, &job.FailedRecords, &job.Metadata,
+		&job.ErrorMessage, &job.ClaimedBy, &job.ClaimedAt, &job.HeartbeatAt, &job.Attempts, &job.MaxAttempts, &job.NextRetryAt, &job.StartedAt, &job.FinishedAt, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
 // processStepRepo implements repository.ProcessStepRepository
 type processStepRepo struct {
 	pool *pgxpool.Pool
@@ -118,8 +375,12 @@ func NewProcessStepRepository(pool *pgxpool.Pool) repository.ProcessStepReposito
 
 func (r *processStepRepo) GetByRoutingID(ctx context.Context, routingID uuid.UUID) ([]*entity.ProcessStep, error) {
 	query := `
-		SELECT id, routing_template_id, process_master_id, sequence_order, formula_expression, COALESCE(description, ''), created_at
-		FROM process_steps WHERE routing_template_id = $1 ORDER BY sequence_order
+		SELECT ps.id, ps.routing_template_id, ps.process_master_id, COALESCE(pm.code, ''), ps.sequence_order,
+			COALESCE(f.expression, ps.formula_expression), ps.formula_id, COALESCE(ps.description, ''), ps.created_at
+		FROM process_steps ps
+		LEFT JOIN process_masters pm ON pm.id = ps.process_master_id
+		LEFT JOIN formulas f ON f.id = ps.formula_id
+		WHERE ps.routing_template_id = $1 ORDER BY ps.sequence_order
 	`
 	rows, err := r.pool.Query(ctx, query, routingID)
 	if err != nil {
@@ -130,7 +391,7 @@ func (r *processStepRepo) GetByRoutingID(ctx context.Context, routingID uuid.UUI
 	var steps []*entity.ProcessStep
 	for rows.Next() {
 		var s entity.ProcessStep
-		if err := rows.Scan(&s.ID, &s.RoutingTemplateID, &s.ProcessMasterID, &s.SequenceOrder, &s.FormulaExpression, &s.Description, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.RoutingTemplateID, &s.ProcessMasterID, &s.ProcessCode, &s.SequenceOrder, &s.FormulaExpression, &s.FormulaID, &s.Description, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		steps = append(steps, &s)
@@ -140,17 +401,50 @@ func (r *processStepRepo) GetByRoutingID(ctx context.Context, routingID uuid.UUI
 
 func (r *processStepRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.ProcessStep, error) {
 	query := `
-		SELECT id, routing_template_id, process_master_id, sequence_order, formula_expression, COALESCE(description, ''), created_at
-		FROM process_steps WHERE id = $1
+		SELECT ps.id, ps.routing_template_id, ps.process_master_id, COALESCE(pm.code, ''), ps.sequence_order,
+			COALESCE(f.expression, ps.formula_expression), ps.formula_id, COALESCE(ps.description, ''), ps.created_at
+		FROM process_steps ps
+		LEFT JOIN process_masters pm ON pm.id = ps.process_master_id
+		LEFT JOIN formulas f ON f.id = ps.formula_id
+		WHERE ps.id = $1
 	`
 	var s entity.ProcessStep
-	err := r.pool.QueryRow(ctx, query, id).Scan(&s.ID, &s.RoutingTemplateID, &s.ProcessMasterID, &s.SequenceOrder, &s.FormulaExpression, &s.Description, &s.CreatedAt)
+	err := r.pool.QueryRow(ctx, query, id).Scan(&s.ID, &s.RoutingTemplateID, &s.ProcessMasterID, &s.ProcessCode, &s.SequenceOrder, &s.FormulaExpression, &s.FormulaID, &s.Description, &s.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &s, nil
 }
 
+func (r *processStepRepo) UpdateFormula(ctx context.Context, id uuid.UUID, expression string) error {
+	return r.updateFormula(ctx, id, "UPDATE process_steps SET formula_expression = $2, formula_id = NULL WHERE id = $1", expression)
+}
+
+func (r *processStepRepo) SetFormulaRef(ctx context.Context, id uuid.UUID, formulaID uuid.UUID) error {
+	return r.updateFormula(ctx, id, "UPDATE process_steps SET formula_id = $2 WHERE id = $1", formulaID)
+}
+
+// updateFormula runs update on a step, recording its formula before and after in the
+// audit log. Returns pgx.ErrNoRows when the step does not exist.
+func (r *processStepRepo) updateFormula(ctx context.Context, id uuid.UUID, update string, value interface{}) error {
+	const formula = "SELECT formula_expression, formula_id FROM process_steps WHERE id = $1"
+	log := &entity.AuditLog{EntityType: entity.AuditEntityProcessStep, EntityID: id, Action: entity.AuditActionUpdate}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		var before, after entity.ProcessStep
+		if err := tx.QueryRow(ctx, formula+" FOR UPDATE", id).Scan(&before.FormulaExpression, &before.FormulaID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, update, id, value); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, formula, id).Scan(&after.FormulaExpression, &after.FormulaID); err != nil {
+			return err
+		}
+		log.Changes = entity.AuditDiff(before, after)
+		return nil
+	})
+}
+
 // routingTemplateRepo implements repository.RoutingTemplateRepository
 type routingTemplateRepo struct {
 	pool *pgxpool.Pool
@@ -207,9 +501,9 @@ func NewProcessMasterRepository(pool *pgxpool.Pool) repository.ProcessMasterRepo
 }
 
 func (r *processMasterRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.ProcessMaster, error) {
-	query := `SELECT id, code, name, description, default_sequence, created_at FROM process_masters WHERE id = $1`
+	query := `SELECT id, code, name, COALESCE(description, ''), default_sequence, COALESCE(output_kg_per_hour, 0), setup_hours, created_at FROM process_masters WHERE id = $1`
 	var p entity.ProcessMaster
-	err := r.pool.QueryRow(ctx, query, id).Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.DefaultSequence, &p.CreatedAt)
+	err := r.pool.QueryRow(ctx, query, id).Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.DefaultSequence, &p.OutputKgPerHour, &p.SetupHours, &p.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -217,7 +511,7 @@ func (r *processMasterRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.
 }
 
 func (r *processMasterRepo) List(ctx context.Context) ([]*entity.ProcessMaster, error) {
-	query := `SELECT id, code, name, description, default_sequence, created_at FROM process_masters ORDER BY default_sequence`
+	query := `SELECT id, code, name, COALESCE(description, ''), default_sequence, COALESCE(output_kg_per_hour, 0), setup_hours, created_at FROM process_masters ORDER BY default_sequence`
 	rows, err := r.pool.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -227,7 +521,7 @@ func (r *processMasterRepo) List(ctx context.Context) ([]*entity.ProcessMaster,
 	var processes []*entity.ProcessMaster
 	for rows.Next() {
 		var p entity.ProcessMaster
-		if err := rows.Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.DefaultSequence, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.Code, &p.Name, &p.Description, &p.DefaultSequence, &p.OutputKgPerHour, &p.SetupHours, &p.CreatedAt); err != nil {
 			return nil, err
 		}
 		processes = append(processes, &p)
@@ -236,16 +530,23 @@ func (r *processMasterRepo) List(ctx context.Context) ([]*entity.ProcessMaster,
 }
 
 func (r *processMasterRepo) Create(ctx context.Context, process *entity.ProcessMaster) error {
-	query := `INSERT INTO process_masters (id, code, name, description, default_sequence, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err := r.pool.Exec(ctx, query, process.ID, process.Code, process.Name, process.Description, process.DefaultSequence, process.CreatedAt)
+	query := `
+		INSERT INTO process_masters (id, code, name, description, default_sequence, output_kg_per_hour, setup_hours, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8)
+	`
+	_, err := r.pool.Exec(ctx, query, process.ID, process.Code, process.Name, process.Description, process.DefaultSequence, process.OutputKgPerHour, process.SetupHours, process.CreatedAt)
 	return err
 }
 
 func (r *processMasterRepo) CreateBatch(ctx context.Context, processes []*entity.ProcessMaster) (int64, error) {
-	columns := []string{"id", "code", "name", "description", "default_sequence", "created_at"}
+	columns := []string{"id", "code", "name", "description", "default_sequence", "output_kg_per_hour", "setup_hours", "created_at"}
 	rows := make([][]interface{}, len(processes))
 	for i, p := range processes {
-		rows[i] = []interface{}{p.ID, p.Code, p.Name, p.Description, p.DefaultSequence, p.CreatedAt}
+		var outputRate *float64
+		if p.OutputKgPerHour > 0 {
+			outputRate = &p.OutputKgPerHour
+		}
+		rows[i] = []interface{}{p.ID, p.Code, p.Name, p.Description, p.DefaultSequence, outputRate, p.SetupHours, p.CreatedAt}
 	}
 	return r.pool.CopyFrom(ctx, pgx.Identifier{"process_masters"}, columns, pgx.CopyFromRows(rows))
 }
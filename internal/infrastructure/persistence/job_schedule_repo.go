@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// jobScheduleRepo implements repository.JobScheduleRepository
+type jobScheduleRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewJobScheduleRepository creates a new job schedule repository
+func NewJobScheduleRepository(pool *pgxpool.Pool) repository.JobScheduleRepository {
+	return &jobScheduleRepo{pool: pool}
+}
+
+const scheduleColumns = `id, name, cron_expression, timezone, job_type, COALESCE(params, '{}'), priority, COALESCE(is_active, true),
+	next_run_at, last_run_at, last_job_id, created_at`
+
+func (r *jobScheduleRepo) Create(ctx context.Context, schedule *entity.JobSchedule) error {
+	query := `
+		INSERT INTO job_schedules (id, name, cron_expression, timezone, job_type, params, priority, is_active, next_run_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := r.pool.Exec(ctx, query,
+		schedule.ID, schedule.Name, schedule.CronExpression, schedule.Timezone, schedule.JobType, schedule.Params, schedule.Priority,
+		schedule.IsActive, schedule.NextRunAt, schedule.CreatedAt)
+	return err
+}
+
+func (r *jobScheduleRepo) List(ctx context.Context) ([]*entity.JobSchedule, error) {
+	return r.query(ctx, `SELECT `+scheduleColumns+` FROM job_schedules ORDER BY name`)
+}
+
+func (r *jobScheduleRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, "DELETE FROM job_schedules WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *jobScheduleRepo) ListDue(ctx context.Context, now time.Time) ([]*entity.JobSchedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM job_schedules WHERE is_active = true AND next_run_at <= $1 ORDER BY next_run_at`
+	return r.query(ctx, query, now)
+}
+
+func (r *jobScheduleRepo) Fire(ctx context.Context, schedule *entity.JobSchedule, job *entity.BatchJob, nextRunAt time.Time) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, insertJobQuery, jobArgs(job)...); err != nil {
+		return false, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	// Only the worker that still sees the run it listed advances the schedule; the others
+	// roll back their job
+	tag, err := tx.Exec(ctx, `
+		UPDATE job_schedules SET next_run_at = $3, last_run_at = $4, last_job_id = $5
+		WHERE id = $1 AND next_run_at = $2
+	`, schedule.ID, schedule.NextRunAt, nextRunAt, job.CreatedAt, job.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance schedule: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	return true, tx.Commit(ctx)
+}
+
+func (r *jobScheduleRepo) query(ctx context.Context, query string, args ...interface{}) ([]*entity.JobSchedule, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*entity.JobSchedule
+	for rows.Next() {
+		var s entity.JobSchedule
+		if err := rows.Scan(&s.ID, &s.Name, &s.CronExpression, &s.Timezone, &s.JobType, &s.Params, &s.Priority, &s.IsActive,
+			&s.NextRunAt, &s.LastRunAt, &s.LastJobID, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, &s)
+	}
+	return schedules, nil
+}
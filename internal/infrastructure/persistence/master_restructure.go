@@ -0,0 +1,155 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+func (r *masterYarnRepo) MergeInto(ctx context.Context, sourceID, targetID uuid.UUID, actor string) (*entity.MasterRestructure, error) {
+	if sourceID == targetID {
+		return nil, fmt.Errorf("cannot merge a master into itself")
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockMasters(ctx, tx, sourceID, targetID); err != nil {
+		return nil, err
+	}
+
+	result := &entity.MasterRestructure{SourceID: sourceID, TargetID: targetID}
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(s.yarn_variant_id), COALESCE(SUM(s.grand_total), 0)
+		FROM yarn_variants v JOIN variant_cost_summaries s ON s.yarn_variant_id = v.id
+		WHERE v.master_yarn_id = $1
+	`, sourceID).Scan(&result.SummariesMoved, &result.GrandTotalMoved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot cost summaries: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, "UPDATE yarn_variants SET master_yarn_id = $2, updated_at = NOW() WHERE master_yarn_id = $1", sourceID, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move variants: %w", err)
+	}
+	result.VariantsMoved = tag.RowsAffected()
+
+	if _, err := tx.Exec(ctx, "UPDATE master_yarns SET is_active = false, updated_at = NOW() WHERE id = $1", sourceID); err != nil {
+		return nil, fmt.Errorf("failed to deactivate source master: %w", err)
+	}
+
+	audit := &entity.AuditLog{
+		EntityType: entity.AuditEntityMasterYarn,
+		EntityID:   sourceID,
+		Action:     entity.AuditActionMergeInto,
+		Actor:      actor,
+		Details: map[string]interface{}{
+			"target_id":         targetID,
+			"variants_moved":    result.VariantsMoved,
+			"summaries_moved":   result.SummariesMoved,
+			"grand_total_moved": result.GrandTotalMoved,
+		},
+	}
+	if err := insertAuditLog(ctx, tx, audit); err != nil {
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	result.AuditLogID = audit.ID
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit merge: %w", err)
+	}
+	return result, nil
+}
+
+func (r *masterYarnRepo) Split(ctx context.Context, sourceID uuid.UUID, newMaster *entity.MasterYarn, variantIDs []uuid.UUID, actor string) (*entity.MasterRestructure, error) {
+	if len(variantIDs) == 0 {
+		return nil, fmt.Errorf("no variants selected")
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockMasters(ctx, tx, sourceID); err != nil {
+		return nil, err
+	}
+
+	fixedAttrs, _ := newMaster.FixedAttrsJSON()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO master_yarns (id, code, name, description, fixed_attrs, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, newMaster.ID, newMaster.Code, newMaster.Name, newMaster.Description, fixedAttrs, newMaster.IsActive, newMaster.CreatedAt, newMaster.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new master: %w", err)
+	}
+
+	result := &entity.MasterRestructure{SourceID: sourceID, TargetID: newMaster.ID}
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(s.yarn_variant_id), COALESCE(SUM(s.grand_total), 0)
+		FROM yarn_variants v JOIN variant_cost_summaries s ON s.yarn_variant_id = v.id
+		WHERE v.master_yarn_id = $1 AND v.id = ANY($2)
+	`, sourceID, variantIDs).Scan(&result.SummariesMoved, &result.GrandTotalMoved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot cost summaries: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE yarn_variants SET master_yarn_id = $3, updated_at = NOW()
+		WHERE master_yarn_id = $1 AND id = ANY($2)
+	`, sourceID, variantIDs, newMaster.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move variants: %w", err)
+	}
+	if moved := tag.RowsAffected(); moved != int64(len(variantIDs)) {
+		return nil, fmt.Errorf("%d of %d selected variants do not belong to master %s", int64(len(variantIDs))-moved, len(variantIDs), sourceID)
+	}
+	result.VariantsMoved = tag.RowsAffected()
+
+	audit := &entity.AuditLog{
+		EntityType: entity.AuditEntityMasterYarn,
+		EntityID:   sourceID,
+		Action:     entity.AuditActionSplit,
+		Actor:      actor,
+		Details: map[string]interface{}{
+			"new_master_id":     newMaster.ID,
+			"new_master_code":   newMaster.Code,
+			"variant_ids":       variantIDs,
+			"summaries_moved":   result.SummariesMoved,
+			"grand_total_moved": result.GrandTotalMoved,
+		},
+	}
+	if err := insertAuditLog(ctx, tx, audit); err != nil {
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	result.AuditLogID = audit.ID
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit split: %w", err)
+	}
+	return result, nil
+}
+
+// lockMasters row-locks the given masters for the rest of the transaction, failing
+// with pgx.ErrNoRows if any does not exist
+func lockMasters(ctx context.Context, tx pgx.Tx, ids ...uuid.UUID) error {
+	var locked int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM (SELECT id FROM master_yarns WHERE id = ANY($1) ORDER BY id FOR UPDATE) m
+	`, ids).Scan(&locked)
+	if err != nil {
+		return fmt.Errorf("failed to lock masters: %w", err)
+	}
+	if locked != len(ids) {
+		return fmt.Errorf("master yarn not found: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
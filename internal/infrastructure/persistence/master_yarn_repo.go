@@ -28,15 +28,18 @@ func (r *masterYarnRepo) Create(ctx context.Context, yarn *entity.MasterYarn) er
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
 	fixedAttrs, _ := yarn.FixedAttrsJSON()
-	_, err := r.pool.Exec(ctx, query,
-		yarn.ID, yarn.Code, yarn.Name, yarn.Description, fixedAttrs, yarn.IsActive, yarn.CreatedAt, yarn.UpdatedAt)
-	return err
+	log := &entity.AuditLog{EntityType: entity.AuditEntityMasterYarn, EntityID: yarn.ID, Action: entity.AuditActionCreate, Changes: entity.AuditDiff(nil, yarn)}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			yarn.ID, yarn.Code, yarn.Name, yarn.Description, fixedAttrs, yarn.IsActive, yarn.CreatedAt, yarn.UpdatedAt)
+		return err
+	})
 }
 
-// CreateBatch uses PostgreSQL COPY protocol for high-performance bulk inserts
-func (r *masterYarnRepo) CreateBatch(ctx context.Context, yarns []*entity.MasterYarn) (int64, error) {
-	columns := []string{"id", "code", "name", "description", "fixed_attrs", "is_active", "created_at", "updated_at"}
+// masterYarnCopyColumns are the columns CreateBatch and CreateBatchSkipExisting copy
+var masterYarnCopyColumns = []string{"id", "code", "name", "description", "fixed_attrs", "is_active", "created_at", "updated_at"}
 
+func masterYarnCopyRows(yarns []*entity.MasterYarn) [][]interface{} {
 	rows := make([][]interface{}, len(yarns))
 	for i, yarn := range yarns {
 		fixedAttrs, _ := yarn.FixedAttrsJSON()
@@ -44,12 +47,16 @@ func (r *masterYarnRepo) CreateBatch(ctx context.Context, yarns []*entity.Master
 			yarn.ID, yarn.Code, yarn.Name, yarn.Description, fixedAttrs, yarn.IsActive, yarn.CreatedAt, yarn.UpdatedAt,
 		}
 	}
+	return rows
+}
 
+// CreateBatch uses PostgreSQL COPY protocol for high-performance bulk inserts
+func (r *masterYarnRepo) CreateBatch(ctx context.Context, yarns []*entity.MasterYarn) (int64, error) {
 	copyCount, err := r.pool.CopyFrom(
 		ctx,
 		pgx.Identifier{"master_yarns"},
-		columns,
-		pgx.CopyFromRows(rows),
+		masterYarnCopyColumns,
+		pgx.CopyFromRows(masterYarnCopyRows(yarns)),
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to copy master yarns: %w", err)
@@ -58,20 +65,28 @@ func (r *masterYarnRepo) CreateBatch(ctx context.Context, yarns []*entity.Master
 	return copyCount, nil
 }
 
-func (r *masterYarnRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.MasterYarn, error) {
-	query := `
-		SELECT id, code, name, description, fixed_attrs, is_active, created_at, updated_at
-		FROM master_yarns WHERE id = $1
-	`
-	var yarn entity.MasterYarn
-	err := r.pool.QueryRow(ctx, query, id).Scan(
-		&yarn.ID, &yarn.Code, &yarn.Name, &yarn.Description, &yarn.FixedAttrs, &yarn.IsActive, &yarn.CreatedAt, &yarn.UpdatedAt)
+func (r *masterYarnRepo) CreateBatchSkipExisting(ctx context.Context, yarns []*entity.MasterYarn) (int64, error) {
+	created, err := copySkipExisting(ctx, r.pool, "master_yarns", masterYarnCopyColumns, masterYarnCopyRows(yarns))
 	if err != nil {
+		return 0, fmt.Errorf("failed to copy master yarns: %w", err)
+	}
+	return created, nil
+}
+
+const masterYarnColumns = `id, code, name, description, fixed_attrs, is_active, created_at, updated_at`
+
+func scanMasterYarn(row pgx.Row) (*entity.MasterYarn, error) {
+	var yarn entity.MasterYarn
+	if err := row.Scan(&yarn.ID, &yarn.Code, &yarn.Name, &yarn.Description, &yarn.FixedAttrs, &yarn.IsActive, &yarn.CreatedAt, &yarn.UpdatedAt); err != nil {
 		return nil, err
 	}
 	return &yarn, nil
 }
 
+func (r *masterYarnRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.MasterYarn, error) {
+	return scanMasterYarn(r.pool.QueryRow(ctx, `SELECT `+masterYarnColumns+` FROM master_yarns WHERE id = $1`, id))
+}
+
 func (r *masterYarnRepo) GetByCode(ctx context.Context, code string) (*entity.MasterYarn, error) {
 	query := `
 		SELECT id, code, name, description, fixed_attrs, is_active, created_at, updated_at
@@ -110,6 +125,48 @@ func (r *masterYarnRepo) List(ctx context.Context, limit, offset int) ([]*entity
 	return yarns, nil
 }
 
+// masterYarnSearch is what master yarn searches filter on
+var masterYarnSearch = &searchTable{
+	from: `SELECT ` + masterYarnColumns + ` FROM master_yarns`,
+	key:  "id",
+	columns: map[string]filterColumn{
+		"id":          {"id", "uuid"},
+		"code":        {"code", "text"},
+		"name":        {"name", "text"},
+		"description": {"description", "text"},
+		"is_active":   {"is_active", "boolean"},
+		"created_at":  {"created_at", "timestamptz"},
+		"updated_at":  {"updated_at", "timestamptz"},
+	},
+	attrs: "fixed_attrs",
+}
+
+func (r *masterYarnRepo) ListAfter(ctx context.Context, cursor entity.Cursor, limit int) ([]*entity.MasterYarn, error) {
+	return r.Search(ctx, nil, cursor, limit)
+}
+
+func (r *masterYarnRepo) Search(ctx context.Context, filters []entity.Filter, cursor entity.Cursor, limit int) ([]*entity.MasterYarn, error) {
+	query, args, err := masterYarnSearch.query(filters, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var yarns []*entity.MasterYarn
+	for rows.Next() {
+		yarn, err := scanMasterYarn(rows)
+		if err != nil {
+			return nil, err
+		}
+		yarns = append(yarns, yarn)
+	}
+	return yarns, rows.Err()
+}
+
 func (r *masterYarnRepo) Count(ctx context.Context) (int64, error) {
 	var count int64
 	err := r.pool.QueryRow(ctx, "SELECT COUNT(*) FROM master_yarns").Scan(&count)
@@ -122,11 +179,75 @@ func (r *masterYarnRepo) Update(ctx context.Context, yarn *entity.MasterYarn) er
 		WHERE id = $1
 	`
 	fixedAttrs, _ := yarn.FixedAttrsJSON()
-	_, err := r.pool.Exec(ctx, query, yarn.ID, yarn.Code, yarn.Name, yarn.Description, fixedAttrs, yarn.IsActive)
-	return err
+	log := &entity.AuditLog{EntityType: entity.AuditEntityMasterYarn, EntityID: yarn.ID, Action: entity.AuditActionUpdate}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		before, err := scanMasterYarn(tx.QueryRow(ctx, `SELECT `+masterYarnColumns+` FROM master_yarns WHERE id = $1 FOR UPDATE`, yarn.ID))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, query, yarn.ID, yarn.Code, yarn.Name, yarn.Description, fixedAttrs, yarn.IsActive); err != nil {
+			return err
+		}
+		log.Changes = entity.AuditDiff(before, yarn)
+		return nil
+	})
 }
 
 func (r *masterYarnRepo) Delete(ctx context.Context, id uuid.UUID) error {
-	_, err := r.pool.Exec(ctx, "DELETE FROM master_yarns WHERE id = $1", id)
-	return err
+	log := &entity.AuditLog{EntityType: entity.AuditEntityMasterYarn, EntityID: id, Action: entity.AuditActionDelete}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		before, err := scanMasterYarn(tx.QueryRow(ctx, `SELECT `+masterYarnColumns+` FROM master_yarns WHERE id = $1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM master_yarns WHERE id = $1", id); err != nil {
+			return err
+		}
+		log.Changes = entity.AuditDiff(before, nil)
+		return nil
+	})
+}
+
+func (r *masterYarnRepo) GetFixedAttrs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]map[string]interface{}, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, fixed_attrs FROM master_yarns WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := make(map[uuid.UUID]map[string]interface{}, len(ids))
+	for rows.Next() {
+		var id uuid.UUID
+		var fixedAttrs map[string]interface{}
+		if err := rows.Scan(&id, &fixedAttrs); err != nil {
+			return nil, err
+		}
+		attrs[id] = fixedAttrs
+	}
+	return attrs, nil
+}
+
+func (r *masterYarnRepo) FindSimilarByName(ctx context.Context, name string, limit int) ([]*entity.MasterYarn, error) {
+	query := `
+		SELECT id, code, name, description, fixed_attrs, is_active, created_at, updated_at
+		FROM master_yarns
+		WHERE is_active = true AND lower(name) % lower($1)
+		ORDER BY similarity(lower(name), lower($1)) DESC
+		LIMIT $2
+	`
+	rows, err := r.pool.Query(ctx, query, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var yarns []*entity.MasterYarn
+	for rows.Next() {
+		var yarn entity.MasterYarn
+		if err := rows.Scan(&yarn.ID, &yarn.Code, &yarn.Name, &yarn.Description, &yarn.FixedAttrs, &yarn.IsActive, &yarn.CreatedAt, &yarn.UpdatedAt); err != nil {
+			return nil, err
+		}
+		yarns = append(yarns, &yarn)
+	}
+	return yarns, nil
 }
@@ -0,0 +1,79 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgreSQL NOTIFY channels shared by the API and worker processes
+const (
+	// CacheInvalidationChannel carries costing cache invalidation events
+	CacheInvalidationChannel = "costing_cache_invalidate"
+	// JobQueueChannel carries the IDs of newly queued batch jobs
+	JobQueueChannel = "costing_job_queued"
+	// SummaryInvalidationChannel carries the variant IDs of written cost summaries
+	SummaryInvalidationChannel = "costing_summary_invalidate"
+)
+
+// NotifyChannel publishes and receives events on one PostgreSQL LISTEN/NOTIFY channel
+type NotifyChannel struct {
+	pool *pgxpool.Pool
+	name string
+}
+
+// NewCacheEvents creates the channel over which every API and worker process drops
+// its cache when formulas or rates change
+func NewCacheEvents(pool *pgxpool.Pool) *NotifyChannel {
+	return &NotifyChannel{pool: pool, name: CacheInvalidationChannel}
+}
+
+// NewJobEvents creates the channel that wakes workers when a job is queued
+func NewJobEvents(pool *pgxpool.Pool) *NotifyChannel {
+	return &NotifyChannel{pool: pool, name: JobQueueChannel}
+}
+
+// NewSummaryEvents creates the channel over which processes caching cost summaries drop
+// the ones another process wrote
+func NewSummaryEvents(pool *pgxpool.Pool) *NotifyChannel {
+	return &NotifyChannel{pool: pool, name: SummaryInvalidationChannel}
+}
+
+// Notify broadcasts an event with the given payload
+func (c *NotifyChannel) Notify(ctx context.Context, payload string) error {
+	if _, err := c.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, c.name, payload); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", c.name, err)
+	}
+	return nil
+}
+
+// NotifyAll broadcasts an event per payload in one round trip
+func (c *NotifyChannel) NotifyAll(ctx context.Context, payloads []string) error {
+	if _, err := c.pool.Exec(ctx, `SELECT pg_notify($1, p) FROM unnest($2::text[]) AS p`, c.name, payloads); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", c.name, err)
+	}
+	return nil
+}
+
+// Listen holds a dedicated connection and calls handle with the payload of every
+// event until ctx is done or the connection fails
+func (c *NotifyChannel) Listen(ctx context.Context, handle func(payload string)) error {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire listener connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+c.name); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", c.name, err)
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
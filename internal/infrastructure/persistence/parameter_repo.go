@@ -0,0 +1,102 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// masterParameterRepo implements repository.MasterParameterRepository
+type masterParameterRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewMasterParameterRepository creates a new master parameter repository
+func NewMasterParameterRepository(pool *pgxpool.Pool) repository.MasterParameterRepository {
+	return &masterParameterRepo{pool: pool}
+}
+
+func (r *masterParameterRepo) List(ctx context.Context) ([]*entity.MasterParameter, error) {
+	query := `
+		SELECT key, label, data_type, COALESCE(default_value, ''), COALESCE(group_code, ''), COALESCE(unit, ''),
+			COALESCE(is_required, false), COALESCE(sequence_order, 0), created_at
+		FROM master_parameters ORDER BY sequence_order, key
+	`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var params []*entity.MasterParameter
+	for rows.Next() {
+		var p entity.MasterParameter
+		if err := rows.Scan(&p.Key, &p.Label, &p.DataType, &p.DefaultValue, &p.GroupCode, &p.Unit, &p.IsRequired, &p.SequenceOrder, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		params = append(params, &p)
+	}
+	return params, nil
+}
+
+func (r *masterParameterRepo) Units(ctx context.Context) (map[string]string, error) {
+	rows, err := r.pool.Query(ctx, "SELECT key, unit FROM master_parameters WHERE unit IS NOT NULL AND unit <> ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	units := make(map[string]string)
+	for rows.Next() {
+		var key, unit string
+		if err := rows.Scan(&key, &unit); err != nil {
+			return nil, err
+		}
+		units[key] = unit
+	}
+	return units, nil
+}
+
+func (r *masterParameterRepo) GroupCodes(ctx context.Context) ([]string, error) {
+	rows, err := r.pool.Query(ctx, "SELECT code FROM parameter_groups ORDER BY code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+func (r *masterParameterRepo) CreateBatch(ctx context.Context, params []*entity.MasterParameter) (int64, error) {
+	columns := []string{"key", "label", "data_type", "default_value", "group_code", "unit", "is_required", "sequence_order", "created_at"}
+	rows := make([][]interface{}, len(params))
+	for i, p := range params {
+		rows[i] = []interface{}{p.Key, p.Label, p.DataType, optionalText(p.DefaultValue), optionalText(p.GroupCode), optionalText(p.Unit), p.IsRequired, p.SequenceOrder, p.CreatedAt}
+	}
+	count, err := r.pool.CopyFrom(ctx, pgx.Identifier{"master_parameters"}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy master parameters: %w", err)
+	}
+	return count, nil
+}
+
+// optionalText stores an empty string as NULL, as List reads NULL back as ""
+func optionalText(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,268 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// priceRateRepo implements repository.PriceRateRepository
+type priceRateRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewPriceRateRepository creates a new price rate repository
+func NewPriceRateRepository(pool *pgxpool.Pool) repository.PriceRateRepository {
+	return &priceRateRepo{pool: pool}
+}
+
+const priceRateColumns = `id, parameter_key, COALESCE(rate_value, 0), COALESCE(rate_expression, ''), effective_date, expired_date, COALESCE(notes, ''), created_at`
+
+func scanPriceRate(row pgx.Row) (*entity.PriceRate, error) {
+	var rate entity.PriceRate
+	err := row.Scan(&rate.ID, &rate.ParameterKey, &rate.RateValue, &rate.RateExpression, &rate.EffectiveDate, &rate.ExpiredDate, &rate.Notes, &rate.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (r *priceRateRepo) GetCurrentRate(ctx context.Context, parameterKey string) (*entity.PriceRate, error) {
+	query := `
+		SELECT ` + priceRateColumns + `
+		FROM price_rates
+		WHERE parameter_key = $1
+		  AND effective_date <= CURRENT_DATE
+		  AND (expired_date IS NULL OR expired_date > CURRENT_DATE)
+		ORDER BY effective_date DESC
+		LIMIT 1
+	`
+	return scanPriceRate(r.pool.QueryRow(ctx, query, parameterKey))
+}
+
+// GetRateAsOf matches the range the overlap constraint indexes, so the lookup is an index
+// probe; ranges do not overlap, so at most one rate is in effect
+func (r *priceRateRepo) GetRateAsOf(ctx context.Context, parameterKey string, date time.Time) (*entity.PriceRate, error) {
+	query := `
+		SELECT ` + priceRateColumns + `
+		FROM price_rates
+		WHERE parameter_key = $1 AND daterange(effective_date, expired_date) @> $2::date
+	`
+	return scanPriceRate(r.pool.QueryRow(ctx, query, parameterKey, date))
+}
+
+func (r *priceRateRepo) History(ctx context.Context, parameterKey string) ([]*entity.PriceRate, error) {
+	query := `SELECT ` + priceRateColumns + ` FROM price_rates WHERE parameter_key = $1 ORDER BY effective_date DESC`
+	rows, err := r.pool.Query(ctx, query, parameterKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []*entity.PriceRate
+	for rows.Next() {
+		rate, err := scanPriceRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+func (r *priceRateRepo) GetAllCurrentRates(ctx context.Context) (map[string]float64, error) {
+	query := `
+		SELECT parameter_key, rate_value FROM (
+			SELECT DISTINCT ON (parameter_key) parameter_key, rate_value
+			FROM price_rates
+			WHERE effective_date <= CURRENT_DATE
+			  AND (expired_date IS NULL OR expired_date > CURRENT_DATE)
+			ORDER BY parameter_key, effective_date DESC
+		) current
+		WHERE rate_value IS NOT NULL
+	`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[string]float64)
+	for rows.Next() {
+		var key string
+		var value float64
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		rates[key] = value
+	}
+	return rates, nil
+}
+
+func (r *priceRateRepo) ListCurrent(ctx context.Context) ([]*entity.PriceRate, error) {
+	query := `
+		SELECT DISTINCT ON (parameter_key) id, parameter_key, COALESCE(rate_value, 0), COALESCE(rate_expression, ''),
+			effective_date, expired_date, COALESCE(notes, ''), created_at
+		FROM price_rates
+		WHERE effective_date <= CURRENT_DATE
+		  AND (expired_date IS NULL OR expired_date > CURRENT_DATE)
+		ORDER BY parameter_key, effective_date DESC
+	`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []*entity.PriceRate
+	for rows.Next() {
+		var rate entity.PriceRate
+		if err := rows.Scan(&rate.ID, &rate.ParameterKey, &rate.RateValue, &rate.RateExpression,
+			&rate.EffectiveDate, &rate.ExpiredDate, &rate.Notes, &rate.CreatedAt); err != nil {
+			return nil, err
+		}
+		rates = append(rates, &rate)
+	}
+	return rates, nil
+}
+
+func (r *priceRateRepo) Create(ctx context.Context, rate *entity.PriceRate) error {
+	query := `
+		INSERT INTO price_rates (id, parameter_key, rate_value, rate_expression, effective_date, expired_date, notes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	value, expression := rateColumns(rate)
+	log := &entity.AuditLog{EntityType: entity.AuditEntityPriceRate, EntityID: rate.ID, Action: entity.AuditActionCreate}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		expired, err := fitRates(ctx, tx, []*entity.PriceRate{rate})
+		if err != nil {
+			return err
+		}
+		for _, entry := range expired {
+			if err := insertAuditLog(ctx, tx, entry); err != nil {
+				return fmt.Errorf("failed to write audit log: %w", err)
+			}
+		}
+		log.Changes = entity.AuditDiff(nil, rate)
+		_, err = tx.Exec(ctx, query,
+			rate.ID, rate.ParameterKey, value, expression, rate.EffectiveDate, rate.ExpiredDate, rate.Notes, rate.CreatedAt)
+		return rateOverlapError(err)
+	})
+}
+
+func (r *priceRateRepo) CreateBatch(ctx context.Context, rates []*entity.PriceRate) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := fitRates(ctx, tx, rates); err != nil {
+		return 0, err
+	}
+	columns := []string{"id", "parameter_key", "rate_value", "rate_expression", "effective_date", "expired_date", "notes", "created_at"}
+	rows := make([][]interface{}, len(rates))
+	for i, rate := range rates {
+		value, expression := rateColumns(rate)
+		rows[i] = []interface{}{rate.ID, rate.ParameterKey, value, expression, rate.EffectiveDate, rate.ExpiredDate, rate.Notes, rate.CreatedAt}
+	}
+	count, err := tx.CopyFrom(ctx, pgx.Identifier{"price_rates"}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, rateOverlapError(err)
+	}
+	return count, tx.Commit(ctx)
+}
+
+// fitRates fits new rates into each parameter's timeline before they are inserted. An
+// open-ended new rate expires when the next new or existing rate of its parameter takes
+// effect, and the open-ended existing rate taking effect before the first new one expires
+// when that takes effect. Returns audit entries for the existing rates it expired.
+func fitRates(ctx context.Context, tx pgx.Tx, rates []*entity.PriceRate) ([]*entity.AuditLog, error) {
+	byKey := make(map[string][]*entity.PriceRate)
+	var keys []string
+	for _, rate := range rates {
+		if _, ok := byKey[rate.ParameterKey]; !ok {
+			keys = append(keys, rate.ParameterKey)
+		}
+		byKey[rate.ParameterKey] = append(byKey[rate.ParameterKey], rate)
+	}
+
+	var expired []*entity.AuditLog
+	for _, key := range keys {
+		timeline := byKey[key]
+		sort.Slice(timeline, func(i, j int) bool { return timeline[i].EffectiveDate.Before(timeline[j].EffectiveDate) })
+		for i, rate := range timeline[:len(timeline)-1] {
+			if rate.ExpiredDate == nil {
+				next := timeline[i+1].EffectiveDate
+				rate.ExpiredDate = &next
+			}
+		}
+
+		last := timeline[len(timeline)-1]
+		if last.ExpiredDate == nil {
+			err := tx.QueryRow(ctx, `
+				SELECT MIN(effective_date) FROM price_rates WHERE parameter_key = $1 AND effective_date > $2
+			`, key, last.EffectiveDate).Scan(&last.ExpiredDate)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		first := timeline[0].EffectiveDate
+		rows, err := tx.Query(ctx, `
+			UPDATE price_rates SET expired_date = $2
+			WHERE parameter_key = $1 AND expired_date IS NULL AND effective_date < $2
+			RETURNING id
+		`, key, first)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			expired = append(expired, &entity.AuditLog{
+				EntityType: entity.AuditEntityPriceRate,
+				EntityID:   id,
+				Action:     entity.AuditActionUpdate,
+				Actor:      repository.ActorFrom(ctx),
+				Changes:    map[string]entity.AuditChange{"expired_date": {Before: nil, After: first}},
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// rateOverlapError marks a violation of the overlap constraint as ErrRateOverlap, keeping
+// the database error for callers reporting it
+func rateOverlapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23P01" && pgErr.ConstraintName == "excl_price_rates_overlap" {
+		return fmt.Errorf("%w: %w", repository.ErrRateOverlap, err)
+	}
+	return err
+}
+
+// rateColumns returns the rate_value and rate_expression column values; exactly one is non-NULL
+func rateColumns(rate *entity.PriceRate) (value, expression interface{}) {
+	if rate.RateExpression != "" {
+		return nil, rate.RateExpression
+	}
+	return rate.RateValue, nil
+}
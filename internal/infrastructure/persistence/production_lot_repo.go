@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// productionLotRepo implements repository.ProductionLotRepository
+type productionLotRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewProductionLotRepository creates a new production lot repository
+func NewProductionLotRepository(pool *pgxpool.Pool) repository.ProductionLotRepository {
+	return &productionLotRepo{pool: pool}
+}
+
+const productionLotColumns = `id, yarn_variant_id, batch_no, production_date, quantity, status, actual_values, standard_cost, actual_cost, costed_at, created_at, updated_at`
+
+func scanProductionLot(row pgx.Row) (*entity.ProductionLot, error) {
+	var lot entity.ProductionLot
+	err := row.Scan(&lot.ID, &lot.YarnVariantID, &lot.BatchNo, &lot.ProductionDate, &lot.Quantity, &lot.Status,
+		&lot.ActualValues, &lot.StandardCost, &lot.ActualCost, &lot.CostedAt, &lot.CreatedAt, &lot.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+func (r *productionLotRepo) Create(ctx context.Context, lot *entity.ProductionLot) error {
+	query := `
+		INSERT INTO production_lots (id, yarn_variant_id, batch_no, production_date, quantity, status, actual_values, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	actualValues, _ := json.Marshal(lot.ActualValues)
+	_, err := r.pool.Exec(ctx, query,
+		lot.ID, lot.YarnVariantID, lot.BatchNo, lot.ProductionDate, lot.Quantity, lot.Status, actualValues, lot.CreatedAt, lot.UpdatedAt)
+	return err
+}
+
+func (r *productionLotRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.ProductionLot, error) {
+	query := `SELECT ` + productionLotColumns + ` FROM production_lots WHERE id = $1`
+	return scanProductionLot(r.pool.QueryRow(ctx, query, id))
+}
+
+func (r *productionLotRepo) ListByVariantID(ctx context.Context, variantID uuid.UUID) ([]*entity.ProductionLot, error) {
+	query := `SELECT ` + productionLotColumns + ` FROM production_lots WHERE yarn_variant_id = $1 ORDER BY production_date DESC, created_at DESC`
+	rows, err := r.pool.Query(ctx, query, variantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []*entity.ProductionLot
+	for rows.Next() {
+		lot, err := scanProductionLot(rows)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, nil
+}
+
+func (r *productionLotRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status entity.LotStatus) error {
+	tag, err := r.pool.Exec(ctx, "UPDATE production_lots SET status = $2 WHERE id = $1", id, status)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *productionLotRepo) UpdateCosts(ctx context.Context, id uuid.UUID, actualValues map[string]interface{}, standardCost, actualCost float64) error {
+	query := `
+		UPDATE production_lots SET actual_values = $2, standard_cost = $3, actual_cost = $4, costed_at = NOW()
+		WHERE id = $1
+	`
+	values, _ := json.Marshal(actualValues)
+	tag, err := r.pool.Exec(ctx, query, id, values, standardCost, actualCost)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *productionLotRepo) CostReportByMaster(ctx context.Context, masterID uuid.UUID) ([]*entity.LotCostLine, error) {
+	query := `
+		SELECT l.id, l.yarn_variant_id, l.batch_no, l.production_date, l.quantity, l.status, l.actual_values,
+			l.standard_cost, l.actual_cost, l.costed_at, l.created_at, l.updated_at, v.sku, m.code
+		FROM production_lots l
+		JOIN yarn_variants v ON v.id = l.yarn_variant_id
+		JOIN master_yarns m ON m.id = v.master_yarn_id
+		WHERE m.id = $1
+		ORDER BY l.production_date DESC, v.sku
+	`
+	rows, err := r.pool.Query(ctx, query, masterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*entity.LotCostLine
+	for rows.Next() {
+		var lot entity.ProductionLot
+		var sku, masterCode string
+		if err := rows.Scan(&lot.ID, &lot.YarnVariantID, &lot.BatchNo, &lot.ProductionDate, &lot.Quantity, &lot.Status,
+			&lot.ActualValues, &lot.StandardCost, &lot.ActualCost, &lot.CostedAt, &lot.CreatedAt, &lot.UpdatedAt, &sku, &masterCode); err != nil {
+			return nil, err
+		}
+		lines = append(lines, entity.NewLotCostLine(&lot, sku, masterCode))
+	}
+	return lines, nil
+}
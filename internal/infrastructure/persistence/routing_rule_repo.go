@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// routingRuleRepo implements repository.RoutingRuleRepository
+type routingRuleRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewRoutingRuleRepository creates a new routing rule repository
+func NewRoutingRuleRepository(pool *pgxpool.Pool) repository.RoutingRuleRepository {
+	return &routingRuleRepo{pool: pool}
+}
+
+func (r *routingRuleRepo) ListActive(ctx context.Context) ([]*entity.RoutingRule, error) {
+	query := `
+		SELECT id, COALESCE(fiber_type, ''), COALESCE(grade, ''), routing_template_id, priority, is_active, created_at
+		FROM routing_rules WHERE is_active = true ORDER BY priority, created_at
+	`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*entity.RoutingRule
+	for rows.Next() {
+		var rule entity.RoutingRule
+		if err := rows.Scan(&rule.ID, &rule.FiberType, &rule.Grade, &rule.RoutingTemplateID, &rule.Priority, &rule.IsActive, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, nil
+}
+
+func (r *routingRuleRepo) Create(ctx context.Context, rule *entity.RoutingRule) error {
+	query := `
+		INSERT INTO routing_rules (id, fiber_type, grade, routing_template_id, priority, is_active, created_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $7)
+	`
+	log := &entity.AuditLog{EntityType: entity.AuditEntityRoutingRule, EntityID: rule.ID, Action: entity.AuditActionCreate, Changes: entity.AuditDiff(nil, rule)}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			rule.ID, rule.FiberType, rule.Grade, rule.RoutingTemplateID, rule.Priority, rule.IsActive, rule.CreatedAt)
+		return err
+	})
+}
+
+func (r *routingRuleRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `
+		DELETE FROM routing_rules WHERE id = $1
+		RETURNING id, COALESCE(fiber_type, ''), COALESCE(grade, ''), routing_template_id, priority, is_active, created_at
+	`
+	log := &entity.AuditLog{EntityType: entity.AuditEntityRoutingRule, EntityID: id, Action: entity.AuditActionDelete}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		var rule entity.RoutingRule
+		err := tx.QueryRow(ctx, query, id).Scan(&rule.ID, &rule.FiberType, &rule.Grade, &rule.RoutingTemplateID, &rule.Priority, &rule.IsActive, &rule.CreatedAt)
+		if err != nil {
+			return err
+		}
+		log.Changes = entity.AuditDiff(&rule, nil)
+		return nil
+	})
+}
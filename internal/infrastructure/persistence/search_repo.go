@@ -0,0 +1,66 @@
+package persistence
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// searchRepo implements repository.SearchRepository over the search_vector columns of
+// master_yarns and yarn_variants
+type searchRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewSearchRepository creates a new search repository
+func NewSearchRepository(pool *pgxpool.Pool) repository.SearchRepository {
+	return &searchRepo{pool: pool}
+}
+
+// Each branch is ranked and limited on its own, so a common word matching millions of
+// variants does not sort them all against the masters.
+func (r *searchRepo) Search(ctx context.Context, query string, limit int) ([]*entity.SearchHit, error) {
+	sql := `
+		WITH q AS (SELECT websearch_to_tsquery('simple', $1) AS query)
+		SELECT type, id, code, name, master_yarn_id, rank FROM (
+			(SELECT $3::text AS type, m.id, m.code, m.name, NULL::uuid AS master_yarn_id,
+				ts_rank(m.search_vector, q.query)::float8 AS rank
+			FROM master_yarns m, q
+			WHERE m.search_vector @@ q.query
+			ORDER BY rank DESC
+			LIMIT $2)
+			UNION ALL
+			(SELECT $4::text, v.id, v.sku, m.name, v.master_yarn_id,
+				ts_rank(v.search_vector, q.query)::float8 AS rank
+			FROM yarn_variants v
+			JOIN master_yarns m ON m.id = v.master_yarn_id, q
+			WHERE v.search_vector @@ q.query
+			ORDER BY rank DESC
+			LIMIT $2)
+		) hits
+		ORDER BY rank DESC, code
+	`
+	rows, err := r.pool.Query(ctx, sql, query, limit, entity.SearchHitMasterYarn, entity.SearchHitYarnVariant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []*entity.SearchHit
+	for rows.Next() {
+		var hit entity.SearchHit
+		var masterYarnID *uuid.UUID
+		if err := rows.Scan(&hit.Type, &hit.ID, &hit.Code, &hit.Name, &masterYarnID, &hit.Rank); err != nil {
+			return nil, err
+		}
+		if masterYarnID != nil {
+			hit.MasterYarnID = *masterYarnID
+		}
+		hits = append(hits, &hit)
+	}
+	return hits, rows.Err()
+}
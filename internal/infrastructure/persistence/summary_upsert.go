@@ -0,0 +1,100 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Summary upsert modes, choosing the statement UpsertBatch moves a batch from its temp
+// table into variant_cost_summaries with. Both load the temp table with binary COPY.
+const (
+	SummaryUpsertAuto   = "auto"   // merge when the server supports it, insert otherwise
+	SummaryUpsertInsert = "insert" // INSERT ... ON CONFLICT DO UPDATE
+	SummaryUpsertMerge  = "merge"  // MERGE, PostgreSQL 15 or later
+)
+
+// mergeMinServerVersion is the first server_version_num with MERGE
+const mergeMinServerVersion = 150000
+
+// ResolveSummaryUpsertMode turns a configured mode into the one UpsertBatch uses, detecting
+// MERGE support for auto. Merge on a server without MERGE is an error rather than a
+// silent fallback.
+func ResolveSummaryUpsertMode(ctx context.Context, pool *pgxpool.Pool, mode string) (string, error) {
+	switch mode {
+	case SummaryUpsertInsert:
+		return mode, nil
+	case SummaryUpsertAuto, SummaryUpsertMerge:
+	default:
+		return "", fmt.Errorf("unknown summary upsert mode %q", mode)
+	}
+
+	version, err := ServerVersion(ctx, pool)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect server version: %w", err)
+	}
+	switch {
+	case version >= mergeMinServerVersion:
+		return SummaryUpsertMerge, nil
+	case mode == SummaryUpsertMerge:
+		return "", fmt.Errorf("summary upsert mode merge requires PostgreSQL 15 or later, server is %d", version)
+	default:
+		return SummaryUpsertInsert, nil
+	}
+}
+
+// ServerVersion returns the server's server_version_num, such as 150004 for 15.4
+func ServerVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	var version int
+	err := pool.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version)
+	return version, err
+}
+
+// summaryUpsertStatements are the statements moving a batch from its temp table, named
+// by %s, into variant_cost_summaries, by mode. A summary whose version hash is unchanged
+// is left as it is rather than rewritten with equal values, so a no-op recalculation
+// writes no WAL and leaves no dead tuples; its last_recalculated_at stays at the last
+// change.
+var summaryUpsertStatements = map[string]string{
+	SummaryUpsertInsert: `
+		INSERT INTO variant_cost_summaries (yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash)
+		SELECT yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash FROM %s
+		ON CONFLICT (yarn_variant_id) DO UPDATE SET
+			total_material_cost = EXCLUDED.total_material_cost,
+			total_process_cost = EXCLUDED.total_process_cost,
+			total_overhead = EXCLUDED.total_overhead,
+			grand_total = EXCLUDED.grand_total,
+			category_breakdown = EXCLUDED.category_breakdown,
+			last_recalculated_at = EXCLUDED.last_recalculated_at,
+			version_hash = EXCLUDED.version_hash
+		WHERE variant_cost_summaries.version_hash IS DISTINCT FROM EXCLUDED.version_hash
+	`,
+	// MERGE joins the batch against existing summaries once instead of attempting a
+	// speculative insert per row, which is the cheaper path when nearly every variant
+	// already has a summary
+	SummaryUpsertMerge: `
+		MERGE INTO variant_cost_summaries s
+		USING %s t ON s.yarn_variant_id = t.yarn_variant_id
+		WHEN MATCHED AND s.version_hash IS DISTINCT FROM t.version_hash THEN UPDATE SET
+			total_material_cost = t.total_material_cost,
+			total_process_cost = t.total_process_cost,
+			total_overhead = t.total_overhead,
+			grand_total = t.grand_total,
+			category_breakdown = t.category_breakdown,
+			last_recalculated_at = t.last_recalculated_at,
+			version_hash = t.version_hash
+		WHEN NOT MATCHED THEN
+			INSERT (yarn_variant_id, total_material_cost, total_process_cost, total_overhead, grand_total, category_breakdown, last_recalculated_at, version_hash)
+			VALUES (t.yarn_variant_id, t.total_material_cost, t.total_process_cost, t.total_overhead, t.grand_total, t.category_breakdown, t.last_recalculated_at, t.version_hash)
+	`,
+}
+
+// isSummaryKeyViolation reports whether err is a duplicate summary for a variant, which
+// MERGE raises when another transaction inserts the same variant's summary concurrently
+func isSummaryKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "variant_cost_summaries_pkey"
+}
@@ -0,0 +1,104 @@
+package persistence
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// variantDeadLetterRepo implements repository.VariantDeadLetterRepository
+type variantDeadLetterRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewVariantDeadLetterRepository creates a new variant dead letter repository
+func NewVariantDeadLetterRepository(pool *pgxpool.Pool) repository.VariantDeadLetterRepository {
+	return &variantDeadLetterRepo{pool: pool}
+}
+
+func (r *variantDeadLetterRepo) RecordFailures(ctx context.Context, jobID uuid.UUID, variantIDs []uuid.UUID, errs []string, deadLetterAfter int) error {
+	query := `
+		INSERT INTO variant_dead_letters AS d (variant_id, failure_count, last_error, last_job_id, dead_lettered_at)
+		SELECT f.variant_id, 1, f.error, $3, CASE WHEN $4 <= 1 THEN NOW() END
+		FROM unnest($1::uuid[], $2::text[]) AS f(variant_id, error)
+		ON CONFLICT (variant_id) DO UPDATE
+		SET failure_count = d.failure_count + 1, last_error = EXCLUDED.last_error, last_job_id = EXCLUDED.last_job_id,
+		    last_failed_at = NOW(),
+		    dead_lettered_at = COALESCE(d.dead_lettered_at, CASE WHEN d.failure_count + 1 >= $4 THEN NOW() END)
+	`
+	_, err := r.pool.Exec(ctx, query, variantIDs, errs, jobID, deadLetterAfter)
+	return err
+}
+
+func (r *variantDeadLetterRepo) ListFailingIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, "SELECT variant_id FROM variant_dead_letters WHERE dead_lettered_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (r *variantDeadLetterRepo) ClearFailures(ctx context.Context, variantIDs []uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, "DELETE FROM variant_dead_letters WHERE variant_id = ANY($1) AND dead_lettered_at IS NULL", variantIDs)
+	return err
+}
+
+func (r *variantDeadLetterRepo) List(ctx context.Context, limit, offset int) ([]*entity.VariantDeadLetter, error) {
+	query := `
+		SELECT d.variant_id, COALESCE(v.sku, ''), d.failure_count, d.last_error, d.last_job_id,
+		       d.first_failed_at, d.last_failed_at, d.dead_lettered_at
+		FROM variant_dead_letters d
+		LEFT JOIN yarn_variants v ON v.id = d.variant_id
+		WHERE d.dead_lettered_at IS NOT NULL
+		ORDER BY d.dead_lettered_at DESC
+		LIMIT $1 OFFSET $2
+	`
+	rows, err := r.pool.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []*entity.VariantDeadLetter
+	for rows.Next() {
+		var d entity.VariantDeadLetter
+		if err := rows.Scan(&d.VariantID, &d.SKU, &d.FailureCount, &d.LastError, &d.LastJobID,
+			&d.FirstFailedAt, &d.LastFailedAt, &d.DeadLetteredAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, &d)
+	}
+	return letters, nil
+}
+
+func (r *variantDeadLetterRepo) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.pool.QueryRow(ctx, "SELECT COUNT(*) FROM variant_dead_letters WHERE dead_lettered_at IS NOT NULL").Scan(&count)
+	return count, err
+}
+
+func (r *variantDeadLetterRepo) Requeue(ctx context.Context, variantID uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, "DELETE FROM variant_dead_letters WHERE variant_id = $1 AND dead_lettered_at IS NOT NULL", variantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// webhookRepo implements repository.WebhookRepository
+type webhookRepo struct {
+	pool *pgxpool.Pool
+}
+
+// NewWebhookRepository creates a new webhook repository
+func NewWebhookRepository(pool *pgxpool.Pool) repository.WebhookRepository {
+	return &webhookRepo{pool: pool}
+}
+
+const subscriptionColumns = `id, url, secret, event_types, is_active, created_at`
+
+const deliveryColumns = `id, subscription_id, job_id, event_type, status, attempts, next_attempt_at, response_status,
+	COALESCE(last_error, ''), created_at, delivered_at`
+
+func scanSubscription(row pgx.Row) (*entity.WebhookSubscription, error) {
+	var s entity.WebhookSubscription
+	if err := row.Scan(&s.ID, &s.URL, &s.Secret, &s.EventTypes, &s.IsActive, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func scanDelivery(row pgx.Row) (*entity.WebhookDelivery, error) {
+	var d entity.WebhookDelivery
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.JobID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *webhookRepo) CreateSubscription(ctx context.Context, sub *entity.WebhookSubscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (id, url, secret, event_types, is_active, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.pool.Exec(ctx, query, sub.ID, sub.URL, sub.Secret, sub.EventTypes, sub.IsActive, sub.CreatedAt)
+	return err
+}
+
+func (r *webhookRepo) GetSubscription(ctx context.Context, id uuid.UUID) (*entity.WebhookSubscription, error) {
+	query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+	return scanSubscription(r.pool.QueryRow(ctx, query, id))
+}
+
+func (r *webhookRepo) ListSubscriptions(ctx context.Context) ([]*entity.WebhookSubscription, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*entity.WebhookSubscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, nil
+}
+
+func (r *webhookRepo) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *webhookRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*entity.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns
+	rows, err := r.pool.Query(ctx, query, entity.WebhookDeliveryPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*entity.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+func (r *webhookRepo) MarkDelivered(ctx context.Context, id uuid.UUID, responseStatus int) error {
+	query := `
+		UPDATE webhook_deliveries SET status = $2, response_status = $3, last_error = NULL, delivered_at = NOW()
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, entity.WebhookDeliveryDelivered, responseStatus)
+	return err
+}
+
+func (r *webhookRepo) MarkFailed(ctx context.Context, id uuid.UUID, responseStatus *int, errorMsg string, nextAttemptAt *time.Time) error {
+	status := entity.WebhookDeliveryPending
+	if nextAttemptAt == nil {
+		status = entity.WebhookDeliveryFailed
+	}
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, response_status = $3, last_error = $4, next_attempt_at = COALESCE($5, next_attempt_at)
+		WHERE id = $1
+	`
+	_, err := r.pool.Exec(ctx, query, id, status, responseStatus, errorMsg, nextAttemptAt)
+	return err
+}
+
+func (r *webhookRepo) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit int) ([]*entity.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE subscription_id = $1 ORDER BY created_at DESC LIMIT $2`
+	rows, err := r.pool.Query(ctx, query, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*entity.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
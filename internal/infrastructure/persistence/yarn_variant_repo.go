@@ -27,15 +27,18 @@ func (r *yarnVariantRepo) Create(ctx context.Context, variant *entity.YarnVarian
 		INSERT INTO yarn_variants (id, master_yarn_id, sku, batch_no, routing_template_id, is_active, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
-	_, err := r.pool.Exec(ctx, query,
-		variant.ID, variant.MasterYarnID, variant.SKU, variant.BatchNo, variant.RoutingTemplateID, variant.IsActive, variant.CreatedAt, variant.UpdatedAt)
-	return err
+	log := &entity.AuditLog{EntityType: entity.AuditEntityYarnVariant, EntityID: variant.ID, Action: entity.AuditActionCreate, Changes: entity.AuditDiff(nil, variant)}
+	return audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			variant.ID, variant.MasterYarnID, variant.SKU, variant.BatchNo, variant.RoutingTemplateID, variant.IsActive, variant.CreatedAt, variant.UpdatedAt)
+		return err
+	})
 }
 
-// CreateBatch uses PostgreSQL COPY protocol for high-performance bulk inserts
-func (r *yarnVariantRepo) CreateBatch(ctx context.Context, variants []*entity.YarnVariant) (int64, error) {
-	columns := []string{"id", "master_yarn_id", "sku", "batch_no", "routing_template_id", "is_active", "created_at", "updated_at"}
+// variantCopyColumns are the columns CreateBatch and CreateBatchSkipExisting copy
+var variantCopyColumns = []string{"id", "master_yarn_id", "sku", "batch_no", "routing_template_id", "is_active", "created_at", "updated_at"}
 
+func variantCopyRows(variants []*entity.YarnVariant) [][]interface{} {
 	rows := make([][]interface{}, len(variants))
 	for i, v := range variants {
 		var routingID interface{}
@@ -46,12 +49,16 @@ func (r *yarnVariantRepo) CreateBatch(ctx context.Context, variants []*entity.Ya
 			v.ID, v.MasterYarnID, v.SKU, v.BatchNo, routingID, v.IsActive, v.CreatedAt, v.UpdatedAt,
 		}
 	}
+	return rows
+}
 
+// CreateBatch uses PostgreSQL COPY protocol for high-performance bulk inserts
+func (r *yarnVariantRepo) CreateBatch(ctx context.Context, variants []*entity.YarnVariant) (int64, error) {
 	copyCount, err := r.pool.CopyFrom(
 		ctx,
 		pgx.Identifier{"yarn_variants"},
-		columns,
-		pgx.CopyFromRows(rows),
+		variantCopyColumns,
+		pgx.CopyFromRows(variantCopyRows(variants)),
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to copy yarn variants: %w", err)
@@ -60,6 +67,14 @@ func (r *yarnVariantRepo) CreateBatch(ctx context.Context, variants []*entity.Ya
 	return copyCount, nil
 }
 
+func (r *yarnVariantRepo) CreateBatchSkipExisting(ctx context.Context, variants []*entity.YarnVariant) (int64, error) {
+	created, err := copySkipExisting(ctx, r.pool, "yarn_variants", variantCopyColumns, variantCopyRows(variants))
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy yarn variants: %w", err)
+	}
+	return created, nil
+}
+
 func (r *yarnVariantRepo) GetByID(ctx context.Context, id uuid.UUID) (*entity.YarnVariant, error) {
 	query := `
 		SELECT id, master_yarn_id, sku, batch_no, routing_template_id, is_active, created_at, updated_at
@@ -110,6 +125,52 @@ func (r *yarnVariantRepo) ListByMasterID(ctx context.Context, masterID uuid.UUID
 	return variants, nil
 }
 
+// yarnVariantSearch is what variant searches filter on
+var yarnVariantSearch = &searchTable{
+	from: `SELECT id, master_yarn_id, sku, batch_no, routing_template_id, is_active, created_at, updated_at FROM yarn_variants`,
+	key:  "id",
+	columns: map[string]filterColumn{
+		"id":                  {"id", "uuid"},
+		"master_yarn_id":      {"master_yarn_id", "uuid"},
+		"sku":                 {"sku", "text"},
+		"batch_no":            {"batch_no", "text"},
+		"routing_template_id": {"routing_template_id", "uuid"},
+		"is_active":           {"is_active", "boolean"},
+		"created_at":          {"created_at", "timestamptz"},
+		"updated_at":          {"updated_at", "timestamptz"},
+	},
+}
+
+func (r *yarnVariantRepo) ListAfter(ctx context.Context, masterID uuid.UUID, cursor entity.Cursor, limit int) ([]*entity.YarnVariant, error) {
+	var filters []entity.Filter
+	if masterID != uuid.Nil {
+		filters = append(filters, entity.Filter{Field: "master_yarn_id", Op: entity.FilterEq, Value: masterID.String()})
+	}
+	return r.Search(ctx, filters, cursor, limit)
+}
+
+func (r *yarnVariantRepo) Search(ctx context.Context, filters []entity.Filter, cursor entity.Cursor, limit int) ([]*entity.YarnVariant, error) {
+	query, args, err := yarnVariantSearch.query(filters, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []*entity.YarnVariant
+	for rows.Next() {
+		var v entity.YarnVariant
+		if err := rows.Scan(&v.ID, &v.MasterYarnID, &v.SKU, &v.BatchNo, &v.RoutingTemplateID, &v.IsActive, &v.CreatedAt, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		variants = append(variants, &v)
+	}
+	return variants, rows.Err()
+}
+
 // ListIDs retrieves variant IDs in batches for worker processing
 func (r *yarnVariantRepo) ListIDs(ctx context.Context, limit, offset int) ([]uuid.UUID, error) {
 	query := `SELECT id FROM yarn_variants WHERE is_active = true ORDER BY id LIMIT $1 OFFSET $2`
@@ -130,24 +191,43 @@ func (r *yarnVariantRepo) ListIDs(ctx context.Context, limit, offset int) ([]uui
 	return ids, nil
 }
 
-// ListWithRouting retrieves variants with routing IDs (optimized - only fetches id and routing_template_id)
-func (r *yarnVariantRepo) ListWithRouting(ctx context.Context, limit, offset int) ([]*entity.YarnVariant, error) {
-	query := `SELECT id, routing_template_id FROM yarn_variants WHERE is_active = true ORDER BY id LIMIT $1 OFFSET $2`
-	rows, err := r.pool.Query(ctx, query, limit, offset)
+// StreamWithRouting reads variants with routing IDs (optimized - only fetches id,
+// master_yarn_id and routing_template_id) in one query, handing each to fn as it arrives
+// instead of materializing the range. A routing filter is a separate statement rather
+// than an optional parameter, so both keep their index plans when prepared.
+func (r *yarnVariantRepo) StreamWithRouting(ctx context.Context, routingID, afterID, throughID uuid.UUID, fn func(*entity.YarnVariant) error) error {
+	query := `
+		SELECT id, master_yarn_id, routing_template_id FROM yarn_variants v
+		WHERE is_active = true AND id > $1 AND id <= $2
+		  AND NOT EXISTS (SELECT 1 FROM variant_dead_letters d WHERE d.variant_id = v.id AND d.dead_lettered_at IS NOT NULL)
+		ORDER BY id
+	`
+	args := []interface{}{afterID, throughID}
+	if routingID != uuid.Nil {
+		query = `
+			SELECT id, master_yarn_id, routing_template_id FROM yarn_variants v
+			WHERE routing_template_id = $3 AND is_active = true AND id > $1 AND id <= $2
+			  AND NOT EXISTS (SELECT 1 FROM variant_dead_letters d WHERE d.variant_id = v.id AND d.dead_lettered_at IS NOT NULL)
+			ORDER BY id
+		`
+		args = append(args, routingID)
+	}
+	rows, err := r.pool.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	variants := make([]*entity.YarnVariant, 0, limit)
 	for rows.Next() {
 		var v entity.YarnVariant
-		if err := rows.Scan(&v.ID, &v.RoutingTemplateID); err != nil {
-			return nil, err
+		if err := rows.Scan(&v.ID, &v.MasterYarnID, &v.RoutingTemplateID); err != nil {
+			return err
+		}
+		if err := fn(&v); err != nil {
+			return err
 		}
-		variants = append(variants, &v)
 	}
-	return variants, nil
+	return rows.Err()
 }
 
 // ListUniqueRoutingIDs retrieves all unique routing template IDs (for caching)
@@ -181,3 +261,44 @@ func (r *yarnVariantRepo) CountByMasterID(ctx context.Context, masterID uuid.UUI
 	err := r.pool.QueryRow(ctx, "SELECT COUNT(*) FROM yarn_variants WHERE master_yarn_id = $1", masterID).Scan(&count)
 	return count, err
 }
+
+func (r *yarnVariantRepo) CountByRouting(ctx context.Context, routingID uuid.UUID) (int64, error) {
+	query := `
+		SELECT COUNT(*) FROM yarn_variants v
+		WHERE routing_template_id = $1 AND is_active = true
+		  AND NOT EXISTS (SELECT 1 FROM variant_dead_letters d WHERE d.variant_id = v.id AND d.dead_lettered_at IS NOT NULL)
+	`
+	var count int64
+	err := r.pool.QueryRow(ctx, query, routingID).Scan(&count)
+	return count, err
+}
+
+func (r *yarnVariantRepo) DeactivateByMasterID(ctx context.Context, masterID uuid.UUID) (int64, error) {
+	var deactivated int64
+	log := &entity.AuditLog{EntityType: entity.AuditEntityMasterYarn, EntityID: masterID, Action: entity.AuditActionDeactivateVariants}
+	err := audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			UPDATE yarn_variants SET is_active = false, updated_at = NOW()
+			WHERE master_yarn_id = $1 AND is_active = true
+		`, masterID)
+		deactivated = tag.RowsAffected()
+		log.Details = map[string]interface{}{"variants": deactivated}
+		return err
+	})
+	return deactivated, err
+}
+
+func (r *yarnVariantRepo) ReassignRoutingByMasterID(ctx context.Context, masterID, routingID uuid.UUID) (int64, error) {
+	var reassigned int64
+	log := &entity.AuditLog{EntityType: entity.AuditEntityMasterYarn, EntityID: masterID, Action: entity.AuditActionReassignRouting}
+	err := audited(ctx, r.pool, log, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			UPDATE yarn_variants SET routing_template_id = $2, updated_at = NOW()
+			WHERE master_yarn_id = $1 AND routing_template_id IS DISTINCT FROM $2
+		`, masterID, routingID)
+		reassigned = tag.RowsAffected()
+		log.Details = map[string]interface{}{"variants": reassigned, "routing_template_id": routingID}
+		return err
+	})
+	return reassigned, err
+}
@@ -0,0 +1,253 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// Duplicate handling modes for master yarn creation and import
+const (
+	DuplicateModeOff   = "off"
+	DuplicateModeWarn  = "warn"
+	DuplicateModeBlock = "block"
+)
+
+// similarCandidateLimit bounds the trigram prefilter before the finer comparison
+const similarCandidateLimit = 20
+
+// DuplicatePolicy configures when two masters are considered duplicates
+type DuplicatePolicy struct {
+	Mode           string
+	NameSimilarity float64 // minimum normalized name similarity, 0..1
+	AttrTolerance  float64 // relative tolerance for numeric fixed attributes
+}
+
+// DuplicateCandidate is an existing master that looks like a duplicate
+type DuplicateCandidate struct {
+	Master         *entity.MasterYarn `json:"master"`
+	NameSimilarity float64            `json:"name_similarity"`
+}
+
+// DuplicateError is returned when creation is blocked by likely duplicates
+type DuplicateError struct {
+	Code       string
+	Candidates []*DuplicateCandidate
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("master yarn %s looks like a duplicate of %s", e.Code, e.Candidates[0].Master.Code)
+}
+
+// ImportResult summarizes a master yarn import
+type ImportResult struct {
+	Created    int64                            `json:"created"`
+	Blocked    []string                         `json:"blocked,omitempty"`    // codes not created
+	Duplicates map[string][]*DuplicateCandidate `json:"duplicates,omitempty"` // keyed by imported code
+}
+
+// MasterService creates, merges and splits master yarns with duplicate detection
+type MasterService struct {
+	masterRepo repository.MasterYarnRepository
+	policy     DuplicatePolicy
+}
+
+// NewMasterService creates a new master service
+func NewMasterService(masterRepo repository.MasterYarnRepository, policy DuplicatePolicy) *MasterService {
+	return &MasterService{
+		masterRepo: masterRepo,
+		policy:     policy,
+	}
+}
+
+// FindDuplicates returns existing active masters with a similar name whose fixed
+// attributes agree within tolerance
+func (s *MasterService) FindDuplicates(ctx context.Context, master *entity.MasterYarn) ([]*DuplicateCandidate, error) {
+	similar, err := s.masterRepo.FindSimilarByName(ctx, master.Name, similarCandidateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find similar masters: %w", err)
+	}
+
+	var candidates []*DuplicateCandidate
+	for _, other := range similar {
+		if other.ID == master.ID {
+			continue
+		}
+		if score, ok := s.duplicateOf(master, other); ok {
+			candidates = append(candidates, &DuplicateCandidate{Master: other, NameSimilarity: score})
+		}
+	}
+	return candidates, nil
+}
+
+// Create creates a master yarn. Likely duplicates are returned as a warning, or block
+// creation with a *DuplicateError in block mode unless force is set.
+func (s *MasterService) Create(ctx context.Context, master *entity.MasterYarn, force bool) ([]*DuplicateCandidate, error) {
+	var candidates []*DuplicateCandidate
+	if s.policy.Mode != DuplicateModeOff {
+		var err error
+		if candidates, err = s.FindDuplicates(ctx, master); err != nil {
+			return nil, err
+		}
+		if len(candidates) > 0 && s.policy.Mode == DuplicateModeBlock && !force {
+			return candidates, &DuplicateError{Code: master.Code, Candidates: candidates}
+		}
+	}
+	if err := s.masterRepo.Create(ctx, master); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// Import creates master yarns in bulk. Each row is checked against existing masters
+// and against earlier rows of the same import. In block mode duplicate rows are
+// skipped and reported unless force is set; the remaining rows are still created.
+func (s *MasterService) Import(ctx context.Context, masters []*entity.MasterYarn, force bool) (*ImportResult, error) {
+	result := &ImportResult{Duplicates: make(map[string][]*DuplicateCandidate)}
+	accepted := make([]*entity.MasterYarn, 0, len(masters))
+
+	for _, master := range masters {
+		if s.policy.Mode != DuplicateModeOff {
+			candidates, err := s.FindDuplicates(ctx, master)
+			if err != nil {
+				return nil, err
+			}
+			for _, other := range accepted {
+				if score, ok := s.duplicateOf(master, other); ok {
+					candidates = append(candidates, &DuplicateCandidate{Master: other, NameSimilarity: score})
+				}
+			}
+			if len(candidates) > 0 {
+				result.Duplicates[master.Code] = candidates
+				if s.policy.Mode == DuplicateModeBlock && !force {
+					result.Blocked = append(result.Blocked, master.Code)
+					continue
+				}
+			}
+		}
+		accepted = append(accepted, master)
+	}
+
+	if len(accepted) > 0 {
+		created, err := s.masterRepo.CreateBatch(ctx, accepted)
+		if err != nil {
+			return nil, err
+		}
+		result.Created = created
+	}
+	return result, nil
+}
+
+// Merge moves all variants of the duplicate master to the canonical master and
+// deactivates the duplicate
+func (s *MasterService) Merge(ctx context.Context, canonicalID, duplicateID uuid.UUID, actor string) (*entity.MasterRestructure, error) {
+	return s.masterRepo.MergeInto(ctx, duplicateID, canonicalID, actor)
+}
+
+// Split moves the selected variants of a master to a new master. Fixed attributes not
+// given for the new master are copied from the source.
+func (s *MasterService) Split(ctx context.Context, sourceID uuid.UUID, newMaster *entity.MasterYarn, variantIDs []uuid.UUID, actor string) (*entity.MasterRestructure, error) {
+	source, err := s.masterRepo.GetByID(ctx, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source master: %w", err)
+	}
+
+	attrs := make(map[string]interface{}, len(source.FixedAttrs))
+	for k, v := range source.FixedAttrs {
+		attrs[k] = v
+	}
+	for k, v := range newMaster.FixedAttrs {
+		attrs[k] = v
+	}
+	newMaster.FixedAttrs = attrs
+
+	seen := make(map[uuid.UUID]bool, len(variantIDs))
+	unique := make([]uuid.UUID, 0, len(variantIDs))
+	for _, id := range variantIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return s.masterRepo.Split(ctx, sourceID, newMaster, unique, actor)
+}
+
+// duplicateOf reports whether b looks like a duplicate of a, with the name similarity
+func (s *MasterService) duplicateOf(a, b *entity.MasterYarn) (float64, bool) {
+	score := NameSimilarity(a.Name, b.Name)
+	if score < s.policy.NameSimilarity {
+		return score, false
+	}
+	return score, AttrsWithinTolerance(a.FixedAttrs, b.FixedAttrs, s.policy.AttrTolerance)
+}
+
+// NameSimilarity returns 1 - normalized edit distance between two names, ignoring case,
+// punctuation and repeated whitespace
+func NameSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizeName(a)), []rune(normalizeName(b))
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// AttrsWithinTolerance reports whether every attribute present in both maps agrees:
+// numbers within relative tolerance, other values equal ignoring case
+func AttrsWithinTolerance(a, b map[string]interface{}, tolerance float64) bool {
+	for key, av := range a {
+		bv, ok := b[key]
+		if !ok {
+			continue
+		}
+		an, aNum := av.(float64)
+		bn, bNum := bv.(float64)
+		switch {
+		case aNum && bNum:
+			scale := math.Max(math.Abs(an), math.Abs(bn))
+			if scale > 0 && math.Abs(an-bn)/scale > tolerance {
+				return false
+			}
+		case !strings.EqualFold(fmt.Sprint(av), fmt.Sprint(bv)):
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeName(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// VariantService creates variants, filling in default routings from routing rules
+type VariantService struct {
+	masterRepo  repository.MasterYarnRepository
+	variantRepo repository.YarnVariantRepository
+	ruleRepo    repository.RoutingRuleRepository
+}
+
+// NewVariantService creates a new variant service
+func NewVariantService(
+	masterRepo repository.MasterYarnRepository,
+	variantRepo repository.YarnVariantRepository,
+	ruleRepo repository.RoutingRuleRepository,
+) *VariantService {
+	return &VariantService{
+		masterRepo:  masterRepo,
+		variantRepo: variantRepo,
+		ruleRepo:    ruleRepo,
+	}
+}
+
+// Create creates a variant, assigning the default routing when none is given
+func (s *VariantService) Create(ctx context.Context, variant *entity.YarnVariant) error {
+	if _, err := s.AssignDefaultRoutings(ctx, []*entity.YarnVariant{variant}); err != nil {
+		return err
+	}
+	return s.variantRepo.Create(ctx, variant)
+}
+
+// CreateBatch creates variants using COPY, assigning default routings where missing
+func (s *VariantService) CreateBatch(ctx context.Context, variants []*entity.YarnVariant) (int64, error) {
+	if _, err := s.AssignDefaultRoutings(ctx, variants); err != nil {
+		return 0, err
+	}
+	return s.variantRepo.CreateBatch(ctx, variants)
+}
+
+// AssignDefaultRoutings sets RoutingTemplateID on variants that have none, based on the
+// attributes of their master. It returns the number of variants that were assigned.
+func (s *VariantService) AssignDefaultRoutings(ctx context.Context, variants []*entity.YarnVariant) (int, error) {
+	var rules []*entity.RoutingRule
+	masters := make(map[uuid.UUID]*entity.MasterYarn)
+	assigned := 0
+
+	for _, v := range variants {
+		if v.RoutingTemplateID != uuid.Nil {
+			continue
+		}
+
+		// Load rules lazily so batches with explicit routings skip the query
+		if rules == nil {
+			var err error
+			if rules, err = s.ruleRepo.ListActive(ctx); err != nil {
+				return assigned, fmt.Errorf("failed to load routing rules: %w", err)
+			}
+		}
+
+		master, ok := masters[v.MasterYarnID]
+		if !ok {
+			var err error
+			if master, err = s.masterRepo.GetByID(ctx, v.MasterYarnID); err != nil {
+				return assigned, fmt.Errorf("failed to get master yarn %s: %w", v.MasterYarnID, err)
+			}
+			masters[v.MasterYarnID] = master
+		}
+
+		if rule := MatchRoutingRule(rules, master.FixedAttrs); rule != nil {
+			v.RoutingTemplateID = rule.RoutingTemplateID
+			assigned++
+		}
+	}
+	return assigned, nil
+}
+
+// PreviewRoutingRule returns the rule that would be applied to a master with the given attributes
+func (s *VariantService) PreviewRoutingRule(ctx context.Context, attrs map[string]interface{}) (*entity.RoutingRule, error) {
+	rules, err := s.ruleRepo.ListActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load routing rules: %w", err)
+	}
+	return MatchRoutingRule(rules, attrs), nil
+}
+
+// MatchRoutingRule picks the most specific matching rule; ties are broken by lowest priority.
+// Rules are expected in priority order, as returned by RoutingRuleRepository.ListActive.
+func MatchRoutingRule(rules []*entity.RoutingRule, attrs map[string]interface{}) *entity.RoutingRule {
+	var best *entity.RoutingRule
+	for _, rule := range rules {
+		if !rule.IsActive || !rule.Matches(attrs) {
+			continue
+		}
+		if best == nil || rule.Specificity() > best.Specificity() ||
+			(rule.Specificity() == best.Specificity() && rule.Priority < best.Priority) {
+			best = rule
+		}
+	}
+	return best
+}
@@ -0,0 +1,68 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// archivePage is the number of variants whose history one transaction archives
+const archivePage = 500
+
+// Archiver runs ARCHIVE_HISTORY jobs: it moves superseded summaries and per-step costs
+// older than the latest few versions of each from the history tables to the archive
+type Archiver struct {
+	historyRepo repository.CostHistoryRepository
+	jobRepo     repository.BatchJobRepository
+	keep        int
+}
+
+// NewArchiver creates a new archiver keeping keep versions for jobs that set none
+func NewArchiver(historyRepo repository.CostHistoryRepository, jobRepo repository.BatchJobRepository, keep int) *Archiver {
+	return &Archiver{historyRepo: historyRepo, jobRepo: jobRepo, keep: keep}
+}
+
+// Run archives the history of every variant in ID order, a page at a time, and completes
+// job. Its checkpoint is saved after every page, so a retried job resumes after it.
+func (a *Archiver) Run(ctx context.Context, job *entity.BatchJob) error {
+	keep := job.KeepVersions()
+	if keep <= 0 {
+		keep = a.keep
+	}
+	var checkpoint entity.JobCheckpoint
+	if saved, ok := job.Checkpoint(); ok {
+		checkpoint = *saved
+	}
+	if err := a.jobRepo.UpdateStatus(ctx, job.ID, entity.JobStatusRunning, checkpoint.Processed, 0); err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+
+	var summaries, costs int64
+	for {
+		lastID, s, c, err := a.historyRepo.ArchiveAfter(ctx, checkpoint.AfterVariantID, archivePage, keep)
+		if err != nil {
+			return fmt.Errorf("failed to archive history after variant %s: %w", checkpoint.AfterVariantID, err)
+		}
+		if lastID == uuid.Nil {
+			break
+		}
+		summaries, costs = summaries+s, costs+c
+		checkpoint.AfterVariantID = lastID
+		checkpoint.Processed += s + c
+		if err := a.jobRepo.SaveCheckpoint(ctx, job.ID, &checkpoint); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		a.jobRepo.UpdateProgress(ctx, job.ID, s+c, 0)
+	}
+
+	if err := a.jobRepo.Complete(ctx, job.ID); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	log.Printf("Archive %s: %d summaries and %d process costs archived, keeping %d versions", job.ID, summaries, costs, keep)
+	return nil
+}
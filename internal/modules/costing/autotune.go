@@ -0,0 +1,109 @@
+package costing
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WorkerTuning adjusts a run's worker count during its first Window, starting from the
+// pool's count. Workers are added while the CPUs have room and taken away while the
+// database falls behind, so one default suits both small and large databases.
+type WorkerTuning struct {
+	Window     time.Duration // how long into a run the count is tuned; 0 keeps it static
+	MaxWorkers int           // most workers tuning adds up to
+}
+
+// workerSet tracks which of a run's workers are running and how many should be
+type workerSet struct {
+	mu      sync.Mutex
+	target  int
+	running []bool
+}
+
+// resize sets the number of workers to n, calling start for each worker to add. Workers
+// beyond n stop before taking their next batch.
+func (s *workerSet) resize(n int, start func(id int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.target = n
+	for len(s.running) < n {
+		s.running = append(s.running, false)
+	}
+	for id := 0; id < n; id++ {
+		if !s.running[id] {
+			s.running[id] = true
+			start(id)
+		}
+	}
+}
+
+// retire reports whether worker id is beyond the target and so should stop, marking it
+// stopped if so
+func (s *workerSet) retire(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < s.target {
+		return false
+	}
+	s.running[id] = false
+	return true
+}
+
+func (s *workerSet) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.target
+}
+
+// tuneWorkers adjusts workers every step of the tuning window until the window ends,
+// done is closed or ctx is done
+func (wp *WorkerPool) tuneWorkers(ctx context.Context, name string, done <-chan struct{}, workers *workerSet, start func(id int), sizer *batchSizer, stalled *atomic.Int64) {
+	step := max(5*time.Second, wp.workerTuning.Window/6)
+	window := time.NewTimer(wp.workerTuning.Window)
+	defer window.Stop()
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+
+	lastCPU, measured := cpuTime()
+	last, lastStalled := time.Now(), stalled.Load()
+	sizer.meanLatency()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-window.C:
+			log.Printf("Job %s: worker count settled at %d", name, workers.size())
+			return
+		case <-ticker.C:
+		}
+
+		cpu, _ := cpuTime()
+		now, stalledNow := time.Now(), stalled.Load()
+		wall := now.Sub(last).Seconds()
+		count := workers.size()
+		usage := (cpu - lastCPU).Seconds() / (wall * float64(runtime.GOMAXPROCS(0)))
+		stallShare := time.Duration(stalledNow-lastStalled).Seconds() / (wall * float64(count))
+		latency := sizer.meanLatency()
+		last, lastCPU, lastStalled = now, cpu, stalledNow
+
+		next := count
+		switch {
+		case (wp.tuning.TargetLatency > 0 && latency > wp.tuning.TargetLatency) || stallShare > 0.5:
+			// The database is behind: more workers would only wait on the writers
+			next = max(1, count*3/4)
+		case measured && usage < 0.8:
+			next = min(max(count, wp.workerTuning.MaxWorkers), count+max(1, count/2))
+		}
+		if next != count {
+			log.Printf("Job %s: %d -> %d workers (CPU %.0f%%, stalled %.0f%%, upsert %v)",
+				name, count, next, usage*100, stallShare*100, latency.Round(time.Millisecond))
+			workers.resize(next, start)
+		}
+	}
+}
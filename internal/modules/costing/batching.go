@@ -0,0 +1,85 @@
+package costing
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchTuning adapts a run's batch size to how long summary upserts take
+type BatchTuning struct {
+	TargetLatency time.Duration // upsert time batches are sized toward; 0 keeps the batch size static
+	MinSize       int           // smallest batch size a slow database shrinks batches to
+}
+
+// batchSizer holds the batch size shared by a run's dispatcher and writers. The run's
+// configured batch size is the ceiling: a batch whose upsert takes longer than the
+// target shrinks the size in proportion, and one well under the target grows it by a
+// quarter, so fewer variants are in flight while the database is slow.
+type batchSizer struct {
+	mu        sync.Mutex
+	size      int
+	min, max  int
+	target    time.Duration
+	low, high int // smallest and largest sizes used
+
+	upserts    int           // observed since meanLatency last read them
+	upsertTime time.Duration // total time of those upserts
+}
+
+func newBatchSizer(size int, tuning BatchTuning) *batchSizer {
+	s := &batchSizer{size: size, min: size, max: size, low: size, high: size}
+	if tuning.TargetLatency > 0 {
+		s.target = tuning.TargetLatency
+		s.min = min(max(1, tuning.MinSize), size)
+	}
+	return s
+}
+
+// current returns the size pages and batches are cut at
+func (s *batchSizer) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// observe adjusts the size after a batch of n summaries took elapsed to upsert.
+// Partial batches, such as a writer's last one, say little about the database and leave
+// the size alone; every batch counts toward meanLatency.
+func (s *batchSizer) observe(n int, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upserts++
+	s.upsertTime += elapsed
+	if s.target == 0 || n < s.size/2 {
+		return
+	}
+	switch {
+	case elapsed > s.target:
+		// Aim for the target at the observed rate, but at most halve at once so one
+		// stalled upsert does not collapse the size
+		next := int(float64(n) * float64(s.target) / float64(elapsed))
+		s.size = max(s.min, s.size/2, min(s.size, next))
+	case elapsed < s.target/2:
+		s.size = min(s.max, s.size+max(1, s.size/4))
+	}
+	s.low, s.high = min(s.low, s.size), max(s.high, s.size)
+}
+
+// meanLatency returns the mean upsert time since it was last called, 0 if there were none
+func (s *batchSizer) meanLatency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.upserts == 0 {
+		return 0
+	}
+	mean := s.upsertTime / time.Duration(s.upserts)
+	s.upserts, s.upsertTime = 0, 0
+	return mean
+}
+
+// bounds returns the smallest and largest sizes used so far
+func (s *batchSizer) bounds() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.low, s.high
+}
@@ -0,0 +1,35 @@
+package costing
+
+import (
+	"sync"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// summaryPool recycles cost summaries, with their category breakdown maps, from the
+// writers back to the calculation workers. A full recalculation otherwise allocates a
+// summary and a map for every variant, which dominates its garbage.
+var summaryPool = sync.Pool{
+	New: func() interface{} { return new(entity.VariantCostSummary) },
+}
+
+// getSummary returns a zeroed summary from the pool with an empty breakdown map sized for
+// steps categories
+func getSummary(steps int) *entity.VariantCostSummary {
+	summary := summaryPool.Get().(*entity.VariantCostSummary)
+	breakdown := summary.CategoryBreakdown
+	if breakdown == nil {
+		breakdown = make(map[string]float64, steps)
+	} else {
+		clear(breakdown)
+	}
+	*summary = entity.VariantCostSummary{CategoryBreakdown: breakdown}
+	return summary
+}
+
+// releaseSummaries puts summaries back in the pool. They must not be used afterwards.
+func releaseSummaries(summaries []*entity.VariantCostSummary) {
+	for _, summary := range summaries {
+		summaryPool.Put(summary)
+	}
+}
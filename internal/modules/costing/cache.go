@@ -0,0 +1,191 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+// watchRetryDelay is how long Watch waits before re-subscribing after a listener error
+const watchRetryDelay = 5 * time.Second
+
+// InvalidationSource delivers cache invalidation events until ctx is done or it fails
+type InvalidationSource interface {
+	Listen(ctx context.Context, handle func(reason string)) error
+}
+
+// CacheStats describes the current cache contents
+type CacheStats struct {
+	Warm     bool      `json:"warm"`
+	Routings int       `json:"routings"`
+	Programs int       `json:"programs"`
+	Params   int       `json:"params"`
+	WarmedAt time.Time `json:"warmed_at,omitempty"`
+}
+
+// RoutingCache keeps routing steps, compiled step formulas and the current base
+// parameters (defaults overridden by current price rates, derived rates resolved) in memory, so jobs start
+// without loading them. Snapshots are immutable and swapped atomically on re-warm.
+type RoutingCache struct {
+	variantRepo repository.YarnVariantRepository
+	stepRepo    repository.ProcessStepRepository
+	rateRepo    repository.PriceRateRepository
+	parser      *formula.Parser
+
+	mu       sync.RWMutex
+	snapshot *cacheSnapshot
+}
+
+type cacheSnapshot struct {
+	steps      map[uuid.UUID][]*entity.ProcessStep
+	programs   map[uuid.UUID]*formula.Program // keyed by process step ID
+	baseParams map[string]interface{}
+	warmedAt   time.Time
+}
+
+// NewRoutingCache creates an empty (cold) routing cache
+func NewRoutingCache(
+	variantRepo repository.YarnVariantRepository,
+	stepRepo repository.ProcessStepRepository,
+	rateRepo repository.PriceRateRepository,
+	parser *formula.Parser,
+) *RoutingCache {
+	return &RoutingCache{
+		variantRepo: variantRepo,
+		stepRepo:    stepRepo,
+		rateRepo:    rateRepo,
+		parser:      parser,
+	}
+}
+
+// Warm loads rates, the steps of every routing in use and compiles their formulas,
+// then replaces the current snapshot. Formulas that fail to compile against the base
+// parameters alone, including those referencing master attributes, are left out and
+// compiled per batch instead.
+func (c *RoutingCache) Warm(ctx context.Context) error {
+	_, err := c.warm(ctx)
+	return err
+}
+
+func (c *RoutingCache) warm(ctx context.Context) (*cacheSnapshot, error) {
+	start := time.Now()
+
+	rates, err := c.rateRepo.ListCurrent(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load current rates: %w", err)
+	}
+	baseParams, err := ResolveRates(c.parser, DefaultBaseParams(), rates)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &cacheSnapshot{
+		steps:      make(map[uuid.UUID][]*entity.ProcessStep),
+		programs:   make(map[uuid.UUID]*formula.Program),
+		baseParams: baseParams,
+	}
+
+	// Programs run with step results added for routings that reference them
+	env := withStepResults(snap.baseParams, stepResults{})
+
+	routingIDs, err := c.variantRepo.ListUniqueRoutingIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routings: %w", err)
+	}
+	for _, routingID := range routingIDs {
+		steps, err := c.stepRepo.GetByRoutingID(ctx, routingID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load steps for routing %s: %w", routingID, err)
+		}
+		snap.steps[routingID] = steps
+		for _, step := range steps {
+			if program, err := c.parser.Compile(step.FormulaExpression, env); err == nil {
+				snap.programs[step.ID] = program
+			}
+		}
+	}
+	snap.warmedAt = time.Now()
+
+	c.mu.Lock()
+	c.snapshot = snap
+	c.mu.Unlock()
+
+	log.Printf("Cache warmed in %v: %d routings, %d compiled formulas, %d params",
+		time.Since(start).Round(time.Millisecond), len(snap.steps), len(snap.programs), len(snap.baseParams))
+	return snap, nil
+}
+
+// Invalidate drops the current snapshot; the next use re-warms the cache
+func (c *RoutingCache) Invalidate() {
+	c.mu.Lock()
+	c.snapshot = nil
+	c.mu.Unlock()
+}
+
+// BaseParams returns the current base parameters, warming the cache if it is cold.
+// The returned map is shared and must not be modified.
+func (c *RoutingCache) BaseParams(ctx context.Context) (map[string]interface{}, error) {
+	snap, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snap.baseParams, nil
+}
+
+// Stats reports what the cache currently holds
+func (c *RoutingCache) Stats() CacheStats {
+	c.mu.RLock()
+	snap := c.snapshot
+	c.mu.RUnlock()
+	if snap == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Warm:     true,
+		Routings: len(snap.steps),
+		Programs: len(snap.programs),
+		Params:   len(snap.baseParams),
+		WarmedAt: snap.warmedAt,
+	}
+}
+
+// Watch re-warms the cache on every invalidation event until ctx is done,
+// re-subscribing after listener errors
+func (c *RoutingCache) Watch(ctx context.Context, source InvalidationSource) {
+	for ctx.Err() == nil {
+		err := source.Listen(ctx, func(reason string) {
+			log.Printf("Cache invalidated (%s), re-warming", reason)
+			if err := c.Warm(ctx); err != nil {
+				log.Printf("Failed to re-warm cache: %v", err)
+				c.Invalidate()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Cache invalidation listener stopped: %v; retrying in %v", err, watchRetryDelay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// current returns the snapshot, warming the cache first if it is cold
+func (c *RoutingCache) current(ctx context.Context) (*cacheSnapshot, error) {
+	c.mu.RLock()
+	snap := c.snapshot
+	c.mu.RUnlock()
+	if snap != nil {
+		return snap, nil
+	}
+	return c.warm(ctx)
+}
@@ -0,0 +1,158 @@
+package costing
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// ErrJobPaused is returned by RecalculateAll when the job was paused while running.
+// Its checkpoint is saved, so resuming the job continues after the last written variant.
+var ErrJobPaused = errors.New("job paused")
+
+// checkpointTracker advances a recalculation's checkpoint as dispatched pages of
+// variants finish. The run's range is split into lanes, consecutive ID ranges each
+// dispatched in order by its own scanner. Pages complete out of order across lanes,
+// workers and writers, so the checkpoint only moves past a page once it and every
+// earlier page of its lane are done, and into a lane once every earlier lane is. Each
+// page that completes is also collected as a chunk, in whatever order it finishes.
+type checkpointTracker struct {
+	mu         sync.Mutex
+	jobID      uuid.UUID
+	pages      map[int]*pageProgress
+	nextPage   int // index given to the next dispatched page
+	lanes      []*trackerLane
+	lane       int // first lane not yet complete
+	checkpoint entity.JobCheckpoint
+	dirty      bool               // checkpoint advanced since the last take
+	completed  []*entity.JobChunk // pages completed since the last take
+}
+
+// trackerLane is the dispatch order of one lane's pages not yet passed by the checkpoint
+type trackerLane struct {
+	through uuid.UUID // last variant ID in the lane
+	open    []int
+	closed  bool // every page of the lane was dispatched
+}
+
+type pageProgress struct {
+	afterID   uuid.UUID
+	lastID    uuid.UUID
+	pending   int64
+	processed int64
+	failed    int64
+}
+
+// newCheckpointTracker starts tracking job from start, the zero checkpoint for a fresh
+// run, over lanes ending at the given variant IDs in order
+func newCheckpointTracker(jobID uuid.UUID, start entity.JobCheckpoint, laneEnds []uuid.UUID) *checkpointTracker {
+	t := &checkpointTracker{jobID: jobID, pages: make(map[int]*pageProgress), checkpoint: start}
+	for _, through := range laneEnds {
+		t.lanes = append(t.lanes, &trackerLane{through: through})
+	}
+	return t
+}
+
+// add registers a dispatched page of count variants in (afterID, lastID] of lane and
+// returns its index
+func (t *checkpointTracker) add(lane int, afterID, lastID uuid.UUID, count int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.register(lane, &pageProgress{afterID: afterID, lastID: lastID, pending: int64(count)})
+}
+
+// skip registers chunk of lane, completed by an earlier run, as a page that is already done
+func (t *checkpointTracker) skip(lane int, chunk *entity.JobChunk) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.register(lane, &pageProgress{
+		afterID:   chunk.AfterVariantID,
+		lastID:    chunk.ThroughVariantID,
+		processed: chunk.ProcessedRecords,
+		failed:    chunk.FailedRecords,
+	})
+	t.advance()
+}
+
+// close records that every page of lane was dispatched
+func (t *checkpointTracker) close(lane int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lanes[lane].closed = true
+	t.advance()
+}
+
+func (t *checkpointTracker) register(lane int, p *pageProgress) int {
+	page := t.nextPage
+	t.nextPage++
+	t.pages[page] = p
+	t.lanes[lane].open = append(t.lanes[lane].open, page)
+	return page
+}
+
+// done records processed written and failed variants of page
+func (t *checkpointTracker) done(page int, processed, failed int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.pages[page]
+	p.pending -= processed + failed
+	p.processed += processed
+	p.failed += failed
+	if p.pending == 0 {
+		t.completed = append(t.completed, &entity.JobChunk{
+			JobID:            t.jobID,
+			AfterVariantID:   p.afterID,
+			ThroughVariantID: p.lastID,
+			Status:           entity.JobStatusCompleted,
+			ProcessedRecords: p.processed,
+			FailedRecords:    p.failed,
+		})
+	}
+	t.advance()
+}
+
+// advance moves the checkpoint past the leading run of complete pages of the first open
+// lane, and on into the next lane once that one is closed and complete
+func (t *checkpointTracker) advance() {
+	for t.lane < len(t.lanes) {
+		l := t.lanes[t.lane]
+		for len(l.open) > 0 {
+			p := t.pages[l.open[0]]
+			if p.pending > 0 {
+				return
+			}
+			t.checkpoint.AfterVariantID = p.lastID
+			t.checkpoint.Processed += p.processed
+			t.checkpoint.Failed += p.failed
+			t.dirty = true
+			delete(t.pages, l.open[0])
+			l.open = l.open[1:]
+		}
+		if !l.closed {
+			return
+		}
+		t.lane++
+		if t.lane < len(t.lanes) {
+			t.checkpoint.AfterVariantID = l.through
+			t.dirty = true
+		}
+	}
+}
+
+// take returns the chunks completed since the last call and the checkpoint, or nil when
+// it did not advance since the last call
+func (t *checkpointTracker) take() ([]*entity.JobChunk, *entity.JobCheckpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	completed := t.completed
+	t.completed = nil
+	if !t.dirty {
+		return completed, nil
+	}
+	t.dirty = false
+	checkpoint := t.checkpoint
+	return completed, &checkpoint
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package costing
+
+import "time"
+
+// cpuTime reports false where process CPU time is not available, so worker tuning only
+// takes workers away
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,26 @@
+package costing
+
+import (
+	"errors"
+)
+
+// ErrWorkerDraining is returned by RecalculateAll when the pool was drained while the job
+// ran. Its checkpoint is saved, so the job can be released for another worker to resume.
+var ErrWorkerDraining = errors.New("worker draining")
+
+// Drain stops running recalculations from dispatching further variants. Variants already
+// dispatched are still written and checkpointed before the runs return, and runs started
+// afterwards stop straight away. Safe to call more than once.
+func (wp *WorkerPool) Drain() {
+	wp.drainOnce.Do(func() { close(wp.drain) })
+}
+
+// Draining reports whether Drain was called
+func (wp *WorkerPool) Draining() bool {
+	select {
+	case <-wp.drain:
+		return true
+	default:
+		return false
+	}
+}
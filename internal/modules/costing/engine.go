@@ -1,12 +1,16 @@
 package costing
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,6 +24,7 @@ import (
 
 // CalculationEngine handles cost calculations
 type CalculationEngine struct {
+	masterRepo      repository.MasterYarnRepository
 	variantRepo     repository.YarnVariantRepository
 	processStepRepo repository.ProcessStepRepository
 	costRepo        repository.VariantProcessCostRepository
@@ -29,60 +34,246 @@ type CalculationEngine struct {
 
 // NewCalculationEngine creates a new calculation engine
 func NewCalculationEngine(
+	masterRepo repository.MasterYarnRepository,
 	variantRepo repository.YarnVariantRepository,
 	processStepRepo repository.ProcessStepRepository,
 	costRepo repository.VariantProcessCostRepository,
 	summaryRepo repository.VariantCostSummaryRepository,
+	formulaParser *formula.Parser,
 ) *CalculationEngine {
 	return &CalculationEngine{
+		masterRepo:      masterRepo,
 		variantRepo:     variantRepo,
 		processStepRepo: processStepRepo,
 		costRepo:        costRepo,
 		summaryRepo:     summaryRepo,
-		formulaParser:   formula.NewParser(),
+		formulaParser:   formulaParser,
 	}
 }
 
 // CalculateVariantFast calculates costs using cached process steps (no DB lookup)
-func (e *CalculationEngine) CalculateVariantFast(variantID uuid.UUID, steps []*entity.ProcessStep, inputParams map[string]interface{}) *entity.VariantCostSummary {
+func (e *CalculationEngine) CalculateVariantFast(variantID uuid.UUID, steps []*entity.ProcessStep, inputParams map[string]interface{}) (*entity.VariantCostSummary, error) {
+	costs, err := e.StepCosts(steps, inputParams)
+	if err != nil {
+		return nil, err
+	}
 	var totalProcessCost float64
+	breakdown := make(map[string]float64, len(steps))
 	now := time.Now()
+	for i, step := range steps {
+		breakdown[categoryKey(step)] += costs[i]
+		totalProcessCost += costs[i]
+	}
 
-	// Calculate each step
-	for _, step := range steps {
-		cost, err := e.formulaParser.Evaluate(step.FormulaExpression, inputParams)
+	summary := buildSummary(variantID, totalProcessCost, inputParams, versionHash(paramsHash(inputParams), stepsHash(steps)), now)
+	summary.CategoryBreakdown = breakdown
+	return summary, nil
+}
+
+// StepCosts evaluates the steps of a routing in order and returns the cost of each. A
+// step that fails (e.g. NaN or division by zero) fails the variant rather than
+// persisting a garbage total.
+func (e *CalculationEngine) StepCosts(steps []*entity.ProcessStep, inputParams map[string]interface{}) ([]float64, error) {
+	params := inputParams
+	results := stepResults{}
+	if usesStepResults(steps) {
+		params = withStepResults(inputParams, results)
+	}
+
+	costs := make([]float64, len(steps))
+	for i, step := range steps {
+		cost, err := e.formulaParser.Evaluate(step.FormulaExpression, params)
 		if err != nil {
-			cost = 0
+			return nil, fmt.Errorf("step %d (%s): %w", step.SequenceOrder, step.ID, err)
 		}
-		totalProcessCost += cost
+		results.record(step, cost)
+		costs[i] = cost
+	}
+	return costs, nil
+}
+
+// CalculateBatchFast calculates costs for variants sharing the same routing steps.
+// Each step formula is compiled once and evaluated for all parameter sets, which must
+// be index-aligned with variantIDs. A variant with a failing step gets a nil summary
+// and its error at the same index.
+func (e *CalculationEngine) CalculateBatchFast(variantIDs []uuid.UUID, steps []*entity.ProcessStep, paramSets []map[string]interface{}) ([]*entity.VariantCostSummary, []error) {
+	return e.calculateBatch(variantIDs, steps, nil, paramSets, nil)
+}
+
+// calculateBatch is CalculateBatchFast using precompiled step programs where available.
+// paramHashes, when not nil, holds the paramsHash of each parameter set, so shared
+// sets are not hashed again for every variant.
+func (e *CalculationEngine) calculateBatch(variantIDs []uuid.UUID, steps []*entity.ProcessStep, programs map[uuid.UUID]*formula.Program, paramSets []map[string]interface{}, paramHashes []string) ([]*entity.VariantCostSummary, []error) {
+	now := time.Now()
+	totals := make([]float64, len(variantIDs))
+	errs := make([]error, len(variantIDs))
+	// Summaries come from the pool with their breakdown maps, which steps add up into
+	summaries := make([]*entity.VariantCostSummary, len(variantIDs))
+	for i := range summaries {
+		summaries[i] = getSummary(len(steps))
 	}
 
-	// Calculate summary
+	// Each variant gets its own view of earlier step results when formulas use them
+	evalSets := paramSets
+	var results []stepResults
+	if usesStepResults(steps) {
+		evalSets = make([]map[string]interface{}, len(paramSets))
+		results = make([]stepResults, len(paramSets))
+		for i, params := range paramSets {
+			results[i] = stepResults{}
+			evalSets[i] = withStepResults(params, results[i])
+		}
+	}
+
+	for _, step := range steps {
+		key := categoryKey(step)
+		var values []float64
+		var stepErrs []error
+		var err error
+		if program, ok := programs[step.ID]; ok {
+			values, stepErrs = e.formulaParser.RunBatch(program, evalSets)
+		} else {
+			values, stepErrs, err = e.formulaParser.EvaluateBatch(step.FormulaExpression, evalSets)
+		}
+		for i := range variantIDs {
+			if errs[i] != nil {
+				continue
+			}
+			switch {
+			case err != nil:
+				errs[i] = fmt.Errorf("step %d (%s): %w", step.SequenceOrder, step.ID, err)
+			case stepErrs[i] != nil:
+				errs[i] = fmt.Errorf("step %d (%s): %w", step.SequenceOrder, step.ID, stepErrs[i])
+			default:
+				totals[i] += values[i]
+				summaries[i].CategoryBreakdown[key] += values[i]
+				if results != nil {
+					results[i].record(step, values[i])
+				}
+			}
+		}
+	}
+
+	routing := stepsHash(steps)
+	versions := make(map[string]string) // params hash to version hash, shared by a master's variants
+	for i, variantID := range variantIDs {
+		if errs[i] != nil {
+			releaseSummaries(summaries[i : i+1])
+			summaries[i] = nil
+			continue
+		}
+		var params string
+		if paramHashes != nil {
+			params = paramHashes[i]
+		} else {
+			params = paramsHash(paramSets[i])
+		}
+		version, ok := versions[params]
+		if !ok {
+			version = versionHash(params, routing)
+			versions[params] = version
+		}
+		fillSummary(summaries[i], variantID, totals[i], paramSets[i], version, now)
+	}
+	return summaries, errs
+}
+
+// buildSummary adds material cost and overhead to the process cost total. versionHash is
+// computed by the caller so it can be shared.
+func buildSummary(variantID uuid.UUID, totalProcessCost float64, inputParams map[string]interface{}, versionHash string, now time.Time) *entity.VariantCostSummary {
+	summary := &entity.VariantCostSummary{}
+	fillSummary(summary, variantID, totalProcessCost, inputParams, versionHash, now)
+	return summary
+}
+
+// fillSummary is buildSummary into an existing summary, leaving its breakdown as it is
+func fillSummary(summary *entity.VariantCostSummary, variantID uuid.UUID, totalProcessCost float64, inputParams map[string]interface{}, versionHash string, now time.Time) {
 	materialCost := getFloatParam(inputParams, "material_cost", 0)
 	overhead := totalProcessCost * getFloatParam(inputParams, "overhead_percentage", 0.1)
 
-	// Generate version hash for change detection
-	paramsJSON, _ := json.Marshal(inputParams)
+	summary.YarnVariantID = variantID
+	summary.TotalMaterialCost = materialCost
+	summary.TotalProcessCost = totalProcessCost
+	summary.TotalOverhead = overhead
+	summary.GrandTotal = materialCost + totalProcessCost + overhead
+	summary.LastRecalculatedAt = now
+	summary.VersionHash = versionHash
+}
+
+// paramsHash returns the hash of calculation params. Maps are marshaled with sorted keys,
+// so equal params always hash the same.
+func paramsHash(params map[string]interface{}) string {
+	paramsJSON, _ := json.Marshal(params)
 	hash := sha256.Sum256(paramsJSON)
+	return hex.EncodeToString(hash[:])
+}
+
+// stepsHash returns the hash of what a routing's steps compute: each step's order,
+// category and formula
+func stepsHash(steps []*entity.ProcessStep) string {
+	hash := sha256.New()
+	for _, step := range steps {
+		fmt.Fprintf(hash, "%s\x00%d\x00%s\x00%s\n", step.ID, step.SequenceOrder, categoryKey(step), step.FormulaExpression)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// versionHash returns a summary's version hash, for change detection: equal hashes mean
+// equal params run through equal steps, so the summary is unchanged. The summary upsert
+// skips rows whose hash is unchanged.
+func versionHash(paramsHash, stepsHash string) string {
+	hash := sha256.Sum256([]byte(paramsHash + stepsHash))
+	return hex.EncodeToString(hash[:])
+}
+
+// paramsHashes remembers the paramsHash of each master's merged params during a run.
+// Params are the run's base params with the master's fixed attributes, the same for
+// every variant of the master, so each is hashed once per run rather than per variant.
+type paramsHashes struct {
+	mu     sync.Mutex
+	hashes map[uuid.UUID]string
+}
 
-	return &entity.VariantCostSummary{
-		YarnVariantID:      variantID,
-		TotalMaterialCost:  materialCost,
-		TotalProcessCost:   totalProcessCost,
-		TotalOverhead:      overhead,
-		GrandTotal:         materialCost + totalProcessCost + overhead,
-		LastRecalculatedAt: now,
-		VersionHash:        hex.EncodeToString(hash[:]),
+func newparamsHashes() *paramsHashes {
+	return &paramsHashes{hashes: make(map[uuid.UUID]string)}
+}
+
+// get returns the hash of masterID's params, hashing them on first use
+func (h *paramsHashes) get(masterID uuid.UUID, params map[string]interface{}) string {
+	h.mu.Lock()
+	hash, ok := h.hashes[masterID]
+	h.mu.Unlock()
+	if ok {
+		return hash
+	}
+	hash = paramsHash(params)
+	h.mu.Lock()
+	h.hashes[masterID] = hash
+	h.mu.Unlock()
+	return hash
+}
+
+// categoryKey is the category breakdown key of a step: its process code, or its ID when
+// the code was not loaded (the backfill falls back the same way)
+func categoryKey(step *entity.ProcessStep) string {
+	if step.ProcessCode != "" {
+		return step.ProcessCode
 	}
+	return step.ID.String()
 }
 
-// CalculateVariant calculates costs for a single variant (with DB lookup - slower)
+// CalculateVariant calculates costs for a single variant (with DB lookup - slower).
+// The master yarn's fixed_attrs are merged over inputParams.
 func (e *CalculationEngine) CalculateVariant(ctx context.Context, variantID uuid.UUID, inputParams map[string]interface{}) (*entity.VariantCostSummary, error) {
 	// Get variant
 	variant, err := e.variantRepo.GetByID(ctx, variantID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get variant: %w", err)
 	}
+	inputParams, err = e.withMasterAttrs(ctx, variant.MasterYarnID, inputParams)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get process steps for routing
 	steps, err := e.processStepRepo.GetByRoutingID(ctx, variant.RoutingTemplateID)
@@ -90,7 +281,16 @@ func (e *CalculationEngine) CalculateVariant(ctx context.Context, variantID uuid
 		return nil, fmt.Errorf("failed to get process steps: %w", err)
 	}
 
-	return e.CalculateVariantFast(variantID, steps, inputParams), nil
+	return e.CalculateVariantFast(variantID, steps, inputParams)
+}
+
+// withMasterAttrs returns params with the master yarn's fixed_attrs merged over them
+func (e *CalculationEngine) withMasterAttrs(ctx context.Context, masterID uuid.UUID, params map[string]interface{}) (map[string]interface{}, error) {
+	attrs, err := e.masterRepo.GetFixedAttrs(ctx, []uuid.UUID{masterID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master attributes: %w", err)
+	}
+	return MergeParams(params, AttrParams(attrs[masterID])), nil
 }
 
 func getFloatParam(params map[string]interface{}, key string, defaultVal float64) float64 {
@@ -109,12 +309,25 @@ func getFloatParam(params map[string]interface{}, key string, defaultVal float64
 
 // WorkerPool manages concurrent calculation workers
 type WorkerPool struct {
-	engine      *CalculationEngine
-	variantRepo repository.YarnVariantRepository
-	summaryRepo repository.VariantCostSummaryRepository
-	jobRepo     repository.BatchJobRepository
-	workerCount int
-	batchSize   int
+	engine        *CalculationEngine
+	variantRepo   repository.YarnVariantRepository
+	summaryRepo   repository.VariantCostSummaryRepository
+	jobRepo       repository.BatchJobRepository
+	partitionRepo repository.JobPartitionRepository
+	chunkRepo     repository.JobChunkRepository
+	deadLetters   repository.VariantDeadLetterRepository
+	cache         *RoutingCache // optional; nil loads routings at the start of every job
+	workerCount   int
+	writerCount   int
+	scanCount     int // scanners dispatching lanes of a run in parallel
+	batchSize     int
+	tuning        BatchTuning
+	throttle      WriteThrottle
+	workerTuning  WorkerTuning
+	limiter       *writeLimiter // shared by the writers of every run
+	deadAfter     int           // consecutive failures after which a variant is dead-lettered
+	drain         chan struct{} // closed by Drain
+	drainOnce     sync.Once
 }
 
 // NewWorkerPool creates a new worker pool
@@ -123,60 +336,297 @@ func NewWorkerPool(
 	variantRepo repository.YarnVariantRepository,
 	summaryRepo repository.VariantCostSummaryRepository,
 	jobRepo repository.BatchJobRepository,
-	workerCount, batchSize int,
+	partitionRepo repository.JobPartitionRepository,
+	chunkRepo repository.JobChunkRepository,
+	deadLetters repository.VariantDeadLetterRepository,
+	cache *RoutingCache,
+	workerCount, writerCount, scanCount, batchSize, deadLetterAfter int,
+	tuning BatchTuning,
+	throttle WriteThrottle,
+	workerTuning WorkerTuning,
 ) *WorkerPool {
+	if writerCount < 1 {
+		writerCount = 1
+	}
+	if scanCount < 1 {
+		scanCount = 1
+	}
 	return &WorkerPool{
-		engine:      engine,
-		variantRepo: variantRepo,
-		summaryRepo: summaryRepo,
-		jobRepo:     jobRepo,
-		workerCount: workerCount,
-		batchSize:   batchSize,
+		engine:        engine,
+		variantRepo:   variantRepo,
+		summaryRepo:   summaryRepo,
+		jobRepo:       jobRepo,
+		partitionRepo: partitionRepo,
+		chunkRepo:     chunkRepo,
+		deadLetters:   deadLetters,
+		cache:         cache,
+		workerCount:   workerCount,
+		writerCount:   writerCount,
+		scanCount:     scanCount,
+		batchSize:     batchSize,
+		tuning:        tuning,
+		throttle:      throttle,
+		workerTuning:  workerTuning,
+		limiter:       newWriteLimiter(throttle),
+		deadAfter:     deadLetterAfter,
+		drain:         make(chan struct{}),
 	}
 }
 
-// RecalculateAll recalculates costs for all variants with optimized batch processing
+// RecalculateAll recalculates costs for all variants with optimized batch processing.
+// Variants are dispatched in ID order as chunks recorded with the job, and its checkpoint
+// is saved as they are written, so a paused or failed run resumes after the last
+// checkpoint and skips chunks that completed past it. Returns
+// ErrJobPaused when the job is paused while running and ErrWorkerDraining when the pool
+// is drained.
 func (wp *WorkerPool) RecalculateAll(ctx context.Context, jobID uuid.UUID, baseParams map[string]interface{}) error {
-	startTime := time.Now()
-
 	// Get total count
-	totalCount, err := wp.variantRepo.Count(ctx)
+	totalCount, err := wp.countVariants(ctx)
+	if err != nil {
+		return err
+	}
+	return wp.recalculateJob(ctx, jobID, uuid.Nil, totalCount, baseParams)
+}
+
+// RecalculateRouting recalculates the active variants of one routing template, e.g.
+// after one of its formulas changed. It runs, checkpoints and resumes like RecalculateAll.
+func (wp *WorkerPool) RecalculateRouting(ctx context.Context, jobID, routingID uuid.UUID, baseParams map[string]interface{}) error {
+	totalCount, err := wp.variantRepo.CountByRouting(ctx, routingID)
 	if err != nil {
-		return fmt.Errorf("failed to count variants: %w", err)
+		return fmt.Errorf("failed to count variants of routing %s: %w", routingID, err)
+	}
+	return wp.recalculateJob(ctx, jobID, routingID, totalCount, baseParams)
+}
+
+// recalculateJob runs job over every variant, or those of routingID unless it is
+// uuid.Nil, and completes it
+func (wp *WorkerPool) recalculateJob(ctx context.Context, jobID, routingID uuid.UUID, totalCount int64, baseParams map[string]interface{}) error {
+	// Resume after the checkpoint a paused or failed run left, if any, and apply the job's
+	// worker count and batch size overrides
+	var start entity.JobCheckpoint
+	var workerCount, batchSize int
+	if job, err := wp.jobRepo.GetByID(ctx, jobID); err == nil {
+		if checkpoint, ok := job.Checkpoint(); ok {
+			start = *checkpoint
+			log.Printf("Resuming after variant %s (%d processed, %d failed)", start.AfterVariantID, start.Processed, start.Failed)
+		}
+		workerCount, batchSize = job.Overrides()
+	}
+
+	// Update job with total
+	if err := wp.jobRepo.SetTotal(ctx, jobID, totalCount); err != nil {
+		return fmt.Errorf("failed to set total: %w", err)
 	}
+	wp.jobRepo.UpdateStatus(ctx, jobID, entity.JobStatusRunning, start.Processed, start.Failed)
 
-	// Pre-fetch ALL routing templates and their process steps (cached for entire run)
-	log.Println("Pre-loading routing templates and process steps...")
-	routingStepsCache, err := wp.loadRoutingStepsCache(ctx)
+	_, paused, err := wp.recalculate(ctx, recalcRun{
+		name:          jobID.String(),
+		jobID:         jobID,
+		total:         totalCount,
+		start:         start,
+		through:       uuid.Max,
+		routingID:     routingID,
+		progressJobID: jobID,
+		workerCount:   workerCount,
+		batchSize:     batchSize,
+		save: func(ctx context.Context, checkpoint *entity.JobCheckpoint) error {
+			return wp.jobRepo.SaveCheckpoint(ctx, jobID, checkpoint)
+		},
+		stop: func(ctx context.Context) bool {
+			job, err := wp.jobRepo.GetByID(ctx, jobID)
+			return err == nil && job.Status == entity.JobStatusPaused
+		},
+		metrics: func(ctx context.Context, metrics *entity.JobMetrics) error {
+			return wp.jobRepo.SaveMetrics(ctx, jobID, metrics)
+		},
+	}, baseParams)
 	if err != nil {
-		return fmt.Errorf("failed to load routing cache: %w", err)
+		return err
+	}
+	if paused {
+		if wp.Draining() {
+			return ErrWorkerDraining
+		}
+		return ErrJobPaused
 	}
-	log.Printf("Loaded %d routing templates into cache", len(routingStepsCache))
+
+	// Complete job
+	if err := wp.jobRepo.Complete(ctx, jobID); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+
+	log.Printf("Job %s completed successfully", jobID)
+	return nil
+}
+
+// recalcRun describes one recalculation over a range of variants: a whole job or one
+// of its partitions
+type recalcRun struct {
+	name          string               // job or partition, for logs
+	jobID         uuid.UUID            // job the run's chunks are recorded for
+	total         int64                // variants in the range, 0 when not known
+	start         entity.JobCheckpoint // resume position and the counts up to it
+	after         uuid.UUID            // the range starts after this variant ID, uuid.Nil for all
+	through       uuid.UUID            // last variant ID in the range, uuid.Max for all
+	routingID     uuid.UUID            // only variants of this routing template, uuid.Nil for all
+	progressJobID uuid.UUID            // job whose processed_records writers increment, uuid.Nil for none
+	workerCount   int                  // overrides the pool's worker count when > 0
+	batchSize     int                  // overrides the pool's batch size when > 0
+	save          func(ctx context.Context, checkpoint *entity.JobCheckpoint) error
+	stop          func(ctx context.Context) bool // polled; true stops dispatching
+	// metrics records the run's metrics at its end; nil skips recording
+	metrics func(ctx context.Context, metrics *entity.JobMetrics) error
+}
+
+// recalculate calculates and writes every variant of run's range after its start
+// checkpoint, recording each page as a chunk and saving the checkpoint as pages are
+// written. Chunks that completed in an earlier run are skipped. The run's metrics are
+// printed when it finishes and recorded either way. It returns the final checkpoint
+// and whether the run was stopped before reaching the end of the range.
+func (wp *WorkerPool) recalculate(ctx context.Context, run recalcRun, baseParams map[string]interface{}) (entity.JobCheckpoint, bool, error) {
+	startTime := time.Now()
+	start := run.start
+	var err error
+	var startMem runtime.MemStats
+	runtime.ReadMemStats(&startMem)
+	var heapPeak atomic.Uint64
+
+	// A job's worker count override turns off worker tuning for its runs
+	workerCount, batchSize := wp.workerCount, wp.batchSize
+	autoWorkers := wp.workerTuning.Window > 0
+	if run.workerCount > 0 {
+		workerCount, autoWorkers = run.workerCount, false
+	}
+	if run.batchSize > 0 {
+		batchSize = run.batchSize
+	}
+
+	// Use the prewarmed cache when available, otherwise pre-fetch ALL routing templates
+	// and their process steps (cached for entire run)
+	var routingStepsCache map[uuid.UUID][]*entity.ProcessStep
+	var programs map[uuid.UUID]*formula.Program
+	if wp.cache != nil {
+		snap, err := wp.cache.current(ctx)
+		if err != nil {
+			return start, false, fmt.Errorf("failed to warm routing cache: %w", err)
+		}
+		routingStepsCache, programs = snap.steps, snap.programs
+		log.Printf("Using prewarmed cache from %s", snap.warmedAt.Format(time.RFC3339))
+	} else {
+		log.Println("Pre-loading routing templates and process steps...")
+		if routingStepsCache, err = wp.loadRoutingStepsCache(ctx); err != nil {
+			return start, false, fmt.Errorf("failed to load routing cache: %w", err)
+		}
+		log.Printf("Loaded %d routing templates into cache", len(routingStepsCache))
+	}
+	cacheTime := time.Since(startTime)
 
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║          TEXTILE COSTING ENGINE - RECALCULATION               ║")
 	fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
-	log.Printf("Job ID:     %s", jobID)
-	log.Printf("Workers:    %d", wp.workerCount)
-	log.Printf("Batch Size: %d", wp.batchSize)
-	log.Printf("Total Variants: %d", totalCount)
+	log.Printf("Job ID:     %s", run.name)
+	if run.routingID != uuid.Nil {
+		log.Printf("Routing:    %s", run.routingID)
+	}
+	log.Printf("GOMAXPROCS: %d", runtime.GOMAXPROCS(0))
+	if autoWorkers {
+		log.Printf("Workers:    %d, tuned up to %d for %v", workerCount, max(workerCount, wp.workerTuning.MaxWorkers), wp.workerTuning.Window)
+	} else {
+		log.Printf("Workers:    %d", workerCount)
+	}
+	log.Printf("Writers:    %d", wp.writerCount)
+	log.Printf("Scanners:   %d", wp.scanCount)
+	log.Printf("Batch Size: %d", batchSize)
+	if wp.tuning.TargetLatency > 0 {
+		log.Printf("Adaptive:   down to %d, target upsert %v", min(max(1, wp.tuning.MinSize), batchSize), wp.tuning.TargetLatency)
+	}
+	if wp.throttle.MaxRowsPerSecond > 0 || wp.throttle.MaxConcurrentUpserts > 0 {
+		log.Printf("Throttle:   %d rows/s, %d concurrent upserts (0 = no limit)", wp.throttle.MaxRowsPerSecond, wp.throttle.MaxConcurrentUpserts)
+	}
+	if run.total > 0 {
+		log.Printf("Total Variants: %d", run.total)
+	}
 	log.Printf("Routing Cache: %d templates", len(routingStepsCache))
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
-	// Update job with total
-	wp.jobRepo.UpdateStatus(ctx, jobID, entity.JobStatusRunning, 0, 0)
+	// Chunks an earlier run completed past the checkpoint, keyed by where they start
+	completed, err := wp.chunkRepo.ListCompleted(ctx, run.jobID, start.AfterVariantID, run.through)
+	if err != nil {
+		return start, false, fmt.Errorf("failed to list completed chunks: %w", err)
+	}
+	skipChunks := make(map[uuid.UUID]*entity.JobChunk, len(completed))
+	for _, c := range completed {
+		skipChunks[c.AfterVariantID] = c
+	}
+
+	// Variants with failures recorded; those written again have them cleared
+	failingIDs, err := wp.deadLetters.ListFailingIDs(ctx)
+	if err != nil {
+		return start, false, fmt.Errorf("failed to list failing variants: %w", err)
+	}
+	failing := make(map[uuid.UUID]struct{}, len(failingIDs))
+	for _, id := range failingIDs {
+		failing[id] = struct{}{}
+	}
+
+	// Lanes split the run's range for parallel scanners, less the part before the checkpoint.
+	// The split depends only on the range, so a resumed run's lanes line up with the chunks
+	// of the runs before it.
+	type scanLane struct{ after, through uuid.UUID }
+	var lanes []scanLane
+	var laneEnds []uuid.UUID
+	laneAfter := run.after
+	for _, through := range splitRange(run.after, run.through, wp.scanCount) {
+		if compareIDs(through, start.AfterVariantID) > 0 {
+			if compareIDs(laneAfter, start.AfterVariantID) < 0 {
+				laneAfter = start.AfterVariantID
+			}
+			lanes = append(lanes, scanLane{after: laneAfter, through: through})
+			laneEnds = append(laneEnds, through)
+		}
+		laneAfter = through
+	}
+	tracker := newCheckpointTracker(run.jobID, start, laneEnds)
+	hashes := newparamsHashes()
 
-	// Create channels - use variant with routing ID to avoid DB lookup
-	type variantWork struct {
-		ID        uuid.UUID
-		RoutingID uuid.UUID
+	// Create channels - work items are variants grouped by routing, so each step formula
+	// is compiled once per group instead of once per variant
+	type variantBatch struct {
+		Page       int // dispatched page, for checkpointing
+		RoutingID  uuid.UUID
+		VariantIDs []uuid.UUID
+		ParamSets  []map[string]interface{} // base params merged with each variant's master attrs
+		Hashes     []string                 // paramsHash of each param set
 	}
-	workChan := make(chan variantWork, wp.batchSize*2)
-	resultChan := make(chan *entity.VariantCostSummary, wp.batchSize*2)
+	// Pages and write batches follow the sizer, and every channel is bounded: when writes
+	// slow down, full channels block the workers and then the dispatcher, so the variants
+	// in memory stay within a few batches however large the run is
+	sizer := newBatchSizer(batchSize, wp.tuning)
+	workChan := make(chan variantBatch, workerCount*2)
+	resultChan := make(chan pageResult, batchSize)
+	var stalled atomic.Int64   // nanoseconds workers spent blocked on a full resultChan
+	var throttled atomic.Int64 // nanoseconds writers spent waiting on the write throttle
+
+	processedCount := start.Processed
+	failedCount := start.Failed
 
-	var processedCount int64
-	var failedCount int64
+	// Stopping (e.g. pausing) stops the dispatcher; variants already dispatched are still written
+	dispatchCtx, stopDispatch := context.WithCancel(ctx)
+	defer stopDispatch()
+	var paused atomic.Bool
+	failure := &runFailure{name: run.name, stop: stopDispatch}
+
+	// Draining the pool stops dispatching at once rather than at the next poll
+	go func() {
+		select {
+		case <-wp.drain:
+			log.Printf("Job %s draining, finishing dispatched variants...", run.name)
+			paused.Store(true)
+			stopDispatch()
+		case <-dispatchCtx.Done():
+		}
+	}()
 
 	// Progress reporter goroutine
 	progressDone := make(chan struct{})
@@ -188,109 +638,279 @@ func (wp *WorkerPool) RecalculateAll(ctx context.Context, jobID uuid.UUID, baseP
 			case <-progressDone:
 				return
 			case <-ticker.C:
+				wp.saveCheckpoint(ctx, run, tracker)
+				sampleHeap(&heapPeak)
+				if !paused.Load() && run.stop(ctx) {
+					log.Printf("Job %s stopping, finishing dispatched variants...", run.name)
+					paused.Store(true)
+					stopDispatch()
+				}
+
 				processed := atomic.LoadInt64(&processedCount)
 				failed := atomic.LoadInt64(&failedCount)
 				elapsed := time.Since(startTime)
-				if elapsed.Seconds() > 0 && processed > 0 {
-					rate := float64(processed) / elapsed.Seconds()
-					remaining := float64(totalCount-processed) / rate
-					log.Printf("Progress: %d/%d (%.1f%%) | Rate: %.0f/s | Failed: %d | ETA: %v",
-						processed, totalCount, float64(processed)/float64(totalCount)*100,
-						rate, failed, time.Duration(remaining)*time.Second)
+				if elapsed.Seconds() > 0 && processed > start.Processed {
+					rate := float64(processed-start.Processed) / elapsed.Seconds()
+					if run.total == 0 {
+						log.Printf("Progress: %d | Rate: %.0f/s | Failed: %d | Batch: %d", processed, rate, failed, sizer.current())
+						continue
+					}
+					remaining := float64(run.total-processed) / rate
+					log.Printf("Progress: %d/%d (%.1f%%) | Rate: %.0f/s | Failed: %d | Batch: %d | ETA: %v",
+						processed, run.total, float64(processed)/float64(run.total)*100,
+						rate, failed, sizer.current(), time.Duration(remaining)*time.Second)
 				}
 			}
 		}
 	}()
 
-	// Start workers - use cached steps, no DB query per variant!
+	// Start workers - use cached steps, no DB query per variant! Each stops when workChan
+	// closes or worker tuning takes it away.
 	var wg sync.WaitGroup
-	for i := 0; i < wp.workerCount; i++ {
+	workers := &workerSet{}
+	startWorker := func(workerID int) {
 		wg.Add(1)
-		go func(workerID int) {
+		go func() {
 			defer wg.Done()
-			for work := range workChan {
+			for !workers.retire(workerID) {
+				work, ok := <-workChan
+				if !ok {
+					return
+				}
 				steps, ok := routingStepsCache[work.RoutingID]
-				if !ok || len(steps) == 0 {
-					atomic.AddInt64(&failedCount, 1)
+				if !ok {
+					// Routing first used after the cache was warmed
+					steps, _ = wp.engine.processStepRepo.GetByRoutingID(ctx, work.RoutingID)
+				}
+				if len(steps) == 0 {
+					atomic.AddInt64(&failedCount, int64(len(work.VariantIDs)))
+					msg := fmt.Sprintf("routing %s has no process steps", work.RoutingID)
+					errMsgs := make([]string, len(work.VariantIDs))
+					for i := range errMsgs {
+						errMsgs[i] = msg
+					}
+					wp.recordFailures(ctx, run.jobID, work.VariantIDs, errMsgs)
+					tracker.done(work.Page, 0, int64(len(work.VariantIDs)))
 					continue
 				}
-				summary := wp.engine.CalculateVariantFast(work.ID, steps, baseParams)
-				resultChan <- summary
+				summaries, errs := wp.engine.calculateBatch(work.VariantIDs, steps, programs, work.ParamSets, work.Hashes)
+				var failedIDs []uuid.UUID
+				var errMsgs []string
+				for i, summary := range summaries {
+					if errs[i] != nil {
+						// Log only the first failure; a broken formula would otherwise flood the log
+						if atomic.AddInt64(&failedCount, 1) == start.Failed+1 {
+							log.Printf("Variant %s failed: %v", work.VariantIDs[i], errs[i])
+						}
+						failedIDs = append(failedIDs, work.VariantIDs[i])
+						errMsgs = append(errMsgs, errs[i].Error())
+						continue
+					}
+					result := pageResult{summary: summary, page: work.Page}
+					select {
+					case resultChan <- result:
+					default:
+						blocked := time.Now()
+						resultChan <- result
+						stalled.Add(int64(time.Since(blocked)))
+					}
+				}
+				if len(failedIDs) > 0 {
+					wp.recordFailures(ctx, run.jobID, failedIDs, errMsgs)
+					tracker.done(work.Page, 0, int64(len(failedIDs)))
+				}
 			}
-		}(i)
+		}()
 	}
+	workers.resize(workerCount, startWorker)
 
-	// Start result collector
+	// Start result collectors, each writing its own batches
 	var resultWg sync.WaitGroup
-	resultWg.Add(1)
-	go func() {
-		defer resultWg.Done()
-		buffer := make([]*entity.VariantCostSummary, 0, wp.batchSize)
-
-		for summary := range resultChan {
-			buffer = append(buffer, summary)
-
-			if len(buffer) >= wp.batchSize {
-				if _, err := wp.summaryRepo.UpsertBatch(ctx, buffer); err != nil {
-					log.Printf("Failed to upsert batch: %v", err)
-				}
-				atomic.AddInt64(&processedCount, int64(len(buffer)))
-
-				// Update job progress periodically
-				wp.jobRepo.UpdateProgress(ctx, jobID, int64(len(buffer)), 0)
+	for i := 0; i < wp.writerCount; i++ {
+		resultWg.Add(1)
+		go func() {
+			defer resultWg.Done()
+			wp.collectResults(ctx, run.progressJobID, resultChan, sizer, &throttled, &processedCount, tracker, failing, failure)
+		}()
+	}
 
-				buffer = buffer[:0]
+	// dispatch records variants, every variant of lane after afterID through lastID, as a
+	// chunk and page and sends them to the workers grouped by routing. It returns false
+	// when dispatching stopped before every group was sent.
+	dispatch := func(lane int, afterID, lastID uuid.UUID, variants []*entity.YarnVariant) bool {
+		masterParams, err := wp.masterParams(ctx, variants, baseParams)
+		if err != nil {
+			log.Printf("Failed to load master attributes: %v", err)
+			return false
+		}
+		chunk := &entity.JobChunk{JobID: run.jobID, AfterVariantID: afterID, ThroughVariantID: lastID}
+		if err := wp.chunkRepo.Start(ctx, chunk); err != nil {
+			log.Printf("Failed to record chunk after %s: %v", afterID, err)
+		}
+		page := tracker.add(lane, afterID, lastID, len(variants))
+		groups := make(map[uuid.UUID]*variantBatch)
+		var routingOrder []uuid.UUID
+		for _, v := range variants {
+			group, ok := groups[v.RoutingTemplateID]
+			if !ok {
+				group = &variantBatch{Page: page, RoutingID: v.RoutingTemplateID}
+				groups[v.RoutingTemplateID] = group
+				routingOrder = append(routingOrder, v.RoutingTemplateID)
 			}
+			group.VariantIDs = append(group.VariantIDs, v.ID)
+			group.ParamSets = append(group.ParamSets, masterParams[v.MasterYarnID])
+			group.Hashes = append(group.Hashes, hashes.get(v.MasterYarnID, masterParams[v.MasterYarnID]))
 		}
-
-		// Flush remaining
-		if len(buffer) > 0 {
-			if _, err := wp.summaryRepo.UpsertBatch(ctx, buffer); err != nil {
-				log.Printf("Failed to upsert final batch: %v", err)
+		for _, routingID := range routingOrder {
+			select {
+			case <-dispatchCtx.Done():
+				// Groups of this page not sent keep it open, so the checkpoint stays before it
+				return false
+			case workChan <- *groups[routingID]:
 			}
-			atomic.AddInt64(&processedCount, int64(len(buffer)))
 		}
-	}()
+		return true
+	}
 
-	// Dispatcher: fetch variant IDs WITH routing IDs in batches
-	go func() {
-		defer close(workChan)
-		offset := 0
-		for {
-			variants, err := wp.variantRepo.ListWithRouting(ctx, wp.batchSize, offset)
-			if err != nil {
-				log.Printf("Failed to list variants: %v", err)
-				return
+	// scan dispatches the variants of lane in ID order. Each stretch between chunks an
+	// earlier run completed is read by one streaming query and cut into pages as rows
+	// arrive, so a lane is planned once per stretch instead of once per page. Completed
+	// chunks are skipped, and a page never runs into one, so they still line up when page
+	// sizes differ from the earlier run's.
+	scan := func(lane int, afterID, through uuid.UUID) {
+		for afterID != through {
+			if chunk, ok := skipChunks[afterID]; ok && compareIDs(chunk.ThroughVariantID, through) <= 0 {
+				tracker.skip(lane, chunk)
+				atomic.AddInt64(&processedCount, chunk.ProcessedRecords)
+				atomic.AddInt64(&failedCount, chunk.FailedRecords)
+				if run.progressJobID != uuid.Nil {
+					wp.jobRepo.UpdateProgress(ctx, run.progressJobID, chunk.ProcessedRecords, chunk.FailedRecords)
+				}
+				afterID = chunk.ThroughVariantID
+				continue
 			}
-			if len(variants) == 0 {
-				break
+			stretchThrough := through
+			next := sort.Search(len(completed), func(i int) bool { return compareIDs(completed[i].AfterVariantID, afterID) > 0 })
+			if next < len(completed) && compareIDs(completed[next].AfterVariantID, through) < 0 {
+				stretchThrough = completed[next].AfterVariantID
 			}
-			for _, v := range variants {
-				select {
-				case <-ctx.Done():
-					return
-				case workChan <- variantWork{ID: v.ID, RoutingID: v.RoutingTemplateID}:
+
+			limit := sizer.current()
+			variants := make([]*entity.YarnVariant, 0, limit)
+			err := wp.variantRepo.StreamWithRouting(dispatchCtx, run.routingID, afterID, stretchThrough, func(v *entity.YarnVariant) error {
+				variants = append(variants, v)
+				if len(variants) < limit {
+					return nil
+				}
+				lastID := v.ID
+				if !dispatch(lane, afterID, lastID, variants) {
+					return errStopScan
 				}
+				afterID = lastID
+				limit = sizer.current()
+				variants = make([]*entity.YarnVariant, 0, limit)
+				return nil
+			})
+			if dispatchCtx.Err() != nil || errors.Is(err, errStopScan) {
+				return
 			}
-			offset += len(variants)
+			if err != nil {
+				log.Printf("Failed to stream variants: %v", err)
+				return
+			}
+			// The last, short page covers the rest of the stretch
+			if len(variants) > 0 && !dispatch(lane, afterID, stretchThrough, variants) {
+				return
+			}
+			afterID = stretchThrough
 		}
+		tracker.close(lane)
+	}
+
+	// Dispatchers: one scanner per lane fetches variant IDs WITH routing IDs, from the checkpoint
+	var scanWg sync.WaitGroup
+	for i, l := range lanes {
+		scanWg.Add(1)
+		go func() {
+			defer scanWg.Done()
+			scan(i, l.after, l.through)
+		}()
+	}
+	scanned := make(chan struct{})
+	go func() {
+		scanWg.Wait()
+		close(workChan)
+		close(scanned)
 	}()
 
+	// The tuner counts as a worker so the WaitGroup stays open while it may add workers
+	if autoWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wp.tuneWorkers(ctx, run.name, scanned, workers, startWorker, sizer, &stalled)
+		}()
+	}
+
 	// Wait for workers to finish
 	wg.Wait()
 	close(resultChan)
 
-	// Wait for result collector
+	// Wait for result collectors
 	resultWg.Wait()
 
 	// Stop progress reporter
 	close(progressDone)
+	wp.saveCheckpoint(ctx, run, tracker)
 
 	// Calculate final metrics
 	elapsed := time.Since(startTime)
-	finalProcessed := atomic.LoadInt64(&processedCount)
-	finalFailed := atomic.LoadInt64(&failedCount)
-	throughput := float64(finalProcessed) / elapsed.Seconds()
+	var endMem runtime.MemStats
+	runtime.ReadMemStats(&endMem)
+	sampleHeap(&heapPeak)
+	minBatch, maxBatch := sizer.bounds()
+	metrics := &entity.JobMetrics{
+		Processed:       atomic.LoadInt64(&processedCount) - start.Processed,
+		Failed:          atomic.LoadInt64(&failedCount) - start.Failed,
+		ElapsedSeconds:  elapsed.Seconds(),
+		CacheSeconds:    cacheTime.Seconds(),
+		ProcessSeconds:  (elapsed - cacheTime).Seconds(),
+		Procs:           runtime.GOMAXPROCS(0),
+		Workers:         workers.size(),
+		AutoWorkers:     autoWorkers,
+		Writers:         wp.writerCount,
+		Scanners:        wp.scanCount,
+		BatchSize:       batchSize,
+		MinBatchSize:    minBatch,
+		MaxBatchSize:    maxBatch,
+		StalledSeconds:  time.Duration(stalled.Load()).Seconds(),
+		ThrottleSeconds: time.Duration(throttled.Load()).Seconds(),
+		HeapAllocBytes:  endMem.HeapAlloc,
+		HeapPeakBytes:   heapPeak.Load(),
+		SysBytes:        endMem.Sys,
+		TotalAllocBytes: endMem.TotalAlloc - startMem.TotalAlloc,
+		NumGC:           endMem.NumGC - startMem.NumGC,
+		Stopped:         paused.Load(),
+		RecordedAt:      time.Now(),
+	}
+	if elapsed > 0 {
+		metrics.Throughput = float64(metrics.Processed) / elapsed.Seconds()
+	}
+	if run.metrics != nil {
+		if err := run.metrics(ctx, metrics); err != nil {
+			log.Printf("Failed to record metrics of job %s: %v", run.name, err)
+		}
+	}
+
+	checkpoint := tracker.checkpoint
+	if failure.err != nil {
+		log.Printf("Job %s failed after variant %s (%d processed, %d failed)", run.name, checkpoint.AfterVariantID, checkpoint.Processed, checkpoint.Failed)
+		return checkpoint, false, failure.err
+	}
+	if paused.Load() {
+		log.Printf("Job %s stopped after variant %s (%d processed, %d failed)", run.name, checkpoint.AfterVariantID, checkpoint.Processed, checkpoint.Failed)
+		return checkpoint, true, nil
+	}
 
 	// Print performance summary
 	fmt.Println()
@@ -298,20 +918,239 @@ func (wp *WorkerPool) RecalculateAll(ctx context.Context, jobID uuid.UUID, baseP
 	fmt.Println("║              RECALCULATION PERFORMANCE SUMMARY                ║")
 	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
 	fmt.Printf("║  %-20s %38v ║\n", "Total Time:", elapsed.Round(time.Millisecond))
-	fmt.Printf("║  %-20s %38d ║\n", "Total Processed:", finalProcessed)
-	fmt.Printf("║  %-20s %38d ║\n", "Total Failed:", finalFailed)
-	fmt.Printf("║  %-20s %34.0f /s ║\n", "Throughput:", throughput)
+	fmt.Printf("║  %-20s %38v ║\n", "Cache Load:", cacheTime.Round(time.Millisecond))
+	fmt.Printf("║  %-20s %38d ║\n", "Total Processed:", metrics.Processed)
+	fmt.Printf("║  %-20s %38d ║\n", "Total Failed:", metrics.Failed)
+	fmt.Printf("║  %-20s %34.0f /s ║\n", "Throughput:", metrics.Throughput)
+	fmt.Printf("║  %-20s %38s ║\n", "Parallelism:", fmt.Sprintf("%d procs, %d workers, %d writers", metrics.Procs, metrics.Workers, wp.writerCount))
+	fmt.Printf("║  %-20s %35.1f MB ║\n", "Peak Heap:", float64(metrics.HeapPeakBytes)/1024/1024)
+	fmt.Printf("║  %-20s %38d ║\n", "GC Cycles:", metrics.NumGC)
 	fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
 
-	// Complete job
+	return checkpoint, false, nil
+}
+
+// errStopScan ends a variant stream once dispatching stops
+var errStopScan = errors.New("scan stopped")
+
+// compareIDs orders variant IDs as PostgreSQL does, bytewise
+func compareIDs(a, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// sampleHeap raises peak to the current heap allocation if it is higher
+func sampleHeap(peak *atomic.Uint64) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	for {
+		old := peak.Load()
+		if mem.HeapAlloc <= old || peak.CompareAndSwap(old, mem.HeapAlloc) {
+			return
+		}
+	}
+}
+
+// RecalculateVariant recalculates and stores the cost summary of a single variant
+func (wp *WorkerPool) RecalculateVariant(ctx context.Context, jobID, variantID uuid.UUID, baseParams map[string]interface{}) error {
+	wp.jobRepo.UpdateStatus(ctx, jobID, entity.JobStatusRunning, 0, 0)
+
+	summary, err := wp.engine.CalculateVariant(ctx, variantID, baseParams)
+	if err != nil {
+		return fmt.Errorf("failed to calculate variant %s: %w", variantID, err)
+	}
+	if err := wp.summaryRepo.Upsert(ctx, summary); err != nil {
+		return fmt.Errorf("failed to save summary of variant %s: %w", variantID, err)
+	}
+	wp.jobRepo.UpdateProgress(ctx, jobID, 1, 0)
+
 	if err := wp.jobRepo.Complete(ctx, jobID); err != nil {
 		return fmt.Errorf("failed to complete job: %w", err)
 	}
-
-	log.Printf("Job %s completed successfully", jobID)
 	return nil
 }
 
+// pageResult is a calculated summary with the dispatched page its variant came from
+type pageResult struct {
+	summary *entity.VariantCostSummary
+	page    int
+}
+
+// runFailure is the error that failed a run. Recording it stops dispatching; variants
+// already dispatched are drained without being written.
+type runFailure struct {
+	name   string // job or partition, for logs
+	stop   context.CancelFunc
+	once   sync.Once
+	failed atomic.Bool
+	err    error // first recorded error; read once the collectors are done
+}
+
+// record fails the run with err unless it already failed
+func (f *runFailure) record(err error) {
+	f.once.Do(func() {
+		f.err = err
+		f.failed.Store(true)
+		log.Printf("Job %s failing, stopping dispatch: %v", f.name, err)
+		f.stop()
+	})
+}
+
+// collectResults upserts summaries from resultChan in batches of sizer's current size
+// until it is closed, reporting each written batch to tracker and, unless jobID is
+// uuid.Nil, to the job's progress. Failures recorded for written variants in failing
+// are cleared. A batch that cannot be written, after the summary repository's retries,
+// fails the run.
+func (wp *WorkerPool) collectResults(ctx context.Context, jobID uuid.UUID, resultChan <-chan pageResult, sizer *batchSizer, throttled *atomic.Int64, processedCount *int64, tracker *checkpointTracker, failing map[uuid.UUID]struct{}, failure *runFailure) {
+	buffer := make([]*entity.VariantCostSummary, 0, sizer.current())
+	pages := make(map[int]int64)
+	var recovered []uuid.UUID
+
+	for result := range resultChan {
+		if _, ok := failing[result.summary.YarnVariantID]; ok {
+			recovered = append(recovered, result.summary.YarnVariantID)
+		}
+		buffer = append(buffer, result.summary)
+		pages[result.page]++
+
+		if len(buffer) >= sizer.current() {
+			if !wp.flushBatch(ctx, buffer, pages, sizer, throttled, failure) {
+				buffer, recovered = buffer[:0], recovered[:0]
+				continue
+			}
+			atomic.AddInt64(processedCount, int64(len(buffer)))
+
+			// Update job progress periodically
+			if jobID != uuid.Nil {
+				wp.jobRepo.UpdateProgress(ctx, jobID, int64(len(buffer)), 0)
+			}
+
+			buffer = buffer[:0]
+			wp.markWritten(tracker, pages)
+			recovered = wp.clearFailures(ctx, recovered)
+		}
+	}
+
+	// Flush remaining
+	if len(buffer) > 0 {
+		if !wp.flushBatch(ctx, buffer, pages, sizer, throttled, failure) {
+			return
+		}
+		atomic.AddInt64(processedCount, int64(len(buffer)))
+		wp.markWritten(tracker, pages)
+	}
+	wp.clearFailures(ctx, recovered)
+}
+
+// flushBatch writes summaries unless the run already failed and reports whether they
+// were written. A batch not written fails the run, and its page counts are dropped
+// without reaching the tracker, so the checkpoint stays before its pages and a retried
+// job writes them again.
+func (wp *WorkerPool) flushBatch(ctx context.Context, summaries []*entity.VariantCostSummary, pages map[int]int64, sizer *batchSizer, throttled *atomic.Int64, failure *runFailure) bool {
+	if failure.failed.Load() {
+		releaseSummaries(summaries)
+	} else if err := wp.writeBatch(ctx, summaries, sizer, throttled); err != nil {
+		failure.record(fmt.Errorf("failed to upsert batch of %d summaries: %w", len(summaries), err))
+	} else {
+		return true
+	}
+	clear(pages)
+	return false
+}
+
+// writeBatch upserts summaries once the write throttle allows, adding the time it waited
+// to throttled and reporting the upsert's latency to sizer. The summaries go back to the
+// pool, written or not.
+func (wp *WorkerPool) writeBatch(ctx context.Context, summaries []*entity.VariantCostSummary, sizer *batchSizer, throttled *atomic.Int64) error {
+	throttled.Add(int64(wp.limiter.acquire(ctx, len(summaries))))
+	defer wp.limiter.release()
+
+	start := time.Now()
+	_, err := wp.summaryRepo.UpsertBatch(ctx, summaries)
+	sizer.observe(len(summaries), time.Since(start))
+	releaseSummaries(summaries)
+	return err
+}
+
+// clearFailures clears the recorded failures of variants written again and returns ids
+// emptied for reuse
+func (wp *WorkerPool) clearFailures(ctx context.Context, ids []uuid.UUID) []uuid.UUID {
+	if len(ids) == 0 {
+		return ids
+	}
+	if err := wp.deadLetters.ClearFailures(ctx, ids); err != nil {
+		log.Printf("Failed to clear failures of %d variants: %v", len(ids), err)
+	}
+	return ids[:0]
+}
+
+// recordFailures counts a failure of each variant, dead-lettering those that failed
+// deadAfter times in a row
+func (wp *WorkerPool) recordFailures(ctx context.Context, jobID uuid.UUID, ids []uuid.UUID, errMsgs []string) {
+	if err := wp.deadLetters.RecordFailures(ctx, jobID, ids, errMsgs, wp.deadAfter); err != nil {
+		log.Printf("Failed to record failures of %d variants: %v", len(ids), err)
+	}
+}
+
+// countVariants returns the number of variants a full recalculation covers, leaving
+// out dead-lettered ones
+func (wp *WorkerPool) countVariants(ctx context.Context) (int64, error) {
+	total, err := wp.variantRepo.Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count variants: %w", err)
+	}
+	dead, err := wp.deadLetters.Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count dead-lettered variants: %w", err)
+	}
+	return total - dead, nil
+}
+
+// markWritten reports the per-page counts of a written batch to tracker and resets them
+func (wp *WorkerPool) markWritten(tracker *checkpointTracker, pages map[int]int64) {
+	for page, n := range pages {
+		tracker.done(page, n, 0)
+		delete(pages, page)
+	}
+}
+
+// saveCheckpoint records run's chunks completed since the last save, then its checkpoint
+// if it advanced
+func (wp *WorkerPool) saveCheckpoint(ctx context.Context, run recalcRun, tracker *checkpointTracker) {
+	chunks, checkpoint := tracker.take()
+	if err := wp.chunkRepo.Complete(ctx, chunks); err != nil {
+		log.Printf("Failed to record completed chunks of job %s: %v", run.name, err)
+	}
+	if checkpoint == nil {
+		return
+	}
+	if err := run.save(ctx, checkpoint); err != nil {
+		log.Printf("Failed to save checkpoint of job %s: %v", run.name, err)
+	}
+}
+
+// masterParams returns baseParams merged with the fixed attributes of each master yarn
+// referenced by variants, keyed by master ID. Variants of one master share a map.
+func (wp *WorkerPool) masterParams(ctx context.Context, variants []*entity.YarnVariant, baseParams map[string]interface{}) (map[uuid.UUID]map[string]interface{}, error) {
+	var masterIDs []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, v := range variants {
+		if !seen[v.MasterYarnID] {
+			seen[v.MasterYarnID] = true
+			masterIDs = append(masterIDs, v.MasterYarnID)
+		}
+	}
+
+	attrs, err := wp.engine.masterRepo.GetFixedAttrs(ctx, masterIDs)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[uuid.UUID]map[string]interface{}, len(masterIDs))
+	for _, id := range masterIDs {
+		params[id] = MergeParams(baseParams, AttrParams(attrs[id]))
+	}
+	return params, nil
+}
+
 // loadRoutingStepsCache loads all routing templates with their process steps into memory
 func (wp *WorkerPool) loadRoutingStepsCache(ctx context.Context) (map[uuid.UUID][]*entity.ProcessStep, error) {
 	cache := make(map[uuid.UUID][]*entity.ProcessStep)
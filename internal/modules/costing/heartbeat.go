@@ -0,0 +1,83 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// StartHeartbeat refreshes the job's heartbeat every interval until the returned stop
+// function is called or ctx is done, so reapers can tell it is still being worked on
+func StartHeartbeat(ctx context.Context, jobRepo repository.BatchJobRepository, jobID uuid.UUID, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := jobRepo.Heartbeat(ctx, jobID); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to record heartbeat of job %s: %v", jobID, err)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// StaleJobReaper releases RUNNING jobs whose worker stopped heartbeating, most likely
+// because it crashed: back to PENDING while attempts remain, otherwise FAILED
+type StaleJobReaper struct {
+	jobRepo    repository.BatchJobRepository
+	staleAfter time.Duration
+}
+
+// NewStaleJobReaper creates a reaper for jobs without a heartbeat for staleAfter
+func NewStaleJobReaper(jobRepo repository.BatchJobRepository, staleAfter time.Duration) *StaleJobReaper {
+	return &StaleJobReaper{jobRepo: jobRepo, staleAfter: staleAfter}
+}
+
+// Reap releases the jobs stale at now and returns those it released, with their new
+// status set. Jobs that heartbeat again or are released by another reaper are skipped.
+func (r *StaleJobReaper) Reap(ctx context.Context, now time.Time) ([]*entity.BatchJob, error) {
+	staleBefore := now.Add(-r.staleAfter)
+	jobs, err := r.jobRepo.ListStale(ctx, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	var released []*entity.BatchJob
+	for _, job := range jobs {
+		lastSeen := job.CreatedAt
+		for _, t := range []*time.Time{job.StartedAt, job.ClaimedAt, job.HeartbeatAt} {
+			if t != nil {
+				lastSeen = *t
+			}
+		}
+		status := entity.JobStatusFailed
+		if job.CanRetry() {
+			status = entity.JobStatusPending
+		}
+		errorMsg := fmt.Sprintf("worker %s stopped sending heartbeats (last at %s, stale after %v)",
+			job.ClaimedBy, lastSeen.Format(time.RFC3339), r.staleAfter)
+
+		ok, err := r.jobRepo.ReleaseStale(ctx, job.ID, staleBefore, status, errorMsg)
+		if err != nil {
+			return released, fmt.Errorf("failed to release job %s: %w", job.ID, err)
+		}
+		if ok {
+			job.Status = status
+			job.ErrorMessage = errorMsg
+			released = append(released, job)
+		}
+	}
+	return released, nil
+}
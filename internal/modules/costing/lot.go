@@ -0,0 +1,67 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// LotCostingService costs production lots against the variant's standard cost
+type LotCostingService struct {
+	engine  *CalculationEngine
+	lotRepo repository.ProductionLotRepository
+}
+
+// NewLotCostingService creates a new lot costing service
+func NewLotCostingService(engine *CalculationEngine, lotRepo repository.ProductionLotRepository) *LotCostingService {
+	return &LotCostingService{
+		engine:  engine,
+		lotRepo: lotRepo,
+	}
+}
+
+// RecordActuals stores measured parameter values for a lot and computes its standard
+// unit cost (base params) and actual unit cost (base params overridden by the actuals)
+func (s *LotCostingService) RecordActuals(ctx context.Context, lotID uuid.UUID, actualValues, baseParams map[string]interface{}) (*entity.ProductionLot, error) {
+	lot, err := s.lotRepo.GetByID(ctx, lotID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lot: %w", err)
+	}
+	if lot.Status == entity.LotStatusClosed {
+		return nil, fmt.Errorf("lot %s is closed", lot.BatchNo)
+	}
+
+	standard, err := s.engine.CalculateVariant(ctx, lot.YarnVariantID, baseParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate standard cost: %w", err)
+	}
+	actual, err := s.engine.CalculateVariant(ctx, lot.YarnVariantID, MergeParams(baseParams, actualValues))
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate actual cost: %w", err)
+	}
+
+	if err := s.lotRepo.UpdateCosts(ctx, lotID, actualValues, standard.GrandTotal, actual.GrandTotal); err != nil {
+		return nil, fmt.Errorf("failed to save lot costs: %w", err)
+	}
+	return s.lotRepo.GetByID(ctx, lotID)
+}
+
+// ChangeStatus moves a lot through its lifecycle, rejecting invalid transitions
+func (s *LotCostingService) ChangeStatus(ctx context.Context, lotID uuid.UUID, status entity.LotStatus) (*entity.ProductionLot, error) {
+	lot, err := s.lotRepo.GetByID(ctx, lotID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lot: %w", err)
+	}
+	if !lot.Status.CanTransitionTo(status) {
+		return nil, fmt.Errorf("cannot move lot from %s to %s", lot.Status, status)
+	}
+	if err := s.lotRepo.UpdateStatus(ctx, lotID, status); err != nil {
+		return nil, err
+	}
+	lot.Status = status
+	return lot, nil
+}
@@ -0,0 +1,40 @@
+package costing
+
+import (
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// SummarizeJobMetrics aggregates the recorded metrics of jobs of jobType, listed most
+// recent first, into totals, averages and a trend in recording order. Jobs without
+// metrics are skipped.
+func SummarizeJobMetrics(jobType entity.JobType, jobs []*entity.BatchJob) *entity.JobMetricsSummary {
+	summary := &entity.JobMetricsSummary{JobType: jobType, Trend: []entity.JobMetricsPoint{}}
+	var throughput, elapsed float64
+	for i := len(jobs) - 1; i >= 0; i-- {
+		metrics, ok := jobs[i].Metrics()
+		if !ok {
+			continue
+		}
+		summary.Jobs++
+		summary.Processed += metrics.Processed
+		summary.Failed += metrics.Failed
+		throughput += metrics.Throughput
+		elapsed += metrics.ElapsedSeconds
+		if metrics.HeapPeakBytes > summary.MaxHeapPeakBytes {
+			summary.MaxHeapPeakBytes = metrics.HeapPeakBytes
+		}
+		summary.Trend = append(summary.Trend, entity.JobMetricsPoint{
+			JobID:          jobs[i].ID,
+			RecordedAt:     metrics.RecordedAt,
+			Processed:      metrics.Processed,
+			Failed:         metrics.Failed,
+			Throughput:     metrics.Throughput,
+			ElapsedSeconds: metrics.ElapsedSeconds,
+		})
+	}
+	if summary.Jobs > 0 {
+		summary.AvgThroughput = throughput / float64(summary.Jobs)
+		summary.AvgElapsedSeconds = elapsed / float64(summary.Jobs)
+	}
+	return summary
+}
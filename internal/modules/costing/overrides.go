@@ -0,0 +1,41 @@
+package costing
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// ValidateOverrides checks the worker count and batch size overrides in job metadata,
+// where set, against the configured maximums
+func ValidateOverrides(metadata map[string]interface{}, maxWorkerCount, maxBatchSize int) error {
+	limits := []struct {
+		key string
+		max int
+	}{
+		{entity.JobWorkerCountKey, maxWorkerCount},
+		{entity.JobBatchSizeKey, maxBatchSize},
+	}
+	for _, limit := range limits {
+		raw, ok := metadata[limit.key]
+		if !ok {
+			continue
+		}
+		var value float64
+		switch v := raw.(type) {
+		case int:
+			value = float64(v)
+		case int64:
+			value = float64(v)
+		case float64:
+			value = v
+		default:
+			return fmt.Errorf("%s must be a number", limit.key)
+		}
+		if value != math.Trunc(value) || value < 1 || value > float64(limit.max) {
+			return fmt.Errorf("%s must be a whole number between 1 and %d", limit.key, limit.max)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package costing
+
+import (
+	"fmt"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+// DefaultBaseParams returns the base parameters used for recalculation
+// before current price_rates are applied on top (see RoutingCache)
+func DefaultBaseParams() map[string]interface{} {
+	return map[string]interface{}{
+		"material_price":      50.0,
+		"electricity_rate":    1.5,
+		"labor_rate":          25.0,
+		"spindle_rate":        15.0,
+		"loom_rate":           20.0,
+		"dye_price":           100.0,
+		"water_rate":          0.02,
+		"steam_rate":          10.0,
+		"finishing_rate":      12.0,
+		"chemical_price":      80.0,
+		"packaging_price":     5.0,
+		"overhead_percentage": 0.1,
+		"raw_material_kg":     100.0,
+		"electricity_kwh_1":   50.0,
+		"labor_hours_1":       8.0,
+		"input_cost_1":        5000.0,
+		"spindle_hours":       10.0,
+		"labor_hours_2":       6.0,
+		"input_cost_2":        6000.0,
+		"loom_hours":          8.0,
+		"labor_hours_3":       5.0,
+		"input_cost_3":        7000.0,
+		"dye_kg":              2.5,
+		"water_liters":        500.0,
+		"steam_hours":         5.0,
+		"input_cost_4":        8000.0,
+		"finishing_hours":     4.0,
+		"chemical_kg":         1.5,
+		"input_cost_5":        9000.0,
+		"packaging_units":     10.0,
+		"labor_hours_6":       3.0,
+		"material_cost":       1000.0,
+	}
+}
+
+// MergeParams returns a copy of base with overrides applied on top
+func MergeParams(base, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// AttrParams converts master yarn fixed_attrs into formula parameters so formulas can
+// branch on them, e.g. fiber_type == "silk" ? dye_kg * 1.3 : dye_kg. Numbers become
+// float64, strings and booleans keep their type, and other values are dropped.
+func AttrParams(attrs map[string]interface{}) map[string]interface{} {
+	params := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		switch val := v.(type) {
+		case float64, string, bool:
+			params[k] = val
+		case float32:
+			params[k] = float64(val)
+		case int:
+			params[k] = float64(val)
+		case int64:
+			params[k] = float64(val)
+		}
+	}
+	return params
+}
+
+// ResolveRates applies current price rates over base. Rates defined by an expression
+// are evaluated against base and the other rates, dependencies first; a cycle between
+// derived rates is an error.
+func ResolveRates(parser *formula.Parser, base map[string]interface{}, rates []*entity.PriceRate) (map[string]interface{}, error) {
+	params := MergeParams(base, nil)
+	derived := make(map[string]string)
+	for _, rate := range rates {
+		if rate.RateExpression != "" {
+			derived[rate.ParameterKey] = rate.RateExpression
+		} else {
+			params[rate.ParameterKey] = rate.RateValue
+		}
+	}
+	if len(derived) == 0 {
+		return params, nil
+	}
+
+	values, err := parser.ResolveDerived(params, derived)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve derived rates: %w", err)
+	}
+	for k, v := range values {
+		params[k] = v
+	}
+	return params, nil
+}
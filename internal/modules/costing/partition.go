@@ -0,0 +1,94 @@
+package costing
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// SplitVariantRange splits the variant ID space into n contiguous ranges of equal width.
+// Variant IDs are random (v4) UUIDs, so the ranges hold about as many variants each.
+func SplitVariantRange(n int) []*entity.JobPartition {
+	bounds := splitRange(uuid.Nil, uuid.Max, n)
+	partitions := make([]*entity.JobPartition, len(bounds))
+	for i := range partitions {
+		p := &entity.JobPartition{PartitionNo: i, Status: entity.JobStatusPending, ThroughVariantID: bounds[i]}
+		if i > 0 {
+			p.AfterVariantID = bounds[i-1]
+		}
+		partitions[i] = p
+	}
+	return partitions
+}
+
+// splitRange splits the variant IDs in (after, through] into at most n contiguous ranges
+// of equal width and returns the last ID of each, ending with through. A range too
+// narrow to split stays whole.
+func splitRange(after, through uuid.UUID, n int) []uuid.UUID {
+	lo, hi := binary.BigEndian.Uint64(after[:8]), binary.BigEndian.Uint64(through[:8])
+	if n < 1 || hi <= lo {
+		n = 1
+	}
+	width := (hi - lo) / uint64(n)
+	if width < 2 {
+		n = 1
+	}
+	bounds := make([]uuid.UUID, n)
+	for i := 0; i < n-1; i++ {
+		bounds[i] = rangeEnd(lo + uint64(i+1)*width)
+	}
+	bounds[n-1] = through
+	return bounds
+}
+
+// rangeEnd returns the largest ID whose first 8 bytes are below prefix
+func rangeEnd(prefix uint64) uuid.UUID {
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[:8], prefix-1)
+	binary.BigEndian.PutUint64(id[8:], math.MaxUint64)
+	return id
+}
+
+// PartitionJob splits a full recalculation job into n partitions for worker replicas to
+// claim with RecalculatePartition. Partitions of an earlier run of the job are kept.
+func (wp *WorkerPool) PartitionJob(ctx context.Context, jobID uuid.UUID, n int) error {
+	totalCount, err := wp.countVariants(ctx)
+	if err != nil {
+		return err
+	}
+	return wp.partitionRepo.Create(ctx, jobID, totalCount, SplitVariantRange(n))
+}
+
+// RecalculatePartition recalculates a claimed partition of job from its checkpoint,
+// with the job's overrides. It stops early, leaving the partition for a later claim, when
+// the job leaves RUNNING (paused, reaped or failed). Reports whether finishing the
+// partition completed the job.
+func (wp *WorkerPool) RecalculatePartition(ctx context.Context, job *entity.BatchJob, partition *entity.JobPartition, baseParams map[string]interface{}) (bool, error) {
+	jobID, partitionNo := partition.JobID, partition.PartitionNo
+	workerCount, batchSize := job.Overrides()
+	checkpoint, stopped, err := wp.recalculate(ctx, recalcRun{
+		name:        fmt.Sprintf("%s#%d", jobID, partitionNo),
+		jobID:       jobID,
+		start:       partition.ResumeCheckpoint(),
+		after:       partition.AfterVariantID,
+		through:     partition.ThroughVariantID,
+		workerCount: workerCount,
+		batchSize:   batchSize,
+		save: func(ctx context.Context, checkpoint *entity.JobCheckpoint) error {
+			return wp.partitionRepo.SaveCheckpoint(ctx, jobID, partitionNo, checkpoint)
+		},
+		stop: func(ctx context.Context) bool {
+			job, err := wp.jobRepo.GetByID(ctx, jobID)
+			return err == nil && job.Status != entity.JobStatusRunning
+		},
+	}, baseParams)
+	if err != nil || stopped {
+		return false, err
+	}
+	return wp.partitionRepo.Complete(ctx, jobID, partitionNo, &checkpoint)
+}
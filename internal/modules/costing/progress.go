@@ -0,0 +1,65 @@
+package costing
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// rateSmoothing weighs the latest sample in the moving average rate; writers report
+// progress a batch at a time, so unsmoothed rates jump between samples
+const rateSmoothing = 0.3
+
+// JobProgressEvent is a snapshot of a job's progress with its current rate and ETA
+type JobProgressEvent struct {
+	JobID      uuid.UUID        `json:"job_id"`
+	Status     entity.JobStatus `json:"status"`
+	Processed  int64            `json:"processed"`
+	Failed     int64            `json:"failed"`
+	Total      int64            `json:"total"`
+	Percent    float64          `json:"percent"`
+	Rate       float64          `json:"rate"`                  // variants per second, smoothed
+	ETASeconds *float64         `json:"eta_seconds,omitempty"` // nil while unknown
+	At         time.Time        `json:"at"`
+}
+
+// ProgressMeter derives a job's rate and ETA from successive snapshots of its counters
+type ProgressMeter struct {
+	sampled   bool
+	lastDone  int64
+	lastAt    time.Time
+	rate      float64
+	rateKnown bool
+}
+
+// Next returns the progress event for job as read at now
+func (m *ProgressMeter) Next(job *entity.BatchJob, now time.Time) JobProgressEvent {
+	done := job.ProcessedRecords + job.FailedRecords
+	if m.sampled && now.After(m.lastAt) && done >= m.lastDone {
+		sample := float64(done-m.lastDone) / now.Sub(m.lastAt).Seconds()
+		if m.rateKnown {
+			m.rate = rateSmoothing*sample + (1-rateSmoothing)*m.rate
+		} else {
+			m.rate, m.rateKnown = sample, true
+		}
+	}
+	m.sampled, m.lastDone, m.lastAt = true, done, now
+
+	event := JobProgressEvent{
+		JobID:     job.ID,
+		Status:    job.Status,
+		Processed: job.ProcessedRecords,
+		Failed:    job.FailedRecords,
+		Total:     job.TotalRecords,
+		Percent:   job.Progress(),
+		Rate:      m.rate,
+		At:        now,
+	}
+	if job.Status == entity.JobStatusRunning && m.rate > 0 && job.TotalRecords > done {
+		eta := float64(job.TotalRecords-done) / m.rate
+		event.ETASeconds = &eta
+	}
+	return event
+}
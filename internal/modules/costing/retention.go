@@ -0,0 +1,46 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// retentionBatch bounds the rows one cleanup statement deletes, keeping its locks short
+const retentionBatch = 1000
+
+// JobCleanup reports what a retention run deleted
+type JobCleanup struct {
+	Before time.Time `json:"before"`
+	Jobs   int64     `json:"jobs"`   // finished jobs, with their partitions, chunks, events and deliveries
+	Events int64     `json:"events"` // events of jobs that are still kept
+}
+
+// PruneJobs deletes finished jobs that finished before now minus retention, and job
+// events recorded before it, in batches until none is left
+func PruneJobs(ctx context.Context, jobRepo repository.BatchJobRepository, eventRepo repository.JobEventRepository, retention time.Duration, now time.Time) (*JobCleanup, error) {
+	cleanup := &JobCleanup{Before: now.Add(-retention)}
+	for {
+		n, err := jobRepo.DeleteFinishedBefore(ctx, cleanup.Before, retentionBatch)
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to delete jobs: %w", err)
+		}
+		cleanup.Jobs += n
+		if n < retentionBatch {
+			break
+		}
+	}
+	for {
+		n, err := eventRepo.DeleteBefore(ctx, cleanup.Before, retentionBatch)
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to delete job events: %w", err)
+		}
+		cleanup.Events += n
+		if n < retentionBatch {
+			break
+		}
+	}
+	return cleanup, nil
+}
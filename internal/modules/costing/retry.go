@@ -0,0 +1,43 @@
+package costing
+
+import (
+	"context"
+	"time"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// RetryPolicy decides what happens to a job whose run failed: it is retried with
+// exponential backoff until it has run MaxAttempts times, then stays FAILED
+type RetryPolicy struct {
+	BaseDelay time.Duration // wait before the first retry
+	MaxDelay  time.Duration // cap on the wait, 0 for none
+}
+
+// Delay returns the wait before retrying a job that has run attempts times:
+// BaseDelay, then doubled for every further attempt, capped at MaxDelay
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts; i++ {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// HandleFailure records a failed run of job: back to PENDING after the backoff delay
+// while attempts remain, otherwise terminally FAILED. It returns the retry delay, or 0
+// when the job failed for good.
+func (p RetryPolicy) HandleFailure(ctx context.Context, jobRepo repository.BatchJobRepository, job *entity.BatchJob, cause error) (time.Duration, error) {
+	if !job.CanRetry() {
+		return 0, jobRepo.Fail(ctx, job.ID, cause.Error())
+	}
+	delay := p.Delay(job.Attempts)
+	return delay, jobRepo.Retry(ctx, job.ID, cause.Error(), time.Now().Add(delay))
+}
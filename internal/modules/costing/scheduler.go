@@ -0,0 +1,100 @@
+package costing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/pkg/cron"
+)
+
+// Scheduler materializes batch jobs from due job schedules
+type Scheduler struct {
+	scheduleRepo repository.JobScheduleRepository
+	maxAttempts  int
+}
+
+// NewScheduler creates a new scheduler; created jobs run up to maxAttempts times
+func NewScheduler(scheduleRepo repository.JobScheduleRepository, maxAttempts int) *Scheduler {
+	return &Scheduler{scheduleRepo: scheduleRepo, maxAttempts: maxAttempts}
+}
+
+// NextRun validates a schedule's cron expression and timezone and returns its first
+// run after now
+func NextRun(expression, timezone string, now time.Time) (time.Time, error) {
+	schedule, err := cron.Parse(expression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	next := schedule.Next(now.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("cron expression never fires")
+	}
+	return next, nil
+}
+
+// RunDue creates a PENDING job for every schedule due at now and advances it to its
+// next run. Runs missed while no worker was up fire once, not once per missed run.
+// Schedules another worker fired first are skipped.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) ([]*entity.BatchJob, error) {
+	schedules, err := s.scheduleRepo.ListDue(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*entity.BatchJob
+	for _, schedule := range schedules {
+		nextRunAt, err := NextRun(schedule.CronExpression, schedule.Timezone, now)
+		if err != nil {
+			log.Printf("Skipping schedule %s: %v", schedule.Name, err)
+			continue
+		}
+
+		job := s.newJob(schedule, now)
+		fired, err := s.scheduleRepo.Fire(ctx, schedule, job, nextRunAt)
+		if err != nil {
+			return jobs, fmt.Errorf("failed to fire schedule %s: %w", schedule.Name, err)
+		}
+		if fired {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *Scheduler) newJob(schedule *entity.JobSchedule, now time.Time) *entity.BatchJob {
+	metadata := make(map[string]interface{}, len(schedule.Params)+1)
+	for k, v := range schedule.Params {
+		metadata[k] = v
+	}
+	metadata["schedule_id"] = schedule.ID.String()
+
+	priority := schedule.JobType.DefaultPriority()
+	if schedule.Priority != nil {
+		priority = *schedule.Priority
+	}
+
+	job := &entity.BatchJob{
+		ID:          uuid.New(),
+		JobType:     schedule.JobType,
+		Status:      entity.JobStatusPending,
+		Priority:    priority,
+		Metadata:    metadata,
+		MaxAttempts: s.maxAttempts,
+		CreatedAt:   now,
+	}
+	if schedule.JobType == entity.JobTypeRecalculateVariant {
+		job.TotalRecords = 1
+	}
+	return job
+}
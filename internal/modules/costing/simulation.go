@@ -0,0 +1,152 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+)
+
+// SimulationService answers what-if questions: how would a variant's cost change if
+// some parameters changed
+type SimulationService struct {
+	engine *CalculationEngine
+}
+
+// NewSimulationService creates a new simulation service
+func NewSimulationService(engine *CalculationEngine) *SimulationService {
+	return &SimulationService{engine: engine}
+}
+
+// Simulate costs the variant with baseParams and again with overrides applied, and
+// diffs the two per step. Each changed parameter is also applied on its own to measure
+// its contribution, so step deltas split into per-parameter bars plus an interaction
+// remainder. Nothing is persisted.
+func (s *SimulationService) Simulate(ctx context.Context, variantID uuid.UUID, baseParams, overrides map[string]interface{}) (*entity.SimulationResult, error) {
+	variant, err := s.engine.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	steps, err := s.engine.processStepRepo.GetByRoutingID(ctx, variant.RoutingTemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get process steps: %w", err)
+	}
+	oldParams, err := s.engine.withMasterAttrs(ctx, variant.MasterYarnID, baseParams)
+	if err != nil {
+		return nil, err
+	}
+	newParams := MergeParams(oldParams, overrides)
+
+	var changed []string
+	for k, v := range overrides {
+		if !reflect.DeepEqual(oldParams[k], v) {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+
+	now := time.Now()
+	oldCosts, oldErrs := s.engine.stepCosts(steps, oldParams)
+	newCosts, newErrs := s.engine.stepCosts(steps, newParams)
+	baseline := buildSummary(variantID, sum(oldCosts), oldParams, paramsHash(oldParams), now)
+	simulated := buildSummary(variantID, sum(newCosts), newParams, paramsHash(newParams), now)
+
+	result := &entity.SimulationResult{
+		YarnVariantID: variantID,
+		Baseline:      baseline,
+		Simulated:     simulated,
+		Delta:         simulated.GrandTotal - baseline.GrandTotal,
+		PctChange:     pctChange(baseline.GrandTotal, simulated.GrandTotal),
+		ChangedParams: changed,
+		Contributions: make([]*entity.ParamContribution, 0, len(changed)),
+		Steps:         make([]*entity.StepCostDiff, len(steps)),
+	}
+	for i, step := range steps {
+		diff := &entity.StepCostDiff{
+			ProcessStepID: step.ID,
+			SequenceOrder: step.SequenceOrder,
+			ProcessCode:   step.ProcessCode,
+			OldCost:       oldCosts[i],
+			NewCost:       newCosts[i],
+			Delta:         newCosts[i] - oldCosts[i],
+			PctChange:     pctChange(oldCosts[i], newCosts[i]),
+		}
+		switch {
+		case newErrs[i] != nil:
+			diff.Error = newErrs[i].Error()
+		case oldErrs[i] != nil:
+			diff.Error = oldErrs[i].Error()
+		}
+		diff.Interaction = diff.Delta
+		result.Steps[i] = diff
+	}
+
+	result.Interaction = result.Delta
+	for _, key := range changed {
+		params := MergeParams(oldParams, map[string]interface{}{key: newParams[key]})
+		costs, _ := s.engine.stepCosts(steps, params)
+		total := buildSummary(variantID, sum(costs), params, "", now).GrandTotal
+
+		contribution := &entity.ParamContribution{
+			Parameter: key,
+			OldValue:  oldParams[key],
+			NewValue:  newParams[key],
+			Delta:     total - baseline.GrandTotal,
+		}
+		result.Contributions = append(result.Contributions, contribution)
+		result.Interaction -= contribution.Delta
+
+		for i, diff := range result.Steps {
+			if delta := costs[i] - oldCosts[i]; delta != 0 {
+				diff.Contributions = append(diff.Contributions, &entity.ParamContribution{
+					Parameter: key,
+					OldValue:  contribution.OldValue,
+					NewValue:  contribution.NewValue,
+					Delta:     delta,
+				})
+				diff.Interaction -= delta
+			}
+		}
+	}
+	return result, nil
+}
+
+// stepCosts evaluates every step in sequence order, exposing earlier results to later
+// steps. A failing step costs zero and reports its error at the same index.
+func (e *CalculationEngine) stepCosts(steps []*entity.ProcessStep, params map[string]interface{}) ([]float64, []error) {
+	costs := make([]float64, len(steps))
+	errs := make([]error, len(steps))
+	results := stepResults{}
+	if usesStepResults(steps) {
+		params = withStepResults(params, results)
+	}
+	for i, step := range steps {
+		costs[i], errs[i] = e.formulaParser.Evaluate(step.FormulaExpression, params)
+		if errs[i] == nil {
+			results.record(step, costs[i])
+		}
+	}
+	return costs, errs
+}
+
+func sum(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// pctChange returns the relative change in percent, or nil when from is zero
+func pctChange(from, to float64) *float64 {
+	if from == 0 {
+		return nil
+	}
+	pct := (to - from) / from * 100
+	return &pct
+}
@@ -0,0 +1,42 @@
+package costing
+
+import (
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+// StepsParam is the parameter through which a formula reads the results of earlier
+// steps of its routing by process code, e.g. steps.SPINNING.cost
+const StepsParam = "steps"
+
+// stepResults holds the results of the steps evaluated so far, keyed by process code
+type stepResults map[string]interface{}
+
+// record makes the step's cost visible to the steps that follow it
+func (r stepResults) record(step *entity.ProcessStep, cost float64) {
+	if step.ProcessCode != "" {
+		r[step.ProcessCode] = map[string]interface{}{"cost": cost}
+	}
+}
+
+// withStepResults returns a copy of params exposing results under StepsParam. The
+// results map is shared, so steps recorded later are visible without copying again.
+func withStepResults(params map[string]interface{}, results stepResults) map[string]interface{} {
+	return MergeParams(params, map[string]interface{}{StepsParam: map[string]interface{}(results)})
+}
+
+// usesStepResults reports whether any step formula references earlier step results
+func usesStepResults(steps []*entity.ProcessStep) bool {
+	for _, step := range steps {
+		names, err := formula.Identifiers(step.FormulaExpression)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if name == StepsParam {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package costing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WriteThrottle caps how fast recalculations write cost summaries, so a full run leaves
+// database connections and I/O to the API during business hours. The limits apply to
+// every run of a worker pool together.
+type WriteThrottle struct {
+	MaxRowsPerSecond     int // summaries upserted per second; 0 for no limit
+	MaxConcurrentUpserts int // upserts in flight at once; 0 for no limit
+}
+
+// writeLimiter applies a WriteThrottle to result writers. Each batch reserves the time
+// its rows take at the allowed rate, so batches are spaced evenly rather than bursting
+// at the start of every second.
+type writeLimiter struct {
+	slots chan struct{} // one per upsert in flight; nil for no limit
+	rate  float64       // rows per second; 0 for no limit
+
+	mu   sync.Mutex
+	next time.Time // when the next batch may start
+}
+
+func newWriteLimiter(throttle WriteThrottle) *writeLimiter {
+	l := &writeLimiter{rate: float64(throttle.MaxRowsPerSecond)}
+	if throttle.MaxConcurrentUpserts > 0 {
+		l.slots = make(chan struct{}, throttle.MaxConcurrentUpserts)
+	}
+	return l
+}
+
+// acquire waits until a batch of n rows may be upserted and returns how long it waited;
+// release must follow once the batch is written. The rate wait ends early when ctx is
+// done, leaving the upsert to fail on it.
+func (l *writeLimiter) acquire(ctx context.Context, n int) time.Duration {
+	start := time.Now()
+	if l.slots != nil {
+		// Holders always release, so this never waits on a stopped run
+		l.slots <- struct{}{}
+	}
+	if l.rate > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait := l.next.Sub(now)
+		l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+		l.mu.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+	}
+	return time.Since(start)
+}
+
+func (l *writeLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
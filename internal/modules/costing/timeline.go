@@ -0,0 +1,96 @@
+package costing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// quantityParam is the input parameter holding the batch weight a routing is costed for
+const quantityParam = "raw_material_kg"
+
+// TimelineService builds planning timelines combining step durations and costs
+type TimelineService struct {
+	engine      *CalculationEngine
+	processRepo repository.ProcessMasterRepository
+}
+
+// NewTimelineService creates a new timeline service
+func NewTimelineService(engine *CalculationEngine, processRepo repository.ProcessMasterRepository) *TimelineService {
+	return &TimelineService{
+		engine:      engine,
+		processRepo: processRepo,
+	}
+}
+
+// Build schedules the variant's routing steps back to back. Each step takes its process
+// setup time plus quantityKg divided by the machine throughput. When quantityKg is zero
+// the raw material quantity from params is used. A step whose formula fails keeps its
+// slot in the schedule and reports the error instead of a cost.
+func (s *TimelineService) Build(ctx context.Context, variantID uuid.UUID, quantityKg float64, params map[string]interface{}) (*entity.ProcessTimeline, error) {
+	variant, err := s.engine.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	steps, err := s.engine.processStepRepo.GetByRoutingID(ctx, variant.RoutingTemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get process steps: %w", err)
+	}
+	params, err = s.engine.withMasterAttrs(ctx, variant.MasterYarnID, params)
+	if err != nil {
+		return nil, err
+	}
+	processes, err := s.processRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	byID := make(map[uuid.UUID]*entity.ProcessMaster, len(processes))
+	for _, p := range processes {
+		byID[p.ID] = p
+	}
+
+	if quantityKg <= 0 {
+		quantityKg = getFloatParam(params, quantityParam, 0)
+	}
+
+	results := stepResults{}
+	if usesStepResults(steps) {
+		params = withStepResults(params, results)
+	}
+
+	timeline := &entity.ProcessTimeline{
+		YarnVariantID: variant.ID,
+		SKU:           variant.SKU,
+		QuantityKg:    quantityKg,
+		Steps:         make([]*entity.TimelineStep, 0, len(steps)),
+	}
+	for _, step := range steps {
+		entry := &entity.TimelineStep{
+			ProcessStepID: step.ID,
+			SequenceOrder: step.SequenceOrder,
+			StartHour:     timeline.TotalHours,
+		}
+		if p, ok := byID[step.ProcessMasterID]; ok {
+			entry.ProcessCode = p.Code
+			entry.ProcessName = p.Name
+			entry.DurationHours, entry.HasRate = p.Duration(quantityKg)
+		}
+		entry.EndHour = entry.StartHour + entry.DurationHours
+
+		if cost, err := s.engine.formulaParser.Evaluate(step.FormulaExpression, params); err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Cost = cost
+			timeline.TotalCost += cost
+			results.record(step, cost)
+		}
+
+		timeline.TotalHours = entry.EndHour
+		timeline.Steps = append(timeline.Steps, entry)
+	}
+	return timeline, nil
+}
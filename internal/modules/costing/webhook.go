@@ -0,0 +1,159 @@
+package costing
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// Headers sent with every webhook delivery. The signature is "sha256=" followed by the
+// hex HMAC-SHA256, keyed with the subscription secret, of the timestamp, a dot and the body.
+const (
+	WebhookEventHeader     = "X-Costing-Event"
+	WebhookDeliveryHeader  = "X-Costing-Delivery"
+	WebhookTimestampHeader = "X-Costing-Timestamp"
+	WebhookSignatureHeader = "X-Costing-Signature"
+)
+
+// webhookBatch bounds how many due deliveries one DeliverDue call claims
+const webhookBatch = 50
+
+// WebhookPayload is the JSON body of a webhook delivery
+type WebhookPayload struct {
+	Event      string           `json:"event"`
+	DeliveryID uuid.UUID        `json:"delivery_id"`
+	Job        *entity.BatchJob `json:"job"` // the job as it is when the delivery is sent
+	SentAt     time.Time        `json:"sent_at"`
+}
+
+// SignWebhook returns the signature header value for body sent at timestamp (Unix seconds)
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSender sends queued job webhook deliveries, retrying failed ones with backoff
+type WebhookSender struct {
+	repo        repository.WebhookRepository
+	jobRepo     repository.BatchJobRepository
+	client      *http.Client
+	retry       RetryPolicy
+	maxAttempts int
+}
+
+// NewWebhookSender creates a sender with a per-request timeout that gives up on a
+// delivery after maxAttempts
+func NewWebhookSender(repo repository.WebhookRepository, jobRepo repository.BatchJobRepository, timeout time.Duration, retry RetryPolicy, maxAttempts int) *WebhookSender {
+	return &WebhookSender{
+		repo:        repo,
+		jobRepo:     jobRepo,
+		client:      &http.Client{Timeout: timeout},
+		retry:       retry,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// DeliverDue claims and sends due deliveries until none is left, returning how many were
+// delivered and how many failed. Other senders skip deliveries claimed here.
+func (s *WebhookSender) DeliverDue(ctx context.Context) (delivered, failed int, err error) {
+	// A claimed delivery is due again once every request of the batch could have timed out
+	lease := s.client.Timeout*webhookBatch + time.Minute
+	for ctx.Err() == nil {
+		deliveries, err := s.repo.ClaimDue(ctx, webhookBatch, lease)
+		if err != nil {
+			return delivered, failed, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+
+		subs := make(map[uuid.UUID]*entity.WebhookSubscription)
+		for _, d := range deliveries {
+			sub, ok := subs[d.SubscriptionID]
+			if !ok {
+				if sub, err = s.repo.GetSubscription(ctx, d.SubscriptionID); err != nil {
+					return delivered, failed, fmt.Errorf("failed to load subscription %s: %w", d.SubscriptionID, err)
+				}
+				subs[d.SubscriptionID] = sub
+			}
+
+			status, sendErr := s.send(ctx, sub, d)
+			if sendErr == nil {
+				delivered++
+				err = s.repo.MarkDelivered(ctx, d.ID, status)
+			} else {
+				failed++
+				err = s.markFailed(ctx, d, status, sendErr)
+			}
+			if err != nil {
+				return delivered, failed, fmt.Errorf("failed to record delivery %s: %w", d.ID, err)
+			}
+		}
+	}
+	return delivered, failed, nil
+}
+
+// send posts the delivery's payload, returning the response status (0 without a response)
+func (s *WebhookSender) send(ctx context.Context, sub *entity.WebhookSubscription, d *entity.WebhookDelivery) (int, error) {
+	job, err := s.jobRepo.GetByID(ctx, d.JobID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load job %s: %w", d.JobID, err)
+	}
+	now := time.Now()
+	body, err := json.Marshal(WebhookPayload{Event: d.EventType, DeliveryID: d.ID, Job: job, SentAt: now})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, d.EventType)
+	req.Header.Set(WebhookDeliveryHeader, d.ID.String())
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, now.Unix(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// markFailed schedules the delivery's next attempt after the backoff delay, or gives up
+// once it has been attempted maxAttempts times
+func (s *WebhookSender) markFailed(ctx context.Context, d *entity.WebhookDelivery, status int, cause error) error {
+	var responseStatus *int
+	if status != 0 {
+		responseStatus = &status
+	}
+	var nextAttemptAt *time.Time
+	if d.Attempts < s.maxAttempts {
+		next := time.Now().Add(s.retry.Delay(d.Attempts))
+		nextAttemptAt = &next
+	}
+	return s.repo.MarkFailed(ctx, d.ID, responseStatus, cause.Error(), nextAttemptAt)
+}
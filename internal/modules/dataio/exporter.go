@@ -0,0 +1,226 @@
+package dataio
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+)
+
+// exportPage is the number of summaries read and written at a time
+const exportPage = 1000
+
+// exportColumns are the CSV columns of every export; a per-process breakdown adds a
+// process_<code> column per process
+var exportColumns = []string{
+	"variant_id", "sku", "master_code", "total_material_cost", "total_process_cost", "total_overhead",
+	"grand_total", "last_recalculated_at", "version_hash",
+}
+
+// Exporter runs EXPORT_DATA jobs: it streams every cost summary, a page at a time, into
+// a CSV or NDJSON file in the export storage
+type Exporter struct {
+	summaryRepo repository.VariantCostSummaryRepository
+	processRepo repository.ProcessMasterRepository
+	jobRepo     repository.BatchJobRepository
+	storage     ExportStorage
+}
+
+// NewExporter creates a new exporter
+func NewExporter(
+	summaryRepo repository.VariantCostSummaryRepository,
+	processRepo repository.ProcessMasterRepository,
+	jobRepo repository.BatchJobRepository,
+	storage ExportStorage,
+) *Exporter {
+	return &Exporter{
+		summaryRepo: summaryRepo,
+		processRepo: processRepo,
+		jobRepo:     jobRepo,
+		storage:     storage,
+	}
+}
+
+// ExportFileName returns the name a job's export file is stored under
+func ExportFileName(jobID uuid.UUID, format string) string {
+	return "costs-" + jobID.String() + "." + format
+}
+
+// Run writes the export of job and completes it. The file only appears in the storage
+// once it is complete; a retried job writes it again from the start.
+func (ex *Exporter) Run(ctx context.Context, job *entity.BatchJob) error {
+	info, ok := job.ExportInfo()
+	if !ok || !ValidFormat(info.Format) {
+		return errors.New("job has no valid export settings")
+	}
+
+	total, err := ex.summaryRepo.Count(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count summaries: %w", err)
+	}
+	if err := ex.jobRepo.SetTotal(ctx, job.ID, total); err != nil {
+		return fmt.Errorf("failed to set total: %w", err)
+	}
+	if err := ex.jobRepo.UpdateStatus(ctx, job.ID, entity.JobStatusRunning, 0, 0); err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+
+	var processCodes []string
+	if info.IncludeSteps {
+		if processCodes, err = ex.processCodes(ctx); err != nil {
+			return err
+		}
+	}
+
+	name := ExportFileName(job.ID, info.Format)
+	w, err := ex.storage.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	counter := &countingWriter{w: w}
+	rows, err := ex.write(ctx, job.ID, bufio.NewWriterSize(counter, 256<<10), info, processCodes)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Commit(); err != nil {
+		return fmt.Errorf("failed to store export file: %w", err)
+	}
+
+	info.File, info.Rows, info.SizeBytes = name, rows, counter.n
+	if err := ex.jobRepo.SaveExportInfo(ctx, job.ID, info); err != nil {
+		return fmt.Errorf("failed to save export info: %w", err)
+	}
+	if err := ex.jobRepo.Complete(ctx, job.ID); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	log.Printf("Export %s: %d rows, %d bytes written to %s", job.ID, rows, counter.n, name)
+	return nil
+}
+
+// write streams every summary to w in variant ID order, reporting progress per page
+func (ex *Exporter) write(ctx context.Context, jobID uuid.UUID, w *bufio.Writer, info *entity.ExportInfo, processCodes []string) (int64, error) {
+	var encode func(*entity.CostExportRow) error
+	var flushRows func() error
+	if info.Format == entity.FileFormatCSV {
+		cw := csv.NewWriter(w)
+		header := append([]string{}, exportColumns...)
+		for _, code := range processCodes {
+			header = append(header, "process_"+code)
+		}
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+		record := make([]string, len(header))
+		encode = func(row *entity.CostExportRow) error {
+			return cw.Write(csvRecord(record, row, processCodes))
+		}
+		flushRows = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		encode = func(row *entity.CostExportRow) error {
+			if !info.IncludeSteps {
+				row.CategoryBreakdown = nil
+			}
+			return enc.Encode(row)
+		}
+		flushRows = func() error { return nil }
+	}
+
+	var written int64
+	afterID := uuid.Nil
+	for {
+		rows, err := ex.summaryRepo.ListForExport(ctx, afterID, exportPage)
+		if err != nil {
+			return written, fmt.Errorf("failed to read summaries: %w", err)
+		}
+		for _, row := range rows {
+			if err := encode(row); err != nil {
+				return written, fmt.Errorf("failed to write export: %w", err)
+			}
+		}
+		if err := flushRows(); err != nil {
+			return written, fmt.Errorf("failed to write export: %w", err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		written += int64(len(rows))
+		afterID = rows[len(rows)-1].YarnVariantID
+		if err := ex.jobRepo.UpdateProgress(ctx, jobID, int64(len(rows)), 0); err != nil {
+			return written, fmt.Errorf("failed to update progress: %w", err)
+		}
+		if len(rows) < exportPage {
+			break
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return written, fmt.Errorf("failed to write export: %w", err)
+	}
+	return written, nil
+}
+
+// processCodes returns the codes of the breakdown columns, in routing order
+func (ex *Exporter) processCodes(ctx context.Context) ([]string, error) {
+	processes, err := ex.processRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load processes: %w", err)
+	}
+	sort.SliceStable(processes, func(i, j int) bool {
+		return processes[i].DefaultSequence < processes[j].DefaultSequence
+	})
+	codes := make([]string, len(processes))
+	for i, p := range processes {
+		codes[i] = p.Code
+	}
+	return codes, nil
+}
+
+// csvRecord fills record with row's columns; a process the row has no cost for is empty
+func csvRecord(record []string, row *entity.CostExportRow, processCodes []string) []string {
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	record[0] = row.YarnVariantID.String()
+	record[1] = row.SKU
+	record[2] = row.MasterCode
+	record[3] = formatFloat(row.TotalMaterialCost)
+	record[4] = formatFloat(row.TotalProcessCost)
+	record[5] = formatFloat(row.TotalOverhead)
+	record[6] = formatFloat(row.GrandTotal)
+	record[7] = row.LastRecalculatedAt.Format(time.RFC3339)
+	record[8] = row.VersionHash
+	for i, code := range processCodes {
+		record[len(exportColumns)+i] = ""
+		if cost, ok := row.CategoryBreakdown[code]; ok {
+			record[len(exportColumns)+i] = formatFloat(cost)
+		}
+	}
+	return record
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,427 @@
+package dataio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/internal/modules/catalog"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+// masterColumns are the master yarn columns; in CSV every other column is a fixed attribute
+var masterColumns = map[string]bool{"code": true, "name": true, "description": true, "is_active": true, "fixed_attrs": true}
+
+// masterBatch imports master yarns, rejecting codes that already exist
+type masterBatch struct {
+	importRepo repository.ImportRepository
+	masterRepo repository.MasterYarnRepository
+	seen       map[string]bool // codes read so far, to reject duplicates within the file
+	rows       []int
+	yarns      []*entity.MasterYarn
+}
+
+func newMasterBatch(importRepo repository.ImportRepository, masterRepo repository.MasterYarnRepository) *masterBatch {
+	return &masterBatch{importRepo: importRepo, masterRepo: masterRepo, seen: make(map[string]bool)}
+}
+
+func (b *masterBatch) add(row importRow) error {
+	code, name := stringField(row, "code"), stringField(row, "name")
+	if code == "" || name == "" {
+		return errors.New("code and name are required")
+	}
+	if b.seen[code] {
+		return fmt.Errorf("code %s appears more than once in the file", code)
+	}
+	isActive, err := boolField(row, "is_active", true)
+	if err != nil {
+		return err
+	}
+
+	attrs := make(map[string]interface{})
+	if raw, ok := row.fields["fixed_attrs"]; ok {
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return errors.New("fixed_attrs must be an object")
+		}
+		attrs = obj
+	}
+	for key, value := range row.fields {
+		if masterColumns[key] {
+			continue
+		}
+		// CSV columns are text; numeric attributes are stored as numbers
+		if s, ok := value.(string); ok {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				value = f
+			}
+		}
+		attrs[key] = value
+	}
+
+	now := time.Now()
+	b.seen[code] = true
+	b.rows = append(b.rows, row.no)
+	b.yarns = append(b.yarns, &entity.MasterYarn{
+		ID:          uuid.New(),
+		Code:        code,
+		Name:        name,
+		Description: stringField(row, "description"),
+		FixedAttrs:  attrs,
+		IsActive:    isActive,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	return nil
+}
+
+func (b *masterBatch) flush(ctx context.Context) (int64, []entity.ImportRowError, error) {
+	if len(b.yarns) == 0 {
+		return 0, nil, nil
+	}
+	defer func() { b.rows, b.yarns = b.rows[:0], b.yarns[:0] }()
+
+	codes := make([]string, len(b.yarns))
+	for i, y := range b.yarns {
+		codes[i] = y.Code
+	}
+	existing, err := b.importRepo.MasterIDsByCode(ctx, codes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to look up master codes: %w", err)
+	}
+
+	var rejected []entity.ImportRowError
+	var yarns []*entity.MasterYarn
+	var rows []int
+	for i, y := range b.yarns {
+		if _, ok := existing[y.Code]; ok {
+			rejected = append(rejected, entity.ImportRowError{Row: b.rows[i], Error: fmt.Sprintf("master yarn %s already exists", y.Code)})
+			continue
+		}
+		yarns = append(yarns, y)
+		rows = append(rows, b.rows[i])
+	}
+	return copyBatch(rows, rejected, func() (int64, error) {
+		return b.masterRepo.CreateBatch(ctx, yarns)
+	})
+}
+
+// variantBatch imports variants of existing master yarns, rejecting SKUs that already
+// exist. Variants without a routing get the default one from the routing rules.
+type variantBatch struct {
+	importRepo     repository.ImportRepository
+	variantService *catalog.VariantService
+	routings       map[uuid.UUID]bool // active routing templates
+	masters        map[string]uuid.UUID
+	seen           map[string]bool
+	rows           []int
+	masterCodes    []string
+	variants       []*entity.YarnVariant
+}
+
+func newVariantBatch(importRepo repository.ImportRepository, variantService *catalog.VariantService, routings map[uuid.UUID]bool) *variantBatch {
+	return &variantBatch{
+		importRepo:     importRepo,
+		variantService: variantService,
+		routings:       routings,
+		masters:        make(map[string]uuid.UUID),
+		seen:           make(map[string]bool),
+	}
+}
+
+func (b *variantBatch) add(row importRow) error {
+	masterCode, sku := stringField(row, "master_code"), stringField(row, "sku")
+	if masterCode == "" || sku == "" {
+		return errors.New("master_code and sku are required")
+	}
+	if b.seen[sku] {
+		return fmt.Errorf("sku %s appears more than once in the file", sku)
+	}
+	isActive, err := boolField(row, "is_active", true)
+	if err != nil {
+		return err
+	}
+	var routingID uuid.UUID
+	if s := stringField(row, "routing_template_id"); s != "" {
+		if routingID, err = uuid.Parse(s); err != nil {
+			return errors.New("routing_template_id must be a UUID")
+		}
+		if !b.routings[routingID] {
+			return fmt.Errorf("routing template %s not found", routingID)
+		}
+	}
+
+	now := time.Now()
+	b.seen[sku] = true
+	b.rows = append(b.rows, row.no)
+	b.masterCodes = append(b.masterCodes, masterCode)
+	b.variants = append(b.variants, &entity.YarnVariant{
+		ID:                uuid.New(),
+		SKU:               sku,
+		BatchNo:           stringField(row, "batch_no"),
+		RoutingTemplateID: routingID,
+		IsActive:          isActive,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	})
+	return nil
+}
+
+func (b *variantBatch) flush(ctx context.Context) (int64, []entity.ImportRowError, error) {
+	if len(b.variants) == 0 {
+		return 0, nil, nil
+	}
+	defer func() { b.rows, b.masterCodes, b.variants = b.rows[:0], b.masterCodes[:0], b.variants[:0] }()
+
+	// Masters found by earlier batches are remembered, so each code is looked up once
+	var missing []string
+	skus := make([]string, len(b.variants))
+	for i, v := range b.variants {
+		skus[i] = v.SKU
+		if _, ok := b.masters[b.masterCodes[i]]; !ok {
+			missing = append(missing, b.masterCodes[i])
+		}
+	}
+	if len(missing) > 0 {
+		found, err := b.importRepo.MasterIDsByCode(ctx, missing)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to look up master codes: %w", err)
+		}
+		for code, id := range found {
+			b.masters[code] = id
+		}
+	}
+	existing, err := b.importRepo.ExistingSKUs(ctx, skus)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to look up SKUs: %w", err)
+	}
+
+	var rejected []entity.ImportRowError
+	var variants []*entity.YarnVariant
+	var rows []int
+	for i, v := range b.variants {
+		masterID, ok := b.masters[b.masterCodes[i]]
+		switch {
+		case !ok:
+			rejected = append(rejected, entity.ImportRowError{Row: b.rows[i], Error: fmt.Sprintf("master yarn %s not found", b.masterCodes[i])})
+		case existing[v.SKU]:
+			rejected = append(rejected, entity.ImportRowError{Row: b.rows[i], Error: fmt.Sprintf("variant %s already exists", v.SKU)})
+		default:
+			v.MasterYarnID = masterID
+			variants = append(variants, v)
+			rows = append(rows, b.rows[i])
+		}
+	}
+	return copyBatch(rows, rejected, func() (int64, error) {
+		return b.variantService.CreateBatch(ctx, variants)
+	})
+}
+
+// rateBatch imports price rates of known parameters, rejecting a parameter and
+// effective date pair that already has a rate
+type rateBatch struct {
+	importRepo repository.ImportRepository
+	rateRepo   repository.PriceRateRepository
+	params     map[string]bool
+	seen       map[string]bool // parameter key and effective date pairs read so far
+	rows       []int
+	rates      []*entity.PriceRate
+}
+
+func newRateBatch(importRepo repository.ImportRepository, rateRepo repository.PriceRateRepository, params map[string]bool) *rateBatch {
+	return &rateBatch{importRepo: importRepo, rateRepo: rateRepo, params: params, seen: make(map[string]bool)}
+}
+
+func (b *rateBatch) add(row importRow) error {
+	key := stringField(row, "parameter_key")
+	if key == "" {
+		return errors.New("parameter_key is required")
+	}
+	if !b.params[key] {
+		return fmt.Errorf("parameter %s not found", key)
+	}
+
+	rate := &entity.PriceRate{ID: uuid.New(), ParameterKey: key, Notes: stringField(row, "notes"), CreatedAt: time.Now()}
+	value, expression := stringField(row, "rate_value"), stringField(row, "rate_expression")
+	switch {
+	case (value == "") == (expression == ""):
+		return errors.New("exactly one of rate_value and rate_expression is required")
+	case value != "":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("rate_value must be a number")
+		}
+		rate.RateValue = v
+	default:
+		names, err := formula.Identifiers(expression)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !b.params[name] {
+				return fmt.Errorf("rate_expression refers to unknown parameter %s", name)
+			}
+		}
+		rate.RateExpression = expression
+	}
+
+	effective, err := time.Parse(time.DateOnly, stringField(row, "effective_date"))
+	if err != nil {
+		return errors.New("effective_date must be a date (YYYY-MM-DD)")
+	}
+	rate.EffectiveDate = effective
+	if s := stringField(row, "expired_date"); s != "" {
+		expired, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return errors.New("expired_date must be a date (YYYY-MM-DD)")
+		}
+		if !expired.After(effective) {
+			return errors.New("expired_date must be after effective_date")
+		}
+		rate.ExpiredDate = &expired
+	}
+
+	pair := key + "@" + effective.Format(time.DateOnly)
+	if b.seen[pair] {
+		return fmt.Errorf("rate of %s effective %s appears more than once in the file", key, effective.Format(time.DateOnly))
+	}
+	b.seen[pair] = true
+	b.rows = append(b.rows, row.no)
+	b.rates = append(b.rates, rate)
+	return nil
+}
+
+func (b *rateBatch) flush(ctx context.Context) (int64, []entity.ImportRowError, error) {
+	if len(b.rates) == 0 {
+		return 0, nil, nil
+	}
+	defer func() { b.rows, b.rates = b.rows[:0], b.rates[:0] }()
+
+	keys := make([]string, len(b.rates))
+	dates := make([]time.Time, len(b.rates))
+	for i, r := range b.rates {
+		keys[i], dates[i] = r.ParameterKey, r.EffectiveDate
+	}
+	exists, err := b.importRepo.ExistingRates(ctx, keys, dates)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to look up rates: %w", err)
+	}
+
+	var rejected []entity.ImportRowError
+	var rates []*entity.PriceRate
+	var rows []int
+	for i, r := range b.rates {
+		if exists[i] {
+			rejected = append(rejected, entity.ImportRowError{
+				Row:   b.rows[i],
+				Error: fmt.Sprintf("rate of %s effective %s already exists", r.ParameterKey, r.EffectiveDate.Format(time.DateOnly)),
+			})
+			continue
+		}
+		rates = append(rates, r)
+		rows = append(rows, b.rows[i])
+	}
+	return copyBatch(rows, rejected, func() (int64, error) {
+		return b.rateRepo.CreateBatch(ctx, rates)
+	})
+}
+
+// parameterTypes are the data types a master parameter can have
+var parameterTypes = map[string]bool{"float": true, "int": true, "bool": true, "string": true}
+
+// parameterBatch imports master parameters, rejecting keys that already exist
+type parameterBatch struct {
+	paramRepo repository.MasterParameterRepository
+	existing  map[string]bool // parameter keys in the database or read so far
+	groups    map[string]bool
+	rows      []int
+	params    []*entity.MasterParameter
+}
+
+func newParameterBatch(paramRepo repository.MasterParameterRepository, existing, groups map[string]bool) *parameterBatch {
+	return &parameterBatch{paramRepo: paramRepo, existing: existing, groups: groups}
+}
+
+func (b *parameterBatch) add(row importRow) error {
+	key := stringField(row, "key")
+	if key == "" {
+		return errors.New("key is required")
+	}
+	if b.existing[key] {
+		return fmt.Errorf("parameter %s already exists or appears more than once in the file", key)
+	}
+	param := &entity.MasterParameter{
+		Key:          key,
+		Label:        stringField(row, "label"),
+		DataType:     stringField(row, "data_type"),
+		DefaultValue: stringField(row, "default_value"),
+		GroupCode:    stringField(row, "group_code"),
+		Unit:         stringField(row, "unit"),
+		CreatedAt:    time.Now(),
+	}
+	if param.Label == "" {
+		param.Label = key
+	}
+	if param.DataType == "" {
+		param.DataType = "float"
+	}
+	if !parameterTypes[param.DataType] {
+		return errors.New("data_type must be float, int, bool or string")
+	}
+	if param.GroupCode != "" && !b.groups[param.GroupCode] {
+		return fmt.Errorf("parameter group %s not found", param.GroupCode)
+	}
+	var err error
+	if param.IsRequired, err = boolField(row, "is_required", false); err != nil {
+		return err
+	}
+	if s := stringField(row, "sequence_order"); s != "" {
+		if param.SequenceOrder, err = strconv.Atoi(s); err != nil {
+			return errors.New("sequence_order must be an integer")
+		}
+	}
+
+	b.existing[key] = true
+	b.rows = append(b.rows, row.no)
+	b.params = append(b.params, param)
+	return nil
+}
+
+func (b *parameterBatch) flush(ctx context.Context) (int64, []entity.ImportRowError, error) {
+	if len(b.params) == 0 {
+		return 0, nil, nil
+	}
+	defer func() { b.rows, b.params = b.rows[:0], b.params[:0] }()
+	return copyBatch(b.rows, nil, func() (int64, error) {
+		return b.paramRepo.CreateBatch(ctx, b.params)
+	})
+}
+
+// copyBatch inserts the valid rows of a batch with insert. COPY is all or nothing, so
+// when the database rejects it (e.g. a row was inserted concurrently) every one of them
+// is rejected with its error; other errors fail the import so it is retried.
+func copyBatch(rows []int, rejected []entity.ImportRowError, insert func() (int64, error)) (int64, []entity.ImportRowError, error) {
+	if len(rows) == 0 {
+		return 0, rejected, nil
+	}
+	n, err := insert()
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		for _, row := range rows {
+			rejected = append(rejected, entity.ImportRowError{Row: row, Error: pgErr.Message})
+		}
+		return 0, rejected, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, rejected, nil
+}
@@ -0,0 +1,424 @@
+package dataio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/internal/modules/catalog"
+)
+
+// importBatch is the number of rows validated and copied together
+const importBatch = 1000
+
+// maxReportedErrors bounds the row errors kept in a job's import report
+const maxReportedErrors = 1000
+
+// maxNDJSONLine bounds the length of one NDJSON record
+const maxNDJSONLine = 1 << 20
+
+// CacheInvalidator tells every process to reload its costing cache
+type CacheInvalidator interface {
+	Notify(ctx context.Context, reason string) error
+}
+
+// Importer runs IMPORT_DATA jobs: it reads the job's staged file, validates every row
+// and bulk-inserts the valid ones with COPY, recording rejected rows in the job
+type Importer struct {
+	importRepo     repository.ImportRepository
+	jobRepo        repository.BatchJobRepository
+	masterRepo     repository.MasterYarnRepository
+	variantService *catalog.VariantService
+	rateRepo       repository.PriceRateRepository
+	paramRepo      repository.MasterParameterRepository
+	routingRepo    repository.RoutingTemplateRepository
+	cacheEvents    CacheInvalidator
+}
+
+// NewImporter creates a new importer
+func NewImporter(
+	importRepo repository.ImportRepository,
+	jobRepo repository.BatchJobRepository,
+	masterRepo repository.MasterYarnRepository,
+	variantService *catalog.VariantService,
+	rateRepo repository.PriceRateRepository,
+	paramRepo repository.MasterParameterRepository,
+	routingRepo repository.RoutingTemplateRepository,
+	cacheEvents CacheInvalidator,
+) *Importer {
+	return &Importer{
+		importRepo:     importRepo,
+		jobRepo:        jobRepo,
+		masterRepo:     masterRepo,
+		variantService: variantService,
+		rateRepo:       rateRepo,
+		paramRepo:      paramRepo,
+		routingRepo:    routingRepo,
+		cacheEvents:    cacheEvents,
+	}
+}
+
+// ValidKind reports whether kind is a record kind that can be imported
+func ValidKind(kind string) bool {
+	switch kind {
+	case entity.ImportKindMasters, entity.ImportKindVariants, entity.ImportKindRates, entity.ImportKindParameters:
+		return true
+	}
+	return false
+}
+
+// ValidFormat reports whether format is a file format both imports and exports support
+func ValidFormat(format string) bool {
+	return format == entity.FileFormatCSV || format == entity.FileFormatNDJSON
+}
+
+// ValidImportFormat reports whether format is a supported import file format
+func ValidImportFormat(format string) bool {
+	return ValidFormat(format) || format == entity.FileFormatXLSX
+}
+
+// DetectFormat infers an import file's format from its name or content type, defaulting
+// to CSV
+func DetectFormat(filename, contentType string) string {
+	switch {
+	case strings.HasSuffix(filename, ".ndjson"), strings.HasSuffix(filename, ".jsonl"),
+		strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonl"):
+		return entity.FileFormatNDJSON
+	case strings.HasSuffix(filename, ".xlsx"), strings.Contains(contentType, "spreadsheetml"):
+		return entity.FileFormatXLSX
+	default:
+		return entity.FileFormatCSV
+	}
+}
+
+// importRow is one record of an import file with its row number
+type importRow struct {
+	no     int
+	fields map[string]interface{}
+	err    error // the row could not be read
+}
+
+// recordBatch validates rows of one kind and inserts those that are valid
+type recordBatch interface {
+	// add validates a row on its own and queues it, returning why it is rejected
+	add(row importRow) error
+	// flush checks the queued rows against the database and inserts the valid ones,
+	// returning how many were inserted and the rejected rows
+	flush(ctx context.Context) (int64, []entity.ImportRowError, error)
+}
+
+// Run imports the file staged for job and completes it. A job that ran before continues
+// after the last row its report reached.
+func (im *Importer) Run(ctx context.Context, job *entity.BatchJob) error {
+	file, err := im.importRepo.GetFile(ctx, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load staged file: %w", err)
+	}
+
+	report, ok := job.ImportReport()
+	if !ok {
+		report = &entity.ImportReport{Kind: file.Kind, Format: file.Format, Errors: []entity.ImportRowError{}}
+	} else if report.Rows > 0 {
+		log.Printf("Resuming import after row %d (%d imported, %d failed)", report.Rows, report.Imported, report.Failed)
+	}
+
+	total, err := countRows(file)
+	if err != nil {
+		return err
+	}
+	if err := im.jobRepo.SetTotal(ctx, job.ID, int64(total)); err != nil {
+		return fmt.Errorf("failed to set total: %w", err)
+	}
+	if err := im.jobRepo.UpdateStatus(ctx, job.ID, entity.JobStatusRunning, report.Imported, report.Failed); err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+
+	err = im.load(ctx, file, report, func(imported, failed int64) error {
+		if err := im.jobRepo.UpdateProgress(ctx, job.ID, imported, failed); err != nil {
+			return fmt.Errorf("failed to update progress: %w", err)
+		}
+		if err := im.jobRepo.SaveImportReport(ctx, job.ID, report); err != nil {
+			return fmt.Errorf("failed to save import report: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Imported rates and variants change what recalculations read from the cache
+	if report.Imported > 0 {
+		if err := im.cacheEvents.Notify(ctx, "imported "+report.Kind); err != nil {
+			log.Printf("Failed to notify cache invalidation: %v", err)
+		}
+	}
+	if err := im.importRepo.DeleteFile(ctx, job.ID); err != nil {
+		log.Printf("Failed to delete staged file of job %s: %v", job.ID, err)
+	}
+	if err := im.jobRepo.Complete(ctx, job.ID); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	log.Printf("Import %s of %s: %d rows, %d imported, %d failed", job.ID, report.Kind, report.Rows, report.Imported, report.Failed)
+	return nil
+}
+
+// Load imports a file of kind right away, without a job, and returns its report. The
+// seeder loads files through it.
+func (im *Importer) Load(ctx context.Context, kind, format string, content []byte) (*entity.ImportReport, error) {
+	file := &entity.ImportFile{Kind: kind, Format: format, Content: content}
+	report := &entity.ImportReport{Kind: kind, Format: format, Errors: []entity.ImportRowError{}}
+	err := im.load(ctx, file, report, func(int64, int64) error { return nil })
+	if err != nil {
+		return report, err
+	}
+	if report.Imported > 0 {
+		if err := im.cacheEvents.Notify(ctx, "imported "+kind); err != nil {
+			log.Printf("Failed to notify cache invalidation: %v", err)
+		}
+	}
+	return report, nil
+}
+
+// load imports the rows of file after the last one report reached, adding them to
+// report. saved is called after every batch with the rows imported and failed since the
+// one before.
+func (im *Importer) load(ctx context.Context, file *entity.ImportFile, report *entity.ImportReport, saved func(imported, failed int64) error) error {
+	batch, err := im.newBatch(ctx, file.Kind)
+	if err != nil {
+		return err
+	}
+
+	rejectRow := func(rowErr entity.ImportRowError) {
+		report.Failed++
+		if len(report.Errors) < maxReportedErrors {
+			report.Errors = append(report.Errors, rowErr)
+		} else {
+			report.ErrorsTruncated = true
+		}
+	}
+
+	var imported, failed int64
+	pending := 0
+	flush := func() error {
+		n, rejected, err := batch.flush(ctx)
+		if err != nil {
+			return err
+		}
+		for _, rowErr := range rejected {
+			rejectRow(rowErr)
+		}
+		report.Imported += n
+		imported += n
+		failed += int64(len(rejected))
+
+		if err := saved(imported, failed); err != nil {
+			return err
+		}
+		imported, failed, pending = 0, 0, 0
+		return nil
+	}
+
+	resumeAfter := report.Rows
+	err = readRows(file, func(row importRow) error {
+		if row.no <= resumeAfter {
+			return nil
+		}
+		report.Rows = row.no
+		if row.err == nil {
+			row.err = batch.add(row)
+		}
+		if row.err != nil {
+			rejectRow(entity.ImportRowError{Row: row.no, Error: row.err.Error()})
+			failed++
+		}
+
+		if pending++; pending >= importBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// newBatch creates the record batch for kind, loading what its validation looks up
+func (im *Importer) newBatch(ctx context.Context, kind string) (recordBatch, error) {
+	switch kind {
+	case entity.ImportKindMasters:
+		return newMasterBatch(im.importRepo, im.masterRepo), nil
+	case entity.ImportKindVariants:
+		templates, err := im.routingRepo.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load routing templates: %w", err)
+		}
+		routings := make(map[uuid.UUID]bool, len(templates))
+		for _, t := range templates {
+			routings[t.ID] = true
+		}
+		return newVariantBatch(im.importRepo, im.variantService, routings), nil
+	case entity.ImportKindRates:
+		params, err := im.paramRepo.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parameters: %w", err)
+		}
+		keys := make(map[string]bool, len(params))
+		for _, p := range params {
+			keys[p.Key] = true
+		}
+		return newRateBatch(im.importRepo, im.rateRepo, keys), nil
+	case entity.ImportKindParameters:
+		params, err := im.paramRepo.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parameters: %w", err)
+		}
+		existing := make(map[string]bool, len(params))
+		for _, p := range params {
+			existing[p.Key] = true
+		}
+		codes, err := im.paramRepo.GroupCodes(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parameter groups: %w", err)
+		}
+		groups := make(map[string]bool, len(codes))
+		for _, code := range codes {
+			groups[code] = true
+		}
+		return newParameterBatch(im.paramRepo, existing, groups), nil
+	default:
+		return nil, fmt.Errorf("unknown import kind %q", kind)
+	}
+}
+
+// countRows counts the records of an import file
+func countRows(file *entity.ImportFile) (int, error) {
+	count := 0
+	err := readRows(file, func(importRow) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// readRows calls fn for every record of file in order. A row that cannot be parsed is
+// passed with its error; an error from fn stops reading and is returned.
+func readRows(file *entity.ImportFile, fn func(importRow) error) error {
+	switch file.Format {
+	case entity.FileFormatCSV:
+		return readCSV(file.Content, fn)
+	case entity.FileFormatNDJSON:
+		return readNDJSON(file.Content, fn)
+	case entity.FileFormatXLSX:
+		return readXLSX(file.Content, fn)
+	default:
+		return fmt.Errorf("unknown import format %q", file.Format)
+	}
+}
+
+func readCSV(content []byte, fn func(importRow) error) error {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // byte order mark
+
+	for no := 1; ; no++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		row := importRow{no: no, fields: make(map[string]interface{}, len(header))}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return fmt.Errorf("failed to read CSV row %d: %w", no, err)
+		}
+		if err != nil {
+			row.err = parseErr.Err
+		}
+		for i, value := range record {
+			if i < len(header) && value != "" {
+				row.fields[header[i]] = value
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+func readNDJSON(content []byte, fn func(importRow) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxNDJSONLine)
+	no := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		no++
+		row := importRow{no: no}
+		if err := json.Unmarshal(line, &row.fields); err != nil {
+			row.err = fmt.Errorf("invalid JSON: %w", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read NDJSON row %d: %w", no+1, err)
+	}
+	return nil
+}
+
+// stringField returns a field as text, formatting JSON numbers and booleans
+func stringField(row importRow, key string) string {
+	switch v := row.fields[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// boolField returns a boolean field, def when it is missing
+func boolField(row importRow, key string, def bool) (bool, error) {
+	switch v := row.fields[key].(type) {
+	case nil:
+		return def, nil
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, fmt.Errorf("%s must be true or false", key)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("%s must be true or false", key)
+	}
+}
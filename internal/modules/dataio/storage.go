@@ -0,0 +1,110 @@
+package dataio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ExportWriter receives an export file's content. Nothing is visible under the file's
+// name until Commit; Abort discards what was written.
+type ExportWriter interface {
+	io.Writer
+	Commit() error
+	Abort()
+}
+
+// ExportStorage stores export files by name. A DirStorage on a volume shared by the API
+// and the workers is the default; object storage can implement the same interface.
+type ExportStorage interface {
+	// Create starts writing the file name, replacing it on Commit
+	Create(name string) (ExportWriter, error)
+	// Open opens the file name for reading, returning its size
+	Open(name string) (io.ReadCloser, int64, error)
+	// Prune deletes files, including abandoned partial ones, last written before before,
+	// returning how many were deleted
+	Prune(before time.Time) (int, error)
+}
+
+// DirStorage stores export files in a local directory
+type DirStorage struct {
+	dir string
+}
+
+// NewDirStorage creates a storage in dir, creating the directory if needed
+func NewDirStorage(dir string) (*DirStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	return &DirStorage{dir: dir}, nil
+}
+
+func (s *DirStorage) Create(name string) (ExportWriter, error) {
+	f, err := os.CreateTemp(s.dir, name+".*.partial")
+	if err != nil {
+		return nil, err
+	}
+	return &dirWriter{File: f, path: s.path(name)}, nil
+}
+
+func (s *DirStorage) Open(name string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *DirStorage) Prune(before time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// path returns where the file name is stored; names never reach outside the directory
+func (s *DirStorage) path(name string) string {
+	return filepath.Join(s.dir, filepath.Base(name))
+}
+
+// dirWriter writes to a temporary file that Commit renames into place
+type dirWriter struct {
+	*os.File
+	path string
+}
+
+func (w *dirWriter) Commit() error {
+	if err := w.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return os.Rename(w.Name(), w.path)
+}
+
+func (w *dirWriter) Abort() {
+	w.Close()
+	os.Remove(w.Name())
+}
@@ -0,0 +1,272 @@
+package dataio
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxEpoch is day 0 of Excel's date serial numbers, counting its leap day in 1900
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxWorkbook is what readXLSX needs of a workbook besides the sheet itself
+type xlsxWorkbook struct {
+	sheet      *zip.File
+	strings    []string // the shared strings cells refer to by index
+	dateStyles []bool   // whether each cell style formats numbers as dates
+}
+
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Style  int    `xml:"s,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+// readXLSX reads the first worksheet of an Excel workbook like a CSV file: the first
+// non-empty row names the columns and every later non-empty row is a record. Shared and
+// inline strings are read as text, and numbers formatted as dates as YYYY-MM-DD.
+func readXLSX(content []byte, fn func(importRow) error) error {
+	book, err := openXLSX(content)
+	if err != nil {
+		return err
+	}
+	r, err := book.sheet.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open worksheet: %w", err)
+	}
+	defer r.Close()
+
+	var header []string
+	no := 0
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read worksheet: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row struct {
+			Cells []xlsxCell `xml:"c"`
+		}
+		if err := dec.DecodeElement(&row, &start); err != nil {
+			return fmt.Errorf("failed to read worksheet row: %w", err)
+		}
+
+		values := make(map[int]string, len(row.Cells))
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				col = xlsxColumn(cell.Ref)
+			}
+			if v := book.value(cell); v != "" {
+				values[col] = v
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if header == nil {
+			header = make([]string, 0, len(values))
+			for col, name := range values {
+				for len(header) <= col {
+					header = append(header, "")
+				}
+				header[col] = strings.ToLower(strings.TrimSpace(name))
+			}
+			continue
+		}
+
+		no++
+		record := importRow{no: no, fields: make(map[string]interface{}, len(values))}
+		for col, value := range values {
+			if col < len(header) && header[col] != "" {
+				record.fields[header[col]] = value
+			}
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// openXLSX finds the first worksheet of a workbook and loads its shared strings and the
+// cell styles that format dates
+func openXLSX(content []byte) (*xlsxWorkbook, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("not an XLSX workbook: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeXLSXPart(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeXLSXPart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, errors.New("workbook has no worksheets")
+	}
+	book := &xlsxWorkbook{}
+	for _, rel := range rels.Rels {
+		if rel.ID != workbook.Sheets[0].RelID {
+			continue
+		}
+		// Targets are relative to xl/, or absolute within the package
+		name := strings.TrimPrefix(rel.Target, "/")
+		if !strings.HasPrefix(rel.Target, "/") {
+			name = path.Join("xl", rel.Target)
+		}
+		book.sheet = files[name]
+	}
+	if book.sheet == nil {
+		return nil, errors.New("first worksheet not found in workbook")
+	}
+
+	if files["xl/sharedStrings.xml"] != nil {
+		var sst struct {
+			Items []struct {
+				Text string   `xml:"t"`
+				Runs []string `xml:"r>t"`
+			} `xml:"si"`
+		}
+		if err := decodeXLSXPart(files, "xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+		book.strings = make([]string, len(sst.Items))
+		for i, item := range sst.Items {
+			book.strings[i] = item.Text + strings.Join(item.Runs, "")
+		}
+	}
+
+	if files["xl/styles.xml"] != nil {
+		var styles struct {
+			NumFmts []struct {
+				ID   int    `xml:"numFmtId,attr"`
+				Code string `xml:"formatCode,attr"`
+			} `xml:"numFmts>numFmt"`
+			CellXfs []struct {
+				NumFmtID int `xml:"numFmtId,attr"`
+			} `xml:"cellXfs>xf"`
+		}
+		if err := decodeXLSXPart(files, "xl/styles.xml", &styles); err != nil {
+			return nil, err
+		}
+		custom := make(map[int]string, len(styles.NumFmts))
+		for _, f := range styles.NumFmts {
+			custom[f.ID] = f.Code
+		}
+		book.dateStyles = make([]bool, len(styles.CellXfs))
+		for i, xf := range styles.CellXfs {
+			book.dateStyles[i] = isDateFormat(xf.NumFmtID, custom[xf.NumFmtID])
+		}
+	}
+	return book, nil
+}
+
+func decodeXLSXPart(files map[string]*zip.File, name string, v interface{}) error {
+	f := files[name]
+	if f == nil {
+		return fmt.Errorf("not an XLSX workbook: %s is missing", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+// value returns a cell's content as text
+func (b *xlsxWorkbook) value(cell xlsxCell) string {
+	switch cell.Type {
+	case "s":
+		i, err := strconv.Atoi(cell.Value)
+		if err != nil || i < 0 || i >= len(b.strings) {
+			return ""
+		}
+		return strings.TrimSpace(b.strings[i])
+	case "inlineStr":
+		return strings.TrimSpace(cell.Inline)
+	case "b":
+		return strconv.FormatBool(cell.Value == "1")
+	case "", "n":
+		if cell.Style >= 0 && cell.Style < len(b.dateStyles) && b.dateStyles[cell.Style] {
+			if serial, err := strconv.ParseFloat(cell.Value, 64); err == nil {
+				return xlsxDate(serial)
+			}
+		}
+	}
+	return strings.TrimSpace(cell.Value)
+}
+
+// xlsxDate formats a date serial number as YYYY-MM-DD, with the time when it has one
+func xlsxDate(serial float64) string {
+	t := xlsxEpoch.Add(time.Duration(serial * float64(24*time.Hour))).Round(time.Second)
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.DateTime)
+}
+
+// xlsxLiteral matches the quoted text, escaped characters and bracketed colours and
+// conditions of a number format, which do not make it a date format
+var xlsxLiteral = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+
+// isDateFormat reports whether number format id, with code when it is a custom format,
+// displays numbers as dates
+func isDateFormat(id int, code string) bool {
+	switch {
+	case id >= 14 && id <= 17, id == 22: // the built-in date formats; 18-21 are times
+		return true
+	case code == "":
+		return false
+	}
+	code = strings.ToLower(xlsxLiteral.ReplaceAllString(code, ""))
+	return strings.ContainsAny(code, "dy")
+}
+
+// xlsxColumn returns the zero-based column of a cell reference such as "AB12"
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
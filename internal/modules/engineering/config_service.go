@@ -0,0 +1,302 @@
+package engineering
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+// ConfigValidationError is returned when a desired configuration is rejected before
+// anything is applied
+type ConfigValidationError struct {
+	Issues []string
+}
+
+func (e *ConfigValidationError) Error() string {
+	return fmt.Sprintf("configuration has %d issue(s): %s", len(e.Issues), strings.Join(e.Issues, "; "))
+}
+
+// ConfigService exports the costing configuration as a declarative document and
+// applies such documents back, GitOps style
+type ConfigService struct {
+	configRepo repository.ConfigRepository
+	parser     *formula.Parser
+}
+
+// NewConfigService creates a new configuration service
+func NewConfigService(configRepo repository.ConfigRepository, parser *formula.Parser) *ConfigService {
+	return &ConfigService{configRepo: configRepo, parser: parser}
+}
+
+// Export returns the complete stored costing configuration
+func (s *ConfigService) Export(ctx context.Context) (*entity.CostingConfig, error) {
+	return s.configRepo.Export(ctx)
+}
+
+// Plan validates desired against the stored configuration and returns the changes
+// applying it would make. Validation failures are returned as a *ConfigValidationError.
+func (s *ConfigService) Plan(ctx context.Context, desired *entity.CostingConfig) ([]*entity.ConfigChange, error) {
+	current, err := s.configRepo.Export(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if issues := s.validate(current, desired); len(issues) > 0 {
+		return nil, &ConfigValidationError{Issues: issues}
+	}
+	return diffConfig(current, desired), nil
+}
+
+// Apply plans desired and, when anything changed, applies it in one transaction
+func (s *ConfigService) Apply(ctx context.Context, desired *entity.CostingConfig) ([]*entity.ConfigChange, error) {
+	changes, err := s.Plan(ctx, desired)
+	if err != nil || len(changes) == 0 {
+		return changes, err
+	}
+	if err := s.configRepo.Apply(ctx, desired, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// validate checks desired for duplicate keys, dangling references, malformed
+// expressions and edits to existing formula versions. Missing data types default to float.
+func (s *ConfigService) validate(current, desired *entity.CostingConfig) []string {
+	var issues []string
+	addf := func(format string, args ...interface{}) {
+		issues = append(issues, fmt.Sprintf(format, args...))
+	}
+	checkExpression := func(where, expression string) {
+		if strings.TrimSpace(expression) == "" {
+			addf("%s: expression is required", where)
+			return
+		}
+		if err := s.parser.CheckComplexity(expression); err != nil {
+			addf("%s: %v", where, err)
+			return
+		}
+		if _, err := formula.Identifiers(expression); err != nil {
+			addf("%s: %v", where, err)
+		}
+	}
+
+	groups := known(current.ParameterGroups, func(g *entity.ConfigParameterGroup) string { return g.Code })
+	for key := range unique(desired.ParameterGroups, func(g *entity.ConfigParameterGroup) string { return g.Code }, "parameter group", addf) {
+		groups[key] = true
+	}
+	unique(desired.Parameters, func(p *entity.ConfigParameter) string { return p.Key }, "parameter", addf)
+	for _, p := range desired.Parameters {
+		if p.DataType == "" {
+			p.DataType = "float"
+		}
+		if p.Group != "" && !groups[p.Group] {
+			addf("parameter %s: unknown group %s", p.Key, p.Group)
+		}
+	}
+
+	processes := known(current.Processes, func(p *entity.ConfigProcess) string { return p.Code })
+	for key := range unique(desired.Processes, func(p *entity.ConfigProcess) string { return p.Code }, "process", addf) {
+		processes[key] = true
+	}
+
+	formulas := make(map[string]string)
+	for _, f := range current.Formulas {
+		formulas[formulaRef(f)] = f.Expression
+	}
+	unique(desired.Formulas, formulaRef, "formula", addf)
+	for _, f := range desired.Formulas {
+		ref := formulaRef(f)
+		if strings.TrimSpace(f.Name) == "" {
+			addf("formula %s: name is required", ref)
+		}
+		if f.Version < 1 {
+			addf("formula %s: version must be at least 1", ref)
+		}
+		if expression, ok := formulas[ref]; ok && expression != f.Expression {
+			addf("formula %s: existing versions are immutable, add a new version instead", ref)
+		}
+		checkExpression("formula "+ref, f.Expression)
+		formulas[ref] = f.Expression
+	}
+
+	routings := known(current.Routings, func(r *entity.ConfigRouting) string { return r.Name })
+	for key := range unique(desired.Routings, func(r *entity.ConfigRouting) string { return r.Name }, "routing", addf) {
+		routings[key] = true
+	}
+	for _, routing := range desired.Routings {
+		unique(routing.Steps, func(step *entity.ConfigStep) string { return stepKey(routing, step) }, "step", addf)
+		for _, step := range routing.Steps {
+			where := "step " + stepKey(routing, step)
+			if !processes[step.Process] {
+				addf("%s: unknown process %s", where, step.Process)
+			}
+			switch {
+			case step.Formula != "" && step.Expression != "":
+				addf("%s: set either expression or formula, not both", where)
+			case step.Formula != "":
+				if _, _, err := step.FormulaRef(); err != nil {
+					addf("%s: %v", where, err)
+				} else if _, ok := formulas[step.Formula]; !ok {
+					addf("%s: unknown formula %s", where, step.Formula)
+				}
+			default:
+				checkExpression(where, step.Expression)
+			}
+		}
+	}
+
+	unique(desired.RoutingRules, ruleKey, "routing rule", addf)
+	for _, rule := range desired.RoutingRules {
+		if !routings[rule.Routing] {
+			addf("routing rule %s: unknown routing %s", ruleKey(rule), rule.Routing)
+		}
+	}
+	return issues
+}
+
+// diffConfig lists the changes that applying desired over current makes, mirroring
+// ConfigRepository.Apply: items are created or updated, steps missing from a routing
+// and active rules missing from desired are deleted
+func diffConfig(current, desired *entity.CostingConfig) []*entity.ConfigChange {
+	var changes []*entity.ConfigChange
+
+	changes = append(changes, diffItems("parameter_group", current.ParameterGroups, desired.ParameterGroups, false,
+		func(g *entity.ConfigParameterGroup) string { return g.Code },
+		func(a, b *entity.ConfigParameterGroup) []string {
+			return changedFields("name", a.Name, b.Name, "description", a.Description, b.Description)
+		})...)
+	changes = append(changes, diffItems("parameter", current.Parameters, desired.Parameters, false,
+		func(p *entity.ConfigParameter) string { return p.Key },
+		func(a, b *entity.ConfigParameter) []string {
+			return changedFields("label", a.Label, b.Label, "data_type", a.DataType, b.DataType, "default", a.Default, b.Default,
+				"group", a.Group, b.Group, "unit", a.Unit, b.Unit, "required", a.Required, b.Required, "sequence", a.Sequence, b.Sequence)
+		})...)
+	changes = append(changes, diffItems("process", current.Processes, desired.Processes, false,
+		func(p *entity.ConfigProcess) string { return p.Code },
+		func(a, b *entity.ConfigProcess) []string {
+			return changedFields("name", a.Name, b.Name, "description", a.Description, b.Description,
+				"default_sequence", a.DefaultSequence, b.DefaultSequence, "output_kg_per_hour", a.OutputKgPerHour, b.OutputKgPerHour,
+				"setup_hours", a.SetupHours, b.SetupHours)
+		})...)
+	changes = append(changes, diffItems("formula", current.Formulas, desired.Formulas, false, formulaRef,
+		func(a, b *entity.ConfigFormula) []string { return nil })...)
+
+	currentRoutings := make(map[string]*entity.ConfigRouting, len(current.Routings))
+	for _, r := range current.Routings {
+		currentRoutings[r.Name] = r
+	}
+	changes = append(changes, diffItems("routing", current.Routings, desired.Routings, false,
+		func(r *entity.ConfigRouting) string { return r.Name },
+		func(a, b *entity.ConfigRouting) []string {
+			return changedFields("description", a.Description, b.Description, "inactive", a.Inactive, b.Inactive)
+		})...)
+	for _, routing := range desired.Routings {
+		var currentSteps []*entity.ConfigStep
+		if r, ok := currentRoutings[routing.Name]; ok {
+			currentSteps = r.Steps
+		}
+		changes = append(changes, diffItems("step", currentSteps, routing.Steps, true,
+			func(step *entity.ConfigStep) string { return stepKey(routing, step) },
+			func(a, b *entity.ConfigStep) []string {
+				return changedFields("process", a.Process, b.Process, "expression", a.Expression, b.Expression,
+					"formula", a.Formula, b.Formula, "description", a.Description, b.Description)
+			})...)
+	}
+
+	changes = append(changes, diffItems("routing_rule", current.RoutingRules, desired.RoutingRules, true, ruleKey,
+		func(a, b *entity.ConfigRoutingRule) []string {
+			return changedFields("routing", a.Routing, b.Routing, "priority", a.Priority, b.Priority)
+		})...)
+	return changes
+}
+
+// diffItems compares two keyed lists: desired items are created or updated, and with
+// prune current items missing from desired are deleted
+func diffItems[T any](kind string, current, desired []T, prune bool, key func(T) string, fields func(a, b T) []string) []*entity.ConfigChange {
+	var changes []*entity.ConfigChange
+	byKey := make(map[string]T, len(current))
+	for _, item := range current {
+		byKey[key(item)] = item
+	}
+	seen := make(map[string]bool, len(desired))
+	for _, item := range desired {
+		k := key(item)
+		seen[k] = true
+		existing, ok := byKey[k]
+		if !ok {
+			changes = append(changes, &entity.ConfigChange{Action: entity.ConfigChangeCreate, Kind: kind, Key: k})
+			continue
+		}
+		if changed := fields(existing, item); len(changed) > 0 {
+			changes = append(changes, &entity.ConfigChange{Action: entity.ConfigChangeUpdate, Kind: kind, Key: k, Fields: changed})
+		}
+	}
+	if prune {
+		for _, item := range current {
+			if k := key(item); !seen[k] {
+				changes = append(changes, &entity.ConfigChange{Action: entity.ConfigChangeDelete, Kind: kind, Key: k})
+			}
+		}
+	}
+	return changes
+}
+
+// changedFields takes (name, old, new) triples and returns the names whose values differ
+func changedFields(triples ...interface{}) []string {
+	var changed []string
+	for i := 0; i+2 < len(triples); i += 3 {
+		if triples[i+1] != triples[i+2] {
+			changed = append(changed, triples[i].(string))
+		}
+	}
+	return changed
+}
+
+// unique reports duplicate keys in items and returns the set of keys
+func unique[T any](items []T, key func(T) string, kind string, addf func(string, ...interface{})) map[string]bool {
+	keys := make(map[string]bool, len(items))
+	for _, item := range items {
+		k := key(item)
+		if strings.TrimSpace(k) == "" {
+			addf("%s: key is required", kind)
+			continue
+		}
+		if keys[k] {
+			addf("%s %s: defined more than once", kind, k)
+		}
+		keys[k] = true
+	}
+	return keys
+}
+
+// known returns the set of keys of items
+func known[T any](items []T, key func(T) string) map[string]bool {
+	keys := make(map[string]bool, len(items))
+	for _, item := range items {
+		keys[key(item)] = true
+	}
+	return keys
+}
+
+func formulaRef(f *entity.ConfigFormula) string {
+	return f.Name + "@" + strconv.Itoa(f.Version)
+}
+
+func stepKey(routing *entity.ConfigRouting, step *entity.ConfigStep) string {
+	return routing.Name + "#" + strconv.Itoa(step.Sequence)
+}
+
+func ruleKey(rule *entity.ConfigRoutingRule) string {
+	fiberType, grade := rule.FiberType, rule.Grade
+	if fiberType == "" {
+		fiberType = "*"
+	}
+	if grade == "" {
+		grade = "*"
+	}
+	return fiberType + "/" + grade
+}
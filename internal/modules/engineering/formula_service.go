@@ -0,0 +1,200 @@
+package engineering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ilramdhan/costing-mvp/internal/domain/entity"
+	"github.com/ilramdhan/costing-mvp/internal/domain/repository"
+	"github.com/ilramdhan/costing-mvp/pkg/formula"
+)
+
+// costUnit is the unit every process step formula must produce
+const costUnit = formula.DimCurrency
+
+// FormulaValidationError is returned when a formula fails unit checks on save
+type FormulaValidationError struct {
+	Issues []formula.UnitIssue
+}
+
+func (e *FormulaValidationError) Error() string {
+	return fmt.Sprintf("formula has %d unit issue(s), first: %s", len(e.Issues), e.Issues[0])
+}
+
+// FormulaService validates and saves process step formulas and the named formula library
+type FormulaService struct {
+	stepRepo    repository.ProcessStepRepository
+	paramRepo   repository.MasterParameterRepository
+	formulaRepo repository.FormulaRepository
+	parser      *formula.Parser
+}
+
+// NewFormulaService creates a new formula service
+func NewFormulaService(stepRepo repository.ProcessStepRepository, paramRepo repository.MasterParameterRepository, formulaRepo repository.FormulaRepository, parser *formula.Parser) *FormulaService {
+	return &FormulaService{
+		stepRepo:    stepRepo,
+		paramRepo:   paramRepo,
+		formulaRepo: formulaRepo,
+		parser:      parser,
+	}
+}
+
+// Validate rejects expressions over the parser's complexity limits, then checks it for
+// dimensional consistency against the units recorded in master_parameters
+func (s *FormulaService) Validate(ctx context.Context, expression string) ([]formula.UnitIssue, error) {
+	if err := s.parser.CheckComplexity(expression); err != nil {
+		return nil, err
+	}
+	units, err := s.paramRepo.Units(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parameter units: %w", err)
+	}
+	return formula.CheckUnits(expression, units, costUnit)
+}
+
+// UpdateStepFormula validates the expression and saves it on the process step.
+// Unit issues are returned as a *FormulaValidationError and nothing is saved.
+func (s *FormulaService) UpdateStepFormula(ctx context.Context, stepID uuid.UUID, expression string) error {
+	if err := s.check(ctx, expression); err != nil {
+		return err
+	}
+	return s.stepRepo.UpdateFormula(ctx, stepID, expression)
+}
+
+// SaveFormula validates the expression and stores it as the next version of the named
+// formula. Existing versions are never modified, so steps pinned to them keep their cost.
+func (s *FormulaService) SaveFormula(ctx context.Context, name, expression, description string) (*entity.Formula, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("formula name is required")
+	}
+	if err := s.check(ctx, expression); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	latest, err := s.formulaRepo.GetByName(ctx, name, 0)
+	switch {
+	case err == nil:
+		version = latest.Version + 1
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("failed to get formula %s: %w", name, err)
+	}
+
+	f := &entity.Formula{
+		ID:          uuid.New(),
+		Name:        name,
+		Version:     version,
+		Expression:  expression,
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.formulaRepo.Create(ctx, f); err != nil {
+		return nil, fmt.Errorf("failed to create formula: %w", err)
+	}
+	return f, nil
+}
+
+// AttachStepFormula points a process step at a library formula by name. Version 0
+// resolves to the latest version at the time of the call.
+func (s *FormulaService) AttachStepFormula(ctx context.Context, stepID uuid.UUID, name string, version int) (*entity.Formula, error) {
+	f, err := s.formulaRepo.GetByName(ctx, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get formula %s: %w", name, err)
+	}
+	if err := s.stepRepo.SetFormulaRef(ctx, stepID, f.ID); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *FormulaService) check(ctx context.Context, expression string) error {
+	issues, err := s.Validate(ctx, expression)
+	if err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		return &FormulaValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// SandboxResult is the outcome of a trial evaluation of a formula
+type SandboxResult struct {
+	Value     *float64               `json:"value"`
+	Error     string                 `json:"error,omitempty"`
+	Params    map[string]interface{} `json:"params"`
+	Generated []string               `json:"generated_params,omitempty"` // params filled in from defaults
+	Trace     []formula.TraceStep    `json:"trace"`
+	Issues    []formula.UnitIssue    `json:"unit_issues,omitempty"`
+}
+
+// Sandbox evaluates an arbitrary expression for interactive authoring. Parameters the
+// caller does not supply are taken from rates (current price rates), then from the
+// parameter's default value in master_parameters, and otherwise set to 1. Evaluation
+// errors are reported in the result rather than returned, so the trace is always shown.
+func (s *FormulaService) Sandbox(ctx context.Context, expression string, params, rates map[string]interface{}) (*SandboxResult, error) {
+	names, err := formula.Identifiers(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SandboxResult{Params: make(map[string]interface{}, len(names))}
+	var defaults map[string]float64
+	for _, name := range names {
+		if v, ok := params[name]; ok {
+			result.Params[name] = v
+			continue
+		}
+		if v, ok := rates[name]; ok {
+			result.Params[name] = v
+		} else {
+			if defaults == nil {
+				if defaults, err = s.parameterDefaults(ctx); err != nil {
+					return nil, err
+				}
+			}
+			v, ok := defaults[name]
+			if !ok {
+				v = 1
+			}
+			result.Params[name] = v
+		}
+		result.Generated = append(result.Generated, name)
+	}
+
+	value, trace, err := s.parser.Trace(expression, result.Params)
+	result.Trace = trace
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Value = &value
+	}
+
+	if result.Issues, err = s.Validate(ctx, expression); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parameterDefaults returns the numeric default values recorded in master_parameters
+func (s *FormulaService) parameterDefaults(ctx context.Context) (map[string]float64, error) {
+	params, err := s.paramRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parameters: %w", err)
+	}
+	defaults := make(map[string]float64, len(params))
+	for _, p := range params {
+		if v, err := strconv.ParseFloat(p.DefaultValue, 64); err == nil {
+			defaults[p.Key] = v
+		}
+	}
+	return defaults, nil
+}
@@ -0,0 +1,15 @@
+-- Default routing assignment rules
+-- Variants created without an explicit routing get the routing of the most specific matching rule
+
+CREATE TABLE routing_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    fiber_type VARCHAR(50), -- NULL matches any fiber type
+    grade VARCHAR(50), -- NULL matches any grade
+    routing_template_id UUID NOT NULL REFERENCES routing_templates(id) ON DELETE CASCADE,
+    priority INT NOT NULL DEFAULT 0, -- lower wins among equally specific rules
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_routing_rules_active ON routing_rules(is_active) WHERE is_active = TRUE;
+CREATE UNIQUE INDEX idx_routing_rules_match ON routing_rules(COALESCE(fiber_type, ''), COALESCE(grade, '')) WHERE is_active = TRUE;
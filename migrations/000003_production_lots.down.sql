@@ -0,0 +1,5 @@
+-- Rollback migration
+
+DROP TRIGGER IF EXISTS trg_production_lots_updated ON production_lots;
+DROP TABLE IF EXISTS production_lots;
+DROP TYPE IF EXISTS lot_status;
@@ -0,0 +1,27 @@
+-- Production lots: batch_no as a first-class entity with lot-level actuals and costing
+
+CREATE TYPE lot_status AS ENUM ('PLANNED', 'IN_PRODUCTION', 'COMPLETED', 'CLOSED');
+
+CREATE TABLE production_lots (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    yarn_variant_id UUID NOT NULL REFERENCES yarn_variants(id) ON DELETE CASCADE,
+    batch_no VARCHAR(100) NOT NULL,
+    production_date DATE NOT NULL DEFAULT CURRENT_DATE,
+    quantity DECIMAL(18, 6) NOT NULL DEFAULT 0, -- produced quantity (kg)
+    status lot_status NOT NULL DEFAULT 'PLANNED',
+    actual_values JSONB DEFAULT '{}', -- measured parameter values overriding the standard inputs
+    standard_cost DECIMAL(18, 6), -- unit cost from standard parameters at costing time
+    actual_cost DECIMAL(18, 6), -- unit cost with actual_values applied
+    costed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(yarn_variant_id, batch_no)
+);
+
+CREATE INDEX idx_production_lots_variant ON production_lots(yarn_variant_id);
+CREATE INDEX idx_production_lots_batch ON production_lots(batch_no);
+CREATE INDEX idx_production_lots_date ON production_lots(production_date DESC);
+
+CREATE TRIGGER trg_production_lots_updated
+    BEFORE UPDATE ON production_lots
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
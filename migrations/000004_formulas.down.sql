@@ -0,0 +1,4 @@
+-- Rollback migration
+
+ALTER TABLE process_steps DROP COLUMN IF EXISTS formula_id;
+DROP TABLE IF EXISTS formulas;
@@ -0,0 +1,19 @@
+-- Named formula library
+-- Process steps may reference a versioned formula instead of carrying their own expression text
+
+CREATE TABLE formulas (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL, -- e.g., "standard_dyeing_cost"
+    version INT NOT NULL DEFAULT 1,
+    expression TEXT NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(name, version)
+);
+
+CREATE INDEX idx_formulas_name ON formulas(name);
+
+-- When set, the referenced formula's expression takes precedence over formula_expression
+ALTER TABLE process_steps ADD COLUMN formula_id UUID REFERENCES formulas(id);
+
+CREATE INDEX idx_process_steps_formula ON process_steps(formula_id) WHERE formula_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Rollback migration
+
+ALTER TABLE process_masters
+    DROP COLUMN IF EXISTS output_kg_per_hour,
+    DROP COLUMN IF EXISTS setup_hours;
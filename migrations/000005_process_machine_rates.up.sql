@@ -0,0 +1,5 @@
+-- Machine rates on process masters, used to derive step durations for planning timelines
+
+ALTER TABLE process_masters
+    ADD COLUMN output_kg_per_hour NUMERIC(12, 4), -- machine throughput; NULL when unknown
+    ADD COLUMN setup_hours NUMERIC(8, 2) NOT NULL DEFAULT 0;
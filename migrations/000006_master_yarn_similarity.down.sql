@@ -0,0 +1,3 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_master_yarns_name_trgm;
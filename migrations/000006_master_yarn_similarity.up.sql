@@ -0,0 +1,5 @@
+-- Trigram index for fuzzy duplicate detection on master yarn names
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_master_yarns_name_trgm ON master_yarns USING gin (lower(name) gin_trgm_ops);
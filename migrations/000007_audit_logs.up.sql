@@ -0,0 +1,13 @@
+-- Audit log for structural changes to master data (merges, splits)
+
+CREATE TABLE audit_logs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    entity_type VARCHAR(50) NOT NULL, -- e.g., "master_yarn"
+    entity_id UUID NOT NULL,
+    action VARCHAR(50) NOT NULL, -- e.g., "MERGE_INTO", "SPLIT"
+    actor VARCHAR(255),
+    details JSONB DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_logs_entity ON audit_logs(entity_type, entity_id, created_at DESC);
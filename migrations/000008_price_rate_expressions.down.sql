@@ -0,0 +1,6 @@
+-- Rollback migration
+
+DELETE FROM price_rates WHERE rate_value IS NULL;
+ALTER TABLE price_rates DROP CONSTRAINT IF EXISTS chk_price_rates_value;
+ALTER TABLE price_rates ALTER COLUMN rate_value SET NOT NULL;
+ALTER TABLE price_rates DROP COLUMN IF EXISTS rate_expression;
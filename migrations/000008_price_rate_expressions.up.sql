@@ -0,0 +1,7 @@
+-- Derived price rates: a rate may be an expression over other rates
+-- (e.g. base_oil_index * 0.8 + 5), evaluated when rates are loaded
+
+ALTER TABLE price_rates ADD COLUMN rate_expression TEXT;
+ALTER TABLE price_rates ALTER COLUMN rate_value DROP NOT NULL;
+ALTER TABLE price_rates ADD CONSTRAINT chk_price_rates_value
+    CHECK ((rate_value IS NULL) <> (rate_expression IS NULL));
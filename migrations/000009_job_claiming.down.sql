@@ -0,0 +1,5 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_batch_jobs_pending;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS claimed_at;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS claimed_by;
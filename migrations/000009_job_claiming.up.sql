@@ -0,0 +1,8 @@
+-- Job claiming: workers take PENDING jobs with SELECT ... FOR UPDATE SKIP LOCKED,
+-- so several worker instances never process the same job
+
+ALTER TABLE batch_jobs
+    ADD COLUMN claimed_by VARCHAR(100), -- worker instance ID
+    ADD COLUMN claimed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_batch_jobs_pending ON batch_jobs(created_at) WHERE status = 'PENDING';
@@ -0,0 +1,4 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_vcs_category_breakdown_missing;
+ALTER TABLE variant_cost_summaries DROP COLUMN IF EXISTS category_breakdown;
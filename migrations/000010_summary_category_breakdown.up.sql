@@ -0,0 +1,8 @@
+-- Category breakdown: process cost per process code on the summary read model.
+-- NULL means not computed yet; `costing backfill --field category_breakdown` fills it
+-- from the stored per-step costs without a full recalculation.
+
+ALTER TABLE variant_cost_summaries ADD COLUMN category_breakdown JSONB;
+
+CREATE INDEX idx_vcs_category_breakdown_missing ON variant_cost_summaries(yarn_variant_id)
+    WHERE category_breakdown IS NULL;
@@ -0,0 +1,7 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_batch_jobs_pending;
+CREATE INDEX idx_batch_jobs_pending ON batch_jobs(created_at) WHERE status = 'PENDING';
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS next_retry_at;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS max_attempts;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS attempts;
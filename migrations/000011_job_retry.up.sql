@@ -0,0 +1,10 @@
+-- Job retry policy: a failed job goes back to PENDING with next_retry_at pushed out by
+-- an exponential backoff until it has run max_attempts times, then stays FAILED
+
+ALTER TABLE batch_jobs
+    ADD COLUMN attempts INT NOT NULL DEFAULT 0, -- runs started so far
+    ADD COLUMN max_attempts INT NOT NULL DEFAULT 3,
+    ADD COLUMN next_retry_at TIMESTAMP WITH TIME ZONE; -- not claimable before this time
+
+DROP INDEX IF EXISTS idx_batch_jobs_pending;
+CREATE INDEX idx_batch_jobs_pending ON batch_jobs(COALESCE(next_retry_at, created_at)) WHERE status = 'PENDING';
@@ -0,0 +1,5 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_batch_jobs_pending;
+CREATE INDEX idx_batch_jobs_pending ON batch_jobs(COALESCE(next_retry_at, created_at)) WHERE status = 'PENDING';
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,7 @@
+-- Job priority: workers claim the highest priority PENDING job first, then the oldest,
+-- so a single-variant recalculation is not queued behind a full recalculation
+
+ALTER TABLE batch_jobs ADD COLUMN priority INT NOT NULL DEFAULT 0; -- higher runs first
+
+DROP INDEX IF EXISTS idx_batch_jobs_pending;
+CREATE INDEX idx_batch_jobs_pending ON batch_jobs(priority DESC, COALESCE(next_retry_at, created_at)) WHERE status = 'PENDING';
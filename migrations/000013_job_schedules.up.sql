@@ -0,0 +1,19 @@
+-- Recurring jobs: worker instances materialize a PENDING batch job from every active
+-- schedule whose next_run_at has passed, then advance next_run_at from the cron expression
+
+CREATE TABLE job_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL UNIQUE,
+    cron_expression VARCHAR(100) NOT NULL, -- minute hour day-of-month month day-of-week, e.g. "0 2 1 * *"
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA zone the cron expression is evaluated in
+    job_type job_type NOT NULL,
+    params JSONB DEFAULT '{}', -- copied into the job's metadata
+    priority INT, -- NULL uses the job type's default priority
+    is_active BOOLEAN DEFAULT TRUE,
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    last_job_id UUID REFERENCES batch_jobs(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_job_schedules_due ON job_schedules(next_run_at) WHERE is_active = TRUE;
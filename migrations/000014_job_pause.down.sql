@@ -0,0 +1,4 @@
+-- Rollback migration
+
+-- PostgreSQL cannot drop an enum value; PAUSED stays defined but unused
+UPDATE batch_jobs SET status = 'CANCELLED' WHERE status = 'PAUSED';
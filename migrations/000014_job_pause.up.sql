@@ -0,0 +1,4 @@
+-- Pause and resume: a PAUSED job is left alone by workers until resumed to PENDING.
+-- Recalculations checkpoint their position in metadata->'checkpoint' and resume from it.
+
+ALTER TYPE job_status ADD VALUE IF NOT EXISTS 'PAUSED';
@@ -0,0 +1,4 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_batch_jobs_running_heartbeat;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS heartbeat_at;
@@ -0,0 +1,6 @@
+-- Worker heartbeats: the worker running a job refreshes heartbeat_at; a RUNNING job whose
+-- heartbeat is older than the stale threshold was left by a crashed worker and is reaped
+
+ALTER TABLE batch_jobs ADD COLUMN heartbeat_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_batch_jobs_running_heartbeat ON batch_jobs(heartbeat_at) WHERE status = 'RUNNING';
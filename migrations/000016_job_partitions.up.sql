@@ -0,0 +1,20 @@
+-- Partitioned jobs: a large recalculation is split into variant ID ranges that worker
+-- replicas claim independently. A worker holds a session advisory lock on the partition it
+-- runs, so a crashed worker's partition is taken over as soon as its connection drops.
+
+CREATE TABLE job_partitions (
+    job_id UUID NOT NULL REFERENCES batch_jobs(id) ON DELETE CASCADE,
+    partition_no INT NOT NULL,
+    after_variant_id UUID NOT NULL, -- exclusive lower bound of the range
+    through_variant_id UUID NOT NULL, -- inclusive upper bound of the range
+    status job_status NOT NULL DEFAULT 'PENDING',
+    claimed_by VARCHAR(255),
+    checkpoint_variant_id UUID, -- last variant written, in ID order
+    processed_records BIGINT NOT NULL DEFAULT 0,
+    failed_records BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (job_id, partition_no)
+);
+
+CREATE INDEX idx_job_partitions_open ON job_partitions(job_id) WHERE status <> 'COMPLETED';
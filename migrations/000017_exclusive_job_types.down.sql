@@ -0,0 +1,3 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_batch_jobs_one_running_exclusive;
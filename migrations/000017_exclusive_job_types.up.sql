@@ -0,0 +1,6 @@
+-- Mutual exclusion for job types that rewrite every summary: at most one RECALCULATE_ALL
+-- job may be RUNNING. Workers leave further ones PENDING until it finishes.
+-- Keep the type list in sync with entity.JobType.Exclusive.
+
+CREATE UNIQUE INDEX idx_batch_jobs_one_running_exclusive ON batch_jobs(job_type)
+    WHERE status = 'RUNNING' AND job_type = 'RECALCULATE_ALL';
@@ -0,0 +1,15 @@
+-- Job chunks: every keyset page a recalculation dispatches is recorded with its variant ID
+-- range and status, so an interrupted job resumes from its last completed chunk and skips
+-- chunks that had completed past it.
+
+CREATE TABLE job_chunks (
+    job_id UUID NOT NULL REFERENCES batch_jobs(id) ON DELETE CASCADE,
+    after_variant_id UUID NOT NULL, -- exclusive start of the range
+    through_variant_id UUID NOT NULL, -- inclusive end of the range
+    status job_status NOT NULL DEFAULT 'RUNNING',
+    processed_records BIGINT NOT NULL DEFAULT 0,
+    failed_records BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (job_id, after_variant_id)
+);
@@ -0,0 +1,3 @@
+-- Rollback migration
+
+DROP TABLE IF EXISTS variant_dead_letters;
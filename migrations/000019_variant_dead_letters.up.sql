@@ -0,0 +1,15 @@
+-- Dead letters: variants whose calculation keeps failing (bad routing, broken formula).
+-- Failures are counted per variant and reset when it calculates again; at the configured
+-- count the variant is dead-lettered and left out of full recalculations until requeued.
+
+CREATE TABLE variant_dead_letters (
+    variant_id UUID PRIMARY KEY REFERENCES yarn_variants(id) ON DELETE CASCADE,
+    failure_count INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    last_job_id UUID,
+    first_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    dead_lettered_at TIMESTAMP WITH TIME ZONE -- NULL while still being retried
+);
+
+CREATE INDEX idx_variant_dead_letters_dead ON variant_dead_letters(dead_lettered_at) WHERE dead_lettered_at IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Rollback migration
+
+DROP TRIGGER IF EXISTS trg_batch_jobs_webhooks ON batch_jobs;
+DROP FUNCTION IF EXISTS enqueue_job_webhooks();
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TYPE IF EXISTS webhook_delivery_status;
+DROP TABLE IF EXISTS webhook_subscriptions;
@@ -0,0 +1,50 @@
+-- Webhooks: external systems subscribe to job events and get a signed HTTP callback.
+-- A trigger queues a delivery for each matching subscription whenever a job reaches
+-- COMPLETED or FAILED, whichever process finished it; workers send and retry them.
+
+CREATE TABLE webhook_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL, -- HMAC-SHA256 key for the signature header
+    event_types TEXT[] NOT NULL, -- e.g. {job.completed,job.failed}
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TYPE webhook_delivery_status AS ENUM ('PENDING', 'DELIVERED', 'FAILED');
+
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    job_id UUID NOT NULL REFERENCES batch_jobs(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    status webhook_delivery_status NOT NULL DEFAULT 'PENDING',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    response_status INT,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id, created_at DESC);
+
+CREATE OR REPLACE FUNCTION enqueue_job_webhooks()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_event TEXT := 'job.' || lower(NEW.status::text);
+BEGIN
+    IF NEW.status IN ('COMPLETED', 'FAILED') AND NEW.status IS DISTINCT FROM OLD.status THEN
+        INSERT INTO webhook_deliveries (subscription_id, job_id, event_type)
+        SELECT s.id, NEW.id, v_event
+        FROM webhook_subscriptions s
+        WHERE s.is_active AND v_event = ANY(s.event_types);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_batch_jobs_webhooks
+    AFTER UPDATE OF status ON batch_jobs
+    FOR EACH ROW EXECUTE FUNCTION enqueue_job_webhooks();
@@ -0,0 +1,5 @@
+-- Rollback migration
+
+DROP TRIGGER IF EXISTS trg_batch_jobs_events ON batch_jobs;
+DROP FUNCTION IF EXISTS record_job_events();
+DROP TABLE IF EXISTS job_events;
@@ -0,0 +1,67 @@
+-- Job event log: a trigger records every status transition of a batch job, plus each
+-- 10% progress milestone, so long runs can be reconstructed afterwards. The actor is the
+-- application_name of the connection that made the change (the API or a worker instance).
+
+CREATE TABLE job_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    job_id UUID NOT NULL REFERENCES batch_jobs(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL, -- STATUS or PROGRESS
+    from_status job_status, -- NULL when the job was created
+    to_status job_status NOT NULL,
+    processed_records BIGINT NOT NULL DEFAULT 0,
+    failed_records BIGINT NOT NULL DEFAULT 0,
+    total_records BIGINT NOT NULL DEFAULT 0,
+    message TEXT, -- the job's error message when it changed with the transition
+    actor VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX idx_job_events_job ON job_events(job_id, created_at);
+
+CREATE OR REPLACE FUNCTION record_job_events()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_actor TEXT := NULLIF(current_setting('application_name', true), '');
+    v_old_step BIGINT := 0;
+    v_new_step BIGINT := 0;
+    v_message TEXT;
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
+        IF TG_OP = 'INSERT' OR NEW.error_message IS DISTINCT FROM OLD.error_message THEN
+            v_message := NEW.error_message;
+        END IF;
+        INSERT INTO job_events (job_id, event_type, from_status, to_status, processed_records,
+            failed_records, total_records, message, actor)
+        VALUES (NEW.id, 'STATUS', CASE WHEN TG_OP = 'UPDATE' THEN OLD.status END, NEW.status,
+            COALESCE(NEW.processed_records, 0), COALESCE(NEW.failed_records, 0),
+            COALESCE(NEW.total_records, 0), v_message, v_actor);
+        RETURN NEW;
+    END IF;
+
+    -- Progress milestones: one event each time the job crosses another 10% of its total
+    IF NEW.status = 'RUNNING' AND COALESCE(NEW.total_records, 0) > 0 THEN
+        v_new_step := (COALESCE(NEW.processed_records, 0) + COALESCE(NEW.failed_records, 0)) * 10 / NEW.total_records;
+        IF COALESCE(OLD.total_records, 0) > 0 THEN
+            v_old_step := (COALESCE(OLD.processed_records, 0) + COALESCE(OLD.failed_records, 0)) * 10 / OLD.total_records;
+        END IF;
+        IF v_new_step > v_old_step AND v_new_step BETWEEN 1 AND 9 THEN
+            INSERT INTO job_events (job_id, event_type, from_status, to_status, processed_records,
+                failed_records, total_records, actor)
+            VALUES (NEW.id, 'PROGRESS', NEW.status, NEW.status, NEW.processed_records,
+                NEW.failed_records, NEW.total_records, v_actor);
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_batch_jobs_events
+    AFTER INSERT OR UPDATE OF status, processed_records, failed_records, total_records ON batch_jobs
+    FOR EACH ROW EXECUTE FUNCTION record_job_events();
+
+-- Jobs created before this migration start their log with their current status
+INSERT INTO job_events (job_id, event_type, to_status, processed_records, failed_records,
+    total_records, message, created_at)
+SELECT id, 'STATUS', status, COALESCE(processed_records, 0), COALESCE(failed_records, 0),
+    COALESCE(total_records, 0), error_message, COALESCE(finished_at, started_at, created_at, NOW())
+FROM batch_jobs;
@@ -0,0 +1,3 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_job_events_created;
@@ -0,0 +1,4 @@
+-- Job retention: finished jobs and job events older than the retention period are deleted
+-- in batches; deleting a job cascades to its partitions, chunks, events and deliveries
+
+CREATE INDEX idx_job_events_created ON job_events(created_at);
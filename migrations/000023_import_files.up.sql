@@ -0,0 +1,12 @@
+-- Data imports: an uploaded file is staged in the database with its IMPORT_DATA job, so
+-- any worker instance can read it; it is removed once the import completes
+
+CREATE TABLE import_files (
+    job_id UUID PRIMARY KEY REFERENCES batch_jobs(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL, -- masters, variants or rates
+    format VARCHAR(10) NOT NULL, -- csv or ndjson
+    filename VARCHAR(255),
+    content BYTEA NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,9 @@
+-- Rollback migration
+
+CREATE INDEX IF NOT EXISTS idx_yarn_variants_routing ON yarn_variants(routing_template_id);
+DROP INDEX IF EXISTS idx_yarn_variants_routing_id;
+
+-- PostgreSQL cannot drop an enum value; RECALCULATE_ROUTING stays defined but unused
+UPDATE batch_jobs SET status = 'CANCELLED'
+WHERE job_type = 'RECALCULATE_ROUTING' AND status IN ('PENDING', 'RUNNING', 'PAUSED');
+DELETE FROM job_schedules WHERE job_type = 'RECALCULATE_ROUTING';
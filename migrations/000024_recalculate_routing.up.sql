@@ -0,0 +1,8 @@
+-- Recalculating only the variants of one routing template, e.g. after one of its formulas
+-- changes. The dispatcher walks the routing's variants in ID order, which this index
+-- serves; it also covers every lookup by routing alone, so it replaces the old index.
+
+ALTER TYPE job_type ADD VALUE IF NOT EXISTS 'RECALCULATE_ROUTING';
+
+CREATE INDEX IF NOT EXISTS idx_yarn_variants_routing_id ON yarn_variants(routing_template_id, id);
+DROP INDEX IF EXISTS idx_yarn_variants_routing;
@@ -0,0 +1,7 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_vcs_created;
+CREATE INDEX IF NOT EXISTS idx_yarn_variants_master ON yarn_variants(master_yarn_id);
+DROP INDEX IF EXISTS idx_yarn_variants_master_created;
+DROP INDEX IF EXISTS idx_yarn_variants_created;
+DROP INDEX IF EXISTS idx_master_yarns_created;
@@ -0,0 +1,10 @@
+-- Keyset pagination: list endpoints page through masters, variants and summaries by
+-- (created_at, id), newest first, seeking to the last row of the previous page instead
+-- of skipping an offset. These indexes serve that order when scanned backwards. The
+-- per-master index also covers every lookup by master alone, so it replaces the old one.
+
+CREATE INDEX IF NOT EXISTS idx_master_yarns_created ON master_yarns(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_yarn_variants_created ON yarn_variants(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_yarn_variants_master_created ON yarn_variants(master_yarn_id, created_at, id);
+DROP INDEX IF EXISTS idx_yarn_variants_master;
+CREATE INDEX IF NOT EXISTS idx_vcs_created ON variant_cost_summaries(created_at, yarn_variant_id);
@@ -0,0 +1,5 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_audit_logs_actor;
+DROP INDEX IF EXISTS idx_audit_logs_created;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS changes;
@@ -0,0 +1,8 @@
+-- The audit log covers every change to master data, formulas and price rates, not only
+-- merges and splits. Each entry records the changed fields with their values before and
+-- after; the log is browsed newest first, optionally by actor.
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS changes JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created ON audit_logs(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor ON audit_logs(actor, created_at, id);
@@ -0,0 +1,35 @@
+-- Rollback migration
+
+CREATE TABLE variant_process_costs_prev (
+    id UUID DEFAULT uuid_generate_v4(),
+    yarn_variant_id UUID NOT NULL,
+    process_step_id UUID NOT NULL,
+    input_values JSONB DEFAULT '{}',
+    calculated_cost DECIMAL(18, 6) DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (id, yarn_variant_id)
+) PARTITION BY HASH (yarn_variant_id);
+
+DO $$
+BEGIN
+    FOR i IN 0..15 LOOP
+        EXECUTE format('CREATE TABLE variant_process_costs_prev_p%s PARTITION OF variant_process_costs_prev FOR VALUES WITH (MODULUS 16, REMAINDER %s)', i, i);
+    END LOOP;
+END $$;
+
+INSERT INTO variant_process_costs_prev (id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at)
+SELECT id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at FROM variant_process_costs;
+
+DROP TABLE variant_process_costs;
+ALTER TABLE variant_process_costs_prev RENAME TO variant_process_costs;
+ALTER TABLE variant_process_costs RENAME CONSTRAINT variant_process_costs_prev_pkey TO variant_process_costs_pkey;
+DO $$
+BEGIN
+    FOR i IN 0..15 LOOP
+        EXECUTE format('ALTER TABLE variant_process_costs_prev_p%s RENAME TO variant_process_costs_p%s', i, i);
+    END LOOP;
+END $$;
+
+CREATE INDEX idx_vpc_variant ON variant_process_costs(yarn_variant_id);
+CREATE INDEX idx_vpc_step ON variant_process_costs(process_step_id);
+CREATE INDEX idx_vpc_input_values ON variant_process_costs USING GIN (input_values);
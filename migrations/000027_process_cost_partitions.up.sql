@@ -0,0 +1,45 @@
+-- variant_process_costs holds one row per variant and step: 60M rows for 10M variants of
+-- 6 steps. It is rebuilt with 64 hash partitions on yarn_variant_id, so each partition
+-- stays near a million rows and every per-variant read and write touches one partition.
+-- Range partitions on updated_at were rejected: every recalculation would move its rows
+-- to another partition.
+--
+-- The key becomes (yarn_variant_id, process_step_id). A unique index on a partitioned
+-- table must include the partition key, and with the old key of a random id plus the
+-- variant, upserts never conflicted and added a row for the step on every write.
+
+CREATE TABLE variant_process_costs_next (
+    id UUID NOT NULL DEFAULT uuid_generate_v4(),
+    yarn_variant_id UUID NOT NULL,
+    process_step_id UUID NOT NULL,
+    input_values JSONB DEFAULT '{}',
+    calculated_cost DECIMAL(18, 6) DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT variant_process_costs_key PRIMARY KEY (yarn_variant_id, process_step_id)
+) PARTITION BY HASH (yarn_variant_id);
+
+DO $$
+BEGIN
+    FOR i IN 0..63 LOOP
+        EXECUTE format('CREATE TABLE variant_process_costs_next_p%s PARTITION OF variant_process_costs_next FOR VALUES WITH (MODULUS 64, REMAINDER %s)', i, i);
+    END LOOP;
+END $$;
+
+-- A step may have several rows from the old upserts; the latest is kept
+INSERT INTO variant_process_costs_next (id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at)
+SELECT DISTINCT ON (yarn_variant_id, process_step_id) id, yarn_variant_id, process_step_id, input_values, calculated_cost, updated_at
+FROM variant_process_costs
+ORDER BY yarn_variant_id, process_step_id, updated_at DESC;
+
+DROP TABLE variant_process_costs;
+ALTER TABLE variant_process_costs_next RENAME TO variant_process_costs;
+DO $$
+BEGIN
+    FOR i IN 0..63 LOOP
+        EXECUTE format('ALTER TABLE variant_process_costs_next_p%s RENAME TO variant_process_costs_p%s', i, i);
+    END LOOP;
+END $$;
+
+-- The key serves lookups by variant, which replaced idx_vpc_variant
+CREATE INDEX idx_vpc_step ON variant_process_costs(process_step_id);
+CREATE INDEX idx_vpc_input_values ON variant_process_costs USING GIN (input_values);
@@ -0,0 +1,11 @@
+-- Rollback migration
+
+DROP TRIGGER IF EXISTS trg_master_yarns_search_vector ON master_yarns;
+DROP FUNCTION IF EXISTS master_yarns_search_vector_trigger();
+DROP TRIGGER IF EXISTS trg_yarn_variants_search_vector ON yarn_variants;
+DROP FUNCTION IF EXISTS yarn_variants_search_vector_trigger();
+DROP INDEX IF EXISTS idx_yarn_variants_search;
+ALTER TABLE yarn_variants DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS yarn_variant_search_vector(TEXT, TEXT, TEXT, TEXT);
+DROP INDEX IF EXISTS idx_master_yarns_search;
+ALTER TABLE master_yarns DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,62 @@
+-- Full-text search over master yarns and variants. Codes and SKUs are not words of any
+-- language, so vectors use the simple configuration: no stemming, no stop words.
+--
+-- A variant's vector includes its master yarn's code and name, so "BATCH-42 polyester"
+-- finds variants of batch 42 of a polyester yarn. It is kept by triggers, since a
+-- generated column cannot read another table.
+
+ALTER TABLE master_yarns ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(code, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(name, '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(description, '')), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_master_yarns_search ON master_yarns USING GIN (search_vector);
+
+CREATE OR REPLACE FUNCTION yarn_variant_search_vector(sku TEXT, batch_no TEXT, master_code TEXT, master_name TEXT)
+RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(sku, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce(batch_no, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce(master_code, '')), 'B') ||
+           setweight(to_tsvector('simple', coalesce(master_name, '')), 'B')
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE yarn_variants ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+UPDATE yarn_variants v
+SET search_vector = yarn_variant_search_vector(v.sku, v.batch_no, m.code, m.name)
+FROM master_yarns m
+WHERE m.id = v.master_yarn_id;
+
+CREATE INDEX IF NOT EXISTS idx_yarn_variants_search ON yarn_variants USING GIN (search_vector);
+
+CREATE OR REPLACE FUNCTION yarn_variants_search_vector_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    SELECT yarn_variant_search_vector(NEW.sku, NEW.batch_no, m.code, m.name)
+    INTO NEW.search_vector
+    FROM master_yarns m
+    WHERE m.id = NEW.master_yarn_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_yarn_variants_search_vector
+    BEFORE INSERT OR UPDATE OF sku, batch_no, master_yarn_id ON yarn_variants
+    FOR EACH ROW EXECUTE FUNCTION yarn_variants_search_vector_trigger();
+
+-- Renaming a master yarn re-indexes its variants
+CREATE OR REPLACE FUNCTION master_yarns_search_vector_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE yarn_variants
+    SET search_vector = yarn_variant_search_vector(sku, batch_no, NEW.code, NEW.name)
+    WHERE master_yarn_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_master_yarns_search_vector
+    AFTER UPDATE OF code, name ON master_yarns
+    FOR EACH ROW
+    WHEN (OLD.code IS DISTINCT FROM NEW.code OR OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION master_yarns_search_vector_trigger();
@@ -0,0 +1,6 @@
+-- Rollback migration
+
+DROP INDEX IF EXISTS idx_master_yarns_yarn_count;
+DROP INDEX IF EXISTS idx_master_yarns_fixed_attrs;
+CREATE INDEX IF NOT EXISTS idx_master_yarns_fixed_attrs ON master_yarns USING GIN (fixed_attrs);
+DROP FUNCTION IF EXISTS jsonb_attr_numeric(JSONB, TEXT);
@@ -0,0 +1,15 @@
+-- Structured queries on master_yarns.fixed_attrs. Equality and membership filters are
+-- containment (@>), which a jsonb_path_ops GIN index serves with a smaller index than
+-- the default operator class. Range filters read numbers through jsonb_attr_numeric,
+-- which is NULL for a missing or non-numeric attribute rather than failing the cast.
+
+CREATE OR REPLACE FUNCTION jsonb_attr_numeric(attrs JSONB, key TEXT)
+RETURNS NUMERIC AS $$
+    SELECT CASE WHEN jsonb_typeof(attrs -> key) = 'number' THEN (attrs ->> key)::numeric END
+$$ LANGUAGE SQL IMMUTABLE;
+
+DROP INDEX IF EXISTS idx_master_yarns_fixed_attrs;
+CREATE INDEX IF NOT EXISTS idx_master_yarns_fixed_attrs ON master_yarns USING GIN (fixed_attrs jsonb_path_ops);
+
+-- yarn_count is the attribute most often queried by range
+CREATE INDEX IF NOT EXISTS idx_master_yarns_yarn_count ON master_yarns (jsonb_attr_numeric(fixed_attrs, 'yarn_count'));
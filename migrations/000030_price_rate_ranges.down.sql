@@ -0,0 +1,5 @@
+-- Rollback migration; expiry dates set by the up migration are kept
+
+CREATE INDEX IF NOT EXISTS idx_price_rates_param ON price_rates(parameter_key);
+ALTER TABLE price_rates DROP CONSTRAINT IF EXISTS excl_price_rates_overlap;
+ALTER TABLE price_rates DROP CONSTRAINT IF EXISTS chk_price_rates_range;
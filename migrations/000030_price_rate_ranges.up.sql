@@ -0,0 +1,29 @@
+-- A parameter has at most one rate on any date: effective ranges [effective_date,
+-- expired_date) of a parameter may not overlap. Existing rates are made to fit first. A
+-- rate that never applied, expiring on or before it took effect, becomes the empty range
+-- at its effective date; an open or overlapping rate expires when the next one takes
+-- effect, which is already the rate every as-of lookup picked.
+
+UPDATE price_rates SET expired_date = effective_date WHERE expired_date < effective_date;
+
+UPDATE price_rates p
+SET expired_date = n.next_effective
+FROM (
+    SELECT id, LEAD(effective_date) OVER (PARTITION BY parameter_key ORDER BY effective_date) AS next_effective
+    FROM price_rates
+    WHERE expired_date IS NULL OR expired_date > effective_date
+) n
+WHERE p.id = n.id
+  AND n.next_effective IS NOT NULL
+  AND (p.expired_date IS NULL OR p.expired_date > n.next_effective);
+
+ALTER TABLE price_rates ADD CONSTRAINT chk_price_rates_range
+    CHECK (expired_date IS NULL OR expired_date > effective_date) NOT VALID;
+
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE price_rates ADD CONSTRAINT excl_price_rates_overlap
+    EXCLUDE USING gist (parameter_key WITH =, daterange(effective_date, expired_date) WITH &&);
+
+-- The exclusion index serves as-of lookups and history by parameter
+DROP INDEX IF EXISTS idx_price_rates_param;
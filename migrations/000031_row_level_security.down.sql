@@ -0,0 +1,36 @@
+-- Rollback migration; fails while two tenants share a master yarn code or variant SKU
+
+DROP INDEX IF EXISTS idx_batch_jobs_one_running_exclusive;
+CREATE UNIQUE INDEX idx_batch_jobs_one_running_exclusive ON batch_jobs(job_type)
+    WHERE status = 'RUNNING' AND job_type = 'RECALCULATE_ALL';
+
+ALTER TABLE yarn_variants DROP CONSTRAINT IF EXISTS yarn_variants_tenant_sku_key;
+ALTER TABLE yarn_variants ADD CONSTRAINT yarn_variants_sku_key UNIQUE (sku);
+ALTER TABLE master_yarns DROP CONSTRAINT IF EXISTS master_yarns_tenant_code_key;
+ALTER TABLE master_yarns ADD CONSTRAINT master_yarns_code_key UNIQUE (code);
+
+DO $$
+DECLARE
+    t TEXT;
+    p REGCLASS;
+BEGIN
+    FOR p IN SELECT inhrelid::regclass FROM pg_inherits WHERE inhparent = 'variant_process_costs'::regclass LOOP
+        EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %s', p);
+        EXECUTE format('ALTER TABLE %s NO FORCE ROW LEVEL SECURITY', p);
+        EXECUTE format('ALTER TABLE %s DISABLE ROW LEVEL SECURITY', p);
+    END LOOP;
+
+    FOREACH t IN ARRAY ARRAY[
+        'master_yarns', 'yarn_variants', 'variant_process_costs', 'variant_cost_summaries',
+        'variant_dead_letters', 'production_lots', 'batch_jobs', 'job_events', 'job_chunks',
+        'job_partitions', 'job_schedules', 'import_files', 'audit_logs',
+        'webhook_subscriptions', 'webhook_deliveries'
+    ] LOOP
+        EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+        EXECUTE format('ALTER TABLE %I NO FORCE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I DISABLE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS tenant_id', t);
+    END LOOP;
+END $$;
+
+DROP FUNCTION IF EXISTS current_tenant();
@@ -0,0 +1,56 @@
+-- Row-level security for databases shared by several tenants. Every tenant-owned table
+-- gets a tenant_id, filled from the connection's app.tenant_id setting, which
+-- pkg/database sets from DB_TENANT_ID on every connection. A policy limits each table to
+-- the rows of that tenant, so a query missing a tenant filter, raw SQL included, cannot
+-- read or write another tenant's rows. Existing rows belong to the 'default' tenant,
+-- which is also the tenant of a connection that sets none.
+--
+-- Reference data stays shared: parameters, price rates, processes, routings, formulas
+-- and routing rules. FORCE applies the policies to the table owner as well; only
+-- superusers and BYPASSRLS roles see every tenant.
+
+CREATE OR REPLACE FUNCTION current_tenant()
+RETURNS TEXT AS $$
+    SELECT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default')
+$$ LANGUAGE sql STABLE;
+
+DO $$
+DECLARE
+    t TEXT;
+    p REGCLASS;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'master_yarns', 'yarn_variants', 'variant_process_costs', 'variant_cost_summaries',
+        'variant_dead_letters', 'production_lots', 'batch_jobs', 'job_events', 'job_chunks',
+        'job_partitions', 'job_schedules', 'import_files', 'audit_logs',
+        'webhook_subscriptions', 'webhook_deliveries'
+    ] LOOP
+        -- A constant default fills existing rows without a rewrite; new rows then take
+        -- the connection's tenant
+        EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT ''default''', t);
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN tenant_id SET DEFAULT current_tenant()', t);
+        EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+        EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+        -- The subquery evaluates current_tenant() once per statement instead of per row
+        EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = (SELECT current_tenant())) WITH CHECK (tenant_id = (SELECT current_tenant()))', t);
+    END LOOP;
+
+    -- Partitions of variant_process_costs can be queried directly, past the parent's policy
+    FOR p IN SELECT inhrelid::regclass FROM pg_inherits WHERE inhparent = 'variant_process_costs'::regclass LOOP
+        EXECUTE format('ALTER TABLE %s ENABLE ROW LEVEL SECURITY', p);
+        EXECUTE format('ALTER TABLE %s FORCE ROW LEVEL SECURITY', p);
+        EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %s', p);
+        EXECUTE format('CREATE POLICY tenant_isolation ON %s USING (tenant_id = (SELECT current_tenant())) WITH CHECK (tenant_id = (SELECT current_tenant()))', p);
+    END LOOP;
+END $$;
+
+-- Codes, SKUs and the running exclusive job are unique per tenant
+ALTER TABLE master_yarns DROP CONSTRAINT IF EXISTS master_yarns_code_key;
+ALTER TABLE master_yarns ADD CONSTRAINT master_yarns_tenant_code_key UNIQUE (tenant_id, code);
+ALTER TABLE yarn_variants DROP CONSTRAINT IF EXISTS yarn_variants_sku_key;
+ALTER TABLE yarn_variants ADD CONSTRAINT yarn_variants_tenant_sku_key UNIQUE (tenant_id, sku);
+
+DROP INDEX IF EXISTS idx_batch_jobs_one_running_exclusive;
+CREATE UNIQUE INDEX idx_batch_jobs_one_running_exclusive ON batch_jobs(tenant_id, job_type)
+    WHERE status = 'RUNNING' AND job_type = 'RECALCULATE_ALL';
@@ -0,0 +1,16 @@
+-- Rollback migration
+
+DROP TRIGGER IF EXISTS trg_variant_process_costs_history ON variant_process_costs;
+DROP TRIGGER IF EXISTS trg_variant_cost_summaries_history ON variant_cost_summaries;
+DROP FUNCTION IF EXISTS record_process_cost_history();
+DROP FUNCTION IF EXISTS record_summary_history();
+
+DROP TABLE IF EXISTS variant_process_cost_archive;
+DROP TABLE IF EXISTS variant_process_cost_history;
+DROP TABLE IF EXISTS variant_cost_summary_archive;
+DROP TABLE IF EXISTS variant_cost_summary_history;
+
+-- PostgreSQL cannot drop an enum value; ARCHIVE_HISTORY stays defined but unused
+UPDATE batch_jobs SET status = 'CANCELLED'
+WHERE job_type = 'ARCHIVE_HISTORY' AND status IN ('PENDING', 'RUNNING', 'PAUSED');
+DELETE FROM job_schedules WHERE job_type = 'ARCHIVE_HISTORY';
@@ -0,0 +1,105 @@
+-- Cost history: every recalculation that changes a summary or a per-step cost keeps the
+-- superseded row in a history table. ARCHIVE_HISTORY jobs move all but each variant's
+-- latest versions into archive tables, so the history the API reads stays small while
+-- older versions remain queryable. The triggers are statement-level with transition
+-- tables, so a batch upsert records its history in one INSERT rather than one per row.
+
+ALTER TYPE job_type ADD VALUE IF NOT EXISTS 'ARCHIVE_HISTORY';
+
+CREATE TABLE variant_cost_summary_history (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL DEFAULT current_tenant(),
+    yarn_variant_id UUID NOT NULL,
+    total_material_cost DECIMAL(18, 6),
+    total_process_cost DECIMAL(18, 6),
+    total_overhead DECIMAL(18, 6),
+    grand_total DECIMAL(18, 6),
+    category_breakdown JSONB,
+    version_hash VARCHAR(64),
+    recalculated_at TIMESTAMP WITH TIME ZONE, -- when the superseded summary was calculated
+    superseded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_vcs_history_variant ON variant_cost_summary_history(yarn_variant_id, superseded_at DESC);
+
+CREATE TABLE variant_cost_summary_archive (
+    LIKE variant_cost_summary_history INCLUDING DEFAULTS,
+    archived_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_vcs_archive_variant ON variant_cost_summary_archive(yarn_variant_id, superseded_at DESC);
+
+CREATE TABLE variant_process_cost_history (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL DEFAULT current_tenant(),
+    yarn_variant_id UUID NOT NULL,
+    process_step_id UUID NOT NULL,
+    input_values JSONB,
+    calculated_cost DECIMAL(18, 6),
+    calculated_at TIMESTAMP WITH TIME ZONE, -- updated_at of the superseded cost
+    superseded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_vpc_history_variant ON variant_process_cost_history(yarn_variant_id, superseded_at DESC);
+
+CREATE TABLE variant_process_cost_archive (
+    LIKE variant_process_cost_history INCLUDING DEFAULTS,
+    archived_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_vpc_archive_variant ON variant_process_cost_archive(yarn_variant_id, superseded_at DESC);
+
+-- History and archives belong to the tenant of the costs they keep
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'variant_cost_summary_history', 'variant_cost_summary_archive',
+        'variant_process_cost_history', 'variant_process_cost_archive'
+    ] LOOP
+        EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+        EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_id = (SELECT current_tenant())) WITH CHECK (tenant_id = (SELECT current_tenant()))', t);
+    END LOOP;
+END $$;
+
+-- A summary is superseded when its costs or version change; rewriting it unchanged
+-- records nothing
+CREATE OR REPLACE FUNCTION record_summary_history()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO variant_cost_summary_history (tenant_id, yarn_variant_id, total_material_cost,
+        total_process_cost, total_overhead, grand_total, category_breakdown, version_hash, recalculated_at)
+    SELECT o.tenant_id, o.yarn_variant_id, o.total_material_cost, o.total_process_cost,
+        o.total_overhead, o.grand_total, o.category_breakdown, o.version_hash, o.last_recalculated_at
+    FROM old_rows o JOIN new_rows n ON n.yarn_variant_id = o.yarn_variant_id
+    WHERE o.version_hash IS DISTINCT FROM n.version_hash
+       OR o.grand_total IS DISTINCT FROM n.grand_total
+       OR o.category_breakdown IS DISTINCT FROM n.category_breakdown;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_variant_cost_summaries_history
+    AFTER UPDATE ON variant_cost_summaries
+    REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION record_summary_history();
+
+CREATE OR REPLACE FUNCTION record_process_cost_history()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO variant_process_cost_history (tenant_id, yarn_variant_id, process_step_id,
+        input_values, calculated_cost, calculated_at)
+    SELECT o.tenant_id, o.yarn_variant_id, o.process_step_id, o.input_values, o.calculated_cost, o.updated_at
+    FROM old_rows o JOIN new_rows n
+        ON n.yarn_variant_id = o.yarn_variant_id AND n.process_step_id = o.process_step_id
+    WHERE o.calculated_cost IS DISTINCT FROM n.calculated_cost
+       OR o.input_values IS DISTINCT FROM n.input_values;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Statement triggers on the partitioned parent see the rows of every partition
+CREATE TRIGGER trg_variant_process_costs_history
+    AFTER UPDATE ON variant_process_costs
+    REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION record_process_cost_history();
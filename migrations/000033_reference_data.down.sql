@@ -0,0 +1,5 @@
+-- Rollback migration
+
+-- The reference data is left in place. Variants, price rates, formulas and routing
+-- rules refer to it, and rows the seeder inserted before this migration cannot be told
+-- apart from the ones it inserted.
@@ -0,0 +1,72 @@
+-- Reference data every environment needs: the parameter groups, the 250 master
+-- parameters formulas read, the process masters and the standard textile routing. It
+-- was seeded by cmd/seeder before. Every insert skips rows that already exist, so
+-- databases the seeder filled keep their rows, IDs and any edits made since.
+
+INSERT INTO parameter_groups (code, name)
+SELECT code, code || ' Parameters'
+FROM unnest(ARRAY['raw_material', 'electricity', 'labor', 'machine', 'quality', 'packaging', 'overhead']) AS code
+ON CONFLICT (code) DO NOTHING;
+
+-- Twenty parameter kinds, numbered from the second round on: raw_material, ...,
+-- labor_rate, raw_material_1, ..., labor_rate_1, ... up to 250 parameters. Groups are
+-- assigned round-robin in the order above.
+INSERT INTO master_parameters (key, label, data_type, default_value, group_code, unit, sequence_order)
+SELECT p.key, p.key, 'float', '0',
+       (ARRAY['raw_material', 'electricity', 'labor', 'machine', 'quality', 'packaging', 'overhead'])[i % 7 + 1],
+       k.unit, i
+FROM generate_series(0, 249) AS i
+JOIN (VALUES
+    (0, 'raw_material', 'kg'),
+    (1, 'electricity_kwh', 'kwh'),
+    (2, 'labor_hours', 'hours'),
+    (3, 'machine_hours', 'hours'),
+    (4, 'water_liters', 'liters'),
+    (5, 'steam_hours', 'hours'),
+    (6, 'chemical_kg', 'kg'),
+    (7, 'dye_kg', 'kg'),
+    (8, 'spindle_hours', 'hours'),
+    (9, 'loom_hours', 'hours'),
+    (10, 'finishing_hours', 'hours'),
+    (11, 'packaging_units', 'pcs'),
+    (12, 'waste_percentage', '%'),
+    (13, 'quality_factor', 'factor'),
+    (14, 'efficiency_rate', '%'),
+    (15, 'overhead_rate', '%'),
+    (16, 'input_cost', 'currency'),
+    (17, 'output_cost', 'currency'),
+    (18, 'material_price', 'currency/kg'),
+    (19, 'labor_rate', 'currency/hour')
+) AS k(n, prefix, unit) ON k.n = i % 20
+CROSS JOIN LATERAL (
+    SELECT CASE WHEN i / 20 > 0 THEN k.prefix || '_' || (i / 20) ELSE k.prefix END AS key
+) AS p
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO process_masters (code, name, default_sequence, output_kg_per_hour, setup_hours) VALUES
+    ('SMELTING', 'Smelting Process', 1, 250, 1),
+    ('SPINNING', 'Spinning Process', 2, 40, 0.5),
+    ('WEAVING', 'Weaving Process', 3, 25, 1),
+    ('DYEING', 'Dyeing Process', 4, 60, 2),
+    ('FINISHING', 'Finishing Process', 5, 80, 0.5),
+    ('PACKING', 'Packing Process', 6, 200, 0.25)
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO routing_templates (name, description, is_active)
+VALUES ('Standard Textile Route', 'Full textile production route', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO process_steps (routing_template_id, process_master_id, sequence_order, formula_expression)
+SELECT rt.id, pm.id, s.sequence_order, s.formula
+FROM (VALUES
+    (1, 'SMELTING', '(raw_material_kg * material_price) + (electricity_kwh_1 * electricity_rate) + (labor_hours_1 * labor_rate)'),
+    (2, 'SPINNING', 'steps.SMELTING.cost + (spindle_hours * spindle_rate) + (labor_hours_2 * labor_rate)'),
+    (3, 'WEAVING', 'steps.SPINNING.cost + (loom_hours * loom_rate) + (labor_hours_3 * labor_rate)'),
+    (4, 'DYEING', 'steps.WEAVING.cost + (dye_kg * dye_price) + (water_liters * water_rate) + (steam_hours * steam_rate)'),
+    (5, 'FINISHING', 'steps.DYEING.cost + (finishing_hours * finishing_rate) + (chemical_kg * chemical_price)'),
+    (6, 'PACKING', 'steps.FINISHING.cost + (packaging_units * packaging_price) + (labor_hours_6 * labor_rate)')
+) AS s(sequence_order, process_code, formula)
+JOIN process_masters pm ON pm.code = s.process_code
+CROSS JOIN routing_templates rt
+WHERE rt.name = 'Standard Textile Route'
+ON CONFLICT (routing_template_id, sequence_order) DO NOTHING;
@@ -0,0 +1,3 @@
+-- Rollback migration
+
+DROP TABLE IF EXISTS seed_runs;
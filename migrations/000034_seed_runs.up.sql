@@ -0,0 +1,20 @@
+-- Seed runs: the progress of each cmd/seeder run, so a run that dies part way can be
+-- resumed with --resume instead of starting over. next_master is the index of the first
+-- master not known to be written; every master before it is. The options are what the
+-- generated data depends on besides the seed, restored on resume.
+
+CREATE TABLE seed_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(63) NOT NULL DEFAULT current_tenant(),
+    seed BIGINT NOT NULL,
+    options JSONB NOT NULL DEFAULT '{}',
+    next_master INT NOT NULL DEFAULT 0,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_seed_runs_incomplete ON seed_runs(created_at DESC) WHERE completed_at IS NULL;
+
+ALTER TABLE seed_runs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE seed_runs FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON seed_runs USING (tenant_id = (SELECT current_tenant())) WITH CHECK (tenant_id = (SELECT current_tenant()));
@@ -0,0 +1,10 @@
+// Package migrations holds the SQL migrations, built into the binaries that apply them
+// so they run from any working directory
+package migrations
+
+import "embed"
+
+// FS holds every *.up.sql and *.down.sql migration
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,76 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("2006-01-02 15:04", value)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestSchedule_Next(t *testing.T) {
+	tests := []struct {
+		expression string
+		from       string
+		want       string
+	}{
+		{"0 2 * * *", "2026-03-10 01:59", "2026-03-10 02:00"},
+		{"0 2 * * *", "2026-03-10 02:00", "2026-03-11 02:00"},
+		{"*/15 * * * *", "2026-03-10 10:07", "2026-03-10 10:15"},
+		{"0 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"30 6 * * mon-fri", "2026-03-13 07:00", "2026-03-16 06:30"}, // Friday -> Monday
+		{"0 0 * * 7", "2026-03-10 00:00", "2026-03-15 00:00"},        // 7 is Sunday
+		{"0 0 29 feb *", "2026-01-01 00:00", "2028-02-29 00:00"},
+		{"0 0 13 * fri", "2026-03-10 00:00", "2026-03-13 00:00"}, // either day field matches
+		{"@monthly", "2026-12-15 08:00", "2027-01-01 00:00"},
+		{"0 9-17/4 * * *", "2026-03-10 10:00", "2026-03-10 13:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			schedule, err := Parse(tt.expression)
+			require.NoError(t, err)
+			assert.Equal(t, mustTime(t, tt.want), schedule.Next(mustTime(t, tt.from)))
+		})
+	}
+}
+
+func TestSchedule_Next_NeverMatches(t *testing.T) {
+	schedule, err := Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(mustTime(t, "2026-01-01 00:00")).IsZero())
+}
+
+func TestSchedule_Next_Location(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	schedule, err := Parse("0 2 * * *")
+	require.NoError(t, err)
+
+	next := schedule.Next(time.Date(2026, 3, 10, 18, 0, 0, 0, time.UTC).In(jakarta))
+	assert.Equal(t, time.Date(2026, 3, 11, 2, 0, 0, 0, jakarta), next)
+	assert.Equal(t, time.Date(2026, 3, 10, 19, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+	} {
+		_, err := Parse(expression)
+		assert.Error(t, err, expression)
+	}
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ilramdhan/costing-mvp/config"
+)
+
+// Database drivers DB_DRIVER selects
+const (
+	DriverPostgres = "postgres" // the server DB_HOST and DB_PORT name
+	DriverEmbedded = "embedded" // a local PostgreSQL the process starts itself
+)
+
+// embeddedStartTimeout bounds how long StartEmbedded waits for the server to accept connections
+const embeddedStartTimeout = 30 * time.Second
+
+// Embedded is a PostgreSQL server run by this process from the locally installed
+// binaries, for development and tests without a provisioned database. It uses the
+// same schema and queries as a real server, so every repository works unchanged.
+type Embedded struct {
+	cmd  *exec.Cmd
+	done chan error
+	dir  string
+	temp bool // dir was created by StartEmbedded and is removed by Stop
+}
+
+// StartEmbedded initialises the data directory cfg names, or a temporary one, starts a
+// server on a free local port, creates cfg.Name and applies the migrations. It points
+// cfg at the server and clears its URL and replica, so pools made from cfg afterwards
+// use it.
+func StartEmbedded(ctx context.Context, cfg *config.DatabaseConfig) (*Embedded, error) {
+	bin, err := findPostgresBin(cfg.EmbeddedBin)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Embedded{dir: cfg.EmbeddedDir}
+	if e.dir == "" {
+		if e.dir, err = os.MkdirTemp("", "costing-pg-"); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+		e.temp = true
+	}
+	if e.dir, err = filepath.Abs(e.dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(e.dir, "PG_VERSION")); errors.Is(err, os.ErrNotExist) {
+		log.Printf("Initialising embedded database in %s", e.dir)
+		initdb := exec.CommandContext(ctx, filepath.Join(bin, "initdb"),
+			"-D", e.dir, "-U", cfg.User, "-A", "trust", "-E", "UTF8", "--no-sync")
+		if out, err := initdb.CombinedOutput(); err != nil {
+			e.cleanup()
+			return nil, fmt.Errorf("initdb failed: %w: %s", err, out)
+		}
+	}
+
+	port, err := freePort()
+	if err != nil {
+		e.cleanup()
+		return nil, err
+	}
+	logFile, err := os.OpenFile(filepath.Join(e.dir, "postgres.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		e.cleanup()
+		return nil, fmt.Errorf("failed to open server log: %w", err)
+	}
+	// Durability is traded for speed: the data is disposable
+	e.cmd = exec.Command(filepath.Join(bin, "postgres"),
+		"-D", e.dir,
+		"-p", strconv.Itoa(port),
+		"-k", e.dir,
+		"-c", "listen_addresses=127.0.0.1",
+		"-c", "fsync=off",
+		"-c", "synchronous_commit=off",
+		"-c", "full_page_writes=off",
+		"-c", "max_connections="+strconv.Itoa(max(100, cfg.PoolMax*2+10)),
+	)
+	e.cmd.Stdout, e.cmd.Stderr = logFile, logFile
+	setEmbeddedProcAttr(e.cmd)
+	if err := e.cmd.Start(); err != nil {
+		logFile.Close()
+		e.cleanup()
+		return nil, fmt.Errorf("failed to start postgres: %w", err)
+	}
+	e.done = make(chan error, 1)
+	go func() {
+		e.done <- e.cmd.Wait()
+		logFile.Close()
+	}()
+
+	cfg.Host, cfg.Port, cfg.URL, cfg.ReplicaDSN = "127.0.0.1", strconv.Itoa(port), "", ""
+	if err := e.prepare(ctx, cfg); err != nil {
+		e.Stop()
+		return nil, err
+	}
+	log.Printf("Embedded database running on port %d (data in %s)", port, e.dir)
+	return e, nil
+}
+
+// prepare waits for the server, creates the database and applies the migrations
+func (e *Embedded) prepare(ctx context.Context, cfg *config.DatabaseConfig) error {
+	admin := *cfg
+	admin.Name = "postgres"
+	conn, err := e.waitReady(ctx, admin.DSN())
+	if err != nil {
+		return err
+	}
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", cfg.Name).Scan(&exists)
+	if err == nil && !exists {
+		_, err = conn.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{cfg.Name}.Sanitize())
+	}
+	conn.Close(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create database %s: %w", cfg.Name, err)
+	}
+
+	pool, err := NewPool(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if _, err := MigrateUp(ctx, pool, Migrations(cfg)); err != nil {
+		return fmt.Errorf("failed to migrate embedded database: %w", err)
+	}
+	return nil
+}
+
+// waitReady connects to dsn once the server accepts connections
+func (e *Embedded) waitReady(ctx context.Context, dsn string) (*pgx.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, embeddedStartTimeout)
+	defer cancel()
+	for {
+		conn, err := pgx.Connect(ctx, dsn)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case exitErr := <-e.done:
+			e.done <- exitErr
+			return nil, fmt.Errorf("postgres exited during startup (see %s): %v", filepath.Join(e.dir, "postgres.log"), exitErr)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("embedded database did not start: %w", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Stop shuts the server down, waiting for it to exit, and removes a temporary data
+// directory. Stopping a stopped server does nothing.
+func (e *Embedded) Stop() {
+	if e.cmd != nil && e.cmd.Process != nil {
+		// SIGINT is a fast shutdown: sessions are disconnected rather than waited for
+		e.cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-e.done:
+		case <-time.After(10 * time.Second):
+			e.cmd.Process.Kill()
+			<-e.done
+		}
+		e.cmd = nil
+	}
+	e.cleanup()
+}
+
+func (e *Embedded) cleanup() {
+	if e.temp {
+		os.RemoveAll(e.dir)
+	}
+}
+
+// findPostgresBin returns the directory holding initdb and postgres: dir when set,
+// otherwise the one on PATH or in a usual install location
+func findPostgresBin(dir string) (string, error) {
+	if dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, "postgres")); err != nil {
+			return "", fmt.Errorf("no postgres binary in %s: %w", dir, err)
+		}
+		return dir, nil
+	}
+	if path, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(path), nil
+	}
+	for _, pattern := range []string{
+		"/usr/lib/postgresql/*/bin",
+		"/usr/pgsql-*/bin",
+		"/opt/homebrew/opt/postgresql*/bin",
+		"/usr/local/opt/postgresql*/bin",
+	} {
+		matches, _ := filepath.Glob(pattern)
+		// Glob sorts by name, so later matches are usually newer versions
+		for i := len(matches) - 1; i >= 0; i-- {
+			if _, err := os.Stat(filepath.Join(matches[i], "initdb")); err == nil {
+				return matches[i], nil
+			}
+		}
+	}
+	return "", errors.New("no PostgreSQL installation found for the embedded driver; install it or set DB_EMBEDDED_BIN")
+}
+
+// freePort returns a local TCP port nothing is listening on
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
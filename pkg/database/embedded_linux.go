@@ -0,0 +1,14 @@
+//go:build linux
+
+package database
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setEmbeddedProcAttr has the kernel stop the server when this process dies, so a fatal
+// exit that skips Stop does not leave it running
+func setEmbeddedProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGINT}
+}
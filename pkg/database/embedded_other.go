@@ -0,0 +1,8 @@
+//go:build !linux
+
+package database
+
+import "os/exec"
+
+// setEmbeddedProcAttr does nothing: only Linux can tie the server's life to this process
+func setEmbeddedProcAttr(cmd *exec.Cmd) {}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ilramdhan/costing-mvp/config"
+)
+
+func TestStartEmbedded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a PostgreSQL server")
+	}
+	if _, err := findPostgresBin(os.Getenv("DB_EMBEDDED_BIN")); err != nil {
+		t.Skip(err)
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("postgres refuses to run as root")
+	}
+
+	ctx := context.Background()
+	cfg := &config.DatabaseConfig{
+		Driver:       DriverEmbedded,
+		User:         "postgres",
+		Name:         "costing_test",
+		PoolMax:      4,
+		PoolMinConns: 1,
+		TenantID:     "default",
+		ReplicaDSN:   "postgres://replica/costing",
+		EmbeddedBin:  os.Getenv("DB_EMBEDDED_BIN"),
+	}
+	embedded, err := StartEmbedded(ctx, cfg)
+	require.NoError(t, err)
+	dir := embedded.dir
+	defer embedded.Stop()
+
+	assert.Equal(t, "127.0.0.1", cfg.Host)
+	assert.Empty(t, cfg.ReplicaDSN)
+
+	pool, err := NewPool(ctx, cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.True(t, IsMigrationApplied(ctx, pool, "000001"))
+
+	// Migrations already applied are skipped on the next start
+	applied, err := MigrateUp(ctx, pool, Migrations(cfg))
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+
+	pool.Close()
+	embedded.Stop()
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err), "temporary data directory is removed")
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// queryBuckets are the upper bounds, in seconds, of the query latency histograms
+var queryBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// poolWatchInterval is how often WatchPool checks for stalls
+const poolWatchInterval = 10 * time.Second
+
+// maxQueryClasses bounds the histograms kept; queries of further classes count as "other"
+const maxQueryClasses = 200
+
+// queryLatencies holds the latency histogram of each query class of every pool in the
+// process
+var queryLatencies = &latencies{classes: make(map[string]*histogram)}
+
+type latencies struct {
+	mu      sync.Mutex
+	classes map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative; the last counts the rest
+	sum    float64
+	count  uint64
+}
+
+func (l *latencies) observe(class string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.classes[class]
+	if !ok {
+		if len(l.classes) >= maxQueryClasses {
+			class = "other"
+			h = l.classes[class]
+		}
+		if h == nil {
+			h = &histogram{counts: make([]uint64, len(queryBuckets)+1)}
+			l.classes[class] = h
+		}
+	}
+	seconds := d.Seconds()
+	h.counts[sort.SearchFloat64s(queryBuckets, seconds)]++
+	h.sum += seconds
+	h.count++
+}
+
+// queryTracer times every query and COPY of a pool's connections by query class, and
+// passes them to the pool's query log
+type queryTracer struct {
+	log queryLog
+}
+
+type traceKey struct{}
+
+type traceStart struct {
+	class string
+	sql   string
+	at    time.Time
+}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceStart{class: queryClass(data.SQL), sql: data.SQL, at: time.Now()})
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.CommandTag.RowsAffected(), data.Err)
+}
+
+func (queryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := data.TableName.Sanitize()
+	return context.WithValue(ctx, traceKey{}, traceStart{class: "COPY " + tableClass(table), sql: "COPY " + table + " FROM STDIN", at: time.Now()})
+}
+
+func (t queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, data.CommandTag.RowsAffected(), data.Err)
+}
+
+func (t queryTracer) end(ctx context.Context, rows int64, err error) {
+	if start, ok := ctx.Value(traceKey{}).(traceStart); ok {
+		elapsed := time.Since(start.at)
+		queryLatencies.observe(start.class, elapsed)
+		t.log.record(ctx, start.sql, elapsed, rows, err)
+	}
+}
+
+// queryClass names the kind of a query for its latency histogram: its statement and the
+// first table it reads or writes, e.g. "SELECT yarn_variants" or "INSERT price_rates".
+// A query through CTEs is classed by its first table.
+func queryClass(sql string) string {
+	words := strings.Fields(sql)
+	if len(words) == 0 {
+		return "other"
+	}
+	statement := strings.ToUpper(words[0])
+	for i, word := range words[:len(words)-1] {
+		switch strings.ToUpper(word) {
+		case "FROM", "INTO", "UPDATE", "JOIN":
+			if table := tableClass(words[i+1]); table != "" {
+				return statement + " " + table
+			}
+		}
+	}
+	return statement
+}
+
+// tableClass is a table name as it appears in a query class, "" for a subquery or
+// parameter. Trailing digits are cut, so per-batch temp tables such as temp_vcs_1700000000
+// share one class.
+func tableClass(name string) string {
+	if strings.HasPrefix(name, "(") || strings.HasPrefix(name, "$") {
+		return ""
+	}
+	name, _, _ = strings.Cut(name, "(")
+	name = strings.ToLower(strings.ReplaceAll(strings.Trim(name, "),;"), `"`, ""))
+	return strings.TrimRight(name, "0123456789_")
+}
+
+// WriteMetrics writes the statistics of pools, by name, and the query latency histograms
+// in the Prometheus text format
+func WriteMetrics(w io.Writer, pools map[string]*pgxpool.Pool) {
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gauges := []struct {
+		name, help string
+		value      func(*pgxpool.Stat) float64
+	}{
+		{"costing_db_pool_acquired_conns", "Connections currently in use.", func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }},
+		{"costing_db_pool_idle_conns", "Connections currently idle.", func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }},
+		{"costing_db_pool_total_conns", "Connections open or being opened.", func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }},
+		{"costing_db_pool_max_conns", "Pool size (DB_POOL_MAX).", func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }},
+	}
+	counters := []struct {
+		name, help string
+		value      func(*pgxpool.Stat) float64
+	}{
+		{"costing_db_pool_acquires_total", "Connections acquired from the pool.", func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }},
+		{"costing_db_pool_empty_acquires_total", "Acquisitions that waited because no connection was idle.", func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }},
+		{"costing_db_pool_canceled_acquires_total", "Acquisitions canceled while waiting.", func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }},
+		{"costing_db_pool_acquire_seconds_total", "Time spent acquiring connections.", func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }},
+	}
+
+	stats := make([]*pgxpool.Stat, len(names))
+	for i, name := range names {
+		stats[i] = pools[name].Stat()
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for i, name := range names {
+			fmt.Fprintf(w, "%s{pool=%q} %g\n", g.name, name, g.value(stats[i]))
+		}
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for i, name := range names {
+			fmt.Fprintf(w, "%s{pool=%q} %g\n", c.name, name, c.value(stats[i]))
+		}
+	}
+
+	queryLatencies.mu.Lock()
+	defer queryLatencies.mu.Unlock()
+	classes := make([]string, 0, len(queryLatencies.classes))
+	for class := range queryLatencies.classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	const name = "costing_db_query_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Query and COPY latency by statement and first table.\n# TYPE %s histogram\n", name, name)
+	for _, class := range classes {
+		h := queryLatencies.classes[class]
+		var cumulative uint64
+		for i, bound := range queryBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{class=%q,le=\"%g\"} %d\n", name, class, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{class=%q,le=\"+Inf\"} %d\n", name, class, h.count)
+		fmt.Fprintf(w, "%s_sum{class=%q} %g\n", name, class, h.sum)
+		fmt.Fprintf(w, "%s_count{class=%q} %d\n", name, class, h.count)
+	}
+}
+
+// Pools names the primary and read pools of a process for WriteMetrics and WatchPools;
+// read is left out when it is the primary
+func Pools(primary, read *pgxpool.Pool) map[string]*pgxpool.Pool {
+	pools := map[string]*pgxpool.Pool{"primary": primary}
+	if read != primary {
+		pools["replica"] = read
+	}
+	return pools
+}
+
+// WatchPools runs WatchPool on each of pools until ctx is done
+func WatchPools(ctx context.Context, pools map[string]*pgxpool.Pool, threshold time.Duration) {
+	for name, pool := range pools {
+		go WatchPool(ctx, name, pool, threshold)
+	}
+}
+
+// WatchPool logs, periodically until ctx is done, when acquisitions from pool waited for
+// a connection longer than threshold on average: more goroutines want connections than
+// the pool holds, typically WORKER_COUNT above DB_POOL_MAX.
+func WatchPool(ctx context.Context, name string, pool *pgxpool.Pool, threshold time.Duration) {
+	ticker := time.NewTicker(poolWatchInterval)
+	defer ticker.Stop()
+	last := pool.Stat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stat := pool.Stat()
+		waited := stat.EmptyAcquireCount() - last.EmptyAcquireCount()
+		acquired := stat.AcquireCount() - last.AcquireCount()
+		if waited > 0 && acquired > 0 {
+			average := (stat.AcquireDuration() - last.AcquireDuration()) / time.Duration(acquired)
+			if average >= threshold {
+				log.Printf("Database pool %s stalled: %d of %d acquisitions waited for a connection, %v on average, with %d of %d connections in use; DB_POOL_MAX may be too small for WORKER_COUNT",
+					name, waited, acquired, average.Round(time.Millisecond), stat.AcquiredConns(), stat.MaxConns())
+			}
+		}
+		last = stat
+	}
+}
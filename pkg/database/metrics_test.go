@@ -0,0 +1,55 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryClass(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT id FROM master_yarns WHERE id = $1", "SELECT master_yarns"},
+		{"\n\t\tINSERT INTO price_rates (id) VALUES ($1)", "INSERT price_rates"},
+		{"UPDATE yarn_variants SET is_active = false", "UPDATE yarn_variants"},
+		{"SELECT * FROM (SELECT 1) t JOIN audit_logs a ON true", "SELECT audit_logs"},
+		{"INSERT INTO variant_cost_summaries SELECT * FROM temp_vcs_1700000000123", "INSERT variant_cost_summaries"},
+		{"SELECT EXISTS (SELECT 1) FROM unnest($1::text[]) AS t(key)", "SELECT unnest"},
+		{`DELETE FROM "batch_jobs" WHERE id = $1`, "DELETE batch_jobs"},
+		{"SELECT current_setting('server_version_num')::int", "SELECT"},
+		{"", "other"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, queryClass(tt.sql), tt.sql)
+	}
+}
+
+func TestTableClass_TempTables(t *testing.T) {
+	assert.Equal(t, "temp_vcs", tableClass(`"temp_vcs_1700000000123"`))
+}
+
+func TestLatencies_Observe(t *testing.T) {
+	l := &latencies{classes: make(map[string]*histogram)}
+	l.observe("SELECT a", 3*time.Millisecond)
+	l.observe("SELECT a", 2*time.Second)
+	l.observe("SELECT a", time.Minute)
+
+	h := l.classes["SELECT a"]
+	assert.Equal(t, uint64(3), h.count)
+	assert.Equal(t, uint64(1), h.counts[1])                 // le 0.005
+	assert.Equal(t, uint64(1), h.counts[9])                 // le 2.5
+	assert.Equal(t, uint64(1), h.counts[len(queryBuckets)]) // beyond the last bucket
+}
+
+func TestLatencies_BoundsClasses(t *testing.T) {
+	l := &latencies{classes: make(map[string]*histogram)}
+	for i := 0; i < maxQueryClasses+10; i++ {
+		l.observe(strings.Repeat("x", i+1), time.Millisecond)
+	}
+	assert.Len(t, l.classes, maxQueryClasses+1)
+	assert.Equal(t, uint64(10), l.classes["other"].count)
+}
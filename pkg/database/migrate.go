@@ -0,0 +1,509 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ilramdhan/costing-mvp/config"
+	"github.com/ilramdhan/costing-mvp/migrations"
+)
+
+// migrationLockKey is the advisory lock a migration run holds, so concurrent runs apply
+// or roll back one at a time
+const migrationLockKey int64 = 0x636f7374696e67 // "costing"
+
+// noTransactionMarker is a line that makes a migration run outside a transaction, for
+// statements a transaction cannot hold such as CREATE INDEX CONCURRENTLY
+const noTransactionMarker = "-- migrate:no-transaction"
+
+// migrationDB runs the queries of migrations: a pool, or the connection holding the
+// migration lock
+type migrationDB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// EnsureMigrationsTable creates the table recording applied migrations if it is missing
+func EnsureMigrationsTable(ctx context.Context, db migrationDB) error {
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		-- Set while a migration outside a transaction runs, and left set if it fails partway
+		ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS dirty BOOLEAN NOT NULL DEFAULT FALSE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	return nil
+}
+
+// ErrDirtyMigration is returned by up and down while a migration that ran outside a
+// transaction is marked as having failed partway
+var ErrDirtyMigration = errors.New("migration failed partway; fix the schema, then run migrate force or migrate repair")
+
+// checkClean returns ErrDirtyMigration, naming the version, when a record is dirty
+func checkClean(ctx context.Context, db migrationDB) error {
+	var version string
+	err := db.QueryRow(ctx, "SELECT version FROM schema_migrations WHERE dirty ORDER BY version LIMIT 1").Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check migration state: %w", err)
+	}
+	return fmt.Errorf("%s: %w", version, ErrDirtyMigration)
+}
+
+// Migrations returns the migrations to run: those in the directory cfg names, or else
+// the ones built into the binary
+func Migrations(cfg *config.DatabaseConfig) fs.FS {
+	if cfg.MigrationsDir != "" {
+		return os.DirFS(cfg.MigrationsDir)
+	}
+	return migrations.FS
+}
+
+// MigrateUp applies the *.up.sql migrations in fsys not applied yet, in version order,
+// and returns how many it applied
+func MigrateUp(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS) (int, error) {
+	return MigrateUpTo(ctx, pool, fsys, "")
+}
+
+// MigrateUpTo applies the migrations in fsys not applied yet up to and including version
+// target, all of them when target is "", and returns how many it applied
+func MigrateUpTo(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, target string) (int, error) {
+	files, err := upFiles(fsys, target)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		if err := EnsureMigrationsTable(ctx, conn); err != nil {
+			return err
+		}
+		if err := checkClean(ctx, conn); err != nil {
+			return err
+		}
+		for _, file := range files {
+			version := MigrationVersion(file)
+			// Checked under the lock, so a run that waited skips what the other applied
+			if IsMigrationApplied(ctx, conn, version) {
+				log.Printf("Skipping %s (already applied)", version)
+				continue
+			}
+
+			log.Printf("Applying %s...", version)
+			if err := runMigration(ctx, conn, fsys, file, version, true); err != nil {
+				return fmt.Errorf("failed to apply %s: %w", file, err)
+			}
+			log.Printf("Applied %s successfully", version)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateBaseline records every migration in fsys up to and including version target as
+// applied without running it, for databases created another way such as from a dump of
+// a migrated one, and returns how many it recorded
+func MigrateBaseline(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, target string) (int, error) {
+	if target == "" {
+		return 0, fmt.Errorf("a baseline version is required")
+	}
+	files, err := upFiles(fsys, target)
+	if err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+	err = withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		if err := EnsureMigrationsTable(ctx, conn); err != nil {
+			return err
+		}
+		for _, file := range files {
+			version := MigrationVersion(file)
+			tag, err := conn.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1) ON CONFLICT (version) DO NOTHING", version)
+			if err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", version, err)
+			}
+			if tag.RowsAffected() > 0 {
+				log.Printf("Marked %s as applied", version)
+				recorded++
+			}
+		}
+		return nil
+	})
+	return recorded, err
+}
+
+// upFiles returns the *.up.sql files in fsys in version order, up to and including
+// version target unless it is ""
+func upFiles(fsys fs.FS, target string) ([]string, error) {
+	files, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find migration files: %w", err)
+	}
+	target = NormalizeVersion(target)
+	if target != "" && !hasVersion(files, target) {
+		return nil, fmt.Errorf("no migration %s", target)
+	}
+	sort.Strings(files)
+	if target != "" {
+		for i, file := range files {
+			if MigrationVersion(file) > target {
+				return files[:i], nil
+			}
+		}
+	}
+	return files, nil
+}
+
+// MigrateDown rolls back applied migrations, newest first, with the *.down.sql files in
+// fsys: steps of them, or when target is set every one after version target, which stays
+// applied ("0" rolls back all). It returns how many it rolled back. The applied versions
+// come from schema_migrations, so files added on disk since are not mistaken for them.
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, steps int, target string) (int, error) {
+	rolledBack := 0
+	err := withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		if err := EnsureMigrationsTable(ctx, conn); err != nil {
+			return err
+		}
+		if err := checkClean(ctx, conn); err != nil {
+			return err
+		}
+		applied, err := AppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions, err := rollbackPlan(applied, steps, NormalizeVersion(target))
+		if err != nil {
+			return err
+		}
+
+		// Find every down file first, so a missing one stops the rollback before it starts
+		files := make([]string, len(versions))
+		for i, version := range versions {
+			matches, err := fs.Glob(fsys, version+"_*.down.sql")
+			if err != nil || len(matches) != 1 {
+				return fmt.Errorf("no single down migration for %s", version)
+			}
+			files[i] = matches[0]
+		}
+
+		for i, file := range files {
+			log.Printf("Rolling back %s...", versions[i])
+			if err := runMigration(ctx, conn, fsys, file, versions[i], false); err != nil {
+				return fmt.Errorf("failed to rollback %s: %w", file, err)
+			}
+			log.Printf("Rolled back %s successfully", versions[i])
+			rolledBack++
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// withMigrationLock runs fn on a connection holding the migration advisory lock, waiting
+// for a concurrent run to release it first. The lock is a session lock, so it is also
+// released if the connection is lost.
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgx.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockKey).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	if !locked {
+		log.Println("Waiting for another migration run to finish...")
+		if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("failed to take migration lock: %w", err)
+		}
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	return fn(conn.Conn())
+}
+
+// runMigration runs the SQL of file, applying version when up and rolling it back
+// otherwise, and records the result in schema_migrations. Both happen in one transaction
+// unless the file has the no-transaction marker; then the record is marked dirty until
+// the last statement succeeds.
+func runMigration(ctx context.Context, conn *pgx.Conn, fsys fs.FS, file, version string, up bool) error {
+	content, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+	record := "DELETE FROM schema_migrations WHERE version = $1"
+	if up {
+		record = "INSERT INTO schema_migrations (version) VALUES ($1)"
+	}
+
+	if !isTransactional(string(content)) {
+		// PostgreSQL runs the statements of one query string in a single transaction, so
+		// they are sent one at a time
+		log.Printf("Running %s outside a transaction", file)
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO schema_migrations (version, dirty) VALUES ($1, TRUE)
+			ON CONFLICT (version) DO UPDATE SET dirty = TRUE
+		`, version); err != nil {
+			return fmt.Errorf("failed to mark migration %s dirty: %w", version, err)
+		}
+		for _, statement := range splitStatements(string(content)) {
+			if _, err := conn.Exec(ctx, statement); err != nil {
+				return err
+			}
+		}
+		if up {
+			record = "UPDATE schema_migrations SET dirty = FALSE, applied_at = NOW() WHERE version = $1"
+		}
+		_, err := conn.Exec(ctx, record, version)
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, string(content)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, version); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// splitStatements splits sql at the semicolons ending its statements, skipping those in
+// quotes, dollar-quoted bodies and comments, and drops statements left empty
+func splitStatements(sql string) []string {
+	var statements []string
+	start := 0
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\'' || sql[i] == '"':
+			if end := strings.IndexByte(sql[i+1:], sql[i]); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(sql)
+			}
+		case sql[i] == '$':
+			// $tag$ ... $tag$, where a tag is empty or a name; $1 is a parameter
+			tagEnd := strings.IndexByte(sql[i+1:], '$')
+			if tagEnd < 0 || !isDollarTag(sql[i+1:i+1+tagEnd]) {
+				continue
+			}
+			tag := sql[i : i+tagEnd+2]
+			if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+				i += len(tag) + end + len(tag) - 1
+			} else {
+				i = len(sql)
+			}
+		case sql[i] == ';':
+			statements = appendStatement(statements, sql[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(sql) {
+		statements = appendStatement(statements, sql[start:])
+	}
+	return statements
+}
+
+// appendStatement appends statement unless it holds nothing but whitespace and comments
+func appendStatement(statements []string, statement string) []string {
+	for _, line := range strings.Split(statement, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return append(statements, strings.TrimSpace(statement))
+		}
+	}
+	return statements
+}
+
+func isDollarTag(tag string) bool {
+	for i := 0; i < len(tag); i++ {
+		if ch := tag[i]; !(ch == '_' || (ch|0x20 >= 'a' && ch|0x20 <= 'z') || (i > 0 && ch >= '0' && ch <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTransactional reports whether a migration may run in a transaction: whether none of
+// its lines is the no-transaction marker
+func isTransactional(sql string) bool {
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.TrimSpace(line) == noTransactionMarker {
+			return false
+		}
+	}
+	return true
+}
+
+// AppliedMigrations returns the versions recorded as applied, newest first
+func AppliedMigrations(ctx context.Context, db migrationDB) ([]string, error) {
+	rows, err := db.Query(ctx, "SELECT version FROM schema_migrations ORDER BY version DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %w", err)
+	}
+	defer rows.Close()
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// rollbackPlan returns the versions of applied, newest first, that a rollback of steps
+// migrations, or to version target, undoes. Exactly one of steps and target is set.
+func rollbackPlan(applied []string, steps int, target string) ([]string, error) {
+	switch {
+	case steps > 0 && target != "":
+		return nil, fmt.Errorf("set either steps or a target version, not both")
+	case steps < 0:
+		return nil, fmt.Errorf("steps must be positive")
+	case steps > 0:
+		return applied[:min(steps, len(applied))], nil
+	case target == "":
+		return nil, fmt.Errorf("set steps or a target version")
+	}
+
+	plan := []string{}
+	for _, version := range applied {
+		if version <= target {
+			break
+		}
+		plan = append(plan, version)
+	}
+	if target != NormalizeVersion("0") && len(plan) == len(applied) {
+		return nil, fmt.Errorf("migration %s is not applied", target)
+	}
+	return plan, nil
+}
+
+// NormalizeVersion pads a numeric version to the six digits of migration file names,
+// so 31 names 000031
+func NormalizeVersion(version string) string {
+	if n, err := strconv.Atoi(version); err == nil && n >= 0 && len(version) < 6 {
+		return fmt.Sprintf("%06d", n)
+	}
+	return version
+}
+
+func hasVersion(files []string, version string) bool {
+	for _, file := range files {
+		if MigrationVersion(file) == version {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrationVersion returns the version of a migration file, the part of its name before
+// the first underscore
+func MigrationVersion(filename string) string {
+	base := filepath.Base(filename)
+	parts := strings.Split(base, "_")
+	if len(parts) > 0 {
+		return parts[0]
+	}
+	return base
+}
+
+// IsMigrationApplied reports whether version is recorded as applied
+func IsMigrationApplied(ctx context.Context, db migrationDB, version string) bool {
+	var count int
+	err := db.QueryRow(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", version).Scan(&count)
+	if err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// migrationNameChars are the runs of characters a migration name replaces with _
+var migrationNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// CreateMigration writes an empty up and down migration named name to dir, numbered one
+// after the latest there, and returns their paths. The name is lowercased with other
+// characters than letters and digits made underscores, e.g. "Add lot index" becomes
+// add_lot_index.
+func CreateMigration(dir, name string, now time.Time) (string, string, error) {
+	name = strings.Trim(migrationNameChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", fmt.Errorf("migration name must contain letters or digits")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find migration files: %w", err)
+	}
+	latest := 0
+	for _, file := range files {
+		if n, err := strconv.Atoi(MigrationVersion(file)); err == nil {
+			latest = max(latest, n)
+		}
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%06d_%s", latest+1, name))
+	header := fmt.Sprintf("-- Created %s\n\n", now.UTC().Format(time.RFC3339))
+	up, down := base+".up.sql", base+".down.sql"
+	if err := writeNewFile(up, "-- "+strings.ReplaceAll(name, "_", " ")+"\n"+header); err != nil {
+		return "", "", err
+	}
+	if err := writeNewFile(down, "-- Rollback migration\n"+header); err != nil {
+		os.Remove(up)
+		return "", "", err
+	}
+	return up, down, nil
+}
+
+// writeNewFile writes content to path, failing if path exists
+func writeNewFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ilramdhan/costing-mvp/migrations"
+)
+
+func TestMigrationVersion(t *testing.T) {
+	assert.Equal(t, "000031", MigrationVersion("migrations/000031_row_level_security.up.sql"))
+	assert.Equal(t, "000001", MigrationVersion("000001_init.down.sql"))
+	assert.Equal(t, "seed.sql", MigrationVersion("seed.sql"))
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"000009_lots.up.sql", "000009_lots.down.sql", "000010_jobs.up.sql", "README.sql"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+
+	up, down, err := CreateMigration(dir, "Add lot-number Index!", now)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "000011_add_lot_number_index.up.sql"), up)
+	assert.Equal(t, filepath.Join(dir, "000011_add_lot_number_index.down.sql"), down)
+
+	content, err := os.ReadFile(up)
+	require.NoError(t, err)
+	assert.Equal(t, "-- add lot number index\n-- Created 2026-10-16T09:30:00Z\n\n", string(content))
+	content, err = os.ReadFile(down)
+	require.NoError(t, err)
+	assert.Equal(t, "-- Rollback migration\n-- Created 2026-10-16T09:30:00Z\n\n", string(content))
+
+	// The next one is numbered after it
+	up, _, err = CreateMigration(dir, "second", now)
+	require.NoError(t, err)
+	assert.Equal(t, "000012", MigrationVersion(up))
+
+	_, _, err = CreateMigration(dir, " -- ", now)
+	assert.Error(t, err)
+}
+
+func TestCreateMigration_EmptyDir(t *testing.T) {
+	up, _, err := CreateMigration(t.TempDir(), "init", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "000001_init.up.sql", filepath.Base(up))
+}
+
+func TestRollbackPlan(t *testing.T) {
+	applied := []string{"000032", "000031", "000030", "000029"}
+	tests := []struct {
+		name   string
+		steps  int
+		target string
+		want   []string
+	}{
+		{"one step", 1, "", []string{"000032"}},
+		{"several steps", 3, "", []string{"000032", "000031", "000030"}},
+		{"more steps than applied", 9, "", applied},
+		{"to a version", 0, "000030", []string{"000032", "000031"}},
+		{"to the latest", 0, "000032", []string{}},
+		{"to zero", 0, "000000", applied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := rollbackPlan(applied, tt.steps, tt.target)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, plan)
+		})
+	}
+
+	for _, bad := range []struct {
+		steps  int
+		target string
+	}{{0, ""}, {-1, ""}, {2, "000030"}, {0, "000010"}} {
+		_, err := rollbackPlan(applied, bad.steps, bad.target)
+		assert.Error(t, err, "steps %d target %q", bad.steps, bad.target)
+	}
+
+	// A target between applied versions keeps the older ones
+	plan, err := rollbackPlan([]string{"000032", "000029"}, 0, "000030")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"000032"}, plan)
+
+	plan, err = rollbackPlan(nil, 1, "")
+	require.NoError(t, err)
+	assert.Empty(t, plan)
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	assert.Equal(t, "000031", NormalizeVersion("31"))
+	assert.Equal(t, "000000", NormalizeVersion("0"))
+	assert.Equal(t, "000031", NormalizeVersion("000031"))
+	assert.Equal(t, "", NormalizeVersion(""))
+	assert.Equal(t, "abc", NormalizeVersion("abc"))
+}
+
+func TestIsTransactional(t *testing.T) {
+	assert.True(t, isTransactional("-- Add lot index\nCREATE INDEX idx_lots ON production_lots (lot_no);\n"))
+	assert.False(t, isTransactional("-- Add lot index\n-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY idx_lots ON production_lots (lot_no);\n"))
+	assert.False(t, isTransactional("  -- migrate:no-transaction  \r\nVACUUM;"))
+	assert.True(t, isTransactional("-- migrate:no-transaction is needed for CONCURRENTLY\nSELECT 1;"))
+}
+
+func TestSplitStatements(t *testing.T) {
+	sql := `-- migrate:no-transaction
+-- Lot lookups; built without blocking writes
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_lots_no ON production_lots (lot_no);
+COMMENT ON INDEX idx_lots_no IS 'lot; number';
+DO $body$ BEGIN PERFORM 1; END $body$;
+CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;
+/* ; */ SELECT $1::int;
+-- trailing comment;
+`
+	assert.Equal(t, []string{
+		"-- migrate:no-transaction\n-- Lot lookups; built without blocking writes\nCREATE INDEX CONCURRENTLY IF NOT EXISTS idx_lots_no ON production_lots (lot_no)",
+		"COMMENT ON INDEX idx_lots_no IS 'lot; number'",
+		"DO $body$ BEGIN PERFORM 1; END $body$",
+		"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql",
+		"/* ; */ SELECT $1::int",
+	}, splitStatements(sql))
+}
+
+func TestBuiltInMigrations(t *testing.T) {
+	ups, err := fs.Glob(migrations.FS, "*.up.sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, ups)
+
+	seen := map[string]string{}
+	for _, up := range ups {
+		version := MigrationVersion(up)
+		assert.Len(t, version, 6, up)
+		if other, ok := seen[version]; ok {
+			t.Errorf("%s and %s share version %s", up, other, version)
+		}
+		seen[version] = up
+		_, err := fs.Stat(migrations.FS, strings.TrimSuffix(up, ".up.sql")+".down.sql")
+		assert.NoError(t, err, "down migration of %s", up)
+	}
+}
+
+func TestUpFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_jobs.up.sql":   {},
+		"000001_init.up.sql":   {},
+		"000001_init.down.sql": {},
+		"000003_lots.up.sql":   {},
+	}
+	files, err := upFiles(fsys, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"000001_init.up.sql", "000002_jobs.up.sql", "000003_lots.up.sql"}, files)
+
+	files, err = upFiles(fsys, "2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"000001_init.up.sql", "000002_jobs.up.sql"}, files)
+
+	_, err = upFiles(fsys, "000004")
+	assert.Error(t, err, "unknown target")
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PlannedMigration is a pending migration as MigratePlan reports it
+type PlannedMigration struct {
+	Version       string
+	File          string
+	SQL           string
+	Transactional bool
+	Statements    []PlannedStatement
+}
+
+// PlannedStatement summarises one statement of a pending migration
+type PlannedStatement struct {
+	Action string // the statement kind, e.g. "CREATE INDEX" or "UPDATE"
+	Object string // the object it creates, changes or drops, "" when none is recognised
+	Table  string // the existing table it reads or writes, "" when none
+	// EstimatedRows is the planner's row estimate for Table, -1 when Table is "", does not
+	// exist yet, or has never been analyzed
+	EstimatedRows int64
+}
+
+// MigratePlan returns the migrations in fsys MigrateUpTo would apply, without applying
+// them or taking the migration lock. Row estimates come from pg_class, so they are as
+// current as the last ANALYZE.
+func MigratePlan(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, target string) ([]PlannedMigration, error) {
+	files, err := upFiles(fsys, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []PlannedMigration
+	for _, file := range files {
+		version := MigrationVersion(file)
+		if IsMigrationApplied(ctx, pool, version) {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		migration := PlannedMigration{
+			Version:       version,
+			File:          file,
+			SQL:           string(content),
+			Transactional: isTransactional(string(content)),
+		}
+		for _, sql := range splitStatements(string(content)) {
+			statement := describeStatement(sql)
+			statement.EstimatedRows = -1
+			if statement.Table != "" {
+				// NULL, and so left at -1, for tables that do not exist yet
+				var rows *int64
+				if err := pool.QueryRow(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)", statement.Table).Scan(&rows); err == nil && rows != nil && *rows >= 0 {
+					statement.EstimatedRows = *rows
+				}
+			}
+			migration.Statements = append(migration.Statements, statement)
+		}
+		plan = append(plan, migration)
+	}
+	return plan, nil
+}
+
+// ident matches a table or other object name, optionally schema-qualified and quoted
+const ident = `(?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?`
+
+// statementPatterns recognise the statements migrations use; the named groups object
+// and table pick out what a statement touches
+var statementPatterns = []struct {
+	action string
+	re     *regexp.Regexp
+}{
+	{"CREATE INDEX", statementPattern(`CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?P<object>` + ident + `)\s+ON\s+(?:ONLY\s+)?(?P<table>` + ident + `)`)},
+	{"CREATE TABLE", statementPattern(`CREATE\s+(?:UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(?P<object>` + ident + `)(?:\s+PARTITION\s+OF\s+(?P<table>` + ident + `))?`)},
+	{"ALTER TABLE", statementPattern(`ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(?P<table>` + ident + `)`)},
+	{"DROP TABLE", statementPattern(`DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?P<table>` + ident + `)`)},
+	{"CREATE TRIGGER", statementPattern(`CREATE\s+(?:OR\s+REPLACE\s+)?TRIGGER\s+(?P<object>` + ident + `)[\s\S]*?\sON\s+(?P<table>` + ident + `)`)},
+	{"DROP TRIGGER", statementPattern(`DROP\s+TRIGGER\s+(?:IF\s+EXISTS\s+)?(?P<object>` + ident + `)\s+ON\s+(?P<table>` + ident + `)`)},
+	{"CREATE POLICY", statementPattern(`CREATE\s+POLICY\s+(?P<object>` + ident + `)\s+ON\s+(?P<table>` + ident + `)`)},
+	{"DROP POLICY", statementPattern(`DROP\s+POLICY\s+(?:IF\s+EXISTS\s+)?(?P<object>` + ident + `)\s+ON\s+(?P<table>` + ident + `)`)},
+	{"INSERT", statementPattern(`INSERT\s+INTO\s+(?P<table>` + ident + `)`)},
+	{"UPDATE", statementPattern(`UPDATE\s+(?:ONLY\s+)?(?P<table>` + ident + `)`)},
+	{"DELETE", statementPattern(`DELETE\s+FROM\s+(?:ONLY\s+)?(?P<table>` + ident + `)`)},
+	{"", statementPattern(`(?P<action>(?:CREATE(?:\s+OR\s+REPLACE)?|ALTER|DROP)\s+(?:MATERIALIZED\s+VIEW|[a-z]+))\s+(?:IF\s+(?:NOT\s+)?EXISTS\s+)?(?P<object>` + ident + `)`)},
+}
+
+// statementPattern compiles a case-insensitive pattern anchored at the statement start
+func statementPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)^` + pattern)
+}
+
+// describeStatement summarises sql: its kind and the object and table it touches. A
+// statement no pattern knows is described by its first word, e.g. DO or COMMENT.
+func describeStatement(sql string) PlannedStatement {
+	body := stripLeadingComments(sql)
+	for _, pattern := range statementPatterns {
+		match := pattern.re.FindStringSubmatch(body)
+		if match == nil {
+			continue
+		}
+		statement := PlannedStatement{Action: pattern.action}
+		for i, name := range pattern.re.SubexpNames() {
+			value := match[i]
+			switch name {
+			case "action":
+				statement.Action = strings.Join(strings.Fields(strings.ToUpper(value)), " ")
+			case "object":
+				statement.Object = value
+			case "table":
+				statement.Table = value
+			}
+		}
+		if statement.Object == "" {
+			statement.Object = statement.Table
+		}
+		return statement
+	}
+	action, _, _ := strings.Cut(strings.Join(strings.Fields(body), " "), " ")
+	return PlannedStatement{Action: strings.ToUpper(action)}
+}
+
+// stripLeadingComments returns sql without the whitespace and -- comments before its
+// first statement keyword
+func stripLeadingComments(sql string) string {
+	for {
+		sql = strings.TrimSpace(sql)
+		if !strings.HasPrefix(sql, "--") {
+			return sql
+		}
+		_, rest, found := strings.Cut(sql, "\n")
+		if !found {
+			return ""
+		}
+		sql = rest
+	}
+}
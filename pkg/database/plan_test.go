@@ -0,0 +1,48 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want PlannedStatement
+	}{
+		{"-- Lot lookups\nCREATE INDEX CONCURRENTLY IF NOT EXISTS idx_lots_no ON production_lots (lot_no)",
+			PlannedStatement{Action: "CREATE INDEX", Object: "idx_lots_no", Table: "production_lots"}},
+		{"create unique index idx_a on public.yarn_variants using btree (sku)",
+			PlannedStatement{Action: "CREATE INDEX", Object: "idx_a", Table: "public.yarn_variants"}},
+		{"CREATE TABLE IF NOT EXISTS variant_cost_summary_history (\n\tid BIGINT\n)",
+			PlannedStatement{Action: "CREATE TABLE", Object: "variant_cost_summary_history"}},
+		{"CREATE TABLE variant_process_costs_p3 PARTITION OF variant_process_costs FOR VALUES WITH (MODULUS 8, REMAINDER 3)",
+			PlannedStatement{Action: "CREATE TABLE", Object: "variant_process_costs_p3", Table: "variant_process_costs"}},
+		{"ALTER TABLE yarn_variants ADD COLUMN lot_no TEXT",
+			PlannedStatement{Action: "ALTER TABLE", Object: "yarn_variants", Table: "yarn_variants"}},
+		{"DROP TABLE IF EXISTS variant_cost_summary_archive",
+			PlannedStatement{Action: "DROP TABLE", Object: "variant_cost_summary_archive", Table: "variant_cost_summary_archive"}},
+		{"CREATE TRIGGER trg_history\n\tAFTER UPDATE ON variant_cost_summaries\n\tREFERENCING OLD TABLE AS old_rows\n\tFOR EACH STATEMENT EXECUTE FUNCTION record_summary_history()",
+			PlannedStatement{Action: "CREATE TRIGGER", Object: "trg_history", Table: "variant_cost_summaries"}},
+		{"CREATE POLICY tenant_isolation ON batch_jobs USING (tenant_id = current_tenant())",
+			PlannedStatement{Action: "CREATE POLICY", Object: "tenant_isolation", Table: "batch_jobs"}},
+		{"UPDATE batch_jobs SET status = 'CANCELLED' WHERE job_type = 'ARCHIVE_HISTORY'",
+			PlannedStatement{Action: "UPDATE", Object: "batch_jobs", Table: "batch_jobs"}},
+		{"DELETE FROM job_schedules WHERE job_type = 'ARCHIVE_HISTORY'",
+			PlannedStatement{Action: "DELETE", Object: "job_schedules", Table: "job_schedules"}},
+		{"ALTER TYPE job_type ADD VALUE IF NOT EXISTS 'ARCHIVE_HISTORY'",
+			PlannedStatement{Action: "ALTER TYPE", Object: "job_type"}},
+		{"CREATE OR REPLACE FUNCTION current_tenant() RETURNS TEXT AS $$ SELECT 1 $$ LANGUAGE sql",
+			PlannedStatement{Action: "CREATE OR REPLACE FUNCTION", Object: "current_tenant"}},
+		{`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`,
+			PlannedStatement{Action: "CREATE EXTENSION", Object: `"uuid-ossp"`}},
+		{"DO $$ BEGIN PERFORM 1; END $$",
+			PlannedStatement{Action: "DO"}},
+		{"comment on column yarn_variants.sku is 'stock keeping unit'",
+			PlannedStatement{Action: "COMMENT"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, describeStatement(tt.sql), tt.sql)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/ilramdhan/costing-mvp/config"
@@ -12,7 +13,25 @@ import (
 
 // NewPool creates a new PostgreSQL connection pool
 func NewPool(ctx context.Context, cfg *config.DatabaseConfig) (*pgxpool.Pool, error) {
-	poolConfig, err := pgxpool.ParseConfig(cfg.DSN())
+	return newPool(ctx, cfg, cfg.DSN())
+}
+
+// NewReadPool creates a pool on the read replica cfg names, for heavy reads that
+// tolerate replication lag. Without a replica it returns primary, so reads can always
+// be routed through the result; close it only when it is not primary.
+func NewReadPool(ctx context.Context, cfg *config.DatabaseConfig, primary *pgxpool.Pool) (*pgxpool.Pool, error) {
+	if cfg.ReplicaDSN == "" {
+		return primary, nil
+	}
+	pool, err := newPool(ctx, cfg, cfg.ReplicaDSN)
+	if err != nil {
+		return nil, fmt.Errorf("read replica: %w", err)
+	}
+	return pool, nil
+}
+
+func newPool(ctx context.Context, cfg *config.DatabaseConfig, dsn string) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse database config: %w", err)
 	}
@@ -23,6 +42,19 @@ func NewPool(ctx context.Context, cfg *config.DatabaseConfig) (*pgxpool.Pool, er
 	poolConfig.MaxConnLifetime = cfg.PoolMaxConnLife
 	poolConfig.MaxConnIdleTime = 15 * time.Minute
 	poolConfig.HealthCheckPeriod = 1 * time.Minute
+	poolConfig.ConnConfig.Tracer = queryTracer{log: newQueryLog(cfg)}
+	if cfg.ApplicationName != "" {
+		poolConfig.ConnConfig.RuntimeParams["application_name"] = cfg.ApplicationName
+	}
+	// Row-level security limits each connection to its tenant's rows. The setting lasts for
+	// the session, so it is made once per connection rather than per query.
+	tenantID := cfg.TenantID
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if _, err := conn.Exec(ctx, "SELECT set_config('app.tenant_id', $1, false)", tenantID); err != nil {
+			return fmt.Errorf("failed to set tenant: %w", err)
+		}
+		return nil
+	}
 
 	// Create pool
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MigrationFix is what force or repair changes in schema_migrations. It changes only the
+// records; no migration SQL runs.
+type MigrationFix struct {
+	Record []string // versions to record as applied
+	Remove []string // records to delete
+	Clean  []string // dirty records to keep as applied and mark clean
+}
+
+// Empty reports whether the fix changes nothing
+func (f MigrationFix) Empty() bool {
+	return len(f.Record) == 0 && len(f.Remove) == 0 && len(f.Clean) == 0
+}
+
+// MigrationRecord is a row of schema_migrations
+type MigrationRecord struct {
+	Version string
+	Dirty   bool
+}
+
+// ForceMigrations makes schema_migrations record exactly the migrations in fsys up to
+// and including version target as applied and clean ("0" for none), for when a failed
+// migration was finished or undone by hand. confirm is shown the fix and applies it by
+// returning true; the fix is returned either way.
+func ForceMigrations(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, target string, confirm func(MigrationFix) bool) (MigrationFix, error) {
+	target = NormalizeVersion(target)
+	files, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return MigrationFix{}, fmt.Errorf("failed to find migration files: %w", err)
+	}
+	if target != NormalizeVersion("0") && !hasVersion(files, target) {
+		return MigrationFix{}, fmt.Errorf("no migration %s", target)
+	}
+	return fixMigrations(ctx, pool, confirm, func(records []MigrationRecord) MigrationFix {
+		return forceFix(records, versions(files), target)
+	})
+}
+
+// RepairMigrations reconciles schema_migrations after manual fixes: dirty records are
+// kept as applied and marked clean, and records of migrations no longer in fsys are
+// removed. A dirty migration that was undone by hand instead needs ForceMigrations to
+// the version before it. confirm is as for ForceMigrations.
+func RepairMigrations(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, confirm func(MigrationFix) bool) (MigrationFix, error) {
+	files, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return MigrationFix{}, fmt.Errorf("failed to find migration files: %w", err)
+	}
+	return fixMigrations(ctx, pool, confirm, func(records []MigrationRecord) MigrationFix {
+		return repairFix(records, versions(files))
+	})
+}
+
+// fixMigrations plans a fix from the records under the migration lock, so no run changes
+// them in between, and applies it in one transaction if confirm accepts it
+func fixMigrations(ctx context.Context, pool *pgxpool.Pool, confirm func(MigrationFix) bool, plan func([]MigrationRecord) MigrationFix) (MigrationFix, error) {
+	var fix MigrationFix
+	err := withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		if err := EnsureMigrationsTable(ctx, conn); err != nil {
+			return err
+		}
+		records, err := MigrationRecords(ctx, conn)
+		if err != nil {
+			return err
+		}
+		fix = plan(records)
+		if fix.Empty() || !confirm(fix) {
+			return nil
+		}
+
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+		if len(fix.Record) > 0 {
+			if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) SELECT unnest($1::text[]) ON CONFLICT (version) DO NOTHING", fix.Record); err != nil {
+				return fmt.Errorf("failed to record migrations: %w", err)
+			}
+		}
+		if len(fix.Remove) > 0 {
+			if _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = ANY($1)", fix.Remove); err != nil {
+				return fmt.Errorf("failed to remove migration records: %w", err)
+			}
+		}
+		if len(fix.Clean) > 0 {
+			if _, err := tx.Exec(ctx, "UPDATE schema_migrations SET dirty = FALSE WHERE version = ANY($1)", fix.Clean); err != nil {
+				return fmt.Errorf("failed to mark migrations clean: %w", err)
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		log.Printf("schema_migrations fixed: %d recorded, %d removed, %d marked clean", len(fix.Record), len(fix.Remove), len(fix.Clean))
+		return nil
+	})
+	return fix, err
+}
+
+// MigrationRecords returns the rows of schema_migrations in version order
+func MigrationRecords(ctx context.Context, db migrationDB) ([]MigrationRecord, error) {
+	rows, err := db.Query(ctx, "SELECT version, dirty FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migration records: %w", err)
+	}
+	defer rows.Close()
+	var records []MigrationRecord
+	for rows.Next() {
+		var r MigrationRecord
+		if err := rows.Scan(&r.Version, &r.Dirty); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// forceFix is the fix that leaves exactly the versions of available up to target
+// recorded, all clean
+func forceFix(records []MigrationRecord, available []string, target string) MigrationFix {
+	var fix MigrationFix
+	recorded := make(map[string]bool, len(records))
+	for _, r := range records {
+		recorded[r.Version] = true
+		switch {
+		case r.Version > target:
+			fix.Remove = append(fix.Remove, r.Version)
+		case r.Dirty:
+			fix.Clean = append(fix.Clean, r.Version)
+		}
+	}
+	for _, version := range available {
+		if version <= target && !recorded[version] {
+			fix.Record = append(fix.Record, version)
+		}
+	}
+	return fix
+}
+
+// repairFix is the fix that marks dirty records clean and removes records of versions
+// not in available
+func repairFix(records []MigrationRecord, available []string) MigrationFix {
+	var fix MigrationFix
+	for _, r := range records {
+		switch {
+		case !slices.Contains(available, r.Version):
+			fix.Remove = append(fix.Remove, r.Version)
+		case r.Dirty:
+			fix.Clean = append(fix.Clean, r.Version)
+		}
+	}
+	return fix
+}
+
+// versions returns the versions of files, in order
+func versions(files []string) []string {
+	out := make([]string, len(files))
+	for i, file := range files {
+		out[i] = MigrationVersion(file)
+	}
+	slices.Sort(out)
+	return out
+}
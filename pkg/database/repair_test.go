@@ -0,0 +1,33 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForceFix(t *testing.T) {
+	available := []string{"000001", "000002", "000003", "000004"}
+	records := []MigrationRecord{{Version: "000001"}, {Version: "000003", Dirty: true}, {Version: "000004"}}
+
+	// A failed 000003 finished by hand: keep it, drop what came after
+	assert.Equal(t, MigrationFix{Record: []string{"000002"}, Remove: []string{"000004"}, Clean: []string{"000003"}},
+		forceFix(records, available, "000003"))
+
+	// A failed 000003 undone by hand
+	assert.Equal(t, MigrationFix{Record: []string{"000002"}, Remove: []string{"000003", "000004"}},
+		forceFix(records, available, "000002"))
+
+	assert.Equal(t, MigrationFix{Remove: []string{"000001", "000003", "000004"}},
+		forceFix(records, available, "000000"))
+
+	assert.True(t, forceFix([]MigrationRecord{{Version: "000001"}}, available[:1], "000001").Empty())
+}
+
+func TestRepairFix(t *testing.T) {
+	available := []string{"000001", "000002", "000003"}
+	records := []MigrationRecord{{Version: "000001"}, {Version: "000002", Dirty: true}, {Version: "000009"}}
+	assert.Equal(t, MigrationFix{Remove: []string{"000009"}, Clean: []string{"000002"}}, repairFix(records, available))
+
+	assert.True(t, repairFix([]MigrationRecord{{Version: "000001"}}, available).Empty())
+}
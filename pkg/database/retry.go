@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ilramdhan/costing-mvp/config"
+)
+
+// RetryPolicy retries work that failed on a transient database error, waiting a random
+// time up to BaseDelay doubled for every further attempt and capped at MaxDelay
+type RetryPolicy struct {
+	MaxAttempts int           // tries in all, including the first; below 2 never retries
+	BaseDelay   time.Duration // upper bound of the wait before the first retry
+	MaxDelay    time.Duration // cap on the wait, 0 for none
+}
+
+// NewRetryPolicy returns the retry policy cfg configures
+func NewRetryPolicy(cfg *config.DatabaseConfig) RetryPolicy {
+	return RetryPolicy{MaxAttempts: cfg.RetryMaxAttempts, BaseDelay: cfg.RetryBaseDelay, MaxDelay: cfg.RetryMaxDelay}
+}
+
+// Do runs fn, again after a backoff while it fails with a transient error and attempts
+// remain, and returns its last error. fn must be safe to repeat: a connection lost while
+// committing may have committed it. Waiting stops when ctx is done.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !IsTransient(err) {
+			return err
+		}
+		delay := p.delay(attempt)
+		log.Printf("Transient database error, retrying in %v (attempt %d of %d): %v", delay.Round(time.Millisecond), attempt+1, p.MaxAttempts, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay is the wait after the given failed attempt: random in [0, ceiling), the ceiling
+// being BaseDelay doubled for every attempt after the first, capped at MaxDelay
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || ceiling < p.MaxDelay); i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// IsTransient reports whether err may succeed when the work is tried again: a
+// serialization failure, deadlock, lost or refused connection, or server shutdown.
+// Canceled and timed out contexts are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		// Class 08: connection exceptions
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	return pgconn.SafeToRetry(err) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
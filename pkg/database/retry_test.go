@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "40P01"}, true},
+		{&pgconn.PgError{Code: "08006"}, true},
+		{&pgconn.PgError{Code: "57P01"}, true},
+		{fmt.Errorf("failed to copy to temp table: %w", &pgconn.PgError{Code: "40P01"}), true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{&pgconn.PgError{Code: "22012"}, false},
+		{context.Canceled, false},
+		{fmt.Errorf("copy: %w", context.DeadlineExceeded), false},
+		{errors.New("invalid input"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, IsTransient(tt.err), "%v", tt.err)
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	deadlock := &pgconn.PgError{Code: "40P01"}
+
+	t.Run("retries transient errors until success", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(context.Background(), func() error {
+			if calls++; calls < 3 {
+				return deadlock
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up after MaxAttempts", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(context.Background(), func() error {
+			calls++
+			return deadlock
+		})
+		assert.ErrorIs(t, err, deadlock)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(context.Background(), func() error {
+			calls++
+			return &pgconn.PgError{Code: "23505"}
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("stops waiting when ctx is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		err := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}.Do(ctx, func() error {
+			calls++
+			return deadlock
+		})
+		assert.ErrorIs(t, err, deadlock)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("zero policy runs once", func(t *testing.T) {
+		calls := 0
+		RetryPolicy{}.Do(context.Background(), func() error {
+			calls++
+			return deadlock
+		})
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 50; i++ {
+			delay := policy.delay(attempt)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.Less(t, delay, ceiling, "attempt %d", attempt)
+		}
+	}
+	assert.Zero(t, RetryPolicy{}.delay(3))
+}
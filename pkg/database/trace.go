@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ilramdhan/costing-mvp/config"
+)
+
+// Query log modes DB_QUERY_LOG selects
+const (
+	QueryLogOff  = "off"  // log no queries
+	QueryLogSlow = "slow" // log queries slower than DB_SLOW_QUERY_MS
+	QueryLogAll  = "all"  // log every query, slow ones as warnings
+)
+
+// maxLoggedSQL bounds the length of the SQL a query log line carries
+const maxLoggedSQL = 2000
+
+type requestIDKey struct{}
+
+type jobIDKey struct{}
+
+// WithRequestID returns ctx carrying the ID of the API request it serves, which the
+// query log records with every query run under it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// WithJobID returns ctx carrying the ID of the job it runs, which the query log records
+// with every query run under it
+func WithJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobIDKey{}, id)
+}
+
+// queryLog decides which queries of a pool are logged and logs them through slog
+type queryLog struct {
+	all  bool          // log every query
+	slow time.Duration // log queries taking at least this as slow, 0 for none
+}
+
+// newQueryLog returns the query log cfg configures; an unknown mode logs slow queries
+func newQueryLog(cfg *config.DatabaseConfig) queryLog {
+	switch cfg.QueryLog {
+	case QueryLogOff:
+		return queryLog{}
+	case QueryLogAll:
+		return queryLog{all: true, slow: cfg.SlowQueryThreshold}
+	default:
+		return queryLog{slow: cfg.SlowQueryThreshold}
+	}
+}
+
+// record logs a finished query if l logs it, with its duration, rows, error, the request
+// or job ctx carries, and its SQL with literals masked. Arguments are never logged.
+func (l queryLog) record(ctx context.Context, sql string, elapsed time.Duration, rows int64, err error) {
+	slow := l.slow > 0 && elapsed >= l.slow
+	if !slow && !l.all {
+		return
+	}
+	attrs := make([]slog.Attr, 0, 6)
+	attrs = append(attrs, slog.Duration("duration", elapsed), slog.Int64("rows", rows))
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if id, _ := ctx.Value(jobIDKey{}).(string); id != "" {
+		attrs = append(attrs, slog.String("job_id", id))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	attrs = append(attrs, slog.String("sql", sanitizeSQL(sql)))
+
+	level, msg := slog.LevelInfo, "query"
+	if slow {
+		level, msg = slog.LevelWarn, "slow query"
+	}
+	slog.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// sanitizeSQL prepares sql for a log line: comments are dropped, whitespace collapsed,
+// and string, dollar-quoted and numeric literals replaced by ? so values written into
+// the SQL itself are not logged. Placeholders such as $1 are kept.
+func sanitizeSQL(sql string) string {
+	var b strings.Builder
+	b.Grow(min(len(sql), maxLoggedSQL))
+	space := false
+	for i := 0; i < len(sql) && b.Len() < maxLoggedSQL; i++ {
+		ch := sql[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = b.Len() > 0
+			continue
+		case ch == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+
+		switch {
+		case ch == '\'':
+			// A doubled quote is an escaped one, so '' runs on into the same literal
+			for i++; i < len(sql); i++ {
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case ch == '"':
+			// Quoted names are kept as they are, digits and all
+			end := strings.IndexByte(sql[i+1:], '"')
+			if end < 0 {
+				b.WriteString(sql[i:])
+				i = len(sql)
+				continue
+			}
+			b.WriteString(sql[i : i+end+2])
+			i += end + 1
+		case ch == '$' && i+1 < len(sql) && !isDigit(sql[i+1]):
+			// $tag$ ... $tag$
+			tagEnd := strings.IndexByte(sql[i+1:], '$')
+			if tagEnd < 0 {
+				b.WriteByte(ch)
+				continue
+			}
+			tag := sql[i : i+tagEnd+2]
+			body := i + len(tag)
+			end := strings.Index(sql[body:], tag)
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i = body + end + len(tag) - 1
+			}
+			b.WriteByte('?')
+		case isDigit(ch) && (i == 0 || !isWordByte(sql[i-1])):
+			for i+1 < len(sql) && (isDigit(sql[i+1]) || sql[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	if b.Len() >= maxLoggedSQL {
+		return b.String()[:maxLoggedSQL] + "..."
+	}
+	return b.String()
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// isWordByte reports whether ch can precede a digit inside a name or placeholder
+func isWordByte(ch byte) bool {
+	return ch == '_' || ch == '$' || isDigit(ch) || (ch|0x20 >= 'a' && ch|0x20 <= 'z')
+}
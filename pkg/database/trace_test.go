@@ -0,0 +1,74 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ilramdhan/costing-mvp/config"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT id FROM master_yarns WHERE id = $1", "SELECT id FROM master_yarns WHERE id = $1"},
+		{"\n\t\tSELECT *\n\t\tFROM batch_jobs  -- pending first\n\t\tLIMIT 10\n", "SELECT * FROM batch_jobs LIMIT ?"},
+		{"UPDATE price_rates SET rate = 12.5 WHERE code = 'COTTON''S'", "UPDATE price_rates SET rate = ? WHERE code = ?"},
+		{`INSERT INTO "temp_vcs_17" SELECT * FROM temp_vcs_1700000000`, `INSERT INTO "temp_vcs_17" SELECT * FROM temp_vcs_1700000000`},
+		{"SELECT $1::int[], v2.id FROM t v2", "SELECT $1::int[], v2.id FROM t v2"},
+		{"DO $$ BEGIN PERFORM 1; END $$; SELECT 2", "DO ?; SELECT ?"},
+		{"SELECT current_setting('app.tenant_id', true)", "SELECT current_setting(?, true)"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sanitizeSQL(tt.sql), tt.sql)
+	}
+
+	long := sanitizeSQL("SELECT " + strings.Repeat("a, ", maxLoggedSQL))
+	assert.Len(t, long, maxLoggedSQL+len("..."))
+}
+
+func TestQueryLog_Record(t *testing.T) {
+	var buf bytes.Buffer
+	// Setting a default slog logger redirects the log package too
+	defer log.SetFlags(log.Flags())
+	defer log.SetOutput(log.Writer())
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	ctx := WithJobID(WithRequestID(context.Background(), "req-1"), "job-1")
+	slow := newQueryLog(&config.DatabaseConfig{QueryLog: QueryLogSlow, SlowQueryThreshold: 100 * time.Millisecond})
+
+	slow.record(ctx, "SELECT 1", 10*time.Millisecond, 1, nil)
+	assert.Empty(t, buf.String(), "fast queries are not logged")
+
+	slow.record(ctx, "SELECT * FROM t WHERE code = 'secret'", 200*time.Millisecond, 3, errors.New("boom"))
+	line := buf.String()
+	assert.Contains(t, line, "level=WARN")
+	assert.Contains(t, line, `msg="slow query"`)
+	assert.Contains(t, line, "duration=200ms")
+	assert.Contains(t, line, "rows=3")
+	assert.Contains(t, line, "request_id=req-1")
+	assert.Contains(t, line, "job_id=job-1")
+	assert.Contains(t, line, "error=boom")
+	assert.NotContains(t, line, "secret")
+
+	buf.Reset()
+	all := newQueryLog(&config.DatabaseConfig{QueryLog: QueryLogAll})
+	all.record(context.Background(), "SELECT 1", time.Millisecond, 1, nil)
+	assert.Contains(t, buf.String(), "level=INFO msg=query")
+	assert.NotContains(t, buf.String(), "request_id")
+
+	buf.Reset()
+	off := newQueryLog(&config.DatabaseConfig{QueryLog: QueryLogOff, SlowQueryThreshold: time.Millisecond})
+	off.record(ctx, "SELECT 1", time.Second, 1, nil)
+	assert.Empty(t, buf.String())
+}
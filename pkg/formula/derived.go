@@ -0,0 +1,88 @@
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CycleError is returned when derived parameters reference each other in a loop
+type CycleError struct {
+	Path []string // e.g. [a b a] for a -> b -> a
+}
+
+func (e *CycleError) Error() string {
+	return "derived parameter cycle: " + strings.Join(e.Path, " -> ")
+}
+
+// ResolveDerived evaluates derived parameters, each defined by an expression over params
+// and other derived parameters. Dependencies are evaluated first, so the result does not
+// depend on map order; a derived parameter replaces a param of the same name. Returns
+// the value of every derived parameter, or a *CycleError when they reference each other
+// in a loop.
+func (p *Parser) ResolveDerived(params map[string]interface{}, derived map[string]string) (map[string]float64, error) {
+	env := make(map[string]interface{}, len(params)+len(derived))
+	for k, v := range params {
+		env[k] = v
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(derived))
+	resolved := make(map[string]float64, len(derived))
+	var stack []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					return &CycleError{Path: append(append([]string{}, stack[i:]...), name)}
+				}
+			}
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+
+		expression := derived[name]
+		deps, err := Identifiers(expression)
+		if err != nil {
+			return fmt.Errorf("derived parameter %s: %w", name, err)
+		}
+		for _, dep := range deps {
+			if _, ok := derived[dep]; ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		value, err := p.Evaluate(expression, env)
+		if err != nil {
+			return fmt.Errorf("derived parameter %s: %w", name, err)
+		}
+		env[name] = value
+		resolved[name] = value
+
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		return nil
+	}
+
+	names := make([]string, 0, len(derived))
+	for name := range derived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
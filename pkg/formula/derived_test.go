@@ -0,0 +1,51 @@
+package formula
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParser_ResolveDerived(t *testing.T) {
+	parser := NewParser()
+	params := map[string]interface{}{
+		"base_oil_index": 100.0,
+		"dye_price":      1.0, // replaced by the derived value
+	}
+	derived := map[string]string{
+		"dye_price":      "chemical_price * 1.2",
+		"chemical_price": "base_oil_index * 0.8 + 5",
+	}
+
+	values, err := parser.ResolveDerived(params, derived)
+	require.NoError(t, err)
+	assert.InDelta(t, 85.0, values["chemical_price"], 0.001)
+	assert.InDelta(t, 102.0, values["dye_price"], 0.001)
+	assert.Equal(t, 1.0, params["dye_price"], "params must not be modified")
+}
+
+func TestParser_ResolveDerived_Cycle(t *testing.T) {
+	parser := NewParser()
+	derived := map[string]string{
+		"a": "b + 1",
+		"b": "c * 2",
+		"c": "a - 1",
+	}
+
+	_, err := parser.ResolveDerived(map[string]interface{}{}, derived)
+	var cycleErr *CycleError
+	require.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"a", "b", "c", "a"}, cycleErr.Path)
+
+	_, err = parser.ResolveDerived(map[string]interface{}{"x": 1.0}, map[string]string{"x": "x + 1"})
+	require.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"x", "x"}, cycleErr.Path)
+}
+
+func TestParser_ResolveDerived_EvaluationError(t *testing.T) {
+	parser := NewParser()
+	_, err := parser.ResolveDerived(map[string]interface{}{}, map[string]string{"rate": "unknown_index * 2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "derived parameter rate")
+}
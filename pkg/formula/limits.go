@@ -0,0 +1,118 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Default complexity limits applied by NewParser
+const (
+	DefaultMaxLength = 2000
+	DefaultMaxNodes  = 500
+)
+
+var (
+	// ErrExpressionTooLong is returned when an expression exceeds the maximum length
+	ErrExpressionTooLong = errors.New("expression too long")
+	// ErrExpressionTooComplex is returned when an expression exceeds the maximum AST node count
+	ErrExpressionTooComplex = errors.New("expression too complex")
+	// ErrEvaluationTimeout is returned when a single evaluation exceeds the configured timeout
+	ErrEvaluationTimeout = errors.New("evaluation timed out")
+)
+
+// WithMaxLength limits the expression length in bytes; 0 disables the check
+func WithMaxLength(n int) Option {
+	return func(p *Parser) {
+		p.maxLength = n
+	}
+}
+
+// WithMaxNodes limits the number of AST nodes in an expression; 0 disables the check
+func WithMaxNodes(n int) Option {
+	return func(p *Parser) {
+		p.maxNodes = n
+	}
+}
+
+// WithTimeout bounds each evaluation; 0 disables the timeout. A timed-out evaluation
+// is abandoned rather than interrupted, so it keeps its goroutine until it finishes
+// and params must not be modified afterwards.
+func WithTimeout(d time.Duration) Option {
+	return func(p *Parser) {
+		p.timeout = d
+	}
+}
+
+// CheckComplexity rejects expressions that exceed the parser's length or node limits.
+// It is cheap enough to run on every save.
+func (p *Parser) CheckComplexity(expression string) error {
+	if p.maxLength > 0 && len(expression) > p.maxLength {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrExpressionTooLong, len(expression), p.maxLength)
+	}
+	if p.maxNodes <= 0 {
+		return nil
+	}
+
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return fmt.Errorf("failed to parse expression '%s': %w", expression, err)
+	}
+	counter := &nodeCounter{}
+	ast.Walk(&tree.Node, counter)
+	if counter.n > p.maxNodes {
+		return fmt.Errorf("%w: %d nodes, limit is %d", ErrExpressionTooComplex, counter.n, p.maxNodes)
+	}
+	return nil
+}
+
+type nodeCounter struct {
+	n int
+}
+
+func (c *nodeCounter) Visit(*ast.Node) {
+	c.n++
+}
+
+// compile checks complexity limits before compiling the expression
+func (p *Parser) compile(expression string, opts []expr.Option) (*vm.Program, error) {
+	if err := p.CheckComplexity(expression); err != nil {
+		return nil, err
+	}
+	program, err := expr.Compile(expression, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile expression '%s': %w", expression, err)
+	}
+	return program, nil
+}
+
+// exec runs a compiled program, bounded by the parser timeout when one is set
+func (p *Parser) exec(program *vm.Program, params map[string]interface{}) (interface{}, error) {
+	if p.timeout <= 0 {
+		return expr.Run(program, params)
+	}
+
+	type outcome struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		value, err := expr.Run(program, params)
+		done <- outcome{value, err}
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.value, o.err
+	case <-timer.C:
+		return nil, ErrEvaluationTimeout
+	}
+}
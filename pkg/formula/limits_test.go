@@ -0,0 +1,49 @@
+package formula
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParser_CheckComplexity(t *testing.T) {
+	parser := NewParser(WithMaxLength(50), WithMaxNodes(5))
+
+	assert.NoError(t, parser.CheckComplexity("a * b + c"))
+
+	err := parser.CheckComplexity(strings.Repeat("a + ", 20) + "a")
+	assert.ErrorIs(t, err, ErrExpressionTooLong)
+
+	err = parser.CheckComplexity("a + b + c + d")
+	assert.ErrorIs(t, err, ErrExpressionTooComplex)
+
+	unlimited := NewParser(WithMaxLength(0), WithMaxNodes(0))
+	assert.NoError(t, unlimited.CheckComplexity(strings.Repeat("a + ", 1000)+"a"))
+}
+
+func TestParser_Evaluate_RejectsComplexExpression(t *testing.T) {
+	parser := NewParser(WithMaxNodes(3))
+	params := map[string]interface{}{"a": 1.0, "b": 2.0, "c": 3.0}
+
+	_, err := parser.Evaluate("a + b + c", params)
+	assert.ErrorIs(t, err, ErrExpressionTooComplex)
+
+	_, _, err = parser.EvaluateBatch("a + b + c", []map[string]interface{}{params})
+	assert.ErrorIs(t, err, ErrExpressionTooComplex)
+}
+
+func TestParser_Evaluate_Timeout(t *testing.T) {
+	parser := NewParser(WithTimeout(time.Millisecond))
+	params := map[string]interface{}{"n": 800000}
+
+	_, err := parser.Evaluate("reduce(1..n, #acc + #) * 1.0", params)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrEvaluationTimeout)
+
+	result, err := parser.Evaluate("n * 2.0", params)
+	require.NoError(t, err)
+	assert.InDelta(t, 1600000.0, result, 0.001)
+}
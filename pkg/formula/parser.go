@@ -1,54 +1,272 @@
 package formula
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm"
 )
 
+var (
+	// ErrNonFiniteResult is returned when a formula evaluates to NaN or ±Inf
+	ErrNonFiniteResult = errors.New("non-finite result")
+	// ErrDivisionByZero is returned when a formula divides by zero and no fallback is configured
+	ErrDivisionByZero = errors.New("division by zero")
+)
+
+// EvalError identifies the sub-expression responsible for an unusable formula result
+type EvalError struct {
+	Expression    string
+	SubExpression string
+	Err           error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%v in '%s' (formula '%s')", e.Err, e.SubExpression, e.Expression)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// safeDivFunc is the internal function division operators are rewritten to when a fallback is set
+const safeDivFunc = "__safe_div"
+
+// Option configures a Parser
+type Option func(*Parser)
+
+// WithDivByZeroFallback makes division by zero yield fallback instead of an error
+func WithDivByZeroFallback(fallback float64) Option {
+	return func(p *Parser) {
+		p.divByZeroFallback = &fallback
+	}
+}
+
 // Parser handles formula parsing and evaluation
 type Parser struct {
 	// No cache needed since we compile with params each time
+	divByZeroFallback *float64
+	maxLength         int
+	maxNodes          int
+	timeout           time.Duration
 }
 
-// NewParser creates a new formula parser
-func NewParser() *Parser {
-	return &Parser{}
+// NewParser creates a new formula parser with the default complexity limits
+func NewParser(opts ...Option) *Parser {
+	p := &Parser{
+		maxLength: DefaultMaxLength,
+		maxNodes:  DefaultMaxNodes,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // Evaluate evaluates a formula with given parameters
 func (p *Parser) Evaluate(expression string, params map[string]interface{}) (float64, error) {
 	// Compile with the actual parameters as the environment
-	program, err := expr.Compile(expression, expr.Env(params), expr.AsFloat64())
+	program, err := p.compile(expression, p.compileOptions(params))
 	if err != nil {
-		return 0, fmt.Errorf("failed to compile expression '%s': %w", expression, err)
+		return 0, err
 	}
 
-	result, err := expr.Run(program, params)
+	return p.run(program, expression, params)
+}
+
+// EvaluateBatch compiles the expression once and evaluates it for every parameter set.
+// The sets must share the keys and value types of the first set, which is used to
+// type-check the expression. err is non-nil only when compilation fails; otherwise
+// values and errs are index-aligned with paramSets.
+func (p *Parser) EvaluateBatch(expression string, paramSets []map[string]interface{}) (values []float64, errs []error, err error) {
+	if len(paramSets) == 0 {
+		return nil, nil, nil
+	}
+	program, err := p.Compile(expression, paramSets[0])
 	if err != nil {
+		return nil, nil, err
+	}
+	values, errs = p.RunBatch(program, paramSets)
+	return values, errs, nil
+}
+
+// Program is a compiled formula that can be evaluated many times
+type Program struct {
+	expression string
+	program    *vm.Program
+}
+
+// Expression returns the source the program was compiled from
+func (prog *Program) Expression() string {
+	return prog.expression
+}
+
+// Compile checks complexity limits and compiles the expression, type-checking it
+// against the keys and value types of env. Params passed to Run must match env.
+func (p *Parser) Compile(expression string, env map[string]interface{}) (*Program, error) {
+	program, err := p.compile(expression, p.compileOptions(env))
+	if err != nil {
+		return nil, err
+	}
+	return &Program{expression: expression, program: program}, nil
+}
+
+// Run evaluates a compiled program
+func (p *Parser) Run(prog *Program, params map[string]interface{}) (float64, error) {
+	return p.run(prog.program, prog.expression, params)
+}
+
+// RunBatch evaluates a compiled program for every parameter set; values and errs are
+// index-aligned with paramSets
+func (p *Parser) RunBatch(prog *Program, paramSets []map[string]interface{}) (values []float64, errs []error) {
+	values = make([]float64, len(paramSets))
+	errs = make([]error, len(paramSets))
+	for i, params := range paramSets {
+		values[i], errs[i] = p.run(prog.program, prog.expression, params)
+	}
+	return values, errs
+}
+
+// run executes a compiled formula and rejects non-numeric or non-finite results
+func (p *Parser) run(program *vm.Program, expression string, params map[string]interface{}) (float64, error) {
+	result, err := p.exec(program, params)
+	if errors.Is(err, ErrEvaluationTimeout) {
+		// Locating the culprit would re-run the slow sub-expressions
+		return 0, &EvalError{Expression: expression, SubExpression: expression, Err: err}
+	}
+	if err != nil {
+		if evalErr := p.locate(expression, params); evalErr != nil {
+			return 0, evalErr
+		}
 		return 0, fmt.Errorf("failed to evaluate formula: %w", err)
 	}
 
-	switch v := result.(type) {
-	case float64:
-		return v, nil
-	case float32:
-		return float64(v), nil
-	case int:
-		return float64(v), nil
-	case int64:
-		return float64(v), nil
-	default:
+	value, ok := toFloat(result)
+	if !ok {
 		return 0, fmt.Errorf("unexpected result type: %T", result)
 	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		if evalErr := p.locate(expression, params); evalErr != nil {
+			return 0, evalErr
+		}
+		return 0, &EvalError{Expression: expression, SubExpression: expression, Err: ErrNonFiniteResult}
+	}
+	return value, nil
 }
 
 // ValidateExpression validates a formula expression with sample params
 func (p *Parser) ValidateExpression(expression string, sampleParams map[string]interface{}) error {
+	if err := p.CheckComplexity(expression); err != nil {
+		return err
+	}
 	_, err := expr.Compile(expression, expr.Env(sampleParams))
 	return err
 }
 
+func (p *Parser) compileOptions(params map[string]interface{}) []expr.Option {
+	return append(p.envOptions(params), expr.AsFloat64())
+}
+
+// envOptions returns the environment and division handling options without result coercion
+func (p *Parser) envOptions(params map[string]interface{}) []expr.Option {
+	opts := []expr.Option{expr.Env(params)}
+	if p.divByZeroFallback != nil {
+		fallback := *p.divByZeroFallback
+		opts = append(opts,
+			expr.Function(safeDivFunc, func(args ...any) (any, error) {
+				divisor, _ := toFloat(args[1])
+				if divisor == 0 {
+					return fallback, nil
+				}
+				dividend, _ := toFloat(args[0])
+				return dividend / divisor, nil
+			}),
+			expr.Patch(divisionPatcher{}),
+		)
+	}
+	return opts
+}
+
+// divisionPatcher rewrites "a / b" into a call to the safe division function
+type divisionPatcher struct{}
+
+func (divisionPatcher) Visit(node *ast.Node) {
+	if n, ok := (*node).(*ast.BinaryNode); ok && n.Operator == "/" {
+		ast.Patch(node, &ast.CallNode{
+			Callee:    &ast.IdentifierNode{Value: safeDivFunc},
+			Arguments: []ast.Node{n.Left, n.Right},
+		})
+	}
+}
+
+// locate walks the expression bottom-up and returns an EvalError for the innermost
+// sub-expression that divides by zero or produces a non-finite value
+func (p *Parser) locate(expression string, params map[string]interface{}) error {
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return nil
+	}
+
+	collector := &nodeCollector{}
+	ast.Walk(&tree.Node, collector)
+
+	for _, node := range collector.nodes {
+		if n, ok := node.(*ast.BinaryNode); ok && (n.Operator == "/" || n.Operator == "%") {
+			if p.divByZeroFallback != nil && n.Operator == "/" {
+				continue
+			}
+			if divisor, ok := p.evalNumber(n.Right.String(), params); ok && divisor == 0 {
+				return &EvalError{Expression: expression, SubExpression: n.String(), Err: ErrDivisionByZero}
+			}
+		}
+		if value, ok := p.evalNumber(node.String(), params); ok && (math.IsNaN(value) || math.IsInf(value, 0)) {
+			return &EvalError{Expression: expression, SubExpression: node.String(), Err: ErrNonFiniteResult}
+		}
+	}
+	return nil
+}
+
+// nodeCollector records nodes in post-order so children come before their parents
+type nodeCollector struct {
+	nodes []ast.Node
+}
+
+func (c *nodeCollector) Visit(node *ast.Node) {
+	c.nodes = append(c.nodes, *node)
+}
+
+func (p *Parser) evalNumber(expression string, params map[string]interface{}) (float64, bool) {
+	program, err := expr.Compile(expression, p.compileOptions(params)...)
+	if err != nil {
+		return 0, false
+	}
+	result, err := expr.Run(program, params)
+	if err != nil {
+		return 0, false
+	}
+	return toFloat(result)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	}
+	return 0, false
+}
+
 // DefaultParser is the global parser instance
 var DefaultParser = NewParser()
 
@@ -1,6 +1,7 @@
 package formula
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -71,6 +72,53 @@ func TestParser_Evaluate_Conditionals(t *testing.T) {
 	assert.Equal(t, 90.0, result)
 }
 
+func TestParser_Evaluate_StringAndBoolAttrs(t *testing.T) {
+	parser := NewParser()
+
+	expression := `fiber_type == "silk" ? dye_kg * 1.3 : dye_kg`
+	silk, err := parser.Evaluate(expression, map[string]interface{}{"fiber_type": "silk", "dye_kg": 10.0})
+	require.NoError(t, err)
+	assert.InDelta(t, 13.0, silk, 0.001)
+
+	cotton, err := parser.Evaluate(expression, map[string]interface{}{"fiber_type": "cotton", "dye_kg": 10.0})
+	require.NoError(t, err)
+	assert.InDelta(t, 10.0, cotton, 0.001)
+
+	result, err := parser.Evaluate(`organic && grade in ["A", "Premium"] ? 5 : 0`, map[string]interface{}{
+		"organic": true,
+		"grade":   "Premium",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, result)
+
+	// A string attribute is not a number
+	_, err = parser.Evaluate("fiber_type * 2", map[string]interface{}{"fiber_type": "silk"})
+	assert.Error(t, err)
+}
+
+func TestParser_Evaluate_NestedStepResults(t *testing.T) {
+	parser := NewParser()
+	params := map[string]interface{}{
+		"steps": map[string]interface{}{
+			"SPINNING": map[string]interface{}{"cost": 400.0},
+		},
+		"loom_hours": 8.0,
+		"loom_rate":  20.0,
+	}
+
+	result, err := parser.Evaluate("steps.SPINNING.cost + loom_hours * loom_rate", params)
+	require.NoError(t, err)
+	assert.InDelta(t, 560.0, result, 0.001)
+
+	// A step that has not run yet can be defaulted with optional chaining
+	result, err = parser.Evaluate("(steps.DYEING?.cost ?? 0) + 1", params)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, result, 0.001)
+
+	_, err = parser.Evaluate("steps.DYEING.cost + 1", params)
+	assert.Error(t, err)
+}
+
 func TestParser_Evaluate_MissingParam(t *testing.T) {
 	parser := NewParser()
 
@@ -94,6 +142,57 @@ func TestParser_Evaluate_InvalidExpression(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestParser_Evaluate_DivisionByZero(t *testing.T) {
+	parser := NewParser()
+
+	_, err := parser.Evaluate("labor_cost + overhead / units", map[string]interface{}{
+		"labor_cost": 100.0,
+		"overhead":   50.0,
+		"units":      0.0,
+	})
+
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrDivisionByZero)
+
+	var evalErr *EvalError
+	require.ErrorAs(t, err, &evalErr)
+	assert.Equal(t, "overhead / units", evalErr.SubExpression)
+}
+
+func TestParser_Evaluate_NonFiniteResult(t *testing.T) {
+	parser := NewParser()
+
+	_, err := parser.Evaluate("base_cost * factor", map[string]interface{}{
+		"base_cost": 100.0,
+		"factor":    math.Inf(1),
+	})
+
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrNonFiniteResult)
+}
+
+func TestParser_Evaluate_DivisionByZeroFallback(t *testing.T) {
+	parser := NewParser(WithDivByZeroFallback(0))
+
+	result, err := parser.Evaluate("labor_cost + overhead / units", map[string]interface{}{
+		"labor_cost": 100.0,
+		"overhead":   50.0,
+		"units":      0.0,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, result)
+
+	// Non-zero divisors are unaffected by the fallback
+	result, err = parser.Evaluate("overhead / units", map[string]interface{}{
+		"overhead": 50.0,
+		"units":    2.0,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 25.0, result)
+}
+
 func TestParser_Evaluate_TextileFormulas(t *testing.T) {
 	parser := NewParser()
 
@@ -168,3 +267,85 @@ func BenchmarkParser_Evaluate(b *testing.B) {
 		parser.Evaluate(expression, params)
 	}
 }
+
+func TestParser_EvaluateBatch(t *testing.T) {
+	parser := NewParser()
+	paramSets := []map[string]interface{}{
+		{"kwh": 10.0, "rate": 1.5, "qty": 2.0},
+		{"kwh": 20.0, "rate": 1.5, "qty": 0.0},
+		{"kwh": 30.0, "rate": 2.0, "qty": 3.0},
+	}
+
+	values, errs, err := parser.EvaluateBatch("kwh * rate / qty", paramSets)
+	require.NoError(t, err)
+	require.Len(t, values, 3)
+	require.Len(t, errs, 3)
+
+	assert.NoError(t, errs[0])
+	assert.InDelta(t, 7.5, values[0], 0.001)
+	assert.ErrorIs(t, errs[1], ErrDivisionByZero)
+	assert.NoError(t, errs[2])
+	assert.InDelta(t, 20.0, values[2], 0.001)
+}
+
+func TestParser_EvaluateBatch_StringAttrs(t *testing.T) {
+	parser := NewParser()
+	paramSets := []map[string]interface{}{
+		{"fiber_type": "silk", "dye_kg": 10.0},
+		{"fiber_type": "wool", "dye_kg": 10.0},
+	}
+
+	values, errs, err := parser.EvaluateBatch(`fiber_type == "silk" ? dye_kg * 1.3 : dye_kg`, paramSets)
+	require.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.InDelta(t, 13.0, values[0], 0.001)
+	assert.NoError(t, errs[1])
+	assert.InDelta(t, 10.0, values[1], 0.001)
+}
+
+func TestParser_EvaluateBatch_CompileError(t *testing.T) {
+	parser := NewParser()
+	_, _, err := parser.EvaluateBatch("kwh * ", []map[string]interface{}{{"kwh": 1.0}})
+	assert.Error(t, err)
+
+	values, errs, err := parser.EvaluateBatch("kwh", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, values)
+	assert.Nil(t, errs)
+}
+
+func BenchmarkParser_EvaluateBatch(b *testing.B) {
+	parser := NewParser()
+	expression := "(electricity_kwh * rate_per_kwh) + (labor_hours * labor_rate) + overhead"
+	paramSets := make([]map[string]interface{}, 1000)
+	for i := range paramSets {
+		paramSets[i] = map[string]interface{}{
+			"electricity_kwh": float64(i),
+			"rate_per_kwh":    1.5,
+			"labor_hours":     8.0,
+			"labor_rate":      25.0,
+			"overhead":        50.0,
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.EvaluateBatch(expression, paramSets)
+	}
+}
+
+func TestParser_CompileAndRun(t *testing.T) {
+	parser := NewParser()
+	env := map[string]interface{}{"kwh": 0.0, "rate": 0.0}
+
+	program, err := parser.Compile("kwh * rate", env)
+	require.NoError(t, err)
+	assert.Equal(t, "kwh * rate", program.Expression())
+
+	result, err := parser.Run(program, map[string]interface{}{"kwh": 10.0, "rate": 1.5})
+	require.NoError(t, err)
+	assert.InDelta(t, 15.0, result, 0.001)
+
+	_, err = parser.Compile("kwh * ", env)
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package formula
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// TraceStep is the value of one sub-expression during evaluation
+type TraceStep struct {
+	Expression string      `json:"expression"`
+	Value      interface{} `json:"value,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Identifiers returns the sorted, de-duplicated parameter names referenced by an expression
+func Identifiers(expression string) ([]string, error) {
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression '%s': %w", expression, err)
+	}
+
+	collector := &nodeCollector{}
+	ast.Walk(&tree.Node, collector)
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, node := range collector.nodes {
+		if n, ok := node.(*ast.IdentifierNode); ok && !seen[n.Value] {
+			seen[n.Value] = true
+			names = append(names, n.Value)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Trace evaluates the expression and records the value of every parameter and
+// sub-expression, innermost first. Literals are omitted. Each step appears once even
+// if it occurs several times in the expression. The overall result and error are the
+// same as Evaluate.
+func (p *Parser) Trace(expression string, params map[string]interface{}) (float64, []TraceStep, error) {
+	if err := p.CheckComplexity(expression); err != nil {
+		return 0, nil, err
+	}
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse expression '%s': %w", expression, err)
+	}
+
+	collector := &nodeCollector{}
+	ast.Walk(&tree.Node, collector)
+
+	seen := make(map[string]bool)
+	var steps []TraceStep
+	for _, node := range collector.nodes {
+		switch node.(type) {
+		case *ast.IntegerNode, *ast.FloatNode, *ast.StringNode, *ast.BoolNode, *ast.NilNode:
+			continue
+		}
+		sub := node.String()
+		if seen[sub] {
+			continue
+		}
+		seen[sub] = true
+
+		step := TraceStep{Expression: sub}
+		value, err := p.runUncoerced(sub, params)
+		switch f, ok := toFloat(value); {
+		case err != nil:
+			step.Error = err.Error()
+		case ok && (math.IsNaN(f) || math.IsInf(f, 0)):
+			// NaN and ±Inf are not representable in JSON responses
+			step.Error = ErrNonFiniteResult.Error()
+		default:
+			step.Value = value
+		}
+		steps = append(steps, step)
+	}
+
+	value, err := p.Evaluate(expression, params)
+	return value, steps, err
+}
+
+// runUncoerced evaluates an expression without coercing the result, so comparisons yield booleans
+func (p *Parser) runUncoerced(expression string, params map[string]interface{}) (interface{}, error) {
+	program, err := expr.Compile(expression, p.envOptions(params)...)
+	if err != nil {
+		return nil, err
+	}
+	return p.exec(program, params)
+}
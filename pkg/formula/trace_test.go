@@ -0,0 +1,51 @@
+package formula
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentifiers(t *testing.T) {
+	names, err := Identifiers("(kwh * rate) + labor_hours * rate")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kwh", "labor_hours", "rate"}, names)
+
+	_, err = Identifiers("kwh * ")
+	assert.Error(t, err)
+}
+
+func TestParser_Trace(t *testing.T) {
+	parser := NewParser()
+	params := map[string]interface{}{
+		"kwh":  10.0,
+		"rate": 2.0,
+	}
+
+	result, steps, err := parser.Trace("(kwh * rate) + 5", params)
+	require.NoError(t, err)
+	assert.InDelta(t, 25.0, result, 0.001)
+
+	require.Len(t, steps, 4)
+	assert.Equal(t, "kwh", steps[0].Expression)
+	assert.Equal(t, 10.0, steps[0].Value)
+	assert.Equal(t, "kwh * rate", steps[2].Expression)
+	assert.Equal(t, 20.0, steps[2].Value)
+	assert.Equal(t, "kwh * rate + 5", steps[3].Expression)
+}
+
+func TestParser_Trace_Error(t *testing.T) {
+	parser := NewParser()
+	params := map[string]interface{}{
+		"cost": 100.0,
+		"qty":  0.0,
+	}
+
+	_, steps, err := parser.Trace("cost / qty", params)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrDivisionByZero)
+	require.Len(t, steps, 3)
+	assert.Nil(t, steps[2].Value)
+	assert.Equal(t, ErrNonFiniteResult.Error(), steps[2].Error)
+}
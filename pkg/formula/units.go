@@ -0,0 +1,301 @@
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Base dimensions understood by the unit checker
+const (
+	DimKg       = "kg"
+	DimKwh      = "kwh"
+	DimHour     = "hour"
+	DimCurrency = "currency"
+	DimMeter    = "meter"
+	DimLiter    = "liter"
+	DimPiece    = "pcs"
+)
+
+// unitAliases maps the spellings found in master_parameters.unit to base dimensions.
+// An empty dimension means dimensionless (percentages, factors).
+var unitAliases = map[string]string{
+	"kg": DimKg, "kgs": DimKg, "kilogram": DimKg,
+	"kwh": DimKwh, "kilowatthour": DimKwh,
+	"hour": DimHour, "hours": DimHour, "h": DimHour, "hr": DimHour, "hrs": DimHour,
+	"currency": DimCurrency, "idr": DimCurrency, "rp": DimCurrency, "usd": DimCurrency,
+	"meter": DimMeter, "meters": DimMeter, "m": DimMeter,
+	"liter": DimLiter, "liters": DimLiter, "l": DimLiter,
+	"pcs": DimPiece, "unit": DimPiece, "units": DimPiece,
+	"%": "", "pct": "", "percent": "", "ratio": "", "factor": "",
+}
+
+// Unit is a product of base dimensions with integer exponents, e.g. currency/kg
+type Unit map[string]int
+
+// ParseUnit parses unit strings such as "kg", "currency/kwh" or "kg*hour"
+func ParseUnit(s string) (Unit, error) {
+	u := Unit{}
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return u, nil
+	}
+
+	for i, part := range strings.Split(s, "/") {
+		sign := 1
+		if i > 0 {
+			sign = -1
+		}
+		for _, factor := range strings.Split(part, "*") {
+			factor = strings.TrimSpace(factor)
+			dim, ok := unitAliases[factor]
+			if !ok {
+				return nil, fmt.Errorf("unknown unit '%s'", factor)
+			}
+			if dim != "" {
+				u[dim] += sign
+			}
+		}
+	}
+	return u.normalize(), nil
+}
+
+// String renders the unit with positive exponents first, e.g. "currency/kg"
+func (u Unit) String() string {
+	var num, den []string
+	dims := make([]string, 0, len(u))
+	for d := range u {
+		dims = append(dims, d)
+	}
+	sort.Strings(dims)
+	for _, d := range dims {
+		for i := 0; i < abs(u[d]); i++ {
+			if u[d] > 0 {
+				num = append(num, d)
+			} else {
+				den = append(den, d)
+			}
+		}
+	}
+
+	s := strings.Join(num, "*")
+	if s == "" {
+		s = "1"
+	}
+	if len(den) > 0 {
+		s += "/" + strings.Join(den, "/")
+	}
+	return s
+}
+
+// Equal reports whether two units have the same dimensions
+func (u Unit) Equal(other Unit) bool {
+	if len(u) != len(other) {
+		return false
+	}
+	for d, e := range u {
+		if other[d] != e {
+			return false
+		}
+	}
+	return true
+}
+
+func (u Unit) mul(other Unit, sign int) Unit {
+	out := Unit{}
+	for d, e := range u {
+		out[d] += e
+	}
+	for d, e := range other {
+		out[d] += sign * e
+	}
+	return out.normalize()
+}
+
+func (u Unit) normalize() Unit {
+	for d, e := range u {
+		if e == 0 {
+			delete(u, d)
+		}
+	}
+	return u
+}
+
+// UnitIssue describes a dimensionally inconsistent sub-expression
+type UnitIssue struct {
+	SubExpression string `json:"sub_expression"`
+	Message       string `json:"message"`
+}
+
+func (i UnitIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.SubExpression, i.Message)
+}
+
+// CheckUnits verifies that additions, subtractions, comparisons and branches in the
+// expression combine compatible units, given the unit of each parameter. Parameters
+// without a unit are treated as unknown and never flagged. When expected is set and the
+// result unit can be determined, the result must match it (e.g. "currency" for costs).
+func CheckUnits(expression string, units map[string]string, expected string) ([]UnitIssue, error) {
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression '%s': %w", expression, err)
+	}
+
+	parsed := make(map[string]Unit, len(units))
+	for key, unit := range units {
+		u, err := ParseUnit(unit)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", key, err)
+		}
+		parsed[key] = u
+	}
+
+	c := &unitChecker{units: parsed}
+	result := c.visit(tree.Node)
+
+	if expected != "" && result.kind == unitKnown {
+		want, err := ParseUnit(expected)
+		if err != nil {
+			return nil, err
+		}
+		if !result.unit.Equal(want) {
+			c.issues = append(c.issues, UnitIssue{
+				SubExpression: expression,
+				Message:       fmt.Sprintf("result has unit %s, expected %s", result.unit, want),
+			})
+		}
+	}
+	return c.issues, nil
+}
+
+type unitKind int
+
+const (
+	unitUnknown unitKind = iota // no unit information, never flagged
+	unitLiteral                 // numeric literal, adapts to the other operand
+	unitKnown
+)
+
+type unitValue struct {
+	kind unitKind
+	unit Unit
+}
+
+type unitChecker struct {
+	units  map[string]Unit
+	issues []UnitIssue
+}
+
+func (c *unitChecker) visit(node ast.Node) unitValue {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		if u, ok := c.units[n.Value]; ok {
+			return unitValue{kind: unitKnown, unit: u}
+		}
+		return unitValue{kind: unitUnknown}
+
+	case *ast.IntegerNode, *ast.FloatNode:
+		return unitValue{kind: unitLiteral, unit: Unit{}}
+
+	case *ast.UnaryNode:
+		v := c.visit(n.Node)
+		if n.Operator == "-" || n.Operator == "+" {
+			return v
+		}
+		return unitValue{kind: unitUnknown}
+
+	case *ast.BinaryNode:
+		left := c.visit(n.Left)
+		right := c.visit(n.Right)
+		switch n.Operator {
+		case "+", "-", "%":
+			return c.combine(n, left, right)
+		case "==", "!=", "<", ">", "<=", ">=":
+			c.combine(n, left, right)
+			return unitValue{kind: unitUnknown}
+		case "*":
+			return product(left, right, 1)
+		case "/":
+			return product(left, right, -1)
+		case "**", "^":
+			if left.kind == unitKnown {
+				if exp, ok := n.Right.(*ast.IntegerNode); ok {
+					out := Unit{}
+					for d, e := range left.unit {
+						out[d] = e * exp.Value
+					}
+					return unitValue{kind: unitKnown, unit: out.normalize()}
+				}
+				return unitValue{kind: unitUnknown}
+			}
+			return left
+		}
+		return unitValue{kind: unitUnknown}
+
+	case *ast.ConditionalNode:
+		c.visit(n.Cond)
+		return c.combine(n, c.visit(n.Exp1), c.visit(n.Exp2))
+
+	case *ast.BuiltinNode:
+		args := make([]unitValue, len(n.Arguments))
+		for i, arg := range n.Arguments {
+			args[i] = c.visit(arg)
+		}
+		switch n.Name {
+		case "abs", "ceil", "floor", "round":
+			if len(args) == 1 {
+				return args[0]
+			}
+		case "max", "min":
+			if len(args) == 0 {
+				break
+			}
+			result := args[0]
+			for _, arg := range args[1:] {
+				result = c.combine(n, result, arg)
+			}
+			return result
+		}
+		return unitValue{kind: unitUnknown}
+	}
+	return unitValue{kind: unitUnknown}
+}
+
+// combine checks operands that must share a unit and returns the resulting unit
+func (c *unitChecker) combine(node ast.Node, left, right unitValue) unitValue {
+	switch {
+	case left.kind == unitUnknown || right.kind == unitUnknown:
+		return unitValue{kind: unitUnknown}
+	case left.kind == unitLiteral:
+		return right
+	case right.kind == unitLiteral:
+		return left
+	case !left.unit.Equal(right.unit):
+		c.issues = append(c.issues, UnitIssue{
+			SubExpression: node.String(),
+			Message:       fmt.Sprintf("incompatible units %s and %s", left.unit, right.unit),
+		})
+		return unitValue{kind: unitUnknown}
+	}
+	return left
+}
+
+func product(left, right unitValue, sign int) unitValue {
+	if left.kind == unitUnknown || right.kind == unitUnknown {
+		return unitValue{kind: unitUnknown}
+	}
+	if left.kind == unitLiteral && right.kind == unitLiteral {
+		return unitValue{kind: unitLiteral, unit: Unit{}}
+	}
+	return unitValue{kind: unitKnown, unit: left.unit.mul(right.unit, sign)}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
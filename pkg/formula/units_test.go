@@ -0,0 +1,75 @@
+package formula
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUnit(t *testing.T) {
+	u, err := ParseUnit("IDR/kg")
+	require.NoError(t, err)
+	assert.Equal(t, Unit{DimCurrency: 1, DimKg: -1}, u)
+	assert.Equal(t, "currency/kg", u.String())
+
+	u, err = ParseUnit("%")
+	require.NoError(t, err)
+	assert.Empty(t, u)
+
+	_, err = ParseUnit("furlong")
+	assert.Error(t, err)
+}
+
+func TestCheckUnits_ConsistentCostFormula(t *testing.T) {
+	units := map[string]string{
+		"raw_material_kg":  "kg",
+		"material_price":   "currency/kg",
+		"electricity_kwh":  "kwh",
+		"electricity_rate": "currency/kwh",
+		"labor_hours":      "hours",
+		"labor_rate":       "currency/hour",
+	}
+
+	issues, err := CheckUnits(
+		"(raw_material_kg * material_price) + (electricity_kwh * electricity_rate) + (labor_hours * labor_rate) * 1.1",
+		units, "currency")
+
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestCheckUnits_FlagsIncompatibleAddition(t *testing.T) {
+	units := map[string]string{
+		"raw_material_kg": "kg",
+		"labor_hours":     "hours",
+	}
+
+	issues, err := CheckUnits("raw_material_kg + labor_hours", units, "")
+
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, "raw_material_kg + labor_hours", issues[0].SubExpression)
+	assert.Contains(t, issues[0].Message, "kg and hour")
+}
+
+func TestCheckUnits_FlagsUnexpectedResultUnit(t *testing.T) {
+	units := map[string]string{
+		"dye_kg":    "kg",
+		"dye_price": "currency",
+	}
+
+	// dye_price is per batch, not per kg, so the product is currency*kg
+	issues, err := CheckUnits("dye_kg * dye_price", units, "currency")
+
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Contains(t, issues[0].Message, "expected currency")
+}
+
+func TestCheckUnits_UnknownParametersAreNotFlagged(t *testing.T) {
+	issues, err := CheckUnits("input_cost_1 + spindle_hours * 2", map[string]string{"spindle_hours": "hours"}, "currency")
+
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
@@ -0,0 +1,136 @@
+package procs
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Sources of the effective GOMAXPROCS value
+const (
+	SourceOverride = "override"   // explicit configuration
+	SourceEnv      = "GOMAXPROCS" // GOMAXPROCS environment variable, applied by the runtime
+	SourceCgroup   = "cgroup"     // container CPU quota
+	SourceNumCPU   = "num_cpu"    // no quota, all host CPUs
+)
+
+// Info describes the parallelism available to the process
+type Info struct {
+	NumCPU     int     `json:"num_cpu"`             // CPUs visible to the process
+	CPUQuota   float64 `json:"cpu_quota,omitempty"` // cgroup quota in CPUs, 0 when unlimited
+	GOMAXPROCS int     `json:"gomaxprocs"`
+	Source     string  `json:"source"`
+}
+
+func (i Info) String() string {
+	quota := "none"
+	if i.CPUQuota > 0 {
+		quota = strconv.FormatFloat(i.CPUQuota, 'f', 2, 64)
+	}
+	return fmt.Sprintf("GOMAXPROCS=%d (source: %s, cpus: %d, cgroup quota: %s)", i.GOMAXPROCS, i.Source, i.NumCPU, quota)
+}
+
+// Apply sets GOMAXPROCS to the parallelism actually available, like automaxprocs:
+// override when positive, else the GOMAXPROCS environment variable when set, else the
+// cgroup CPU quota rounded down (minimum 1), else runtime.NumCPU(). In containers
+// NumCPU reports host CPUs, so without this the scheduler over-subscribes the quota
+// and the process is throttled.
+func Apply(override int) Info {
+	info := Info{NumCPU: runtime.NumCPU()}
+	if quota, ok := CPUQuota(); ok {
+		info.CPUQuota = quota
+	}
+
+	switch {
+	case override > 0:
+		info.GOMAXPROCS, info.Source = override, SourceOverride
+	case os.Getenv("GOMAXPROCS") != "":
+		info.GOMAXPROCS, info.Source = runtime.GOMAXPROCS(0), SourceEnv
+		return info
+	case info.CPUQuota > 0 && info.CPUQuota < float64(info.NumCPU):
+		info.GOMAXPROCS, info.Source = max(1, int(math.Floor(info.CPUQuota))), SourceCgroup
+	default:
+		info.GOMAXPROCS, info.Source = info.NumCPU, SourceNumCPU
+	}
+	runtime.GOMAXPROCS(info.GOMAXPROCS)
+	return info
+}
+
+// CPUQuota returns the CPU limit of the process's cgroup in CPUs (cgroup v2 cpu.max,
+// or v1 cpu.cfs_quota_us / cpu.cfs_period_us). ok is false when there is no limit or
+// it cannot be read.
+func CPUQuota() (quota float64, ok bool) {
+	v2, v1 := cgroupPaths()
+	for _, dir := range v2 {
+		if data, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+			return parseCPUMax(string(data))
+		}
+	}
+	for _, dir := range v1 {
+		quotaData, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		periodData, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			continue
+		}
+		return parseCFS(string(quotaData), string(periodData))
+	}
+	return 0, false
+}
+
+// cgroupPaths returns candidate cgroup v2 and v1 cpu controller directories, most
+// specific first, from /proc/self/cgroup
+func cgroupPaths() (v2, v1 []string) {
+	const root = "/sys/fs/cgroup"
+	f, err := os.Open("/proc/self/cgroup")
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// hierarchy-ID:controller-list:cgroup-path
+			parts := strings.SplitN(scanner.Text(), ":", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			if parts[0] == "0" && parts[1] == "" {
+				v2 = append(v2, filepath.Join(root, parts[2]))
+				continue
+			}
+			for _, controller := range strings.Split(parts[1], ",") {
+				if controller == "cpu" {
+					v1 = append(v1, filepath.Join(root, parts[1], parts[2]), filepath.Join(root, "cpu", parts[2]))
+				}
+			}
+		}
+	}
+	return append(v2, root), append(v1, filepath.Join(root, "cpu"))
+}
+
+// parseCPUMax parses cgroup v2 cpu.max: "<quota> <period>" or "max <period>"
+func parseCPUMax(s string) (float64, bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+	return parseCFS(fields[0], fields[1])
+}
+
+// parseCFS divides a cgroup quota by its period; a negative quota means unlimited
+func parseCFS(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(strings.TrimSpace(quota), 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(period), 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
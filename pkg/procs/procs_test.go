@@ -0,0 +1,43 @@
+package procs
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCPUMax(t *testing.T) {
+	quota, ok := parseCPUMax("200000 100000\n")
+	assert.True(t, ok)
+	assert.InDelta(t, 2.0, quota, 0.0001)
+
+	quota, ok = parseCPUMax("150000 100000")
+	assert.True(t, ok)
+	assert.InDelta(t, 1.5, quota, 0.0001)
+
+	_, ok = parseCPUMax("max 100000\n")
+	assert.False(t, ok)
+
+	_, ok = parseCPUMax("")
+	assert.False(t, ok)
+}
+
+func TestParseCFS(t *testing.T) {
+	quota, ok := parseCFS("50000\n", "100000\n")
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5, quota, 0.0001)
+
+	_, ok = parseCFS("-1", "100000")
+	assert.False(t, ok)
+}
+
+func TestApply_Override(t *testing.T) {
+	previous := runtime.GOMAXPROCS(0)
+	defer runtime.GOMAXPROCS(previous)
+
+	info := Apply(3)
+	assert.Equal(t, 3, info.GOMAXPROCS)
+	assert.Equal(t, SourceOverride, info.Source)
+	assert.Equal(t, 3, runtime.GOMAXPROCS(0))
+}